@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// namePrefixIndex lets an anchored name regex scan only the matching range
+// of a user's names
+var namePrefixIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+	Options: options.Index().SetName("user_name_prefix"),
+}
+
+// suggestion is a document offered by autocomplete
+type suggestion struct {
+	ID        string    `json:"id" bson:"_id"`
+	Name      string    `json:"name" bson:"name"`
+	Folder    string    `json:"folder,omitempty" bson:"folder,omitempty"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// namePrefixPattern matches names starting with prefix, taken literally.
+// Anchoring and leaving case alone keep the match within index bounds.
+func namePrefixPattern(prefix string) string {
+	return "^" + regexp.QuoteMeta(prefix)
+}
+
+// rankSuggestions moves a name equal to q ahead of the others, which stay
+// most recently updated first
+func rankSuggestions(q string, suggestions []suggestion) {
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Name == q && suggestions[j].Name != q
+	})
+}
+
+// Autocomplete documents - the caller's documents whose name starts with q,
+// for name pickers that query on every keystroke. Only metadata is returned.
+func autocompleteDocuments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := query.Get("q")
+	if q == "" {
+		sendValidationError(w, []FieldError{{Field: "q", Message: "is required"}})
+		return
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+
+	filter := live(bson.M{"name": bson.M{"$regex": namePrefixPattern(q)}})
+	if userID := getUserID(r); userID != "global" {
+		filter["user_id"] = userID
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"name": 1, "folder": 1, "updated_at": 1}).
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetLimit(int64(limit))
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	cursor, err := docCollection.Find(c, filter, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to search documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	suggestions := []suggestion{}
+	if err := cursor.All(c, &suggestions); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+	rankSuggestions(q, suggestions)
+
+	body, err := json.Marshal(suggestions)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to serialize documents"})
+		return
+	}
+	// Responses depend on the credentials, so only the client may cache them
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(config.AutocompleteMaxAge))
+	if notModified(w, r, weakETag(body)) {
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: suggestions})
+}
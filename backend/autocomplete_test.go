@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNamePrefixPattern(t *testing.T) {
+	tests := []struct {
+		prefix string
+		name   string
+		want   bool
+	}{
+		{"rep", "report", true},
+		{"rep", "Report", false},
+		{"rep", "my report", false},
+		{"a.b", "a.b notes", true},
+		{"a.b", "axb notes", false},
+		{"(x", "(x) draft", true},
+		{"c++", "c++ tips", true},
+		{"c++", "ccc", false},
+	}
+	for _, tt := range tests {
+		if got := regexp.MustCompile(namePrefixPattern(tt.prefix)).MatchString(tt.name); got != tt.want {
+			t.Errorf("prefix %q on %q: match = %v, want %v", tt.prefix, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRankSuggestions(t *testing.T) {
+	suggestions := []suggestion{{Name: "notes 2"}, {Name: "notes"}, {Name: "notes 1"}}
+	rankSuggestions("notes", suggestions)
+	var names []string
+	for _, s := range suggestions {
+		names = append(names, s.Name)
+	}
+	if got, want := strings.Join(names, ","), "notes,notes 2,notes 1"; got != want {
+		t.Errorf("order = %s, want %s", got, want)
+	}
+}
+
+func TestAutocompleteDocuments(t *testing.T) {
+	setupTestDB(t)
+	for _, name := range []string{"report", "reports 2024", "my report", "Report"} {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(`{"name":"`+name+`","data":{}}`)), "u1")
+		r.Header.Set("Content-Type", "application/json")
+		if w := serve(createDocument, r); w.Code != http.StatusCreated {
+			t.Fatalf("create %q: status %d", name, w.Code)
+		}
+	}
+
+	w := serve(autocompleteDocuments, asUser(httptest.NewRequest(http.MethodGet, "/api/documents/autocomplete?q="+url.QueryEscape("rep"), nil), "u1"))
+	var resp struct {
+		Data []suggestion `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Data) != 2 {
+		t.Fatalf("status %d, %d suggestions; want 200 and 2", w.Code, len(resp.Data))
+	}
+	for _, s := range resp.Data {
+		if !strings.HasPrefix(s.Name, "rep") {
+			t.Errorf("suggested %q, which does not start with the prefix", s.Name)
+		}
+	}
+	if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, "private") {
+		t.Errorf("Cache-Control = %q, want private", got)
+	}
+}
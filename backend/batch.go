@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const maxBatchOps = 20
+
+// batchOperation is one request of a batch
+type batchOperation struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Run several API requests in one round trip. Each operation is dispatched
+// through the route table with the caller's credentials, in order, and its
+// status and body are collected. Operations are independent: a failure does
+// not stop or undo the others (use /api/transactions for that).
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Operations []batchOperation `json:"operations"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	if len(input.Operations) == 0 {
+		sendValidationError(w, []FieldError{{Field: "operations", Message: "must not be empty"}})
+		return
+	}
+	if len(input.Operations) > maxBatchOps {
+		sendValidationError(w, []FieldError{{Field: "operations", Message: fmt.Sprintf("must have at most %d entries", maxBatchOps)}})
+		return
+	}
+
+	var fieldErrors []FieldError
+	for i, op := range input.Operations {
+		_, path := splitVersion(op.Path)
+		if !strings.HasPrefix(path, "/api/") || strings.HasPrefix(path, "/api/batch") {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("operations[%d].path", i), Message: "must be an /api/ path other than /api/batch"})
+		}
+		if op.Method == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("operations[%d].method", i), Message: "is required"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(input.Operations))
+	for _, op := range input.Operations {
+		results = append(results, runBatchOperation(r, op))
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: results})
+}
+
+// runBatchOperation dispatches one operation as a request carrying the
+// batch request's credentials
+func runBatchOperation(r *http.Request, op batchOperation) map[string]interface{} {
+	_, path := splitVersion(op.Path)
+	req, err := http.NewRequestWithContext(r.Context(), strings.ToUpper(op.Method), path, bytes.NewReader(op.Body))
+	if err != nil {
+		return map[string]interface{}{"status": http.StatusBadRequest, "body": APIResponse{Success: false, Error: "Invalid operation: " + err.Error()}}
+	}
+	for _, name := range []string{"X-API-Key", "Authorization", "X-Feature"} {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	// A key passed as ?api_key= authenticates each operation the same way
+	if key := r.URL.Query().Get("api_key"); key != "" {
+		query := req.URL.Query()
+		query.Set("api_key", key)
+		req.URL.RawQuery = query.Encode()
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	buf := &bufferedResponse{header: http.Header{}, status: http.StatusOK}
+	fieldsMiddleware(routes).ServeHTTP(buf, req)
+
+	var decoded interface{}
+	if err := json.Unmarshal(buf.body.Bytes(), &decoded); err != nil {
+		decoded = buf.body.String()
+	}
+	return map[string]interface{}{"status": buf.status, "body": decoded}
+}
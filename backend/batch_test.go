@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBatchValidation(t *testing.T) {
+	tooMany := `{"operations":[` + strings.Repeat(`{"method":"GET","path":"/api/documents"},`, maxBatchOps) + `{"method":"GET","path":"/api/documents"}]}`
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed JSON", `{"operations":[`, http.StatusBadRequest},
+		{"no operations", `{"operations":[]}`, http.StatusUnprocessableEntity},
+		{"too many operations", tooMany, http.StatusUnprocessableEntity},
+		{"missing method", `{"operations":[{"path":"/api/documents"}]}`, http.StatusUnprocessableEntity},
+		{"non-API path", `{"operations":[{"method":"GET","path":"/health"}]}`, http.StatusUnprocessableEntity},
+		{"nested batch", `{"operations":[{"method":"POST","path":"/api/batch"}]}`, http.StatusUnprocessableEntity},
+		{"nested versioned batch", `{"operations":[{"method":"POST","path":"/v1/api/batch"}]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if w := serve(batchHandler, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestBatchDispatch(t *testing.T) {
+	saved := routes
+	t.Cleanup(func() { routes = saved })
+	routes = newRouter()
+	routes.handle("/api/echo/{id}", accessPublic, methods{
+		http.MethodPost: withID(func(w http.ResponseWriter, r *http.Request, id string) {
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			sendJSON(w, http.StatusCreated, APIResponse{Success: true, Data: map[string]interface{}{
+				"id": id, "body": body, "key": r.Header.Get("X-API-Key"),
+			}})
+		}),
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`{"operations":[
+		{"method":"post","path":"/v1/api/echo/a","body":{"x":1}},
+		{"method":"GET","path":"/api/echo/b"},
+		{"method":"POST","path":"/api/missing"},
+		{"method":"POST","path":"/api/echo/c?fields=id"}
+	]}`))
+	r.Header.Set("X-API-Key", "key")
+	w := serve(batchHandler, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Data []struct {
+			Status int `json:"status"`
+			Body   struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"body"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	tests := []struct {
+		status int
+		data   string
+	}{
+		{http.StatusCreated, `{"body":{"x":1},"id":"a","key":"key"}`},
+		{http.StatusMethodNotAllowed, `null`},
+		{http.StatusNotFound, `null`},
+		{http.StatusCreated, `{"id":"c"}`},
+	}
+	if len(resp.Data) != len(tests) {
+		t.Fatalf("results = %d, want %d", len(resp.Data), len(tests))
+	}
+	for i, tt := range tests {
+		if resp.Data[i].Status != tt.status {
+			t.Errorf("operation %d: status = %d, want %d", i, resp.Data[i].Status, tt.status)
+		}
+		if got, _ := json.Marshal(resp.Data[i].Body.Data); string(got) != tt.data {
+			t.Errorf("operation %d: data = %s, want %s", i, got, tt.data)
+		}
+	}
+}
+
+func TestBatchQueryKey(t *testing.T) {
+	savedRoutes, savedKey := routes, config.APIKey
+	t.Cleanup(func() { routes, config.APIKey = savedRoutes, savedKey })
+	config.APIKey = "global-key"
+	routes = newRouter()
+	routes.handle("/api/whoami", accessUser, methods{
+		http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+			sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: map[string]interface{}{"user_id": getUserID(r)}})
+		},
+	})
+
+	// The batch itself was authenticated by ?api_key= rather than a header
+	r := httptest.NewRequest(http.MethodPost, "/api/batch?api_key=global-key", strings.NewReader(`{"operations":[
+		{"method":"GET","path":"/api/whoami"},
+		{"method":"GET","path":"/api/whoami?api_key=other"}
+	]}`))
+	w := serve(batchHandler, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resp struct {
+		Data []struct {
+			Status int `json:"status"`
+			Body   struct {
+				Data map[string]interface{} `json:"data"`
+			} `json:"body"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Data) != 2 {
+		t.Fatalf("%d results, want 2", len(resp.Data))
+	}
+	for i, result := range resp.Data {
+		if result.Status != http.StatusOK || result.Body.Data["user_id"] != "global" {
+			t.Errorf("operation %d: status %d, user %v, want 200 as the batch's caller", i, result.Status, result.Body.Data["user_id"])
+		}
+	}
+}
+
+func TestBatchDocumentRoutes(t *testing.T) {
+	setupTestDB(t)
+	savedRoutes, savedVerification := routes, config.RequireVerification
+	t.Cleanup(func() { routes, config.RequireVerification = savedRoutes, savedVerification })
+	routes = newRoutes()
+	config.RequireVerification = "create"
+
+	c := context.Background()
+	unverified := false
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", APIKey: "u1-key"})
+	usersCollection.InsertOne(c, User{ID: "u2", Email: "u2@example.com", APIKey: "u2-key", Verified: &unverified})
+	keysCollection.InsertOne(c, ScopedKey{ID: "k1", UserID: "u1", Key: "u1-read", Scopes: []string{scopeRead}, CreatedAt: time.Now().UTC()})
+	docCollection.InsertOne(c, JSONDocument{ID: "theirs", UserID: "u2", Name: "theirs", Data: map[string]interface{}{}})
+
+	type result struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	}
+	batch := func(key, operations string) []result {
+		r := httptest.NewRequest(http.MethodPost, "/api/batch", strings.NewReader(`{"operations":`+operations+`}`))
+		r.Header.Set("X-API-Key", key)
+		w := serve(batchHandler, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("batch: status = %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Data []result `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Data
+	}
+	statuses := func(results []result) []int {
+		codes := make([]int, len(results))
+		for i, result := range results {
+			codes[i] = result.Status
+		}
+		return codes
+	}
+
+	created := batch("u1-key", `[{"method":"POST","path":"/api/documents","body":{"name":"a","data":{"n":1}}}]`)
+	var doc struct {
+		Data JSONDocument `json:"data"`
+	}
+	json.Unmarshal(created[0].Body, &doc)
+	if created[0].Status != http.StatusCreated || doc.Data.ID == "" {
+		t.Fatalf("create: status %d: %s", created[0].Status, created[0].Body)
+	}
+	path := "/api/documents/" + doc.Data.ID
+
+	tests := []struct {
+		name       string
+		key        string
+		operations string
+		want       []int
+	}{
+		{"owner reads its document but not another's", "u1-key",
+			`[{"method":"GET","path":"` + path + `"},{"method":"GET","path":"/api/documents/theirs"}]`,
+			[]int{http.StatusOK, http.StatusNotFound}},
+		{"read-only key can't write", "u1-read",
+			`[{"method":"GET","path":"` + path + `"},{"method":"DELETE","path":"` + path + `"},{"method":"POST","path":"/api/documents","body":{"name":"b"}}]`,
+			[]int{http.StatusOK, http.StatusForbidden, http.StatusForbidden}},
+		{"unverified account can't create", "u2-key",
+			`[{"method":"POST","path":"/api/documents","body":{"name":"c"}}]`,
+			[]int{http.StatusForbidden}},
+		{"unknown key", "nope",
+			`[{"method":"GET","path":"` + path + `"}]`,
+			[]int{http.StatusUnauthorized}},
+		{"owner deletes", "u1-key",
+			`[{"method":"DELETE","path":"` + path + `"},{"method":"GET","path":"` + path + `"}]`,
+			[]int{http.StatusOK, http.StatusNotFound}},
+	}
+	for _, tt := range tests {
+		if got := statuses(batch(tt.key, tt.operations)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: statuses = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if n, _ := docCollection.CountDocuments(c, bson.M{"user_id": bson.M{"$in": bson.A{"u1", "u2"}}, "deleted_at": bson.M{"$exists": false}}); n != 1 {
+		t.Errorf("live documents = %d, want only the other user's", n)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker fast-fails requests after repeated MongoDB failures.
+// Once the cooldown has passed, a single probe request is let through
+// and its outcome decides whether the breaker closes or opens again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{state: breakerClosed, threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a request may proceed and whether it is the probe
+func (b *circuitBreaker) Allow() (allowed bool, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		b.state = breakerHalfOpen
+	}
+
+	switch b.state {
+	case breakerOpen:
+		return false, false
+	case breakerHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	}
+	return true, false
+}
+
+// EndProbe releases the probe slot so another request can test recovery
+func (b *circuitBreaker) EndProbe() {
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
+
+// Record updates the breaker with the outcome of a database operation.
+// Only timeouts and network errors count as failures; application errors
+// such as ErrNoDocuments mean the database is responding.
+func (b *circuitBreaker) Record(err error) {
+	failed := err != nil && (mongo.IsTimeout(err) || mongo.IsNetworkError(err))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.failures = 0
+		if b.state == breakerHalfOpen {
+			b.state = breakerClosed
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// State returns the current breaker state
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return breakerHalfOpen
+	}
+	return b.state
+}
+
+// Breaker middleware - rejects requests with 503 while the database breaker is open
+func breakerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isHealthPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, probe := dbBreaker.Allow()
+		if !allowed {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(config.BreakerCooldown.Seconds())))
+			sendJSON(w, http.StatusServiceUnavailable, APIResponse{
+				Success: false,
+				Error:   "Database temporarily unavailable",
+			})
+			return
+		}
+		if probe {
+			defer dbBreaker.EndProbe()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	timeout := context.DeadlineExceeded
+	tests := []struct {
+		name   string
+		errs   []error
+		cooled bool
+		want   string
+	}{
+		{"starts closed", nil, false, breakerClosed},
+		{"opens at the threshold", []error{timeout, timeout, timeout}, false, breakerOpen},
+		{"below the threshold", []error{timeout, timeout}, false, breakerClosed},
+		{"success resets the count", []error{timeout, timeout, nil, timeout}, false, breakerClosed},
+		{"application errors are successes", []error{timeout, timeout, mongo.ErrNoDocuments, timeout}, false, breakerClosed},
+		{"other errors are not failures", []error{errors.New("bad"), errors.New("bad"), errors.New("bad")}, false, breakerClosed},
+		{"half-open after the cooldown", []error{timeout, timeout, timeout}, true, breakerHalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(3, time.Minute)
+			for _, err := range tt.errs {
+				b.Record(err)
+			}
+			if tt.cooled {
+				b.openedAt = time.Now().Add(-2 * time.Minute)
+			}
+			if got := b.State(); got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name  string
+		probe error
+		want  string
+	}{
+		{"probe succeeds", nil, breakerClosed},
+		{"probe fails", context.DeadlineExceeded, breakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(1, time.Minute)
+			b.Record(context.DeadlineExceeded)
+			if allowed, _ := b.Allow(); allowed {
+				t.Fatal("open breaker allowed a request")
+			}
+
+			b.openedAt = time.Now().Add(-2 * time.Minute)
+			allowed, probe := b.Allow()
+			if !allowed || !probe {
+				t.Fatalf("Allow = %v, %v after the cooldown, want the probe", allowed, probe)
+			}
+			if allowed, _ := b.Allow(); allowed {
+				t.Error("a second request was let through during the probe")
+			}
+
+			b.Record(tt.probe)
+			b.EndProbe()
+			if got := b.State(); got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerMiddleware(t *testing.T) {
+	saved := dbBreaker
+	t.Cleanup(func() { dbBreaker = saved })
+	dbBreaker = newCircuitBreaker(1, time.Minute)
+	dbBreaker.Record(context.DeadlineExceeded)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for path, want := range map[string]int{"/api/documents": http.StatusServiceUnavailable, "/health": http.StatusOK, "/health/live": http.StatusOK, "/health/ready": http.StatusOK} {
+		w := httptest.NewRecorder()
+		breakerMiddleware(ok).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, want)
+		}
+	}
+}
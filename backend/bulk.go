@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBulkDocuments caps how many documents one bulk request may create
+const maxBulkDocuments = 500
+
+// Create many documents in one round trip. Each item is validated on its
+// own and failures are reported per item rather than failing the batch.
+func bulkCreateDocuments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	var input struct {
+		Documents []struct {
+			Name   string          `json:"name"`
+			Folder string          `json:"folder"`
+			Data   json.RawMessage `json:"data"`
+		} `json:"documents"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	if len(input.Documents) == 0 {
+		sendValidationError(w, []FieldError{{Field: "documents", Message: "must list at least one document"}})
+		return
+	}
+	if len(input.Documents) > maxBulkDocuments {
+		sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("A bulk request may create at most %d documents", maxBulkDocuments),
+			Code:    "too_many_documents",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	results := make([]map[string]interface{}, len(input.Documents))
+	var docs []interface{}
+	var docItems []int
+
+	fail := func(i int, msg string) {
+		results[i] = map[string]interface{}{"index": i, "success": false, "error": msg}
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	for i, item := range input.Documents {
+		if item.Name == "" {
+			fail(i, "name is required")
+			continue
+		}
+		if len(item.Data) > config.MaxDataBytes {
+			fail(i, fmt.Sprintf("data exceeds %d bytes", config.MaxDataBytes))
+			continue
+		}
+
+		var data map[string]interface{}
+		if len(item.Data) > 0 {
+			if err := json.Unmarshal(item.Data, &data); err != nil {
+				fail(i, "data must be an object")
+				continue
+			}
+		}
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+		if fe := validateData(data, nil); len(fe) > 0 {
+			fail(i, fe[0].Field+" "+fe[0].Message)
+			continue
+		}
+
+		doc, fe, err := newImportedDocument(c, userID, item.Name, data, now)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+			return
+		}
+		if len(fe) > 0 {
+			fail(i, fe[0].Field+" "+fe[0].Message)
+			continue
+		}
+		doc.Folder = item.Folder
+		docs = append(docs, doc)
+		docItems = append(docItems, i)
+	}
+
+	if len(docs) > 0 {
+		var size int64
+		for _, doc := range docs {
+			size += dataSize(doc.(JSONDocument).Data)
+		}
+		if !chargeStorage(c, w, userID, size) {
+			return
+		}
+
+		_, err := docCollection.InsertMany(c, docs, options.InsertMany().SetOrdered(false))
+		dbBreaker.Record(err)
+
+		failed := map[int]mongo.BulkWriteError{}
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, we := range bulkErr.WriteErrors {
+				failed[we.Index] = we
+			}
+		} else if err != nil {
+			releaseStorage(userID, size)
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to save documents"})
+			return
+		}
+
+		for n, doc := range docs {
+			i := docItems[n]
+			if we, ok := failed[n]; ok {
+				releaseStorage(userID, dataSize(doc.(JSONDocument).Data))
+				switch {
+				case isContentConflict(we):
+					fail(i, "a document with the same data already exists")
+				case mongo.IsDuplicateKeyError(we) && config.UniqueDocNames:
+					fail(i, "a document with this name already exists")
+				case mongo.IsDuplicateKeyError(we):
+					fail(i, "a document with this name already exists in the folder")
+				default:
+					fail(i, we.Message)
+				}
+				continue
+			}
+			results[i] = map[string]interface{}{"index": i, "success": true, "id": doc.(JSONDocument).ID}
+		}
+	}
+
+	created := 0
+	for _, result := range results {
+		if result["success"] == true {
+			created++
+		}
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Created %d of %d document(s)", created, len(results)),
+		Data:    map[string]interface{}{"results": results},
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// bulkCreate posts documents to the bulk endpoint as u1
+func bulkCreate(body string) (int, []map[string]interface{}) {
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/bulk", strings.NewReader(body)), "u1")
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(bulkCreateDocuments, r)
+	var resp struct {
+		Data struct {
+			Results []map[string]interface{} `json:"results"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.Data.Results
+}
+
+func TestBulkCreateLimits(t *testing.T) {
+	if code, _ := bulkCreate(`{"documents":[]}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("no documents: status %d, want 422", code)
+	}
+	items := strings.TrimSuffix(strings.Repeat(`{"name":"n"},`, maxBulkDocuments+1), ",")
+	if code, _ := bulkCreate(`{"documents":[` + items + `]}`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("%d documents: status %d, want 413", maxBulkDocuments+1, code)
+	}
+}
+
+func TestBulkCreateResults(t *testing.T) {
+	setupTestDB(t)
+	code, results := bulkCreate(`{"documents":[
+		{"name":"a","data":{"n":1}},
+		{"data":{"n":2}},
+		{"name":"c","data":[1,2]},
+		{"name":"d","folder":"f","data":{"n":4}}
+	]}`)
+	if code != http.StatusOK || len(results) != 4 {
+		t.Fatalf("status %d, %d results; want 200 with 4", code, len(results))
+	}
+
+	wantErrors := map[int]string{1: "name is required", 2: "data must be an object"}
+	for i, result := range results {
+		if result["index"] != float64(i) {
+			t.Errorf("result %d has index %v", i, result["index"])
+		}
+		if msg, failed := wantErrors[i]; failed {
+			if result["success"] != false || result["error"] != msg {
+				t.Errorf("result %d = %v, want error %q", i, result, msg)
+			}
+			continue
+		}
+		id, _ := result["id"].(string)
+		if result["success"] != true || id == "" {
+			t.Errorf("result %d = %v, want an id", i, result)
+			continue
+		}
+		if n, _ := docCollection.CountDocuments(context.Background(), bson.M{"_id": id, "user_id": "u1"}); n != 1 {
+			t.Errorf("result %d: document %s not stored", i, id)
+		}
+	}
+}
+
+func TestBulkCreateDuplicateNames(t *testing.T) {
+	setupTestDB(t)
+	saved := config.UniqueDocNames
+	t.Cleanup(func() { config.UniqueDocNames = saved })
+	config.UniqueDocNames = true
+	if _, err := docCollection.Indexes().CreateOne(context.Background(), userNameIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index refuses the second of two items sharing a name; the
+	// other items are still created
+	code, results := bulkCreate(`{"documents":[
+		{"name":"same","data":{"n":1}},
+		{"name":"same","folder":"f","data":{"n":2}},
+		{"name":"other","data":{"n":3}}
+	]}`)
+	if code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("status %d, %d results; want 200 with 3", code, len(results))
+	}
+	if results[0]["success"] != true || results[2]["success"] != true {
+		t.Errorf("distinct names were refused: %v", results)
+	}
+	if results[1]["success"] != false || results[1]["error"] != "a document with this name already exists" {
+		t.Errorf("duplicate name: %v, want the UNIQUE_DOC_NAMES error", results[1])
+	}
+	if n, _ := docCollection.CountDocuments(context.Background(), bson.M{"user_id": "u1"}); n != 2 {
+		t.Errorf("stored %d documents, want 2", n)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DocumentWithChildren is a listed document plus the number of documents
+// whose PARENT_FIELD references it
+type DocumentWithChildren struct {
+	JSONDocument `bson:",inline"`
+	ChildCount   int64 `json:"child_count" bson:"child_count"`
+}
+
+// childCounts counts the children of each parent in one aggregation. Only
+// the user's own documents are counted; parents without children are absent
+// from the result.
+func childCounts(c context.Context, userID string, parentIDs []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(parentIDs) == 0 {
+		return counts, nil
+	}
+
+	match := live(bson.M{config.ParentField: bson.M{"$in": parentIDs}})
+	if userID != "global" {
+		match["user_id"] = userID
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + config.ParentField, "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := docCollection.Aggregate(c, pipeline)
+	dbBreaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	var groups []struct {
+		ID    string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(c, &groups); err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		counts[g.ID] = g.Count
+	}
+	return counts, nil
+}
+
+// withChildCounts pairs each document with its child count, zero for leaves
+func withChildCounts(c context.Context, userID string, docs []JSONDocument) ([]DocumentWithChildren, error) {
+	ids := make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	counts, err := childCounts(c, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]DocumentWithChildren, len(docs))
+	for i, doc := range docs {
+		results[i] = DocumentWithChildren{JSONDocument: doc, ChildCount: counts[doc.ID]}
+	}
+	return results, nil
+}
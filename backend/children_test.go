@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWithChildCountsEmpty(t *testing.T) {
+	docs, err := withChildCounts(context.Background(), "u1", nil)
+	if err != nil || len(docs) != 0 {
+		t.Errorf("withChildCounts(nil) = %v, %v, want no documents", docs, err)
+	}
+}
+
+func TestWithChildCounts(t *testing.T) {
+	setupTestDB(t)
+	saved := config.ParentField
+	t.Cleanup(func() { config.ParentField = saved })
+	config.ParentField = "data.parent"
+
+	trashed := time.Now().UTC()
+	parents := []JSONDocument{
+		{ID: "p1", UserID: "u1", Name: "p1"},
+		{ID: "p2", UserID: "u1", Name: "p2"},
+		{ID: "leaf", UserID: "u1", Name: "leaf"},
+	}
+	children := []JSONDocument{
+		{ID: "c1", UserID: "u1", Name: "c", Data: map[string]interface{}{"parent": "p1"}},
+		{ID: "c2", UserID: "u1", Name: "c", Data: map[string]interface{}{"parent": "p1"}},
+		{ID: "c3", UserID: "u1", Name: "c", Data: map[string]interface{}{"parent": "p2"}},
+		{ID: "c4", UserID: "u1", Name: "c", Data: map[string]interface{}{"parent": "p2"}, DeletedAt: &trashed},
+		{ID: "c5", UserID: "u2", Name: "c", Data: map[string]interface{}{"parent": "p2"}},
+	}
+	for _, doc := range append(parents, children...) {
+		docCollection.InsertOne(context.Background(), doc)
+	}
+
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"u1", `{"leaf":0,"p1":2,"p2":1}`},
+		{"global", `{"leaf":0,"p1":2,"p2":2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			docs, err := withChildCounts(context.Background(), tt.user, parents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := map[string]int64{}
+			for _, doc := range docs {
+				counts[doc.ID] = doc.ChildCount
+			}
+			if got, _ := json.Marshal(counts); string(got) != tt.want {
+				t.Errorf("child counts = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
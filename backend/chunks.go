@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxUploadDataBytes caps an upload's chunks and the data they commit
+	// as BSON, leaving room under MongoDB's 16MB document limit for the
+	// rest of the document
+	maxUploadDataBytes = 15 << 20
+	// maxUploadChunks caps the chunks in one upload, each of which adds
+	// its own overhead to the stored upload
+	maxUploadChunks = 1000
+)
+
+// uploadSession collects the chunks of a document's data between begin and
+// commit. A document has at most one; beginning again discards it, and
+// MongoDB removes it once expires_at passes.
+type uploadSession struct {
+	DocumentID string    `json:"document_id" bson:"_id"`
+	UserID     string    `json:"-" bson:"user_id"`
+	Chunks     [][]byte  `json:"-" bson:"chunks"`
+	Count      int       `json:"chunks" bson:"count"`
+	Size       int64     `json:"size" bson:"size"`
+	MaxBytes   int64     `json:"max_bytes" bson:"-"`
+	CreatedAt  time.Time `json:"created_at" bson:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// uploadFilter matches the unexpired upload for document id, limited to the
+// caller's own unless they hold the global key
+func uploadFilter(r *http.Request, id string) bson.M {
+	filter := bson.M{"_id": id, "expires_at": bson.M{"$gt": time.Now().UTC()}}
+	if userID := getUserID(r); userID != "global" {
+		filter["user_id"] = userID
+	}
+	return filter
+}
+
+func sendUploadNotFound(w http.ResponseWriter) {
+	sendJSON(w, http.StatusNotFound, APIResponse{
+		Success: false,
+		Error:   "No upload in progress; begin one first",
+		Code:    "upload_not_found",
+	})
+}
+
+// Begin upload - starts collecting chunks for the document's data,
+// replacing any upload already in progress for it
+func beginUpload(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	doc, err := findOwnedDocument(c, r, id)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if doc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+
+	// Stored times keep milliseconds, and created_at is matched exactly
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	session := uploadSession{
+		DocumentID: id,
+		UserID:     doc.UserID,
+		Chunks:     [][]byte{},
+		MaxBytes:   config.UploadMaxBytes,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(config.UploadTTL),
+	}
+	_, err = uploadsCollection.ReplaceOne(c, bson.M{"_id": id}, session, options.Replace().SetUpsert(true))
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to begin upload"})
+		return
+	}
+
+	// Uploads hold their chunks outside the storage quota, so each owner
+	// may only have a few open. Counting after the upsert means concurrent
+	// begins can't all slip under the limit.
+	if config.MaxUploadsPerUser > 0 {
+		open, err := uploadsCollection.CountDocuments(c, bson.M{"user_id": doc.UserID, "expires_at": bson.M{"$gt": now}})
+		dbBreaker.Record(err)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to begin upload"})
+			return
+		}
+		if open > int64(config.MaxUploadsPerUser) {
+			_, err = uploadsCollection.DeleteOne(c, bson.M{"_id": id, "created_at": now})
+			dbBreaker.Record(err)
+			sendJSON(w, http.StatusTooManyRequests, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("At most %d uploads may be in progress; commit one or let it expire", config.MaxUploadsPerUser),
+				Code:    "too_many_uploads",
+			})
+			return
+		}
+	}
+	sendJSON(w, http.StatusCreated, APIResponse{Success: true, Message: "Upload started; send chunk 1", Data: session})
+}
+
+// Append chunk - adds chunk {n} of the data's JSON text. Chunks are numbered
+// from 1 and must arrive in order; a chunk out of turn gets 409 with the
+// number expected next, so an interrupted upload can resume.
+func appendChunk(w http.ResponseWriter, r *http.Request, id string) {
+	n, err := strconv.Atoi(pathParam(r, "n"))
+	if err != nil || n < 1 {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "chunk must be a positive integer"})
+		return
+	}
+	if n > maxUploadChunks {
+		sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Upload exceeds %d chunks", maxUploadChunks),
+			Code:    "upload_too_large",
+		})
+		return
+	}
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if len(body) == 0 {
+		sendValidationError(w, []FieldError{{Field: "body", Message: "chunk is empty"}})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	size := int64(len(body))
+	filter := uploadFilter(r, id)
+	filter["count"] = n - 1
+	filter["size"] = bson.M{"$lte": config.UploadMaxBytes - size}
+	update := bson.M{"$push": bson.M{"chunks": body}, "$inc": bson.M{"count": 1, "size": size}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"chunks": 0})
+
+	var session uploadSession
+	err = uploadsCollection.FindOneAndUpdate(c, filter, update, opts).Decode(&session)
+	dbBreaker.Record(err)
+	if err == nil {
+		session.MaxBytes = config.UploadMaxBytes
+		sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: fmt.Sprintf("Chunk %d received", n), Data: session})
+		return
+	}
+	if err != mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to store chunk"})
+		return
+	}
+
+	// Work out which condition failed
+	err = uploadsCollection.FindOne(c, uploadFilter(r, id), options.FindOne().SetProjection(bson.M{"chunks": 0})).Decode(&session)
+	dbBreaker.Record(err)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		sendUploadNotFound(w)
+	case err != nil:
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to store chunk"})
+	case session.Count != n-1:
+		sendJSON(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Expected chunk %d", session.Count+1),
+			Code:    "chunk_out_of_order",
+			Data:    map[string]interface{}{"next_chunk": session.Count + 1},
+		})
+	default:
+		sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Upload exceeds %d bytes", config.UploadMaxBytes),
+			Code:    "upload_too_large",
+		})
+	}
+}
+
+// Commit upload - joins the chunks, parses them as the document's new data
+// and saves it like a PUT of {data}. The upload is kept until the write
+// succeeds, so a commit refused over quota can be retried; data that
+// doesn't parse or validate needs a new upload.
+func commitUpload(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	doc, err := findOwnedDocument(c, r, id)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if doc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	prior := doc
+
+	var session uploadSession
+	err = uploadsCollection.FindOne(c, uploadFilter(r, id)).Decode(&session)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendUploadNotFound(w)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to load upload"})
+		return
+	}
+	if session.Count == 0 {
+		sendValidationError(w, []FieldError{{Field: "chunks", Message: "no chunks were uploaded"}})
+		return
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(bytes.Join(session.Chunks, nil), &data); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if data == nil {
+		sendValidationError(w, []FieldError{{Field: "data", Message: "must be a JSON object"}})
+		return
+	}
+
+	// Stored as BSON the data can outgrow its JSON text, small numbers
+	// most of all
+	if dataSize(data) > maxUploadDataBytes {
+		sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Data exceeds %d bytes once stored", maxUploadDataBytes),
+			Code:    "upload_too_large",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	stampData(data, doc.Data, doc.CreatedAt, now)
+
+	if fieldErrors := validateData(data, doc.Unique); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	set := bson.M{"updated_at": now, "data": data, "content_hash": contentHashValue(data)}
+	fieldErrors, err := documentSchemaErrors(c, &doc, doc.Name, data, set)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	growth := dataSize(data) - dataSize(prior.Data)
+	if !chargeStorage(c, w, prior.UserID, growth) {
+		return
+	}
+	filter := bson.M{"_id": id, "user_id": prior.UserID, "frozen": bson.M{"$ne": true}}
+	result, err := docCollection.UpdateOne(c, live(filter), bson.M{"$set": set})
+	dbBreaker.Record(err)
+	settleStorage(prior.UserID, growth, err == nil && result.MatchedCount > 0)
+	if mongo.IsDuplicateKeyError(err) {
+		sendWriteConflict(w, err)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	recordVersion(c, r, prior)
+	_, err = uploadsCollection.DeleteOne(c, bson.M{"_id": id})
+	dbBreaker.Record(err)
+
+	doc.Data, doc.UpdatedAt = data, now
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Upload committed", Data: doc})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChunkRoutesRefuseDataKeys(t *testing.T) {
+	// Keys limited to some data keys can't slip a whole new data past the
+	// check through an upload
+	scopes := []string{scopeRead, scopeWrite}
+	for pattern, method := range map[string]string{
+		"/api/documents/{id}/chunks/begin":  http.MethodPost,
+		"/api/documents/{id}/chunks/{n}":    http.MethodPut,
+		"/api/documents/{id}/chunks/commit": http.MethodPost,
+	} {
+		if scopedKeyAllows(pattern, method, scopes, true) {
+			t.Errorf("%s %s allowed for a key limited to data keys", method, pattern)
+		}
+		if !scopedKeyAllows(pattern, method, scopes, false) {
+			t.Errorf("%s %s refused for a write key", method, pattern)
+		}
+	}
+}
+
+func TestChunkedUpload(t *testing.T) {
+	setupTestDB(t)
+	saved := config.UploadMaxBytes
+	t.Cleanup(func() { config.UploadMaxBytes = saved })
+	config.UploadMaxBytes = 64
+
+	doc := JSONDocument{ID: "doc-1", UserID: "u1", Name: "Assembled", Data: map[string]interface{}{"old": true}}
+	if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+
+	call := func(method, path string, h func(http.ResponseWriter, *http.Request, string), body string, params map[string]string) (int, APIResponse) {
+		params["id"] = "doc-1"
+		r := asUser(httptest.NewRequest(method, path, strings.NewReader(body)), "u1")
+		w := serve(withID(h), withPathParams(r, params))
+		var resp APIResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	begin := func() (int, APIResponse) {
+		return call(http.MethodPost, "/api/documents/doc-1/chunks/begin", beginUpload, "", map[string]string{})
+	}
+	chunk := func(n, body string) (int, APIResponse) {
+		return call(http.MethodPut, "/api/documents/doc-1/chunks/"+n, appendChunk, body, map[string]string{"n": n})
+	}
+	commit := func() (int, APIResponse) {
+		return call(http.MethodPost, "/api/documents/doc-1/chunks/commit", commitUpload, "", map[string]string{})
+	}
+
+	if code, resp := chunk("1", `{"a":`); code != http.StatusNotFound || resp.Code != "upload_not_found" {
+		t.Errorf("chunk before begin: status %d, code %q, want 404 upload_not_found", code, resp.Code)
+	}
+
+	if code, _ := begin(); code != http.StatusCreated {
+		t.Fatalf("begin: status %d", code)
+	}
+	parts := []string{`{"items":[1,`, `2,3],"title":`, `"joined"}`}
+	for i, part := range parts {
+		if code, resp := chunk(strconv.Itoa(i+1), part); code != http.StatusOK {
+			t.Fatalf("chunk %d: status %d: %s", i+1, code, resp.Error)
+		}
+	}
+	if code, resp := chunk("5", `x`); code != http.StatusConflict || resp.Code != "chunk_out_of_order" {
+		t.Errorf("chunk 5 after 3: status %d, code %q, want 409 chunk_out_of_order", code, resp.Code)
+	}
+	if code, resp := chunk("4", strings.Repeat(" ", 64)); code != http.StatusRequestEntityTooLarge || resp.Code != "upload_too_large" {
+		t.Errorf("chunk past UPLOAD_MAX_BYTES: status %d, code %q, want 413 upload_too_large", code, resp.Code)
+	}
+
+	if code, resp := commit(); code != http.StatusOK {
+		t.Fatalf("commit: status %d: %s", code, resp.Error)
+	}
+	var stored JSONDocument
+	if err := docCollection.FindOne(context.Background(), bson.M{"_id": "doc-1"}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if raw, _ := json.Marshal(stored.Data); string(raw) != `{"items":[1,2,3],"title":"joined"}` {
+		t.Errorf("stored data = %s, want the joined chunks", raw)
+	}
+	if code, _ := commit(); code != http.StatusNotFound {
+		t.Errorf("second commit: status %d, want 404 once the upload is used", code)
+	}
+
+	// Chunks that don't join into JSON leave the document alone
+	begin()
+	chunk("1", `{"broken":`)
+	if code, resp := commit(); code != http.StatusBadRequest || resp.Code != "invalid_json" {
+		t.Errorf("commit of broken JSON: status %d, code %q, want 400 invalid_json", code, resp.Code)
+	}
+
+	// An expired upload is gone
+	uploadsCollection.UpdateOne(context.Background(), bson.M{"_id": "doc-1"}, bson.M{"$set": bson.M{"expires_at": time.Now().Add(-time.Minute)}})
+	if code, resp := commit(); code != http.StatusNotFound || resp.Code != "upload_not_found" {
+		t.Errorf("commit after expiry: status %d, code %q, want 404 upload_not_found", code, resp.Code)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	setupTestDB(t)
+	saved := config.MaxUploadsPerUser
+	t.Cleanup(func() { config.MaxUploadsPerUser = saved })
+	config.MaxUploadsPerUser = 1
+
+	for _, id := range []string{"doc-1", "doc-2"} {
+		docCollection.InsertOne(context.Background(), JSONDocument{ID: id, UserID: "u1", Name: id, Data: map[string]interface{}{}})
+	}
+	call := func(h func(http.ResponseWriter, *http.Request, string), id, body string, params map[string]string) (int, APIResponse) {
+		params["id"] = id
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/"+id+"/chunks", strings.NewReader(body)), "u1")
+		w := serve(withID(h), withPathParams(r, params))
+		var resp APIResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	if code, _ := call(beginUpload, "doc-1", "", map[string]string{}); code != http.StatusCreated {
+		t.Fatalf("first begin: status %d", code)
+	}
+	if code, resp := call(beginUpload, "doc-2", "", map[string]string{}); code != http.StatusTooManyRequests || resp.Code != "too_many_uploads" {
+		t.Errorf("second open upload: status %d, code %q, want 429 too_many_uploads", code, resp.Code)
+	}
+	if n, _ := uploadsCollection.CountDocuments(context.Background(), bson.M{"_id": "doc-2"}); n != 0 {
+		t.Error("refused upload was kept")
+	}
+	// Beginning the same document again replaces its upload
+	if code, _ := call(beginUpload, "doc-1", "", map[string]string{}); code != http.StatusCreated {
+		t.Errorf("restarting the open upload: status %d, want 201", code)
+	}
+
+	if code, resp := call(appendChunk, "doc-1", "x", map[string]string{"n": strconv.Itoa(maxUploadChunks + 1)}); code != http.StatusRequestEntityTooLarge || resp.Code != "upload_too_large" {
+		t.Errorf("chunk past the chunk limit: status %d, code %q, want 413 upload_too_large", code, resp.Code)
+	}
+
+	// A million zeros are 2MB of JSON but over 15MB as BSON, where every
+	// array element carries its index as a key
+	zeros := `{"z":[` + strings.Repeat("0,", 1<<20) + `0]}`
+	half := len(zeros) / 2
+	call(appendChunk, "doc-1", zeros[:half], map[string]string{"n": "1"})
+	call(appendChunk, "doc-1", zeros[half:], map[string]string{"n": "2"})
+	if code, resp := call(commitUpload, "doc-1", "", map[string]string{}); code != http.StatusRequestEntityTooLarge || resp.Code != "upload_too_large" {
+		t.Errorf("commit past the BSON limit: status %d, code %q, want 413 upload_too_large", code, resp.Code)
+	}
+}
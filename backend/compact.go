@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// compactData returns data without null values, empty objects and empty
+// arrays, and how many keys were removed. Objects left empty once their
+// members are removed go too. Array elements are never dropped since their
+// positions may matter, but objects inside arrays are compacted. Zero,
+// false and "" are kept.
+func compactData(data map[string]interface{}) (map[string]interface{}, int) {
+	compacted := make(map[string]interface{}, len(data))
+	removed := 0
+	for key, value := range data {
+		value, n := compactValue(value)
+		removed += n
+		if isEmptyValue(value) {
+			removed++
+			continue
+		}
+		compacted[key] = value
+	}
+	return compacted, removed
+}
+
+// compactValue compacts one value. Stored data decodes arrays as bson.A,
+// so arrays are normalized first.
+func compactValue(value interface{}) (interface{}, int) {
+	if obj, ok := value.(map[string]interface{}); ok {
+		return compactData(obj)
+	}
+	if arr, ok := asArray(value); ok {
+		items := make([]interface{}, len(arr))
+		removed := 0
+		for i, item := range arr {
+			items[i] = item
+			if obj, ok := item.(map[string]interface{}); ok {
+				var n int
+				items[i], n = compactData(obj)
+				removed += n
+			}
+		}
+		return items, removed
+	}
+	return value, 0
+}
+
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	if obj, ok := value.(map[string]interface{}); ok {
+		return len(obj) == 0
+	}
+	if arr, ok := asArray(value); ok {
+		return len(arr) == 0
+	}
+	return false
+}
+
+// Compact a document - strips null values, empty objects and empty arrays
+// from its data. Only done on request, since empties mean something to
+// some clients.
+func compactDocument(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var existingDoc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&existingDoc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if existingDoc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	filter["frozen"] = bson.M{"$ne": true}
+	prior := existingDoc
+
+	data, removed := compactData(existingDoc.Data)
+	if removed == 0 {
+		sendJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Nothing to compact",
+			Data:    map[string]interface{}{"removed": 0, "document": existingDoc},
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	stampData(data, existingDoc.Data, existingDoc.CreatedAt, now)
+
+	set := bson.M{"data": data, "content_hash": contentHashValue(data), "updated_at": now}
+	fieldErrors, err := documentSchemaErrors(c, &existingDoc, existingDoc.Name, data, set)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	// Stamping may add a key, so compaction doesn't always shrink the data
+	growth := dataSize(data) - dataSize(prior.Data)
+	if !chargeStorage(c, w, prior.UserID, growth) {
+		return
+	}
+	result, err := docCollection.UpdateOne(c, filter, bson.M{"$set": set})
+	dbBreaker.Record(err)
+	settleStorage(prior.UserID, growth, err == nil && result.MatchedCount > 0)
+	if isContentConflict(err) {
+		sendDuplicateContent(w, nil)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to compact document"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	recordVersion(c, r, prior)
+
+	existingDoc.Data = data
+	existingDoc.UpdatedAt = now
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Removed %d empty value(s)", removed),
+		Data:    map[string]interface{}{"removed": removed, "document": existingDoc},
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompactData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		removed int
+	}{
+		{"nothing to remove", `{"a":1,"b":"x","c":[1,2]}`, `{"a":1,"b":"x","c":[1,2]}`, 0},
+		{"null, empty object and empty array", `{"a":null,"b":{},"c":[],"d":1}`, `{"d":1}`, 3},
+		{"zero values kept", `{"a":0,"b":false,"c":""}`, `{"a":0,"b":false,"c":""}`, 0},
+		{"object emptied by compaction", `{"a":{"b":null}}`, `{}`, 2},
+		{"objects inside arrays", `{"a":[{"b":null,"c":1},null,[]]}`, `{"a":[{"c":1},null,[]]}`, 1},
+		{"nested empty array", `{"a":{"b":[],"c":2}}`, `{"a":{"c":2}}`, 1},
+	}
+	for _, tt := range tests {
+		for _, stored := range []bool{false, true} {
+			name := tt.name
+			if stored {
+				name += " (stored)"
+			}
+			t.Run(name, func(t *testing.T) {
+				var data map[string]interface{}
+				if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+					t.Fatal(err)
+				}
+				if stored {
+					data = storedData(t, data)
+				}
+				got, removed := compactData(data)
+				raw, _ := json.Marshal(got)
+				if string(raw) != tt.want {
+					t.Errorf("compactData = %s, want %s", raw, tt.want)
+				}
+				if removed != tt.removed {
+					t.Errorf("removed = %d, want %d", removed, tt.removed)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// Supported content codings, in server preference order for ties
+var compressionEncodings = []string{"gzip", "deflate"}
+
+// encodingQuality returns the q-value Accept-Encoding gives a coding,
+// falling back to the "*" wildcard
+func encodingQuality(acceptEncoding, coding string) float64 {
+	exact, wildcard := -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, param := range params[1:] {
+			if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && key == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		switch name {
+		case coding:
+			exact = q
+		case "*":
+			wildcard = q
+		}
+	}
+	if exact >= 0 {
+		return exact
+	}
+	if wildcard >= 0 {
+		return wildcard
+	}
+	return 0
+}
+
+// negotiateEncoding picks the best supported coding, or "" for identity
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, coding := range compressionEncodings {
+		if q := encodingQuality(acceptEncoding, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressBody encodes body with the given coding and level
+func compressBody(coding string, level int, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var enc io.WriteCloser
+	var err error
+	switch coding {
+	case "gzip":
+		enc, err = gzip.NewWriterLevel(&buf, level)
+	case "deflate":
+		enc, err = flate.NewWriter(&buf, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := enc.Write(body); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Compression middleware - encodes responses of at least COMPRESSION_MIN_BYTES
+// with the best coding the client accepts (gzip or deflate) at
+// COMPRESSION_LEVEL. Smaller bodies, partial content and already-encoded
+// responses pass through.
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// WebSocket upgrades need the connection itself, not a buffer
+		if !config.Compression || r.Method == http.MethodHead || websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		coding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if coding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		body := buf.body.Bytes()
+		if len(body) >= config.CompressionMinBytes && w.Header().Get("Content-Encoding") == "" && buf.status != http.StatusPartialContent {
+			if compressed, err := compressBody(coding, config.CompressionLevel, body); err == nil {
+				w.Header().Set("Content-Encoding", coding)
+				w.Header().Del("Content-Length")
+				body = compressed
+			}
+		}
+
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	})
+}
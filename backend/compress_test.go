@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncodingQuality(t *testing.T) {
+	tests := []struct {
+		accept string
+		coding string
+		want   float64
+	}{
+		{"", "gzip", 0},
+		{"gzip", "gzip", 1},
+		{"GZIP", "gzip", 1},
+		{"deflate", "gzip", 0},
+		{"gzip;q=0.5, deflate", "gzip", 0.5},
+		{"gzip; q=0", "gzip", 0},
+		{"*", "gzip", 1},
+		{"*;q=0.3", "deflate", 0.3},
+		{"gzip;q=0.8, *;q=0.1", "gzip", 0.8},
+		{"*;q=0.9, gzip;q=0", "gzip", 0},
+		{"gzip;q=abc", "gzip", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept+" "+tt.coding, func(t *testing.T) {
+			if got := encodingQuality(tt.accept, tt.coding); got != tt.want {
+				t.Errorf("encodingQuality(%q, %q) = %v, want %v", tt.accept, tt.coding, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"*", "gzip"},
+		{"*, gzip;q=0", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := negotiateEncoding(tt.accept); got != tt.want {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	savedOn, savedMin, savedLevel := config.Compression, config.CompressionMinBytes, config.CompressionLevel
+	t.Cleanup(func() {
+		config.Compression, config.CompressionMinBytes, config.CompressionLevel = savedOn, savedMin, savedLevel
+	})
+	config.CompressionMinBytes, config.CompressionLevel = 100, 6
+
+	large := strings.Repeat("x", 200)
+	tests := []struct {
+		name     string
+		enabled  bool
+		accept   string
+		body     string
+		status   int
+		encoding string
+	}{
+		{"gzip", true, "gzip", large, http.StatusOK, "gzip"},
+		{"deflate", true, "deflate", large, http.StatusOK, "deflate"},
+		{"below the minimum", true, "gzip", "small", http.StatusOK, ""},
+		{"partial content", true, "gzip", large, http.StatusPartialContent, ""},
+		{"not accepted", true, "", large, http.StatusOK, ""},
+		{"disabled", false, "gzip", large, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Compression = tt.enabled
+			h := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/documents", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			var reader io.Reader = bytes.NewReader(w.Body.Bytes())
+			switch tt.encoding {
+			case "gzip":
+				gz, err := gzip.NewReader(reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gz
+			case "deflate":
+				reader = flate.NewReader(reader)
+			}
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("decoded body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
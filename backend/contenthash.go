@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// contentHashIndex backs UNIQUE_CONTENT. Documents with empty data store a
+// null hash and are left out.
+var contentHashIndex = mongo.IndexModel{
+	Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "content_hash", Value: 1}},
+	Options: options.Index().SetName("user_content_hash").SetUnique(true).
+		SetPartialFilterExpression(bson.M{"content_hash": bson.M{"$type": "string"}}),
+}
+
+// contentHash is the canonical hash of data compared by UNIQUE_CONTENT, or
+// "" for empty data. encoding/json sorts keys, so key order doesn't matter.
+// The DATA_CREATED_FIELD and DATA_MODIFIED_FIELD stamps are left out since
+// they differ on every write.
+func contentHash(data map[string]interface{}) string {
+	hashed := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if key != "" && (key == config.DataCreatedField || key == config.DataModifiedField) {
+			continue
+		}
+		hashed[key] = value
+	}
+	if len(hashed) == 0 {
+		return ""
+	}
+	raw, err := json.Marshal(hashed)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// contentHashValue is the content_hash to $set for data: null rather than
+// "" when there is no hash, so the unique index skips the document
+func contentHashValue(data map[string]interface{}) interface{} {
+	if hash := contentHash(data); hash != "" {
+		return hash
+	}
+	return nil
+}
+
+// isContentConflict reports whether a write failed on the UNIQUE_CONTENT
+// index rather than another unique index
+func isContentConflict(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "user_content_hash")
+}
+
+// findByContentHash returns the caller's document whose data hashes to hash,
+// trashed or not, or nil. Like findByClientKey it reads from the primary.
+func findByContentHash(c context.Context, userID, hash string) (*JSONDocument, error) {
+	primary, err := docCollection.Clone(options.Collection().SetReadPreference(readpref.Primary()))
+	if err != nil {
+		return nil, err
+	}
+	var doc JSONDocument
+	err = primary.FindOne(c, bson.M{"user_id": userID, "content_hash": hash}).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// sendDuplicateContent answers a create whose data another of the caller's
+// documents already has: with UNIQUE_CONTENT=return that document is
+// returned with 200, otherwise the create is refused with 409. doc is nil
+// when the other document isn't known.
+func sendDuplicateContent(w http.ResponseWriter, doc *JSONDocument) {
+	resp := APIResponse{
+		Success: false,
+		Error:   "A document with the same data already exists",
+		Code:    "duplicate_content",
+	}
+	switch {
+	case doc == nil:
+	case doc.DeletedAt != nil:
+		resp.Error = "A document with the same data is in the trash"
+		resp.Data = map[string]interface{}{"id": doc.ID}
+	case config.UniqueContent == "return":
+		w.Header().Set("Location", documentLocation(w, doc.ID))
+		sendJSON(w, http.StatusOK, APIResponse{
+			Success: true,
+			Message: "Document with the same data already exists",
+			Data:    doc,
+		})
+		return
+	default:
+		resp.Data = map[string]interface{}{"id": doc.ID}
+	}
+	sendJSON(w, http.StatusConflict, resp)
+}
+
+// sendWriteConflict reports a write refused by a unique index: the same
+// data as another document under UNIQUE_CONTENT, or else a taken name
+func sendWriteConflict(w http.ResponseWriter, err error) {
+	if isContentConflict(err) {
+		sendDuplicateContent(w, nil)
+		return
+	}
+	sendNameConflict(w)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestContentHash(t *testing.T) {
+	savedCreated, savedModified := config.DataCreatedField, config.DataModifiedField
+	t.Cleanup(func() { config.DataCreatedField, config.DataModifiedField = savedCreated, savedModified })
+	config.DataCreatedField, config.DataModifiedField = "created", "modified"
+
+	parse := func(s string) map[string]interface{} {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	base := contentHash(parse(`{"a":1,"b":{"c":[1,"x"]}}`))
+	if base == "" {
+		t.Fatal("no hash for non-empty data")
+	}
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		same bool
+	}{
+		{"key order", parse(`{"b":{"c":[1,"x"]},"a":1}`), true},
+		{"as stored", storedData(t, parse(`{"a":1,"b":{"c":[1,"x"]}}`)), true},
+		{"timestamps", parse(`{"a":1,"b":{"c":[1,"x"]},"created":"2024-01-01T00:00:00Z","modified":"2024-02-01T00:00:00Z"}`), true},
+		{"other value", parse(`{"a":2,"b":{"c":[1,"x"]}}`), false},
+		{"array order", parse(`{"a":1,"b":{"c":["x",1]}}`), false},
+	}
+	for _, tt := range tests {
+		if got := contentHash(tt.data) == base; got != tt.same {
+			t.Errorf("%s: same hash = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+
+	if got := contentHash(parse(`{"modified":"2024-02-01T00:00:00Z"}`)); got != "" {
+		t.Errorf("data holding only timestamps hashed to %q, want none", got)
+	}
+	if got := contentHashValue(map[string]interface{}{}); got != nil {
+		t.Errorf("contentHashValue of empty data = %v, want nil", got)
+	}
+}
+
+func TestBackfillContentHash(t *testing.T) {
+	saved := config.UniqueContent
+	t.Cleanup(func() { config.UniqueContent = saved })
+	config.UniqueContent = "reject"
+
+	set := backfillFields(bson.M{"_id": "a", "data": bson.M{"n": int32(1)}, "name": "n", "is_public": false})
+	if want := contentHash(map[string]interface{}{"n": 1.0}); set["content_hash"] != want {
+		t.Errorf("content_hash backfilled as %v, want %s", set["content_hash"], want)
+	}
+	if set := backfillFields(bson.M{"_id": "b", "name": "n"}); set["content_hash"] != nil {
+		t.Errorf("content_hash for missing data backfilled as %v, want null", set["content_hash"])
+	}
+}
+
+func TestUniqueContent(t *testing.T) {
+	setupTestDB(t)
+	saved := config.UniqueContent
+	t.Cleanup(func() { config.UniqueContent = saved })
+	if _, err := docCollection.Indexes().CreateOne(context.Background(), contentHashIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(body string) (int, APIResponse) {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(body)), "u1")
+		r.Header.Set("Content-Type", "application/json")
+		w := serve(createDocument, r)
+		var resp APIResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+	idOf := func(resp APIResponse) string {
+		data, _ := resp.Data.(map[string]interface{})
+		id, _ := data["id"].(string)
+		return id
+	}
+
+	config.UniqueContent = "reject"
+	code, first := create(`{"name":"upload","data":{"rows":[1,2,3]}}`)
+	if code != http.StatusCreated {
+		t.Fatalf("first create: status %d", code)
+	}
+	code, resp := create(`{"name":"upload again","data":{"rows":[1,2,3]}}`)
+	if code != http.StatusConflict || resp.Code != "duplicate_content" || idOf(resp) != idOf(first) {
+		t.Errorf("reject: status %d, code %q, id %q; want 409 duplicate_content naming %s", code, resp.Code, idOf(resp), idOf(first))
+	}
+
+	config.UniqueContent = "return"
+	if code, resp := create(`{"name":"upload again","data":{"rows":[1,2,3]}}`); code != http.StatusOK || idOf(resp) != idOf(first) {
+		t.Errorf("return: status %d, id %q; want 200 and the original %s", code, idOf(resp), idOf(first))
+	}
+
+	// Empty data is never a duplicate
+	for i := 0; i < 2; i++ {
+		if code, _ := create(`{"name":"blank","data":{}}`); code != http.StatusCreated {
+			t.Errorf("empty data create %d: status %d, want 201", i, code)
+		}
+	}
+
+	// Updating another document to the same data is refused too
+	_, other := create(`{"name":"other","data":{"rows":[4]}}`)
+	r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/"+idOf(other), strings.NewReader(`{"data":{"rows":[1,2,3]}}`)), "u1")
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(withID(updateDocument), withPathParams(r, map[string]string{"id": idOf(other)}))
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusConflict || resp.Code != "duplicate_content" {
+		t.Errorf("update onto duplicate data: status %d, code %q; want 409 duplicate_content", w.Code, resp.Code)
+	}
+}
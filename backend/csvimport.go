@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// csvValue converts a CSV cell, optionally inferring numbers and booleans
+func csvValue(cell string, inferTypes bool) interface{} {
+	if !inferTypes {
+		return cell
+	}
+	switch cell {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseFloat(cell, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+		return n
+	}
+	return cell
+}
+
+// Import a CSV body with a header row. ?mode=per-row creates one document
+// per row named from ?name_column=; ?mode=single stores every row in the
+// data.rows array of one document named ?name=.
+func importCSV(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	query := r.URL.Query()
+
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = "per-row"
+	}
+	inferTypes := query.Get("infer_types") == "true"
+
+	var fieldErrors []FieldError
+	switch mode {
+	case "per-row":
+		if query.Get("name_column") == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: "name_column", Message: "is required in per-row mode"})
+		}
+	case "single":
+		if query.Get("name") == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: "name", Message: "is required in single mode"})
+		}
+	default:
+		fieldErrors = append(fieldErrors, FieldError{Field: "mode", Message: `must be "per-row" or "single"`})
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "CSV must start with a header row", Code: "invalid_csv"})
+		return
+	}
+
+	nameIndex := -1
+	for i, column := range header {
+		if column == query.Get("name_column") {
+			nameIndex = i
+		}
+	}
+	if mode == "per-row" && nameIndex < 0 {
+		sendValidationError(w, []FieldError{{Field: "name_column", Message: "is not a column in the header row"}})
+		return
+	}
+
+	now := time.Now().UTC()
+	var rows []map[string]interface{}
+	var rowErrors []map[string]interface{}
+	var docs []interface{}
+	var docRows []int
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid CSV: " + err.Error(), Code: "invalid_csv"})
+			return
+		}
+		if len(record) != len(header) {
+			rowErrors = append(rowErrors, map[string]interface{}{
+				"row":   line,
+				"error": fmt.Sprintf("expected %d fields, got %d", len(header), len(record)),
+			})
+			continue
+		}
+
+		row := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			row[column] = csvValue(record[i], inferTypes)
+		}
+
+		if mode == "single" {
+			rows = append(rows, row)
+			continue
+		}
+
+		name := record[nameIndex]
+		if name == "" {
+			rowErrors = append(rowErrors, map[string]interface{}{"row": line, "error": "name column is empty"})
+			continue
+		}
+		if fe := validateData(row, nil); len(fe) > 0 {
+			rowErrors = append(rowErrors, map[string]interface{}{"row": line, "error": fe[0].Field + " " + fe[0].Message})
+			continue
+		}
+		doc, fe, err := newImportedDocument(c, userID, name, row, now)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to import documents"})
+			return
+		}
+		if len(fe) > 0 {
+			rowErrors = append(rowErrors, map[string]interface{}{"row": line, "error": fe[0].Field + " " + fe[0].Message})
+			continue
+		}
+		docs = append(docs, doc)
+		docRows = append(docRows, line)
+	}
+
+	if mode == "single" {
+		data := map[string]interface{}{"rows": rows}
+		if rows == nil {
+			data["rows"] = []interface{}{}
+		}
+		doc, fe, err := newImportedDocument(c, userID, query.Get("name"), data, now)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to import documents"})
+			return
+		}
+		if len(fe) > 0 {
+			sendValidationError(w, fe)
+			return
+		}
+		docs = append(docs, doc)
+		docRows = append(docRows, 0)
+	}
+
+	created := []map[string]interface{}{}
+	if len(docs) > 0 {
+		var size int64
+		for _, doc := range docs {
+			size += dataSize(doc.(JSONDocument).Data)
+		}
+		if !chargeStorage(c, w, userID, size) {
+			return
+		}
+
+		_, err := docCollection.InsertMany(c, docs, options.InsertMany().SetOrdered(false))
+		dbBreaker.Record(err)
+
+		failed := map[int]string{}
+		var bulkErr mongo.BulkWriteException
+		if errors.As(err, &bulkErr) {
+			for _, we := range bulkErr.WriteErrors {
+				failed[we.Index] = we.Message
+			}
+		} else if err != nil {
+			releaseStorage(userID, size)
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to import documents"})
+			return
+		}
+
+		for i, doc := range docs {
+			if msg, ok := failed[i]; ok {
+				releaseStorage(userID, dataSize(doc.(JSONDocument).Data))
+				rowErrors = append(rowErrors, map[string]interface{}{"row": docRows[i], "error": msg})
+				continue
+			}
+			created = append(created, map[string]interface{}{"row": docRows[i], "id": doc.(JSONDocument).ID})
+		}
+	}
+
+	if rowErrors == nil {
+		rowErrors = []map[string]interface{}{}
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Imported %d document(s)", len(created)),
+		Data: map[string]interface{}{
+			"created": created,
+			"errors":  rowErrors,
+		},
+	})
+}
+
+// newImportedDocument builds a document for an imported row, validating it
+// against any schema registered under its name
+func newImportedDocument(c context.Context, userID, name string, data map[string]interface{}, now time.Time) (JSONDocument, []FieldError, error) {
+	stampData(data, nil, now, now)
+	schemaRef, err := schemaForName(c, userID, name)
+	if err != nil {
+		return JSONDocument{}, nil, err
+	}
+	if schemaRef != nil {
+		if fieldErrors, err := validateSchemaRef(c, userID, schemaRef, data); err != nil || len(fieldErrors) > 0 {
+			return JSONDocument{}, fieldErrors, err
+		}
+	}
+
+	doc := JSONDocument{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Data:      data,
+		SchemaRef: schemaRef,
+		CreatedAt: now,
+		UpdatedAt: now,
+
+		ContentHash: contentHash(data),
+	}
+	if config.InferSchema {
+		doc.Schema = inferSchema(doc.Data)
+	}
+	return doc, nil, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCSVValue(t *testing.T) {
+	tests := []struct {
+		cell  string
+		infer bool
+		want  interface{}
+	}{
+		{"42", false, "42"},
+		{"true", false, "true"},
+		{"42", true, 42.0},
+		{"-1.5e3", true, -1500.0},
+		{"true", true, true},
+		{"false", true, false},
+		{"True", true, "True"},
+		{"NaN", true, "NaN"},
+		{"Inf", true, "Inf"},
+		{"", true, ""},
+		{"abc", true, "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cell, func(t *testing.T) {
+			if got := csvValue(tt.cell, tt.infer); got != tt.want {
+				t.Errorf("csvValue(%q, %v) = %#v, want %#v", tt.cell, tt.infer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportCSVValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  int
+	}{
+		{"unknown mode", "?mode=columns", "a\n1\n", http.StatusUnprocessableEntity},
+		{"per-row without name_column", "", "a\n1\n", http.StatusUnprocessableEntity},
+		{"single without name", "?mode=single", "a\n1\n", http.StatusUnprocessableEntity},
+		{"empty body", "?name_column=a", "", http.StatusBadRequest},
+		{"name_column not in header", "?name_column=b", "a\n1\n", http.StatusUnprocessableEntity},
+		{"bad quoting", "?mode=single&name=x", "a\n\"1\n", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/import/csv"+tt.query, strings.NewReader(tt.body)), "u1")
+			r.Header.Set("Content-Type", "text/csv")
+			if w := serve(importCSV, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	const body = "name,count,ok\na,1,true\nb,2\n,3,false\nc,4,false\n"
+	tests := []struct {
+		name    string
+		query   string
+		created int
+		errors  []float64
+	}{
+		{"per-row", "?name_column=name&infer_types=true", 2, []float64{3, 4}},
+		{"single", "?mode=single&name=all", 1, []float64{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/import/csv"+tt.query, strings.NewReader(body)), "u1")
+			r.Header.Set("Content-Type", "text/csv")
+			w := serve(importCSV, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			var resp struct {
+				Data struct {
+					Created []map[string]interface{} `json:"created"`
+					Errors  []map[string]interface{} `json:"errors"`
+				} `json:"data"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if len(resp.Data.Created) != tt.created {
+				t.Errorf("created = %v, want %d", resp.Data.Created, tt.created)
+			}
+			var rows []float64
+			for _, e := range resp.Data.Errors {
+				rows = append(rows, e["row"].(float64))
+			}
+			if !reflect.DeepEqual(rows, tt.errors) {
+				t.Errorf("error rows = %v, want %v", rows, tt.errors)
+			}
+			count, _ := docCollection.CountDocuments(context.Background(), bson.M{"user_id": "u1"})
+			if count != int64(tt.created) {
+				t.Errorf("stored documents = %d, want %d", count, tt.created)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import "time"
+
+// stampData writes the DATA_CREATED_FIELD and DATA_MODIFIED_FIELD
+// timestamps into data about to be stored. prior is the document's current
+// data (nil on create) and keeps the original created value across full
+// replacements. Values the client sent win unless DATA_TIMESTAMPS_OVERRIDE
+// is set.
+func stampData(data, prior map[string]interface{}, createdAt, now time.Time) {
+	if field := config.DataCreatedField; field != "" && stampable(data, field) {
+		if previous, ok := prior[field]; ok {
+			data[field] = previous
+		} else {
+			data[field] = createdAt.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	if field := config.DataModifiedField; field != "" && stampable(data, field) {
+		data[field] = now.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+func stampable(data map[string]interface{}, field string) bool {
+	_, provided := data[field]
+	return !provided || config.DataTimestampsOverride
+}
+
+// identifyData returns data as served on public reads, with the document's
+// ID under DATA_ID_FIELD and its name under DATA_NAME_FIELD. Nothing is
+// stored: data is copied when a field is added. A user value under the same
+// key is kept unless DATA_ID_OVERRIDE is set.
+func identifyData(doc JSONDocument, data map[string]interface{}) map[string]interface{} {
+	fields := map[string]string{}
+	if field := config.DataIDField; field != "" && identifiable(data, field) {
+		fields[field] = doc.ID
+	}
+	if field := config.DataNameField; field != "" && identifiable(data, field) {
+		fields[field] = doc.Name
+	}
+	if len(fields) == 0 {
+		return data
+	}
+
+	served := make(map[string]interface{}, len(data)+len(fields))
+	for k, v := range data {
+		served[k] = v
+	}
+	for k, v := range fields {
+		served[k] = v
+	}
+	return served
+}
+
+func identifiable(data map[string]interface{}, field string) bool {
+	_, taken := data[field]
+	return !taken || config.DataIDOverride
+}
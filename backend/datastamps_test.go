@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStampData(t *testing.T) {
+	savedCreated, savedModified, savedOverride := config.DataCreatedField, config.DataModifiedField, config.DataTimestampsOverride
+	t.Cleanup(func() {
+		config.DataCreatedField, config.DataModifiedField, config.DataTimestampsOverride = savedCreated, savedModified, savedOverride
+	})
+
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		created  string
+		modified string
+		override bool
+		data     string
+		prior    string
+		want     string
+	}{
+		{"disabled", "", "", false, `{"a":1}`, ``, `{"a":1}`},
+		{"create", "_created", "_modified", false, `{"a":1}`, ``,
+			`{"_created":"2024-01-01T00:00:00Z","_modified":"2024-02-02T00:00:00Z","a":1}`},
+		{"modified only", "", "_modified", false, `{"a":1}`, ``,
+			`{"_modified":"2024-02-02T00:00:00Z","a":1}`},
+		{"replace keeps the original created value", "_created", "_modified", false, `{"a":2}`, `{"_created":"2020-05-05T00:00:00Z"}`,
+			`{"_created":"2020-05-05T00:00:00Z","_modified":"2024-02-02T00:00:00Z","a":2}`},
+		{"client values win", "_created", "_modified", false, `{"_created":"mine","_modified":"mine"}`, ``,
+			`{"_created":"mine","_modified":"mine"}`},
+		{"override replaces client values", "_created", "_modified", true, `{"_created":"mine","_modified":"mine"}`, ``,
+			`{"_created":"2024-01-01T00:00:00Z","_modified":"2024-02-02T00:00:00Z"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DataCreatedField, config.DataModifiedField, config.DataTimestampsOverride = tt.created, tt.modified, tt.override
+			var data, prior map[string]interface{}
+			json.Unmarshal([]byte(tt.data), &data)
+			if tt.prior != "" {
+				json.Unmarshal([]byte(tt.prior), &prior)
+			}
+			stampData(data, prior, created, now)
+			if got, _ := json.Marshal(data); string(got) != tt.want {
+				t.Errorf("stamped data = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentifyData(t *testing.T) {
+	savedID, savedName, savedOverride := config.DataIDField, config.DataNameField, config.DataIDOverride
+	t.Cleanup(func() {
+		config.DataIDField, config.DataNameField, config.DataIDOverride = savedID, savedName, savedOverride
+	})
+
+	doc := JSONDocument{ID: "doc-1", Name: "Notes"}
+	tests := []struct {
+		name     string
+		id       string
+		docName  string
+		override bool
+		data     string
+		want     string
+	}{
+		{"disabled", "", "", false, `{"a":1}`, `{"a":1}`},
+		{"id", "_id", "", false, `{"a":1}`, `{"_id":"doc-1","a":1}`},
+		{"id and name", "_id", "_name", false, `{"a":1}`, `{"_id":"doc-1","_name":"Notes","a":1}`},
+		{"user field kept", "_id", "", false, `{"_id":"mine"}`, `{"_id":"mine"}`},
+		{"user field overridden", "_id", "", true, `{"_id":"mine"}`, `{"_id":"doc-1"}`},
+		{"no data", "_id", "", false, `null`, `{"_id":"doc-1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DataIDField, config.DataNameField, config.DataIDOverride = tt.id, tt.docName, tt.override
+			var data map[string]interface{}
+			json.Unmarshal([]byte(tt.data), &data)
+			before, _ := json.Marshal(data)
+
+			got, _ := json.Marshal(identifyData(doc, data))
+			if string(got) != tt.want {
+				t.Errorf("identifyData = %s, want %s", got, tt.want)
+			}
+			if after, _ := json.Marshal(data); string(after) != string(before) {
+				t.Errorf("stored data changed from %s to %s", before, after)
+			}
+		})
+	}
+}
+
+func TestPublicReadIdentifiesData(t *testing.T) {
+	setupTestDB(t)
+	saved := config.DataIDField
+	t.Cleanup(func() { config.DataIDField = saved })
+	config.DataIDField = "_id"
+
+	c := context.Background()
+	docCollection.InsertOne(c, JSONDocument{ID: "doc-1", UserID: "u1", Name: "n", IsPublic: true, Data: map[string]interface{}{"a": 1}})
+
+	w := serve(withID(publicHandler), withPathParams(httptest.NewRequest(http.MethodGet, "/public/doc-1", nil), map[string]string{"id": "doc-1"}))
+	var served map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	if served["_id"] != "doc-1" {
+		t.Errorf("public data _id = %v, want doc-1", served["_id"])
+	}
+
+	var stored JSONDocument
+	docCollection.FindOne(c, bson.M{"_id": "doc-1"}).Decode(&stored)
+	if _, ok := stored.Data["_id"]; ok {
+		t.Errorf("injected _id was stored: %v", stored.Data)
+	}
+}
+
+func TestDownloadIdentifiesData(t *testing.T) {
+	savedID, savedName := config.DataIDField, config.DataNameField
+	t.Cleanup(func() { config.DataIDField, config.DataNameField = savedID, savedName })
+	config.DataIDField, config.DataNameField = "_id", "_name"
+
+	doc := JSONDocument{ID: "doc-1", UserID: "u1", Name: "n", Data: map[string]interface{}{"a": 1.0}}
+	w := httptest.NewRecorder()
+	downloadDocument(w, httptest.NewRequest(http.MethodGet, "/api/documents/doc-1?download=true", nil), doc)
+	var served JSONDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	if served.Data["_id"] != "doc-1" || served.Data["_name"] != "n" {
+		t.Errorf("downloaded data = %v, want _id and _name", served.Data)
+	}
+	if len(doc.Data) != 1 {
+		t.Errorf("document data changed to %v", doc.Data)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// decodeListed decodes up to limit documents from cursor. A stored document
+// that doesn't fit JSONDocument, such as a legacy one with a field of another
+// type, fails the whole list with LIST_DECODE_ERRORS=fail; with skip it is
+// logged and counted instead. It also returns the ID of the last document
+// read, decoded or not, which is where the next page starts.
+func decodeListed(c context.Context, r *http.Request, cursor *mongo.Cursor, limit int) ([]JSONDocument, int, string, error) {
+	docs := []JSONDocument{}
+	skipped, lastID := 0, ""
+	for read := 0; read < limit && cursor.Next(c); read++ {
+		lastID, _ = cursor.Current.Lookup("_id").StringValueOK()
+
+		var doc JSONDocument
+		if err := cursor.Decode(&doc); err != nil {
+			if config.DecodeErrors == "fail" {
+				return nil, 0, "", err
+			}
+			log.Printf("request_id=%s skipped document %s that failed to decode: %v", requestID(r), lastID, err)
+			skipped++
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, skipped, lastID, cursor.Err()
+}
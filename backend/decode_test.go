@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestListSkipsUndecodableDocuments(t *testing.T) {
+	setupTestDB(t)
+	saved := config.DecodeErrors
+	t.Cleanup(func() { config.DecodeErrors = saved })
+
+	for _, doc := range []interface{}{
+		bson.M{"_id": "a", "user_id": "u1", "name": "first", "data": bson.M{"n": 1}},
+		// A legacy document whose name was stored as a number
+		bson.M{"_id": "b", "user_id": "u1", "name": 42, "data": bson.M{"n": 2}},
+		bson.M{"_id": "c", "user_id": "u1", "name": "third", "data": bson.M{"n": 3}},
+	} {
+		if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type page struct {
+		Data       []JSONDocument `json:"data"`
+		Skipped    int            `json:"skipped"`
+		HasMore    bool           `json:"has_more"`
+		NextCursor string         `json:"next_cursor"`
+	}
+	list := func(query string) (int, page) {
+		w := serve(listDocuments, asUser(httptest.NewRequest(http.MethodGet, "/api/documents"+query, nil), "u1"))
+		var p page
+		json.NewDecoder(w.Body).Decode(&p)
+		return w.Code, p
+	}
+
+	config.DecodeErrors = "skip"
+	code, first := list("?limit=2")
+	if code != http.StatusOK || len(first.Data) != 1 || first.Data[0].ID != "a" || first.Skipped != 1 {
+		t.Fatalf("first page: status %d, %+v; want document a and 1 skipped", code, first)
+	}
+	if !first.HasMore || first.NextCursor != "b" {
+		t.Errorf("first page: has_more %v, next_cursor %q; want true and b", first.HasMore, first.NextCursor)
+	}
+	if _, second := list("?limit=2&cursor=" + first.NextCursor); len(second.Data) != 1 || second.Data[0].ID != "c" || second.Skipped != 0 {
+		t.Errorf("second page: %+v, want document c only", second)
+	}
+
+	config.DecodeErrors = "fail"
+	if code, _ := list(""); code != http.StatusInternalServerError {
+		t.Errorf("fail mode: status %d, want 500", code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const maxDerivations = 20
+
+// Derivation is a read-time computed field. The result is added to the
+// document's data under Field when the document is fetched and never stored.
+type Derivation struct {
+	Field     string   `json:"field" bson:"field"`
+	Op        string   `json:"op" bson:"op"`
+	Paths     []string `json:"paths" bson:"paths"`
+	Separator string   `json:"separator,omitempty" bson:"separator,omitempty"`
+	Format    string   `json:"format,omitempty" bson:"format,omitempty"`
+}
+
+// Named output formats for the format_date derivation
+var dateFormats = map[string]string{
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+	"datetime": time.RFC3339,
+	"rfc1123":  time.RFC1123,
+}
+
+// validateDerivations checks derivation definitions when they are saved
+func validateDerivations(defs []Derivation) []FieldError {
+	var fieldErrors []FieldError
+	if len(defs) > maxDerivations {
+		return []FieldError{{Field: "derived", Message: fmt.Sprintf("must have at most %d entries", maxDerivations)}}
+	}
+
+	seen := map[string]bool{}
+	for i, def := range defs {
+		prefix := fmt.Sprintf("derived[%d]", i)
+		if !dataKeyPattern.MatchString(def.Field) {
+			fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".field", Message: "must be a top-level data key"})
+		} else if seen[def.Field] {
+			fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".field", Message: "is defined more than once"})
+		}
+		seen[def.Field] = true
+
+		// Inputs are read from the data only, never the document's other
+		// members such as user_id
+		for _, path := range def.Paths {
+			if !strings.HasPrefix(path, "data.") || !isQueryableField(path) {
+				fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".paths", Message: "must be data paths such as data.title"})
+				break
+			}
+		}
+
+		switch def.Op {
+		case "concat":
+			if len(def.Paths) == 0 {
+				fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".paths", Message: "must not be empty"})
+			}
+		case "count", "format_date":
+			if len(def.Paths) != 1 {
+				fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".paths", Message: "must have exactly one entry"})
+			}
+			if def.Op == "format_date" && dateFormats[def.Format] == "" {
+				fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".format", Message: `must be "date", "time", "datetime" or "rfc1123"`})
+			}
+		default:
+			fieldErrors = append(fieldErrors, FieldError{Field: prefix + ".op", Message: `must be "concat", "count" or "format_date"`})
+		}
+	}
+	return fieldErrors
+}
+
+// applyDerivations returns a copy of data with the derived fields added.
+// Derivations whose inputs are missing or of the wrong type are skipped.
+func applyDerivations(data map[string]interface{}, defs []Derivation) map[string]interface{} {
+	if len(defs) == 0 {
+		return data
+	}
+
+	derived := make(map[string]interface{}, len(data)+len(defs))
+	for k, v := range data {
+		derived[k] = v
+	}
+
+	for _, def := range defs {
+		values := make([]interface{}, 0, len(def.Paths))
+		for _, path := range def.Paths {
+			if value, ok := lookupPath(data, strings.TrimPrefix(path, "data.")); ok {
+				values = append(values, value)
+			}
+		}
+
+		switch def.Op {
+		case "concat":
+			parts := make([]string, 0, len(values))
+			for _, value := range values {
+				parts = append(parts, fmt.Sprint(value))
+			}
+			derived[def.Field] = strings.Join(parts, def.Separator)
+		case "count":
+			if len(values) == 1 {
+				if items, ok := asArray(values[0]); ok {
+					derived[def.Field] = len(items)
+				}
+			}
+		case "format_date":
+			if len(values) == 1 {
+				if s, ok := values[0].(string); ok {
+					if t, err := time.Parse(time.RFC3339, s); err == nil {
+						derived[def.Field] = t.UTC().Format(dateFormats[def.Format])
+					}
+				}
+			}
+		}
+	}
+	return derived
+}
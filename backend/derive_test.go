@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestValidateDerivations(t *testing.T) {
+	tooMany := make([]Derivation, maxDerivations+1)
+	tests := []struct {
+		name string
+		defs []Derivation
+		want []string
+	}{
+		{"none", nil, nil},
+		{"valid", []Derivation{
+			{Field: "full", Op: "concat", Paths: []string{"data.first", "data.last"}, Separator: " "},
+			{Field: "n", Op: "count", Paths: []string{"data.tags"}},
+			{Field: "day", Op: "format_date", Paths: []string{"data.at"}, Format: "date"},
+		}, nil},
+		{"too many", tooMany, []string{"derived"}},
+		{"bad field", []Derivation{{Field: "a.b", Op: "concat", Paths: []string{"data.x"}}}, []string{"derived[0].field"}},
+		{"duplicate field", []Derivation{
+			{Field: "a", Op: "concat", Paths: []string{"data.x"}},
+			{Field: "a", Op: "concat", Paths: []string{"data.y"}},
+		}, []string{"derived[1].field"}},
+		{"metadata path", []Derivation{{Field: "a", Op: "concat", Paths: []string{"name"}}}, []string{"derived[0].paths"}},
+		{"owner path", []Derivation{{Field: "a", Op: "concat", Paths: []string{"user_id"}}}, []string{"derived[0].paths"}},
+		{"id path", []Derivation{{Field: "a", Op: "concat", Paths: []string{"_id"}}}, []string{"derived[0].paths"}},
+		{"whole data", []Derivation{{Field: "a", Op: "count", Paths: []string{"data"}}}, []string{"derived[0].paths"}},
+		{"path after a data path", []Derivation{{Field: "a", Op: "concat", Paths: []string{"data.x", "deleted_at"}}}, []string{"derived[0].paths"}},
+		{"operator in path", []Derivation{{Field: "a", Op: "concat", Paths: []string{"data.$where"}}}, []string{"derived[0].paths"}},
+		{"concat without paths", []Derivation{{Field: "a", Op: "concat"}}, []string{"derived[0].paths"}},
+		{"count of two paths", []Derivation{{Field: "a", Op: "count", Paths: []string{"data.x", "data.y"}}}, []string{"derived[0].paths"}},
+		{"unknown date format", []Derivation{{Field: "a", Op: "format_date", Paths: []string{"data.x"}, Format: "iso"}}, []string{"derived[0].format"}},
+		{"unknown op", []Derivation{{Field: "a", Op: "sum", Paths: []string{"data.x"}}}, []string{"derived[0].op"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, fe := range validateDerivations(tt.defs) {
+				got = append(got, fe.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("validateDerivations fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDerivations(t *testing.T) {
+	const data = `{"first":"Ada","last":"Lovelace","age":36,"tags":["a","b"],"at":"2024-03-04T05:06:07+02:00","meta":{"n":1}}`
+	tests := []struct {
+		name string
+		def  Derivation
+		want string
+	}{
+		{"concat", Derivation{Field: "d", Op: "concat", Paths: []string{"data.first", "data.last"}, Separator: " "}, `"Ada Lovelace"`},
+		{"concat numbers", Derivation{Field: "d", Op: "concat", Paths: []string{"data.first", "data.age"}, Separator: "-"}, `"Ada-36"`},
+		{"concat skips missing", Derivation{Field: "d", Op: "concat", Paths: []string{"data.first", "data.middle"}}, `"Ada"`},
+		{"concat nested", Derivation{Field: "d", Op: "concat", Paths: []string{"data.meta.n"}}, `"1"`},
+		{"count", Derivation{Field: "d", Op: "count", Paths: []string{"data.tags"}}, `2`},
+		{"count of a non-array", Derivation{Field: "d", Op: "count", Paths: []string{"data.first"}}, ``},
+		{"format date", Derivation{Field: "d", Op: "format_date", Paths: []string{"data.at"}, Format: "datetime"}, `"2024-03-04T03:06:07Z"`},
+		{"format date of a non-date", Derivation{Field: "d", Op: "format_date", Paths: []string{"data.first"}, Format: "date"}, ``},
+		{"overrides stored field", Derivation{Field: "first", Op: "concat", Paths: []string{"data.last"}}, `"Lovelace"`},
+	}
+	for _, tt := range tests {
+		for _, stored := range []bool{false, true} {
+			name := tt.name
+			if stored {
+				name += " (stored)"
+			}
+			t.Run(name, func(t *testing.T) {
+				var input map[string]interface{}
+				if err := json.Unmarshal([]byte(data), &input); err != nil {
+					t.Fatal(err)
+				}
+				if stored {
+					input = storedData(t, input)
+				}
+				before, _ := json.Marshal(input)
+
+				derived := applyDerivations(input, []Derivation{tt.def})
+				got := ""
+				if value, ok := derived[tt.def.Field]; ok {
+					raw, _ := json.Marshal(value)
+					got = string(raw)
+				}
+				if got != tt.want {
+					t.Errorf("%s = %s, want %s", tt.def.Field, got, tt.want)
+				}
+				if after, _ := json.Marshal(input); string(after) != string(before) {
+					t.Errorf("applyDerivations modified its input: %s", after)
+				}
+			})
+		}
+	}
+}
+
+func TestDerivedFieldsAreNotStored(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(`{
+		"name": "person",
+		"data": {"first": "Ada", "last": "Lovelace"},
+		"derived": [{"field": "full", "op": "concat", "paths": ["data.first", "data.last"], "separator": " "}]
+	}`)), "u1")
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(createDocument, r)
+	var created struct {
+		Data JSONDocument `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&created)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", w.Code, w.Body)
+	}
+	id := created.Data.ID
+
+	read := func() map[string]interface{} {
+		r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents/"+id, nil), "u1")
+		w := serve(withID(getDocument), withPathParams(r, map[string]string{"id": id}))
+		var resp struct {
+			Data JSONDocument `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Data.Data
+	}
+	stored := func() map[string]interface{} {
+		var doc JSONDocument
+		docCollection.FindOne(c, bson.M{"_id": id}).Decode(&doc)
+		return doc.Data
+	}
+
+	if got := read()["full"]; got != "Ada Lovelace" {
+		t.Errorf("read full = %v, want Ada Lovelace", got)
+	}
+	if _, ok := stored()["full"]; ok {
+		t.Error("derived field was stored on create")
+	}
+
+	// The value follows its inputs on the next read
+	docCollection.UpdateOne(c, bson.M{"_id": id}, bson.M{"$set": bson.M{"data.first": "Augusta"}})
+	if got := read()["full"]; got != "Augusta Lovelace" {
+		t.Errorf("read full after update = %v, want Augusta Lovelace", got)
+	}
+	if _, ok := stored()["full"]; ok {
+		t.Error("derived field was stored on read")
+	}
+}
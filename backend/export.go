@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errForbiddenAddress = errors.New("destination address is not allowed")
+
+// exportClient sends exports to user-supplied URLs. Every connection is
+// checked after DNS resolution so private and loopback addresses cannot be
+// reached, and redirects are not followed.
+var exportClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !exportAddressAllowed(ip) {
+					return errForbiddenAddress
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func exportAddressAllowed(ip net.IP) bool {
+	if config.ExportAllowPrivate {
+		return true
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast())
+}
+
+// validateExportURL checks scheme and the optional host allowlist
+func validateExportURL(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if err != nil || target.Host == "" {
+		return nil, errors.New("url must be an absolute http(s) URL")
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, errors.New("url must use http or https")
+	}
+	if target.User != nil {
+		return nil, errors.New("url must not contain credentials; use headers instead")
+	}
+	if len(config.ExportAllowedHosts) > 0 {
+		host := strings.ToLower(target.Hostname())
+		for _, allowed := range config.ExportAllowedHosts {
+			if host == strings.ToLower(allowed) {
+				return target, nil
+			}
+		}
+		return nil, errors.New("url host is not in the export allowlist")
+	}
+	return target, nil
+}
+
+// Push all of the user's documents to an external sink as JSON Lines
+func exportPush(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	var input struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	}
+
+	body, ok := readBody(w, r, 64<<10)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	target, err := validateExportURL(input.URL)
+	if err != nil {
+		sendValidationError(w, []FieldError{{Field: "url", Message: err.Error()}})
+		return
+	}
+
+	filter := live(bson.M{})
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	// The cursor lives as long as the push, so both share EXPORT_TIMEOUT
+	reqCtx, cancel := context.WithTimeout(r.Context(), config.ExportTimeout)
+	defer cancel()
+
+	cursor, err := docCollection.Find(reqCtx, filter)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to read documents"})
+		return
+	}
+	defer cursor.Close(reqCtx)
+
+	// Stream documents straight from the cursor into the request body
+	pr, pw := io.Pipe()
+	streamed := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		enc := json.NewEncoder(pw)
+		for cursor.Next(reqCtx) {
+			var doc JSONDocument
+			if err := cursor.Decode(&doc); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if err := enc.Encode(doc); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			streamed++
+		}
+		pw.CloseWithError(cursor.Err())
+	}()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, target.String(), pr)
+	if err != nil {
+		pr.Close()
+		<-done
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid export request"})
+		return
+	}
+	for name, value := range input.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := exportClient.Do(req)
+	pr.Close()
+	<-done
+	if err != nil {
+		msg := "Failed to deliver export"
+		if errors.Is(err, errForbiddenAddress) {
+			msg = "Export destination resolves to a forbidden address"
+		}
+		sendJSON(w, http.StatusBadGateway, APIResponse{Success: false, Error: msg})
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	data := map[string]interface{}{
+		"streamed":    streamed,
+		"sink_status": resp.StatusCode,
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		sendJSON(w, http.StatusBadGateway, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Export sink responded with status %d", resp.StatusCode),
+			Data:    data,
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Export delivered", Data: data})
+}
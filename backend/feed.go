@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// feedItem is one entry of a document shaped as a feed
+type feedItem struct {
+	Title       string
+	Link        string
+	Description string
+	Date        time.Time
+}
+
+// rssFeed is an RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description,omitempty"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+}
+
+// atomFeed is an Atom 1.0 document
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+// parseFeed checks that data has a feed shape: a title and an items array
+// whose entries each have a title, link and RFC 3339 date
+func parseFeed(data map[string]interface{}) (title, link, description string, items []feedItem, err error) {
+	title, _ = data["title"].(string)
+	if title == "" {
+		return "", "", "", nil, errors.New("data.title must be a non-empty string")
+	}
+	link, _ = data["link"].(string)
+	description, _ = data["description"].(string)
+
+	rawItems, ok := asArray(data["items"])
+	if !ok {
+		return "", "", "", nil, errors.New("data.items must be an array")
+	}
+
+	for i, raw := range rawItems {
+		entry, ok := raw.(map[string]interface{})
+		if !ok {
+			return "", "", "", nil, fmt.Errorf("data.items[%d] must be an object", i)
+		}
+		item := feedItem{}
+		item.Title, _ = entry["title"].(string)
+		item.Link, _ = entry["link"].(string)
+		item.Description, _ = entry["description"].(string)
+		date, _ := entry["date"].(string)
+		if item.Title == "" || item.Link == "" {
+			return "", "", "", nil, fmt.Errorf("data.items[%d] needs a title and link", i)
+		}
+		if item.Date, err = time.Parse(time.RFC3339, date); err != nil {
+			return "", "", "", nil, fmt.Errorf("data.items[%d].date must be an RFC 3339 timestamp", i)
+		}
+		items = append(items, item)
+	}
+	return title, link, description, items, nil
+}
+
+// Serve a public document as an RSS 2.0 (default) or Atom (?format=atom) feed
+func feedHandler(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	err := docCollection.FindOne(c, publicFilter(id)).Decode(&doc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if !allowPublicRead(w, doc) {
+		return
+	}
+
+	title, link, description, items, err := parseFeed(publicData(doc))
+	if err != nil {
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{Success: false, Error: "Document is not a feed: " + err.Error()})
+		return
+	}
+
+	var feed interface{}
+	contentType := "application/rss+xml; charset=utf-8"
+
+	if r.URL.Query().Get("format") == "atom" {
+		contentType = "application/atom+xml; charset=utf-8"
+		atom := atomFeed{
+			Title:   title,
+			ID:      "urn:uuid:" + doc.ID,
+			Updated: doc.UpdatedAt.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+		}
+		for _, item := range items {
+			atom.Entries = append(atom.Entries, atomEntry{
+				Title:   item.Title,
+				ID:      item.Link,
+				Updated: item.Date.UTC().Format(time.RFC3339),
+				Link:    atomLink{Href: item.Link},
+				Summary: item.Description,
+			})
+		}
+		feed = atom
+	} else {
+		rss := rssFeed{Version: "2.0", Channel: rssChannel{Title: title, Link: link, Description: description}}
+		for _, item := range items {
+			rss.Channel.Items = append(rss.Channel.Items, rssItem{
+				Title:       item.Title,
+				Link:        item.Link,
+				Description: item.Description,
+				GUID:        item.Link,
+				PubDate:     item.Date.UTC().Format(time.RFC1123Z),
+			})
+		}
+		feed = rss
+	}
+
+	output, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to render feed"})
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", publicCacheControl())
+	w.Write([]byte(xml.Header))
+	w.Write(output)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testFeed = `{"title":"Blog","link":"https://example.com","description":"Posts",
+	"items":[{"title":"First","link":"https://example.com/1","date":"2024-01-02T03:04:05Z"}]}`
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		items   int
+		wantErr string
+	}{
+		{"valid", testFeed, 1, ""},
+		{"no items", `{"title":"Blog","items":[]}`, 0, ""},
+		{"missing title", `{"items":[]}`, 0, "data.title must be a non-empty string"},
+		{"items not an array", `{"title":"Blog","items":{}}`, 0, "data.items must be an array"},
+		{"item not an object", `{"title":"Blog","items":[1]}`, 0, "data.items[0] must be an object"},
+		{"item without link", `{"title":"Blog","items":[{"title":"a","date":"2024-01-02T03:04:05Z"}]}`, 0, "data.items[0] needs a title and link"},
+		{"item with bad date", `{"title":"Blog","items":[{"title":"a","link":"b","date":"yesterday"}]}`, 0, "data.items[0].date must be an RFC 3339 timestamp"},
+	}
+	for _, tt := range tests {
+		for _, stored := range []bool{false, true} {
+			name := tt.name
+			if stored {
+				name += " (stored)"
+			}
+			t.Run(name, func(t *testing.T) {
+				var data map[string]interface{}
+				if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+					t.Fatal(err)
+				}
+				if stored {
+					data = storedData(t, data)
+				}
+				_, _, _, items, err := parseFeed(data)
+				if tt.wantErr != "" {
+					if err == nil || err.Error() != tt.wantErr {
+						t.Fatalf("error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(items) != tt.items {
+					t.Errorf("items = %d, want %d", len(items), tt.items)
+				}
+			})
+		}
+	}
+}
+
+func TestFeedHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		contentType string
+		root        string
+	}{
+		{"rss", "", "application/rss+xml; charset=utf-8", "rss"},
+		{"atom", "atom", "application/atom+xml; charset=utf-8", "feed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			var data map[string]interface{}
+			json.Unmarshal([]byte(testFeed), &data)
+			docCollection.InsertOne(context.Background(), JSONDocument{ID: "f", UserID: "u1", Name: "f", Data: data, IsPublic: true})
+
+			r := httptest.NewRequest(http.MethodGet, "/public/f/feed?format="+tt.format, nil)
+			w := serve(withID(feedHandler), withPathParams(r, map[string]string{"id": "f"}))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			var root struct{ XMLName xml.Name }
+			if err := xml.NewDecoder(strings.NewReader(w.Body.String())).Decode(&root); err != nil {
+				t.Fatal(err)
+			}
+			if root.XMLName.Local != tt.root {
+				t.Errorf("root element = %q, want %q", root.XMLName.Local, tt.root)
+			}
+			if !strings.Contains(w.Body.String(), "https://example.com/1") {
+				t.Errorf("feed is missing the item link: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestFeedHandlerVisibility(t *testing.T) {
+	setupTestDB(t)
+	var data map[string]interface{}
+	json.Unmarshal([]byte(testFeed), &data)
+	ids := insertVisibilityDocs(t, JSONDocument{UserID: "u1", Name: "f", Data: data})
+	for id, want := range ids {
+		r := httptest.NewRequest(http.MethodGet, "/public/"+id+"/feed.xml", nil)
+		w := serve(withID(feedHandler), withPathParams(r, map[string]string{"id": id}))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", id, w.Code, want)
+		}
+	}
+}
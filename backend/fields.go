@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fieldSelector is a parsed ?fields= value. Each key maps to the selection
+// applied to that member; a nil selector keeps the member whole.
+type fieldSelector map[string]fieldSelector
+
+// parseFieldSelector parses selections such as "name,data(a,b)" or the
+// equivalent dotted form "name,data.a,data.b"
+func parseFieldSelector(value string) (fieldSelector, error) {
+	sel, rest, err := parseSelectorList(value)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return sel, nil
+}
+
+func parseSelectorList(s string) (fieldSelector, string, error) {
+	sel := fieldSelector{}
+	for {
+		end := strings.IndexAny(s, ",()")
+		if end < 0 {
+			end = len(s)
+		}
+		name := strings.TrimSpace(s[:end])
+		s = s[end:]
+		if name == "" {
+			return nil, s, errors.New("empty field name")
+		}
+
+		var child fieldSelector
+		if strings.HasPrefix(s, "(") {
+			var err error
+			child, s, err = parseSelectorList(s[1:])
+			if err != nil {
+				return nil, s, err
+			}
+			if !strings.HasPrefix(s, ")") {
+				return nil, s, errors.New("unbalanced parentheses")
+			}
+			s = s[1:]
+		}
+		path := strings.Split(name, ".")
+		for _, part := range path {
+			if part == "" {
+				return nil, s, errors.New("empty field name")
+			}
+		}
+		sel.add(path, child)
+
+		if !strings.HasPrefix(s, ",") {
+			return sel, s, nil
+		}
+		s = s[1:]
+	}
+}
+
+// add merges a dotted path and its child selection into the selector
+func (sel fieldSelector) add(path []string, child fieldSelector) {
+	key := path[0]
+	existing, exists := sel[key]
+	if exists && existing == nil {
+		return // already selected whole
+	}
+	if len(path) == 1 && child == nil {
+		sel[key] = nil
+		return
+	}
+
+	if existing == nil {
+		existing = fieldSelector{}
+		sel[key] = existing
+	}
+	if len(path) > 1 {
+		existing.add(path[1:], child)
+		return
+	}
+	for k, v := range child {
+		existing.add([]string{k}, v)
+	}
+}
+
+// apply keeps only the selected members. Arrays are shaped element-wise
+// and scalars pass through untouched.
+func (sel fieldSelector) apply(value interface{}) interface{} {
+	if sel == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		shaped := make(map[string]interface{}, len(sel))
+		for key, child := range sel {
+			if member, ok := v[key]; ok {
+				shaped[key] = child.apply(member)
+			}
+		}
+		return shaped
+	case []interface{}:
+		shaped := make([]interface{}, len(v))
+		for i, item := range v {
+			shaped[i] = sel.apply(item)
+		}
+		return shaped
+	}
+	return value
+}
+
+// documentMembers maps the JSON names of a document's members, besides id
+// and data, to where they're stored. It's read from JSONDocument's tags so
+// a new member can be selected without being listed here.
+var documentMembers = func() map[string]string {
+	members := map[string]string{}
+	t := reflect.TypeOf(JSONDocument{})
+	for i := 0; i < t.NumField(); i++ {
+		jsonName, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		bsonName, _, _ := strings.Cut(t.Field(i).Tag.Get("bson"), ",")
+		if jsonName == "-" || jsonName == "id" || jsonName == "data" {
+			continue
+		}
+		members[jsonName] = bsonName
+	}
+	return members
+}()
+
+// documentProjection turns a ?fields= selection into a projection for
+// reading one document, so data that wasn't asked for is never loaded.
+// Paths that can't be projected are skipped; fieldsMiddleware still trims
+// the response to exactly the selection. It returns nil when nothing can be
+// projected.
+func documentProjection(raw string) bson.M {
+	sel, err := parseFieldSelector(raw)
+	if raw == "" || err != nil {
+		return nil
+	}
+
+	projection := bson.M{}
+	for key, child := range sel {
+		switch {
+		case key == "id":
+			projection["_id"] = 1
+		case key == "data":
+			child.projectPaths("data", projection)
+		case documentMembers[key] != "":
+			projection[documentMembers[key]] = 1
+		}
+	}
+	if len(projection) == 0 {
+		return nil
+	}
+	// Derivations are needed to tell whether the projection is enough
+	projection["derived"] = 1
+	return projection
+}
+
+// projectPaths adds the selected data paths below prefix to projection
+func (sel fieldSelector) projectPaths(prefix string, projection bson.M) {
+	if sel == nil {
+		projection[prefix] = 1
+		return
+	}
+	for key, child := range sel {
+		if path := prefix + "." + key; isQueryableField(path) {
+			child.projectPaths(path, projection)
+		}
+	}
+}
+
+// bufferedResponse captures a handler's response so it can be rewritten
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
+
+// Fields middleware - trims JSON responses to the members named in
+// ?fields=. For APIResponse envelopes the selection applies to "data";
+// other JSON bodies (e.g. /public/) are shaped directly. Handlers that do
+// their own projection run first, so this selection always applies last.
+func fieldsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.URL.Query().Get("fields")
+		if raw == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sel, err := parseFieldSelector(raw)
+		if err != nil {
+			sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid fields selector: " + err.Error()})
+			return
+		}
+
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		body := buf.body.Bytes()
+		if strings.HasPrefix(buf.header.Get("Content-Type"), "application/json") && buf.status < 300 && buf.status != http.StatusPartialContent {
+			// Numbers pass through as written, so integers above 2^53
+			// aren't rounded to float64
+			var decoded interface{}
+			dec := json.NewDecoder(bytes.NewReader(body))
+			dec.UseNumber()
+			if err := dec.Decode(&decoded); err == nil {
+				if envelope, ok := decoded.(map[string]interface{}); ok && envelope["success"] == true {
+					if data, ok := envelope["data"]; ok {
+						envelope["data"] = sel.apply(data)
+					}
+				} else {
+					decoded = sel.apply(decoded)
+				}
+				if shaped, err := json.Marshal(decoded); err == nil {
+					body = append(shaped, '\n')
+					w.Header().Del("Content-Length")
+				}
+			}
+		}
+
+		w.WriteHeader(buf.status)
+		w.Write(body)
+	})
+}
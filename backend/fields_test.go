@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseFieldSelector(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    fieldSelector
+		wantErr bool
+	}{
+		{"name", fieldSelector{"name": nil}, false},
+		{"name, id", fieldSelector{"name": nil, "id": nil}, false},
+		{"data(a,b)", fieldSelector{"data": {"a": nil, "b": nil}}, false},
+		{"data.a,data.b", fieldSelector{"data": {"a": nil, "b": nil}}, false},
+		{"data(a(b)),data.a.c", fieldSelector{"data": {"a": {"b": nil, "c": nil}}}, false},
+		{"data,data.a", fieldSelector{"data": nil}, false},
+		{"data.a,data", fieldSelector{"data": nil}, false},
+		{"", nil, true},
+		{"name,", nil, true},
+		{"data(a", nil, true},
+		{"data)a", nil, true},
+		{"data()", nil, true},
+		{"data.", nil, true},
+		{"data..a", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseFieldSelector(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFieldSelector(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFieldSelector(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldSelectorApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		value    string
+		want     string
+	}{
+		{"top-level members", "id,name", `{"id":"1","name":"n","data":{"a":1}}`, `{"id":"1","name":"n"}`},
+		{"nested members", "data(a)", `{"id":"1","data":{"a":1,"b":2}}`, `{"data":{"a":1}}`},
+		{"missing members omitted", "name,missing", `{"name":"n"}`, `{"name":"n"}`},
+		{"arrays shaped element-wise", "id", `[{"id":1,"x":2},{"id":3}]`, `[{"id":1},{"id":3}]`},
+		{"scalars pass through", "data(a)", `{"data":5}`, `{"data":5}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := parseFieldSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(sel.apply(value))
+			if string(got) != tt.want {
+				t.Errorf("apply = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldsMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		body   interface{}
+		status int
+		want   string
+	}{
+		{"no selector", "", APIResponse{Success: true, Data: map[string]interface{}{"a": 1, "b": 2}}, http.StatusOK,
+			`{"success":true,"data":{"a":1,"b":2}}`},
+		{"envelope data", "?fields=a", APIResponse{Success: true, Data: map[string]interface{}{"a": 1, "b": 2}}, http.StatusOK,
+			`{"data":{"a":1},"success":true}`},
+		{"bare body", "?fields=a", map[string]interface{}{"a": 1, "b": 2}, http.StatusOK,
+			`{"a":1}`},
+		{"errors untouched", "?fields=a", APIResponse{Success: false, Error: "nope"}, http.StatusNotFound,
+			`{"success":false,"error":"nope"}`},
+		{"invalid selector", "?fields=a(", nil, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := fieldsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if resp, ok := tt.body.(APIResponse); ok {
+					sendJSON(w, tt.status, resp)
+					return
+				}
+				sendJSON(w, tt.status, tt.body)
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/documents"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.want == "" {
+				return
+			}
+			var got, want interface{}
+			json.Unmarshal(w.Body.Bytes(), &got)
+			json.Unmarshal([]byte(tt.want), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("body = %s, want %s", w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldsMiddlewareKeepsNumbers(t *testing.T) {
+	h := fieldsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"success":true,"data":{"big":9007199254740993,"exp":1.5e300,"small":0.1,"other":1}}`))
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/documents?fields=big,exp,small", nil))
+	want := `{"data":{"big":9007199254740993,"exp":1.5e300,"small":0.1},"success":true}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("body = %s, want %s", w.Body, want)
+	}
+}
+
+func TestDocumentMembers(t *testing.T) {
+	for _, member := range []string{"user_id", "name", "client_key", "inferred_schema", "public_fields", "updated_at"} {
+		if documentMembers[member] != member {
+			t.Errorf("documentMembers[%q] = %q, want %q", member, documentMembers[member], member)
+		}
+	}
+	// id and data are projected on their own, and content_hash is never sent
+	for _, member := range []string{"id", "data", "content_hash", "ContentHash"} {
+		if _, ok := documentMembers[member]; ok {
+			t.Errorf("documentMembers has %q", member)
+		}
+	}
+}
+
+func TestDocumentProjection(t *testing.T) {
+	tests := []struct {
+		fields string
+		want   bson.M
+	}{
+		{"", nil},
+		{"data(", nil},
+		{"unknown", nil},
+		{"id", bson.M{"_id": 1, "derived": 1}},
+		{"name,created_at", bson.M{"name": 1, "created_at": 1, "derived": 1}},
+		{"client_key,is_public", bson.M{"client_key": 1, "is_public": 1, "derived": 1}},
+		{"data", bson.M{"data": 1, "derived": 1}},
+		{"data(a,b)", bson.M{"data.a": 1, "data.b": 1, "derived": 1}},
+		{"data.a.b", bson.M{"data.a.b": 1, "derived": 1}},
+		{"data(a(b,c)),name", bson.M{"data.a.b": 1, "data.a.c": 1, "name": 1, "derived": 1}},
+		// The whole member wins over a path inside it
+		{"data(a),data", bson.M{"data": 1, "derived": 1}},
+		// Paths that can't be queried are left to fieldsMiddleware
+		{"data($where)", nil},
+		{"data(a,$x),id", bson.M{"data.a": 1, "_id": 1, "derived": 1}},
+	}
+	for _, tt := range tests {
+		if got := documentProjection(tt.fields); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("documentProjection(%q) = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validateGeoPoint checks that the configured geo field, when present in
+// data, is a GeoJSON Point with in-range coordinates
+func validateGeoPoint(data map[string]interface{}) []FieldError {
+	if config.GeoField == "" {
+		return nil
+	}
+
+	value, ok := lookupPath(data, strings.TrimPrefix(config.GeoField, "data."))
+	if !ok || value == nil {
+		return nil
+	}
+
+	invalid := []FieldError{{Field: config.GeoField, Message: `must be a GeoJSON Point {"type":"Point","coordinates":[lng,lat]}`}}
+
+	point, ok := value.(map[string]interface{})
+	if !ok || point["type"] != "Point" {
+		return invalid
+	}
+	coords, ok := asArray(point["coordinates"])
+	if !ok || len(coords) != 2 {
+		return invalid
+	}
+	lng, lngOK := coords[0].(float64)
+	lat, latOK := coords[1].(float64)
+	if !lngOK || !latOK || !validCoordinates(lng, lat) {
+		return invalid
+	}
+	return nil
+}
+
+func validCoordinates(lng, lat float64) bool {
+	return lng >= -180 && lng <= 180 && lat >= -90 && lat <= 90
+}
+
+// Find documents near a point, sorted by distance
+func nearDocuments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	query := r.URL.Query()
+
+	if config.GeoField == "" {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Geospatial queries are not enabled"})
+		return
+	}
+
+	lng, lngErr := strconv.ParseFloat(query.Get("lng"), 64)
+	lat, latErr := strconv.ParseFloat(query.Get("lat"), 64)
+	meters, metersErr := strconv.ParseFloat(query.Get("meters"), 64)
+	if lngErr != nil || latErr != nil || !validCoordinates(lng, lat) {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "lng must be within [-180, 180] and lat within [-90, 90]"})
+		return
+	}
+	if metersErr != nil || meters <= 0 {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "meters must be a positive number"})
+		return
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	scope := live(bson.M{})
+	if userID != "global" {
+		scope["user_id"] = userID
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$geoNear", Value: bson.M{
+			"near":          bson.M{"type": "Point", "coordinates": bson.A{lng, lat}},
+			"key":           config.GeoField,
+			"distanceField": "distance",
+			"maxDistance":   meters,
+			"spherical":     true,
+			"query":         scope,
+		}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	cursor, err := docCollection.Aggregate(c, pipeline)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to query nearby documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	results := []struct {
+		JSONDocument `bson:",inline"`
+		Distance     float64 `json:"distance_meters" bson:"distance"`
+	}{}
+	if err := cursor.All(c, &results); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: results})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestValidateGeoPoint(t *testing.T) {
+	saved := config.GeoField
+	t.Cleanup(func() { config.GeoField = saved })
+	config.GeoField = "data.location"
+
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"valid point", `{"location":{"type":"Point","coordinates":[-0.12,51.5]}}`, true},
+		{"field absent", `{"title":"x"}`, true},
+		{"field null", `{"location":null}`, true},
+		{"edge coordinates", `{"location":{"type":"Point","coordinates":[180,-90]}}`, true},
+		{"longitude out of range", `{"location":{"type":"Point","coordinates":[181,0]}}`, false},
+		{"latitude out of range", `{"location":{"type":"Point","coordinates":[0,91]}}`, false},
+		{"not a point", `{"location":{"type":"LineString","coordinates":[0,0]}}`, false},
+		{"three coordinates", `{"location":{"type":"Point","coordinates":[0,0,0]}}`, false},
+		{"string coordinates", `{"location":{"type":"Point","coordinates":["0","0"]}}`, false},
+		{"not an object", `{"location":[0,0]}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(validateGeoPoint(data)) == 0; got != tt.valid {
+				t.Errorf("valid = %v, want %v", got, tt.valid)
+			}
+		})
+	}
+
+	config.GeoField = ""
+	if errs := validateGeoPoint(map[string]interface{}{"location": "anything"}); errs != nil {
+		t.Errorf("disabled geo field still validated: %v", errs)
+	}
+}
+
+func TestNearDocumentsParameters(t *testing.T) {
+	saved := config.GeoField
+	t.Cleanup(func() { config.GeoField = saved })
+
+	tests := []struct {
+		name   string
+		field  string
+		query  string
+		status int
+	}{
+		{"disabled", "", "?lng=0&lat=0&meters=10", http.StatusNotFound},
+		{"missing coordinates", "data.location", "?meters=10", http.StatusBadRequest},
+		{"latitude out of range", "data.location", "?lng=0&lat=95&meters=10", http.StatusBadRequest},
+		{"missing distance", "data.location", "?lng=0&lat=0", http.StatusBadRequest},
+		{"negative distance", "data.location", "?lng=0&lat=0&meters=-5", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.GeoField = tt.field
+			r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents/near"+tt.query, nil), "u1")
+			if w := serve(nearDocuments, r); w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestNearDocuments(t *testing.T) {
+	setupTestDB(t)
+	saved := config.GeoField
+	t.Cleanup(func() { config.GeoField = saved })
+	config.GeoField = "data.location"
+
+	c := context.Background()
+	if _, err := docCollection.Indexes().CreateOne(c, mongo.IndexModel{Keys: bson.D{{Key: config.GeoField, Value: "2dsphere"}}}); err != nil {
+		t.Fatal(err)
+	}
+	point := func(lat float64) map[string]interface{} {
+		return map[string]interface{}{"location": map[string]interface{}{"type": "Point", "coordinates": bson.A{0.0, lat}}}
+	}
+	trashed := time.Now().UTC()
+	for _, doc := range []JSONDocument{
+		// A degree of latitude is about 111km
+		{ID: "far", UserID: "u1", Name: "far", Data: point(1)},
+		{ID: "1km", UserID: "u1", Name: "1km", Data: point(0.01)},
+		{ID: "100m", UserID: "u1", Name: "100m", Data: point(0.001)},
+		{ID: "other-user", UserID: "u2", Name: "other-user", Data: point(0.0005)},
+		{ID: "trashed", UserID: "u1", Name: "trashed", Data: point(0.0002), DeletedAt: &trashed},
+		{ID: "no-location", UserID: "u1", Name: "no-location", Data: map[string]interface{}{}},
+	} {
+		docCollection.InsertOne(c, doc)
+	}
+
+	r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents/near?lng=0&lat=0&meters=2000", nil), "u1")
+	w := serve(nearDocuments, r)
+	var resp struct {
+		Data []struct {
+			ID       string  `json:"id"`
+			Distance float64 `json:"distance_meters"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || len(resp.Data) != 2 {
+		t.Fatalf("status %d, %d documents; want 200 with 2: %+v", w.Code, len(resp.Data), resp.Data)
+	}
+	if resp.Data[0].ID != "100m" || resp.Data[1].ID != "1km" {
+		t.Errorf("order = %s, %s; want nearest first", resp.Data[0].ID, resp.Data[1].ID)
+	}
+	if d := resp.Data[0].Distance; d < 100 || d > 120 {
+		t.Errorf("distance to 100m = %v meters", d)
+	}
+}
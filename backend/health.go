@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// isHealthPath reports whether path is one of the health checks, which stay
+// reachable over plain HTTP and while the database breaker is open
+func isHealthPath(path string) bool {
+	return path == "/health" || path == "/health/live" || path == "/health/ready"
+}
+
+// pingDatabase round-trips a ping to the MongoDB primary, bounded by
+// HEALTH_TIMEOUT, and returns how long it took
+func pingDatabase(parent context.Context) (time.Duration, error) {
+	c, cancel := context.WithCancel(parent)
+	if config.HealthTimeout > 0 {
+		c, cancel = context.WithTimeout(parent, config.HealthTimeout)
+	}
+	defer cancel()
+
+	start := time.Now()
+	err := docCollection.Database().Client().Ping(c, readpref.Primary())
+	return time.Since(start), err
+}
+
+// Liveness handler - the process is up and serving. It never touches the
+// database, so an orchestrator won't restart the server over a Mongo outage.
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "JSON API Server is running",
+		Data:    map[string]interface{}{"timestamp": time.Now().UTC()},
+	})
+}
+
+// Readiness handler - pings MongoDB and answers 503 when it can't be
+// reached, so traffic is held back until the database is. Also served at
+// /health.
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	latency, err := pingDatabase(r.Context())
+
+	data := map[string]interface{}{
+		"version":    "1.1.0",
+		"storage":    "mongodb",
+		"auth":       "email",
+		"database":   dbBreaker.State(),
+		"latency_ms": float64(latency.Microseconds()) / 1000,
+		"timestamp":  time.Now().UTC(),
+		// Clients can size uploads without probing for a 413
+		"limits": map[string]interface{}{
+			"max_body_bytes":   config.MaxBodyBytes,
+			"max_data_bytes":   config.MaxDataBytes,
+			"max_auth_bytes":   config.MaxAuthBytes,
+			"upload_max_bytes": config.UploadMaxBytes,
+		},
+	}
+	if err != nil {
+		data["database"] = "unreachable"
+		sendJSON(w, http.StatusServiceUnavailable, APIResponse{
+			Success: false,
+			Error:   "Database unreachable",
+			Data:    data,
+		})
+		return
+	}
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "JSON API Server is running",
+		Data:    data,
+	})
+}
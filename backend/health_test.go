@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type healthResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Database  string           `json:"database"`
+		LatencyMS *float64         `json:"latency_ms"`
+		Limits    map[string]int64 `json:"limits"`
+	} `json:"data"`
+}
+
+func checkHealth(t *testing.T, handler http.HandlerFunc, path string) (int, healthResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var resp healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, resp
+}
+
+func TestLivenessHandler(t *testing.T) {
+	// Liveness never reaches the database, so no collection is needed
+	saved := docCollection
+	t.Cleanup(func() { docCollection = saved })
+	docCollection = nil
+
+	if code, resp := checkHealth(t, livenessHandler, "/health/live"); code != http.StatusOK || !resp.Success {
+		t.Errorf("status = %d, success = %v, want 200, true", code, resp.Success)
+	}
+}
+
+func TestReadinessUnreachable(t *testing.T) {
+	savedCollection, savedTimeout := docCollection, config.HealthTimeout
+	t.Cleanup(func() { docCollection, config.HealthTimeout = savedCollection, savedTimeout })
+	config.HealthTimeout = 200 * time.Millisecond
+
+	// Nothing listens on port 1, so server selection fails until the timeout
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	docCollection = client.Database("health").Collection("documents")
+
+	start := time.Now()
+	code, resp := checkHealth(t, readinessHandler, "/health/ready")
+	if code != http.StatusServiceUnavailable || resp.Success || resp.Data.Database != "unreachable" {
+		t.Errorf("status = %d, success = %v, database = %q, want 503, false, unreachable", code, resp.Success, resp.Data.Database)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("readiness took %s, want it bounded by HEALTH_TIMEOUT", elapsed)
+	}
+	if resp.Data.LatencyMS == nil {
+		t.Error("latency_ms missing")
+	}
+	want := map[string]int64{
+		"max_body_bytes": config.MaxBodyBytes,
+		"max_data_bytes": int64(config.MaxDataBytes),
+		"max_auth_bytes": config.MaxAuthBytes,
+	}
+	for key, value := range want {
+		if resp.Data.Limits[key] != value {
+			t.Errorf("limits.%s = %d, want %d", key, resp.Data.Limits[key], value)
+		}
+	}
+}
+
+func TestReadinessReachable(t *testing.T) {
+	setupTestDB(t)
+
+	for _, path := range []string{"/health", "/health/ready"} {
+		code, resp := checkHealth(t, readinessHandler, path)
+		if code != http.StatusOK || !resp.Success || resp.Data.LatencyMS == nil {
+			t.Errorf("%s: status = %d, success = %v, latency_ms = %v, want 200 with a latency", path, code, resp.Success, resp.Data.LatencyMS)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Idempotency keys and client keys are opaque printable tokens
+var syncKeyPattern = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+// idempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key. Status is 0 while the first attempt is still running;
+// that attempt holds the key until LeaseExpires.
+type idempotencyRecord struct {
+	ID           string    `bson:"_id"`
+	UserID       string    `bson:"user_id"`
+	RequestHash  string    `bson:"request_hash"`
+	Status       int       `bson:"status"`
+	Location     string    `bson:"location,omitempty"`
+	Token        string    `bson:"creation_token,omitempty"`
+	Body         []byte    `bson:"body,omitempty"`
+	CreatedAt    time.Time `bson:"created_at"`
+	LeaseExpires time.Time `bson:"lease_expires"`
+}
+
+// idempotencyLease is how long an attempt holds its key. A request's
+// database work is bounded by DB_TIMEOUT, so an attempt still in progress
+// after twice that is taken to have died with its process.
+func idempotencyLease() time.Duration {
+	if config.DBTimeout > 0 {
+		return 2 * config.DBTimeout
+	}
+	return time.Minute
+}
+
+// requestHash fingerprints a request so a key reused for a different
+// request is caught
+func requestHash(r *http.Request, body []byte) string {
+	sum := sha256.New()
+	io.WriteString(sum, r.Method+" "+r.URL.Path+"\n")
+	sum.Write(body)
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// Idempotency - with an Idempotency-Key header, the first response is kept
+// for IDEMPOTENCY_TTL and retries with the same key and body get it back
+// unchanged, marked Idempotent-Replayed. Server errors are not kept, so a
+// retry after one runs the request again.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if !syncKeyPattern.MatchString(key) {
+			sendValidationError(w, []FieldError{{Field: "Idempotency-Key", Message: "must be 1 to 255 printable ASCII characters"}})
+			return
+		}
+
+		// The handler enforces the size limit on the replayed body
+		body, err := io.ReadAll(io.LimitReader(r.Body, config.MaxBodyBytes+1))
+		if err != nil {
+			sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Failed to read request body"})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := getUserID(r)
+		// Stored times keep milliseconds, and the lease is matched exactly
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		record := idempotencyRecord{
+			ID:           userID + ":" + key,
+			UserID:       userID,
+			RequestHash:  requestHash(r, body),
+			CreatedAt:    now,
+			LeaseExpires: now.Add(idempotencyLease()),
+		}
+		c, cancel := dbContext(r.Context())
+		defer cancel()
+		_, err = idempotencyCollection.InsertOne(c, record)
+		dbBreaker.Record(err)
+		if mongo.IsDuplicateKeyError(err) {
+			var claimed bool
+			claimed, err = takeOverStaleAttempt(c, record)
+			if err == nil && !claimed {
+				replayIdempotent(c, w, record)
+				return
+			}
+		}
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to record idempotency key"})
+			return
+		}
+
+		buf := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+		next(buf, r)
+
+		// The outcome is kept even if the client has gone, or the key would
+		// stay in progress until the lease runs out. An attempt whose key
+		// was taken over after its lease leaves the record to the new one.
+		save, cancelSave := dbContext(context.WithoutCancel(r.Context()))
+		defer cancelSave()
+		held := bson.M{"_id": record.ID, "lease_expires": record.LeaseExpires}
+		if buf.status >= http.StatusInternalServerError {
+			_, err = idempotencyCollection.DeleteOne(save, held)
+		} else {
+			_, err = idempotencyCollection.UpdateOne(save, held, bson.M{"$set": bson.M{
+				"status":         buf.status,
+				"location":       w.Header().Get("Location"),
+				"creation_token": w.Header().Get("Creation-Token"),
+				"body":           buf.body.Bytes(),
+			}})
+		}
+		dbBreaker.Record(err)
+
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	}
+}
+
+// takeOverStaleAttempt claims the key for attempt when the request that
+// first used it is still in progress after its lease, so a retry runs the
+// request again instead of getting 409 until the record expires
+func takeOverStaleAttempt(c context.Context, attempt idempotencyRecord) (bool, error) {
+	result, err := idempotencyCollection.UpdateOne(c, bson.M{
+		"_id":          attempt.ID,
+		"request_hash": attempt.RequestHash,
+		"status":       0,
+		"$or": bson.A{
+			bson.M{"lease_expires": bson.M{"$lte": attempt.CreatedAt}},
+			// Attempts recorded before leases were
+			bson.M{"lease_expires": bson.M{"$exists": false}, "created_at": bson.M{"$lte": attempt.CreatedAt.Add(-idempotencyLease())}},
+		},
+	}, bson.M{"$set": bson.M{"lease_expires": attempt.LeaseExpires}})
+	dbBreaker.Record(err)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// replayIdempotent answers a retry with the stored response of the request
+// that first used its key
+func replayIdempotent(c context.Context, w http.ResponseWriter, attempt idempotencyRecord) {
+	var stored idempotencyRecord
+	err := idempotencyCollection.FindOne(c, bson.M{"_id": attempt.ID}).Decode(&stored)
+	dbBreaker.Record(err)
+	switch {
+	case err != nil:
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up idempotency key"})
+	case stored.RequestHash != attempt.RequestHash:
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{
+			Success: false,
+			Error:   "Idempotency-Key was already used for a different request",
+			Code:    "idempotency_key_reused",
+		})
+	case stored.Status == 0:
+		w.Header().Set("Retry-After", "1")
+		sendJSON(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "A request with this Idempotency-Key is still in progress",
+			Code:    "idempotency_in_progress",
+		})
+	default:
+		if stored.Location != "" {
+			w.Header().Set("Location", stored.Location)
+		}
+		if stored.Token != "" {
+			w.Header().Set("Creation-Token", stored.Token)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+	}
+}
+
+// findByClientKey returns the caller's document created with a client key,
+// trashed or not, or nil. It reads from the primary so a create that just
+// landed is never missed.
+func findByClientKey(c context.Context, userID, clientKey string) (*JSONDocument, error) {
+	primary, err := docCollection.Clone(options.Collection().SetReadPreference(readpref.Primary()))
+	if err != nil {
+		return nil, err
+	}
+	var doc JSONDocument
+	err = primary.FindOne(c, bson.M{"user_id": userID, "client_key": clientKey}).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// sendSynced answers a create whose client key already has a document:
+// nothing is written and the existing document is returned
+func sendSynced(w http.ResponseWriter, doc *JSONDocument) {
+	if doc.DeletedAt != nil {
+		sendJSON(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "The document created with this client_key is in the trash",
+			Code:    "client_key_trashed",
+		})
+		return
+	}
+	w.Header().Set("Location", documentLocation(w, doc.ID))
+	w.Header().Set("Creation-Token", doc.ClientKey)
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Document already exists for this client_key",
+		Data:    doc,
+	})
+}
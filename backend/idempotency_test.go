@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// createAs posts a document as u1 through the idempotency wrapper
+func createAs(body, idempotencyKey string) *httptest.ResponseRecorder {
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(body)), "u1")
+	if idempotencyKey != "" {
+		r.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	return serve(idempotent(createDocument), r)
+}
+
+// createdID returns the document ID in a create response
+func createdID(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp struct {
+		Data JSONDocument `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %s: %v", w.Body, err)
+	}
+	return resp.Data.ID
+}
+
+func TestIdempotentRejectsBadKey(t *testing.T) {
+	w := createAs(`{"name":"a"}`, "has spaces")
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestReconnectCreatesAreNotDuplicated(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	body := `{"name":"note","data":{"text":"offline"}}`
+
+	first := createAs(body, "sync-1")
+	if first.Code != http.StatusCreated || first.Header().Get("Creation-Token") != "sync-1" {
+		t.Fatalf("first create: status %d, Creation-Token %q: %s", first.Code, first.Header().Get("Creation-Token"), first.Body)
+	}
+	id := createdID(t, first)
+
+	// The connection dropped before the client saw the response
+	retry := createAs(body, "sync-1")
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: status %d, replayed %q, body %s; want the original response", retry.Code, retry.Header().Get("Idempotent-Replayed"), retry.Body)
+	}
+
+	// Reusing the key for another request is refused
+	if w := createAs(`{"name":"other"}`, "sync-1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another body: status %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+
+	// Once the stored response has expired the creation token still holds
+	idempotencyCollection.DeleteMany(c, bson.M{})
+	late := createAs(body, "sync-1")
+	if late.Code != http.StatusOK || createdID(t, late) != id {
+		t.Errorf("late retry: status %d, id %q; want 200 with %q", late.Code, createdID(t, late), id)
+	}
+
+	// A client key alone makes a resent create a no-op
+	keyed := `{"name":"draft","client_key":"local-42","data":{}}`
+	if w := createAs(keyed, ""); w.Code != http.StatusCreated {
+		t.Fatalf("create with client_key: status %d: %s", w.Code, w.Body)
+	}
+	if w := createAs(keyed, ""); w.Code != http.StatusOK {
+		t.Errorf("resent create with client_key: status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if n, _ := docCollection.CountDocuments(c, bson.M{"user_id": "u1"}); n != 2 {
+		t.Errorf("stored %d documents, want 2", n)
+	}
+}
+
+func TestStaleIdempotencyAttemptIsTakenOver(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	body := `{"name":"note","data":{}}`
+	hash := requestHash(httptest.NewRequest(http.MethodPost, "/api/documents", nil), []byte(body))
+	now := time.Now().UTC()
+
+	// One attempt is still within its lease; the other's process died
+	idempotencyCollection.InsertOne(c, idempotencyRecord{ID: "u1:running", UserID: "u1", RequestHash: hash, CreatedAt: now, LeaseExpires: now.Add(time.Minute)})
+	idempotencyCollection.InsertOne(c, idempotencyRecord{ID: "u1:stuck", UserID: "u1", RequestHash: hash, CreatedAt: now.Add(-time.Hour), LeaseExpires: now.Add(-time.Minute)})
+
+	if w := createAs(body, "running"); w.Code != http.StatusConflict {
+		t.Errorf("retry within the lease: status %d, want %d", w.Code, http.StatusConflict)
+	}
+	w := createAs(body, "stuck")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("retry after the lease: status %d, want %d: %s", w.Code, http.StatusCreated, w.Body)
+	}
+	if replay := createAs(body, "stuck"); replay.Code != http.StatusCreated || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay after takeover: status %d, replayed %q", replay.Code, replay.Header().Get("Idempotent-Replayed"))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Query indexes are the data.* indexes managed through /admin/indexes. They
+// are compound on user_id so they serve the per-user filters every list
+// query applies, and their names carry a prefix so built-in indexes can't
+// be dropped through the API.
+const (
+	queryIndexPrefix   = "query_"
+	maxQueryIndexDepth = 3
+)
+
+// queryIndexName names the managed index for a field and direction
+func queryIndexName(field string, direction int) string {
+	return fmt.Sprintf("%s%s_%d", queryIndexPrefix, field, direction)
+}
+
+// List indexes on the documents collection
+func listIndexes(w http.ResponseWriter, r *http.Request) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	specs, err := docCollection.Indexes().ListSpecifications(c)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list indexes"})
+		return
+	}
+
+	indexes := make([]map[string]interface{}, 0, len(specs))
+	for _, spec := range specs {
+		var keys bson.D
+		bson.Unmarshal(spec.KeysDocument, &keys)
+		indexes = append(indexes, map[string]interface{}{
+			"name":    spec.Name,
+			"keys":    keys.Map(),
+			"managed": strings.HasPrefix(spec.Name, queryIndexPrefix),
+		})
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: indexes})
+}
+
+// Create an index supporting queries on a data field
+func createIndex(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Field     string `json:"field"`
+		Direction int    `json:"direction"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if input.Direction == 0 {
+		input.Direction = 1
+	}
+
+	var fieldErrors []FieldError
+	if !isQueryableField(input.Field) || !strings.HasPrefix(input.Field, "data.") {
+		fieldErrors = append(fieldErrors, FieldError{Field: "field", Message: "must be a data path such as data.title"})
+	} else if strings.Count(input.Field, ".") > maxQueryIndexDepth {
+		fieldErrors = append(fieldErrors, FieldError{Field: "field", Message: fmt.Sprintf("must be at most %d levels below data", maxQueryIndexDepth)})
+	}
+	if input.Direction != 1 && input.Direction != -1 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "direction", Message: "must be 1 or -1"})
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	specs, err := docCollection.Indexes().ListSpecifications(c)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list indexes"})
+		return
+	}
+	name := queryIndexName(input.Field, input.Direction)
+	managed := 0
+	for _, spec := range specs {
+		if spec.Name == name {
+			sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "Index already exists"})
+			return
+		}
+		if strings.HasPrefix(spec.Name, queryIndexPrefix) {
+			managed++
+		}
+	}
+	if managed >= config.MaxQueryIndexes {
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{Success: false, Error: fmt.Sprintf("At most %d query indexes may be created", config.MaxQueryIndexes)})
+		return
+	}
+
+	_, err = docCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: input.Field, Value: input.Direction}},
+		Options: options.Index().SetName(name),
+	})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create index"})
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Index created",
+		Data:    map[string]interface{}{"name": name, "field": input.Field, "direction": input.Direction},
+	})
+}
+
+// Drop an index created through createIndex
+func dropIndex(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "name")
+	if !strings.HasPrefix(name, queryIndexPrefix) {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Only query indexes created through this API can be dropped"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	_, err := docCollection.Indexes().DropOne(c, name)
+	dbBreaker.Record(err)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == 27 { // IndexNotFound
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Index not found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to drop index"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Index dropped"})
+}
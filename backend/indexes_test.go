@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCreateIndexValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"metadata field", `{"field":"name"}`},
+		{"outside data", `{"field":"title"}`},
+		{"operator in path", `{"field":"data.$where"}`},
+		{"too deep", `{"field":"data.a.b.c.d"}`},
+		{"bad direction", `{"field":"data.a","direction":2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/admin/indexes", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if w := serve(createIndex, r); w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want 422: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestDropBuiltInIndex(t *testing.T) {
+	for _, name := range []string{"_id_", "user_content", "user_id_1"} {
+		r := withPathParams(httptest.NewRequest(http.MethodDelete, "/admin/indexes/"+name, nil), map[string]string{"name": name})
+		if w := serve(dropIndex, r); w.Code != http.StatusForbidden {
+			t.Errorf("dropping %s: status = %d, want 403", name, w.Code)
+		}
+	}
+}
+
+func TestQueryIndexLifecycle(t *testing.T) {
+	setupTestDB(t)
+	saved := config.MaxQueryIndexes
+	t.Cleanup(func() { config.MaxQueryIndexes = saved })
+	config.MaxQueryIndexes = 2
+
+	create := func(body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/indexes", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		return serve(createIndex, r).Code
+	}
+	drop := func(name string) int {
+		r := withPathParams(httptest.NewRequest(http.MethodDelete, "/admin/indexes/"+name, nil), map[string]string{"name": name})
+		return serve(dropIndex, r).Code
+	}
+
+	steps := []struct {
+		name string
+		call func() int
+		want int
+	}{
+		{"create", func() int { return create(`{"field":"data.title"}`) }, http.StatusCreated},
+		{"create duplicate", func() int { return create(`{"field":"data.title","direction":1}`) }, http.StatusConflict},
+		{"create descending", func() int { return create(`{"field":"data.title","direction":-1}`) }, http.StatusCreated},
+		{"create over the limit", func() int { return create(`{"field":"data.other"}`) }, http.StatusUnprocessableEntity},
+		{"drop", func() int { return drop(queryIndexName("data.title", 1)) }, http.StatusOK},
+		{"drop again", func() int { return drop(queryIndexName("data.title", 1)) }, http.StatusNotFound},
+	}
+	for _, step := range steps {
+		if got := step.call(); got != step.want {
+			t.Fatalf("%s: status = %d, want %d", step.name, got, step.want)
+		}
+	}
+
+	w := serve(listIndexes, httptest.NewRequest(http.MethodGet, "/admin/indexes", nil))
+	var resp struct {
+		Data []struct {
+			Name    string `json:"name"`
+			Managed bool   `json:"managed"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	var managed []string
+	for _, index := range resp.Data {
+		if index.Managed {
+			managed = append(managed, index.Name)
+		}
+	}
+	if len(managed) != 1 || managed[0] != queryIndexName("data.title", -1) {
+		t.Errorf("managed indexes = %v, want [%s]", managed, queryIndexName("data.title", -1))
+	}
+}
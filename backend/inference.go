@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SchemaField is one entry of an inferred document schema
+type SchemaField struct {
+	Path string `json:"path" bson:"path"`
+	Type string `json:"type" bson:"type"`
+}
+
+// inferSchema records the JSON type of every field in data by dotted path.
+// Objects are descended into; arrays are recorded as "array".
+func inferSchema(data map[string]interface{}) []SchemaField {
+	types := make(map[string]string)
+	inferFields(types, "", data)
+
+	schema := make([]SchemaField, 0, len(types))
+	for path, typ := range types {
+		schema = append(schema, SchemaField{Path: path, Type: typ})
+	}
+	sort.Slice(schema, func(i, j int) bool { return schema[i].Path < schema[j].Path })
+	return schema
+}
+
+func inferFields(schema map[string]string, prefix string, data map[string]interface{}) {
+	for key, value := range data {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		schema[path] = jsonType(value)
+		if nested, ok := value.(map[string]interface{}); ok {
+			inferFields(schema, path, nested)
+		}
+	}
+}
+
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}, bson.A:
+		return "array"
+	default:
+		return "number"
+	}
+}
+
+// schemaDrift compares new data against an inferred schema and lists added,
+// removed and retyped fields
+func schemaDrift(fields []SchemaField, data map[string]interface{}) []FieldError {
+	schema := make(map[string]string, len(fields))
+	for _, field := range fields {
+		schema[field.Path] = field.Type
+	}
+	current := make(map[string]string)
+	inferFields(current, "", data)
+
+	var drift []FieldError
+	for path, typ := range current {
+		expected, ok := schema[path]
+		switch {
+		case !ok:
+			drift = append(drift, FieldError{Field: "data." + path, Message: "is not in the inferred schema"})
+		case expected != typ:
+			drift = append(drift, FieldError{Field: "data." + path, Message: "changed type from " + expected + " to " + typ})
+		}
+	}
+	for path := range schema {
+		if _, ok := current[path]; !ok {
+			drift = append(drift, FieldError{Field: "data." + path, Message: "was removed"})
+		}
+	}
+
+	sort.Slice(drift, func(i, j int) bool { return drift[i].Field < drift[j].Field })
+	return drift
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInferSchema(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []SchemaField
+	}{
+		{"empty", `{}`, []SchemaField{}},
+		{"scalars", `{"s":"x","n":1.5,"b":true,"z":null}`, []SchemaField{
+			{"b", "boolean"}, {"n", "number"}, {"s", "string"}, {"z", "null"},
+		}},
+		{"nested objects", `{"a":{"b":{"c":1}}}`, []SchemaField{
+			{"a", "object"}, {"a.b", "object"}, {"a.b.c", "number"},
+		}},
+		{"arrays are not descended into", `{"a":[{"b":1}]}`, []SchemaField{
+			{"a", "array"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+				t.Fatal(err)
+			}
+			if got := inferSchema(data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inferSchema = %v, want %v", got, tt.want)
+			}
+			if got := inferSchema(storedData(t, data)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("inferSchema(stored) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchemaDrift(t *testing.T) {
+	schema := []SchemaField{{"a", "number"}, {"b", "object"}, {"b.c", "string"}}
+	tests := []struct {
+		name string
+		data string
+		want []FieldError
+	}{
+		{"unchanged", `{"a":2,"b":{"c":"y"}}`, nil},
+		{"added field", `{"a":2,"b":{"c":"y","d":true}}`, []FieldError{
+			{Field: "data.b.d", Message: "is not in the inferred schema"},
+		}},
+		{"removed field", `{"b":{"c":"y"}}`, []FieldError{
+			{Field: "data.a", Message: "was removed"},
+		}},
+		{"retyped field", `{"a":"2","b":{"c":"y"}}`, []FieldError{
+			{Field: "data.a", Message: "changed type from number to string"},
+		}},
+		{"object replaced by scalar", `{"a":2,"b":null}`, []FieldError{
+			{Field: "data.b", Message: "changed type from object to null"},
+			{Field: "data.b.c", Message: "was removed"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+				t.Fatal(err)
+			}
+			if got := schemaDrift(schema, data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schemaDrift = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceSchemaOnUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		enforce  string
+		data     string
+		want     int
+		warnings int
+	}{
+		{"no enforcement", "", `{"a":"x","b":1}`, http.StatusOK, 0},
+		{"warn without drift", "warn", `{"a":2}`, http.StatusOK, 0},
+		{"warn with drift", "warn", `{"a":"x","b":1}`, http.StatusOK, 2},
+		{"strict without drift", "strict", `{"a":2}`, http.StatusOK, 0},
+		{"strict with drift", "strict", `{"a":"x","b":1}`, http.StatusUnprocessableEntity, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			doc := JSONDocument{ID: "d", UserID: "u1", Name: "n", Data: map[string]interface{}{"a": 1.0}}
+			doc.Schema = inferSchema(doc.Data)
+			if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+				t.Fatal(err)
+			}
+
+			body := strings.NewReader(`{"data":` + tt.data + `}`)
+			r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/d?enforce_schema="+tt.enforce, body), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			w := serve(withID(updateDocument), withPathParams(r, map[string]string{"id": "d"}))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			var resp APIResponse
+			json.NewDecoder(w.Body).Decode(&resp)
+			if len(resp.Warnings) != tt.warnings {
+				t.Errorf("warnings = %v, want %d", resp.Warnings, tt.warnings)
+			}
+		})
+	}
+}
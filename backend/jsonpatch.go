@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// isJSONPatch reports whether the request body is an RFC 6902 JSON Patch
+// (Content-Type: application/json-patch+json)
+func isJSONPatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json-patch+json"
+}
+
+// patchTarget is the part of a document JSON Patch operations address, so
+// paths look like /data/items/0 or /name
+type patchTarget struct {
+	Name   string                 `json:"name"`
+	Folder string                 `json:"folder,omitempty"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+// errPatchShape marks a patch that applied but left the document without a
+// name or with fields other than name, folder and data
+var errPatchShape = errors.New("patched document must have a name, an object data and no other fields")
+
+// applyJSONPatch applies the RFC 6902 operations in body to the patchable
+// fields of doc. A failed test operation returns jsonpatch.ErrTestFailed.
+func applyJSONPatch(doc JSONDocument, body []byte) (patchTarget, error) {
+	patch, err := jsonpatch.DecodePatch(body)
+	if err != nil {
+		return patchTarget{}, err
+	}
+	target, err := json.Marshal(patchTarget{Name: doc.Name, Folder: doc.Folder, Data: doc.Data})
+	if err != nil {
+		return patchTarget{}, err
+	}
+
+	// Copies may not grow the document past what a create could store
+	opts := jsonpatch.NewApplyOptions()
+	opts.AccumulatedCopySizeLimit = int64(config.MaxDataBytes)
+	patched, err := patch.ApplyWithOptions(target, opts)
+	if err != nil {
+		return patchTarget{}, err
+	}
+
+	var result patchTarget
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&result); err != nil || result.Name == "" || result.Data == nil {
+		return patchTarget{}, errPatchShape
+	}
+	return result, nil
+}
+
+// changedKeys lists the top-level keys whose values differ between two
+// versions of a document's data
+func changedKeys(before, after map[string]interface{}) []string {
+	var changed []string
+	for key, value := range after {
+		old, ok := before[key]
+		if !ok {
+			changed = append(changed, key)
+			continue
+		}
+		// Stored values decode as BSON types, so compare their JSON
+		a, _ := json.Marshal(old)
+		b, _ := json.Marshal(value)
+		if !bytes.Equal(a, b) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// JSON Patch document - applies RFC 6902 operations to the stored
+// document's name, folder and data in order. Reached through PATCH with an
+// application/json-patch+json body.
+func jsonPatchDocument(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var existingDoc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&existingDoc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if existingDoc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	filter["frozen"] = bson.M{"$ne": true}
+	prior := existingDoc
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	// Anything but a JSON array of operations is a malformed body
+	var ops []json.RawMessage
+	if err := json.Unmarshal(body, &ops); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	patched, err := applyJSONPatch(existingDoc, body)
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		sendJSON(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "JSON Patch test failed: " + err.Error(),
+			Code:    "patch_test_failed",
+		})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{
+			Success: false,
+			Error:   "Invalid JSON Patch: " + err.Error(),
+			Code:    "invalid_patch",
+		})
+		return
+	}
+
+	if dataKeys, scoped := scopedDataKeys(r); scoped {
+		if patched.Name != existingDoc.Name || patched.Folder != existingDoc.Folder {
+			sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Scoped keys may only update data", Code: "scope_denied"})
+			return
+		}
+		changed := map[string]interface{}{}
+		for _, key := range changedKeys(existingDoc.Data, patched.Data) {
+			changed[key] = true
+		}
+		if outside := disallowedKeys(changed, dataKeys); len(outside) > 0 {
+			sendScopeDenied(w, outside)
+			return
+		}
+	}
+
+	if raw, err := json.Marshal(patched.Data); err == nil && len(raw) > config.MaxDataBytes {
+		sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Document data exceeds %d bytes", config.MaxDataBytes),
+			Code:    "data_too_large",
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	stampData(patched.Data, existingDoc.Data, existingDoc.CreatedAt, now)
+
+	if fieldErrors := validateData(patched.Data, existingDoc.Unique); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	set := bson.M{"updated_at": now, "name": patched.Name, "data": patched.Data, "content_hash": contentHashValue(patched.Data)}
+	fieldErrors, err := documentSchemaErrors(c, &existingDoc, patched.Name, patched.Data, set)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	update := bson.M{"$set": set}
+	if patched.Folder == "" {
+		update["$unset"] = bson.M{"folder": ""}
+	} else {
+		set["folder"] = patched.Folder
+	}
+
+	growth := dataSize(patched.Data) - dataSize(prior.Data)
+	if !chargeStorage(c, w, prior.UserID, growth) {
+		return
+	}
+	result, err := docCollection.UpdateOne(c, filter, update)
+	dbBreaker.Record(err)
+	settleStorage(prior.UserID, growth, err == nil && result.MatchedCount > 0)
+	if mongo.IsDuplicateKeyError(err) {
+		sendWriteConflict(w, err)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	recordVersion(c, r, prior)
+
+	existingDoc.Name, existingDoc.Folder, existingDoc.Data = patched.Name, patched.Folder, patched.Data
+	existingDoc.UpdatedAt = now
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document patched", Data: existingDoc})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	var data map[string]interface{}
+	json.Unmarshal([]byte(`{"items":[{"n":1}],"a":{"b":1},"old":true}`), &data)
+	doc := JSONDocument{Name: "list", Folder: "f", Data: storedData(t, data)}
+
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+		err   error
+	}{
+		{"append", `[{"op":"add","path":"/data/items/-","value":{"n":2}}]`, `{"name":"list","folder":"f","data":{"a":{"b":1},"items":[{"n":1},{"n":2}],"old":true}}`, nil},
+		{"in order", `[{"op":"test","path":"/data/a/b","value":1},{"op":"replace","path":"/data/a/b","value":2},{"op":"remove","path":"/data/old"}]`, `{"name":"list","folder":"f","data":{"a":{"b":2},"items":[{"n":1}]}}`, nil},
+		{"move and copy", `[{"op":"copy","from":"/data/a","path":"/data/c"},{"op":"move","from":"/data/old","path":"/data/new"}]`, `{"name":"list","folder":"f","data":{"a":{"b":1},"c":{"b":1},"items":[{"n":1}],"new":true}}`, nil},
+		{"rename and unfile", `[{"op":"replace","path":"/name","value":"renamed"},{"op":"remove","path":"/folder"}]`, `{"name":"renamed","data":{"a":{"b":1},"items":[{"n":1}],"old":true}}`, nil},
+		{"failed test", `[{"op":"replace","path":"/data/old","value":false},{"op":"test","path":"/data/a/b","value":5}]`, "", jsonpatch.ErrTestFailed},
+		{"missing path", `[{"op":"remove","path":"/data/missing/x"}]`, "", jsonpatch.ErrMissing},
+		{"index out of range", `[{"op":"add","path":"/data/items/7","value":1}]`, "", jsonpatch.ErrInvalidIndex},
+		{"removed name", `[{"op":"remove","path":"/name"}]`, "", errPatchShape},
+		{"unknown field", `[{"op":"add","path":"/id","value":"x"}]`, "", errPatchShape},
+		{"data not an object", `[{"op":"replace","path":"/data","value":[1]}]`, "", errPatchShape},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch(doc, []byte(tt.patch))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw, _ := json.Marshal(got); string(raw) != tt.want {
+				t.Errorf("patched = %s, want %s", raw, tt.want)
+			}
+		})
+	}
+	if raw, _ := json.Marshal(doc.Data); string(raw) != `{"a":{"b":1},"items":[{"n":1}],"old":true}` {
+		t.Errorf("applyJSONPatch modified the stored data: %s", raw)
+	}
+}
+
+func TestChangedKeys(t *testing.T) {
+	var before map[string]interface{}
+	json.Unmarshal([]byte(`{"same":[1,{"x":2}],"changed":1,"gone":true}`), &before)
+	before = storedData(t, before)
+	after := map[string]interface{}{"same": []interface{}{1.0, map[string]interface{}{"x": 2.0}}, "changed": 2.0, "added": "x"}
+
+	if got := strings.Join(changedKeys(before, after), ","); got != "added,changed,gone" {
+		t.Errorf("changedKeys = %s, want added,changed,gone", got)
+	}
+}
+
+func TestJSONPatchDocument(t *testing.T) {
+	setupTestDB(t)
+	doc := JSONDocument{
+		ID:        "doc-1",
+		UserID:    "u1",
+		Name:      "list",
+		Data:      map[string]interface{}{"items": []interface{}{"a"}},
+		UpdatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		r := asUser(httptest.NewRequest(http.MethodPatch, "/api/documents/doc-1", strings.NewReader(body)), "u1")
+		r.Header.Set("Content-Type", "application/json-patch+json")
+		return serve(withID(patchDocument), withPathParams(r, map[string]string{"id": "doc-1"}))
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"append", `[{"op":"add","path":"/data/items/-","value":"b"}]`, http.StatusOK},
+		{"failed test", `[{"op":"test","path":"/data/items/0","value":"z"}]`, http.StatusConflict},
+		{"malformed path", `[{"op":"replace","path":"/data/items/9","value":"c"}]`, http.StatusUnprocessableEntity},
+		{"not an array", `{"op":"add"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if w := patch(tt.body); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	var stored JSONDocument
+	docCollection.FindOne(context.Background(), bson.M{"_id": "doc-1"}).Decode(&stored)
+	if raw, _ := json.Marshal(stored.Data); string(raw) != `{"items":["a","b"]}` {
+		t.Errorf("stored data = %s, want the appended item only", raw)
+	}
+	if !stored.UpdatedAt.After(doc.UpdatedAt) {
+		t.Error("updated_at was not bumped")
+	}
+}
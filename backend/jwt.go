@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// jwtHeader is the only header tokens are issued with or accepted under
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func signJWT(unsigned string) string {
+	mac := hmac.New(sha256.New, []byte(config.JWTSecret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issueToken signs an HS256 session token for the user, valid for JWT_TTL
+func issueToken(userID string) (string, time.Time, error) {
+	now := time.Now().UTC()
+	expires := now.Add(config.JWTTTL)
+	claims, err := json.Marshal(jwtClaims{Subject: userID, IssuedAt: now.Unix(), ExpiresAt: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return unsigned + "." + signJWT(unsigned), expires, nil
+}
+
+// parseToken verifies the signature and expiry of a session token and
+// returns its claims
+func parseToken(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	if config.JWTSecret == "" {
+		return claims, errInvalidToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return claims, errInvalidToken
+	}
+	if !hmac.Equal([]byte(signJWT(parts[0]+"."+parts[1])), []byte(parts[2])) {
+		return claims, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return claims, errInvalidToken
+	}
+	if claims.Subject == "" || claims.Subject == "global" || time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errInvalidToken
+	}
+	return claims, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// Refresh handler - exchanges a valid session token for a new one
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if config.JWTSecret == "" {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Session tokens are not enabled"})
+		return
+	}
+
+	claims, err := parseToken(bearerToken(r))
+	if err != nil {
+		sendJSON(w, http.StatusUnauthorized, APIResponse{Success: false, Error: "Invalid or expired token"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	// Unlike authentication, refreshing checks the account still exists so
+	// deleted users can't keep a session alive
+	count, err := usersCollection.CountDocuments(c, bson.M{"_id": claims.Subject})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to refresh token"})
+		return
+	}
+	if count == 0 {
+		sendJSON(w, http.StatusUnauthorized, APIResponse{Success: false, Error: "Invalid or expired token"})
+		return
+	}
+
+	token, expires, err := issueToken(claims.Subject)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to refresh token"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    map[string]interface{}{"token": token, "token_expires_at": expires},
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withJWTSecret enables session tokens for the length of a test
+func withJWTSecret(t *testing.T) {
+	t.Helper()
+	secret, ttl := config.JWTSecret, config.JWTTTL
+	config.JWTSecret, config.JWTTTL = "test-secret", time.Minute
+	t.Cleanup(func() { config.JWTSecret, config.JWTTTL = secret, ttl })
+}
+
+// forgeToken signs arbitrary claims with the test secret
+func forgeToken(t *testing.T, claims jwtClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + signJWT(unsigned)
+}
+
+func TestParseToken(t *testing.T) {
+	withJWTSecret(t)
+	valid, _, _ := issueToken("u1")
+	parts := strings.Split(valid, ".")
+	future := time.Now().Add(time.Hour).Unix()
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+	tests := []struct {
+		name    string
+		token   string
+		subject string
+	}{
+		{"valid", valid, "u1"},
+		{"empty", "", ""},
+		{"two parts", parts[0] + "." + parts[1], ""},
+		{"tampered payload", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"u2","exp":9999999999}`)) + "." + parts[2], ""},
+		{"tampered signature", parts[0] + "." + parts[1] + ".AAAA", ""},
+		{"alg none", noneHeader + "." + parts[1] + ".", ""},
+		{"expired", forgeToken(t, jwtClaims{Subject: "u1", ExpiresAt: time.Now().Add(-time.Second).Unix()}), ""},
+		{"global subject", forgeToken(t, jwtClaims{Subject: "global", ExpiresAt: future}), ""},
+		{"no subject", forgeToken(t, jwtClaims{ExpiresAt: future}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := parseToken(tt.token)
+			if tt.subject == "" {
+				if err != errInvalidToken {
+					t.Errorf("parseToken error = %v, want errInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != tt.subject {
+				t.Errorf("subject = %q, want %q", claims.Subject, tt.subject)
+			}
+		})
+	}
+
+	// Tokens stop verifying once the secret changes or is removed
+	config.JWTSecret = "another-secret"
+	if _, err := parseToken(valid); err != errInvalidToken {
+		t.Errorf("token under another secret: error = %v", err)
+	}
+	config.JWTSecret = ""
+	if _, err := parseToken(valid); err != errInvalidToken {
+		t.Errorf("token without a secret: error = %v", err)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"Bearer abc", "abc"},
+		{"bearer abc ", "abc"},
+		{"Basic abc", ""},
+		{"Bearer", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", tt.header)
+			if got := bearerToken(r); got != tt.want {
+				t.Errorf("bearerToken(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
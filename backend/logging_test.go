@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoggingMiddleware(t *testing.T) {
+	var out bytes.Buffer
+	saved, savedEnabled := requestLogger, config.LogRequests
+	t.Cleanup(func() { requestLogger, config.LogRequests = saved, savedEnabled })
+	requestLogger = slog.New(slog.NewJSONHandler(&out, nil))
+	config.LogRequests = true
+
+	handler := requestIDMiddleware(loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logUser(func(w http.ResponseWriter, r *http.Request) {
+			sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		})(w, asUser(r, "u1"))
+	})))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/documents/missing", nil))
+
+	id := w.Header().Get("X-Request-ID")
+	if id == "" {
+		t.Fatal("no X-Request-ID header")
+	}
+	var resp APIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RequestID != id {
+		t.Errorf("error response request_id = %q, want %q", resp.RequestID, id)
+	}
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", out.String(), err)
+	}
+	want := map[string]interface{}{
+		"request_id": id,
+		"method":     "GET",
+		"path":       "/api/documents/missing",
+		"status":     float64(http.StatusNotFound),
+		"user_id":    "u1",
+	}
+	for key, value := range want {
+		if line[key] != value {
+			t.Errorf("log %s = %v, want %v", key, line[key], value)
+		}
+	}
+	if _, ok := line["duration_ms"].(float64); !ok {
+		t.Errorf("log duration_ms = %v, want a number", line["duration_ms"])
+	}
+}
+
+func TestSendJSONSuccessHasNoRequestID(t *testing.T) {
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Request-ID", "abc")
+	sendJSON(w, http.StatusOK, APIResponse{Success: true})
+	if bytes.Contains(w.Body.Bytes(), []byte("request_id")) {
+		t.Errorf("success response %s carries a request_id", w.Body)
+	}
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,9 +33,110 @@ import (
 type Config struct {
 	Port           string
 	APIKey         string
+	APIKeyPrefix   string
+	APIKeyGrace    time.Duration
 	MongoURI       string
 	DatabaseName   string
 	AllowedOrigins []string
+	MaxGroups      int
+	OrphanGlobal   bool
+	StrictData     bool
+	Features       map[string]bool
+
+	GeoField       string
+	ParentField    string
+	TextSearch     bool
+	CountMode      string
+	DecodeErrors   string
+	FreezeUndo     bool
+	TrashRetention time.Duration
+	VersionLimit   int
+	InferSchema    bool
+	PublicPretty   bool
+	PublicFields   string
+	NumberFormat   string
+
+	DataCreatedField       string
+	DataModifiedField      string
+	DataTimestampsOverride bool
+	DataIDField            string
+	DataNameField          string
+	DataIDOverride         bool
+
+	ExportAllowedHosts []string
+	ExportAllowPrivate bool
+	ExportTimeout      time.Duration
+	MaxBodyBytes       int64
+	MaxDataBytes       int
+	MaxAuthBytes       int64
+	UploadMaxBytes     int64
+	UploadTTL          time.Duration
+	MaxUploadsPerUser  int
+	MigrationMaxBytes  int64
+	MaxStorageBytes    int64
+	MaxStreamsPerUser  int
+
+	PublicMaxAge               int
+	PublicStaleWhileRevalidate int
+	PublicStaleIfError         int
+	PublicDocRateLimit         int
+	AutocompleteMaxAge         int
+	RateLimitRPM               int
+	RateLimitGlobalRPM         int
+
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	MaxQueryIndexes int
+	UniqueNames     bool
+	UniqueDocNames  bool
+	UniqueContent   string
+	PathMode        string
+
+	RequestIDTrusted []string
+	TrustedProxies   []string
+	HTTPSOnly        string
+
+	JWTSecret string
+	JWTTTL    time.Duration
+
+	// RequireVerification is off, login or create
+	RequireVerification string
+	VerifyURL           string
+	SMTPHost            string
+	SMTPPort            string
+	SMTPUsername        string
+	SMTPPassword        string
+	MailFrom            string
+	ResetURL            string
+	ResetTTL            time.Duration
+
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSMinVersion   string
+	TLSCipherSuites []string
+	HSTSMaxAge      int
+	HSTSSubdomains  bool
+
+	StatsTopN     int
+	StatsCacheTTL time.Duration
+
+	Compression         bool
+	CompressionLevel    int
+	CompressionMinBytes int
+
+	ReadPreference   string
+	ReadMaxStaleness time.Duration
+	FreshnessHeader  bool
+
+	ShutdownTimeout time.Duration
+	HealthTimeout   time.Duration
+	IdempotencyTTL  time.Duration
+	DBTimeout       time.Duration
+
+	LogRequests     bool
+	LogBodies       bool
+	LogRedactFields []string
 }
 
 // User represents a user account
@@ -35,6 +146,20 @@ type User struct {
 	Password  string    `json:"-" bson:"password"`
 	APIKey    string    `json:"api_key" bson:"api_key"`
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	// StorageBytes is the running total of the user's document data; nil
+	// for accounts created before it was tracked until first recounted
+	StorageBytes *int64 `json:"-" bson:"storage_bytes,omitempty"`
+	// Verified is false until the emailed link is opened; nil for accounts
+	// created before verification existed
+	Verified    *bool  `json:"verified,omitempty" bson:"verified,omitempty"`
+	VerifyToken string `json:"-" bson:"verify_token,omitempty"`
+	// ResetToken is the hash of a pending password reset token
+	ResetToken   string     `json:"-" bson:"reset_token,omitempty"`
+	ResetExpires *time.Time `json:"-" bson:"reset_expires,omitempty"`
+	// PreviousAPIKey is the key before the last rotation, accepted until
+	// PreviousKeyExpires
+	PreviousAPIKey     string     `json:"-" bson:"previous_api_key,omitempty"`
+	PreviousKeyExpires *time.Time `json:"-" bson:"previous_api_key_expires,omitempty"`
 }
 
 // JSONDocument represents a stored JSON document
@@ -42,24 +167,70 @@ type JSONDocument struct {
 	ID        string                 `json:"id" bson:"_id"`
 	UserID    string                 `json:"user_id" bson:"user_id"`
 	Name      string                 `json:"name" bson:"name"`
+	Folder    string                 `json:"folder,omitempty" bson:"folder,omitempty"`
+	Series    string                 `json:"series,omitempty" bson:"series,omitempty"`
 	Data      map[string]interface{} `json:"data" bson:"data"`
-	CreatedAt time.Time              `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time              `json:"updated_at" bson:"updated_at"`
+	Frozen    bool                   `json:"frozen" bson:"frozen,omitempty"`
+	IsPublic  bool                   `json:"is_public" bson:"is_public"`
+	Schema    []SchemaField          `json:"inferred_schema,omitempty" bson:"inferred_schema,omitempty"`
+	Unique    []string               `json:"unique_arrays,omitempty" bson:"unique_arrays,omitempty"`
+	Derived   []Derivation           `json:"derived,omitempty" bson:"derived,omitempty"`
+	SchemaRef *SchemaRef             `json:"schema_ref,omitempty" bson:"schema_ref,omitempty"`
+	RateLimit int                    `json:"public_rate_limit,omitempty" bson:"public_rate_limit,omitempty"`
+	Template  string                 `json:"template,omitempty" bson:"template,omitempty"`
+	// PublicFields are the data paths a public read shows when
+	// PUBLIC_FIELDS_MODE is allowlist
+	PublicFields []string `json:"public_fields,omitempty" bson:"public_fields,omitempty"`
+	// ClientKey is the caller's own ID for the document, unique per user,
+	// so offline clients can resend a create without duplicating it
+	ClientKey string     `json:"client_key,omitempty" bson:"client_key,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" bson:"updated_at"`
+
+	// ContentHash identifies the data for UNIQUE_CONTENT
+	ContentHash string `json:"-" bson:"content_hash,omitempty"`
 }
 
 // APIResponse is a standard API response
 type APIResponse struct {
-	Success bool        `json:"success"`
-	Message string      `json:"message,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
+	Success  bool         `json:"success"`
+	Message  string       `json:"message,omitempty"`
+	Data     interface{}  `json:"data,omitempty"`
+	Error    string       `json:"error,omitempty"`
+	Code     string       `json:"code,omitempty"`
+	Offset   *int64       `json:"offset,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	Warnings []FieldError `json:"warnings,omitempty"`
+	// RequestID is set on errors so users can quote it when reporting them
+	RequestID string `json:"request_id,omitempty"`
+	// Pagination for list responses, kept beside data so data stays the
+	// page itself
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more,omitempty"`
+	// Skipped counts listed documents left out because they failed to decode
+	Skipped int `json:"skipped,omitempty"`
+}
+
+// FieldError describes a validation failure on a single input field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 var (
-	config          Config
-	docCollection   *mongo.Collection
-	usersCollection *mongo.Collection
-	ctx             = context.Background()
+	config             Config
+	docCollection      *mongo.Collection
+	usersCollection    *mongo.Collection
+	seriesCollection   *mongo.Collection
+	schemasCollection  *mongo.Collection
+	keysCollection     *mongo.Collection
+	versionsCollection *mongo.Collection
+	// idempotencyCollection keeps responses to Idempotency-Key requests
+	idempotencyCollection *mongo.Collection
+	uploadsCollection     *mongo.Collection
+	dbBreaker             *circuitBreaker
+	routes                *router
 )
 
 func init() {
@@ -69,10 +240,114 @@ func init() {
 	config = Config{
 		Port:           getEnv("PORT", "8080"),
 		APIKey:         getEnv("API_KEY", ""),
+		APIKeyPrefix:   getEnv("API_KEY_PREFIX", ""),
+		APIKeyGrace:    getEnvDuration("API_KEY_GRACE", 24*time.Hour),
 		MongoURI:       getEnv("MONGODB_URI", "mongodb://localhost:27017"),
 		DatabaseName:   getEnv("DATABASE_NAME", "jsonapi"),
 		AllowedOrigins: strings.Split(getEnv("ALLOWED_ORIGINS", "*"), ","),
+		MaxGroups:      getEnvInt("MAX_GROUPS", 100),
+		OrphanGlobal:   getEnvBool("ORPHANS_INCLUDE_GLOBAL", false),
+		StrictData:     getEnvBool("STRICT_OBJECT_DATA", true),
+		Features:       parseFeatureFlags(getEnv("FEATURE_FLAGS", "strict_json=false")),
+
+		GeoField:       getEnv("GEO_FIELD", "data.location"),
+		ParentField:    getEnv("PARENT_FIELD", "data.parent_id"),
+		TextSearch:     getEnvBool("TEXT_SEARCH", true),
+		CountMode:      getEnv("LIST_COUNT_MODE", "exact"),
+		DecodeErrors:   getEnv("LIST_DECODE_ERRORS", "skip"),
+		FreezeUndo:     getEnvBool("FREEZE_REVERSIBLE", false),
+		TrashRetention: getEnvDuration("TRASH_RETENTION", 30*24*time.Hour),
+		VersionLimit:   getEnvInt("VERSION_LIMIT", 50),
+		InferSchema:    getEnvBool("INFER_SCHEMA", false),
+		PublicPretty:   getEnvBool("PUBLIC_PRETTY", false),
+		PublicFields:   getEnv("PUBLIC_FIELDS_MODE", "all"),
+		NumberFormat:   getEnv("NUMBER_FORMAT", "plain"),
+
+		DataCreatedField:       getEnv("DATA_CREATED_FIELD", ""),
+		DataModifiedField:      getEnv("DATA_MODIFIED_FIELD", ""),
+		DataTimestampsOverride: getEnvBool("DATA_TIMESTAMPS_OVERRIDE", false),
+		DataIDField:            getEnv("DATA_ID_FIELD", ""),
+		DataNameField:          getEnv("DATA_NAME_FIELD", ""),
+		DataIDOverride:         getEnvBool("DATA_ID_OVERRIDE", false),
+
+		ExportAllowedHosts: splitList(getEnv("EXPORT_ALLOWED_HOSTS", "")),
+		ExportAllowPrivate: getEnvBool("EXPORT_ALLOW_PRIVATE", false),
+		ExportTimeout:      getEnvDuration("EXPORT_TIMEOUT", 60*time.Second),
+		MaxBodyBytes:       int64(getEnvInt("MAX_BODY_BYTES", 2<<20)),
+		MigrationMaxBytes:  int64(getEnvInt("MIGRATION_MAX_BYTES", 64<<20)),
+		MaxStorageBytes:    int64(getEnvInt("MAX_STORAGE_BYTES_PER_USER", 0)),
+		MaxStreamsPerUser:  getEnvInt("MAX_STREAMS_PER_USER", 5),
+		MaxDataBytes:       getEnvInt("MAX_DATA_BYTES", 1<<20),
+		MaxAuthBytes:       int64(getEnvInt("MAX_AUTH_BYTES", 4<<10)),
+		UploadMaxBytes:     int64(getEnvInt("UPLOAD_MAX_BYTES", 8<<20)),
+		UploadTTL:          getEnvDuration("UPLOAD_TTL", time.Hour),
+		MaxUploadsPerUser:  getEnvInt("MAX_UPLOADS_PER_USER", 3),
+
+		PublicMaxAge:               getEnvInt("PUBLIC_MAX_AGE", 60),
+		PublicStaleWhileRevalidate: getEnvInt("PUBLIC_STALE_WHILE_REVALIDATE", 0),
+		PublicStaleIfError:         getEnvInt("PUBLIC_STALE_IF_ERROR", 0),
+		PublicDocRateLimit:         getEnvInt("PUBLIC_DOC_RATE_LIMIT", 0),
+		AutocompleteMaxAge:         getEnvInt("AUTOCOMPLETE_MAX_AGE", 30),
+		RateLimitRPM:               getEnvInt("RATE_LIMIT_RPM", 100),
+		RateLimitGlobalRPM:         getEnvInt("RATE_LIMIT_GLOBAL_RPM", 1000),
+
+		BreakerThreshold: getEnvInt("BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  getEnvDuration("BREAKER_COOLDOWN", 30*time.Second),
+
+		MaxQueryIndexes: getEnvInt("MAX_QUERY_INDEXES", 10),
+		UniqueNames:     getEnvBool("UNIQUE_NAMES_PER_FOLDER", false),
+		UniqueDocNames:  getEnvBool("UNIQUE_DOC_NAMES", false),
+		UniqueContent:   getEnv("UNIQUE_CONTENT", "off"),
+		PathMode:        getEnv("PATH_MODE", "clean"),
+
+		RequestIDTrusted: splitList(getEnv("REQUEST_ID_TRUSTED_SOURCES", "")),
+		TrustedProxies:   splitList(getEnv("TRUSTED_PROXIES", "")),
+		HTTPSOnly:        getEnv("HTTPS_ONLY", "off"),
+
+		JWTSecret: getEnv("JWT_SECRET", ""),
+		JWTTTL:    getEnvDuration("JWT_TTL", 15*time.Minute),
+
+		RequireVerification: getEnv("REQUIRE_VERIFICATION", "off"),
+		VerifyURL:           getEnv("VERIFY_URL", "http://localhost:8080/auth/verify"),
+		SMTPHost:            getEnv("SMTP_HOST", ""),
+		SMTPPort:            getEnv("SMTP_PORT", "587"),
+		SMTPUsername:        getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:        getEnv("SMTP_PASSWORD", ""),
+		MailFrom:            getEnv("MAIL_FROM", "no-reply@localhost"),
+		ResetURL:            getEnv("RESET_URL", ""),
+		ResetTTL:            getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		TLSCertFile:     getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:      getEnv("TLS_KEY_FILE", ""),
+		TLSMinVersion:   getEnv("TLS_MIN_VERSION", "1.2"),
+		TLSCipherSuites: splitList(getEnv("TLS_CIPHER_SUITES", "")),
+		HSTSMaxAge:      getEnvInt("HSTS_MAX_AGE", 31536000),
+		HSTSSubdomains:  getEnvBool("HSTS_INCLUDE_SUBDOMAINS", false),
+
+		StatsTopN:     getEnvInt("STATS_TOP_N", 10),
+		StatsCacheTTL: getEnvDuration("STATS_CACHE_TTL", 5*time.Minute),
+
+		Compression:         getEnvBool("COMPRESSION", true),
+		CompressionLevel:    getEnvInt("COMPRESSION_LEVEL", gzip.DefaultCompression),
+		CompressionMinBytes: getEnvInt("COMPRESSION_MIN_BYTES", 1024),
+
+		ReadPreference:   getEnv("READ_PREFERENCE", "primary"),
+		ReadMaxStaleness: getEnvDuration("READ_MAX_STALENESS", 0),
+		FreshnessHeader:  getEnvBool("FRESHNESS_HEADER", false),
+
+		ShutdownTimeout: getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second),
+		HealthTimeout:   getEnvDuration("HEALTH_TIMEOUT", 2*time.Second),
+		IdempotencyTTL:  getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		DBTimeout:       getEnvDuration("DB_TIMEOUT", 10*time.Second),
+
+		LogRequests:     getEnvBool("LOG_REQUESTS", true),
+		LogBodies:       getEnvBool("LOG_BODIES", false),
+		LogRedactFields: splitList(getEnv("LOG_REDACT_FIELDS", "password,api_key")),
 	}
+
+	compileRedaction()
+	mailer = newMailer()
+	dbBreaker = newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -82,63 +357,372 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// dbContext bounds database work by DB_TIMEOUT. Deriving it from the
+// request's context also stops the work when the client goes away.
+func dbContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if config.DBTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, config.DBTimeout)
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
+	log.SetOutput(redactingWriter{out: os.Stderr})
+	c := context.Background()
+
 	// Connect to MongoDB
 	clientOptions := options.Client().ApplyURI(config.MongoURI)
-	client, err := mongo.Connect(ctx, clientOptions)
+	client, err := mongo.Connect(c, clientOptions)
 	if err != nil {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
-	defer client.Disconnect(ctx)
 
-	if err := client.Ping(ctx, nil); err != nil {
+	if err := client.Ping(c, nil); err != nil {
 		log.Fatalf("Failed to ping MongoDB: %v", err)
 	}
 	log.Println("Connected to MongoDB")
 
 	db := client.Database(config.DatabaseName)
-	docCollection = db.Collection("documents")
+	readPref, err := documentReadPreference()
+	if err != nil {
+		log.Fatalf("Invalid READ_PREFERENCE/READ_MAX_STALENESS: %v", err)
+	}
+	docCollection = db.Collection("documents", options.Collection().SetReadPreference(readPref))
 	usersCollection = db.Collection("users")
+	seriesCollection = db.Collection("series")
+	schemasCollection = db.Collection("schemas")
+	keysCollection = db.Collection("scoped_keys")
+	versionsCollection = db.Collection("document_versions")
+	idempotencyCollection = db.Collection("idempotency_keys")
+	uploadsCollection = db.Collection("uploads")
 
 	// Create indexes
-	docCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+	docCollection.Indexes().CreateOne(c, mongo.IndexModel{
 		Keys: bson.D{{Key: "user_id", Value: 1}},
 	})
-	usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+	usersCollection.Indexes().CreateOne(c, mongo.IndexModel{
 		Keys:    bson.D{{Key: "email", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	})
-	usersCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+	usersCollection.Indexes().CreateOne(c, mongo.IndexModel{
 		Keys:    bson.D{{Key: "api_key", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	})
+	usersCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "previous_api_key", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	usersCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "verify_token", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	usersCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "reset_token", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	versionsCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "document_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	keysCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	docCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "client_key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"client_key": bson.M{"$type": "string"}}),
+	})
+	idempotencyCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(config.IdempotencyTTL.Seconds())),
+	})
+	schemasCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	uploadsCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if config.UniqueNames {
+		if _, err := docCollection.Indexes().CreateOne(c, folderNameIndex); err != nil {
+			log.Fatalf("Failed to create unique name index (resolve duplicate names first): %v", err)
+		}
+	}
+	if config.UniqueDocNames {
+		// The prefix index left from running without UNIQUE_DOC_NAMES has
+		// the same keys, and MongoDB won't build a second index on them.
+		// It's missing on a fresh database, so the error is ignored.
+		docCollection.Indexes().DropOne(c, *namePrefixIndex.Options.Name)
+		if _, err := docCollection.Indexes().CreateOne(c, userNameIndex); err != nil {
+			log.Fatalf("Failed to create unique document name index (resolve duplicate names first): %v", err)
+		}
+	} else {
+		// The unique index serves name prefixes too when it exists
+		docCollection.Indexes().CreateOne(c, namePrefixIndex)
+	}
+	if config.UniqueContent != "off" {
+		if _, err := docCollection.Indexes().CreateOne(c, contentHashIndex); err != nil {
+			log.Fatalf("Failed to create unique content index (resolve duplicate documents first): %v", err)
+		}
+	}
+	if config.GeoField != "" {
+		if !isQueryableField(config.GeoField) || metadataFields[config.GeoField] {
+			log.Fatalf("GEO_FIELD must be a data path such as data.location, got %q", config.GeoField)
+		}
+		docCollection.Indexes().CreateOne(c, mongo.IndexModel{
+			Keys: bson.D{{Key: config.GeoField, Value: "2dsphere"}},
+		})
+	}
 
-	// Setup routes
-	mux := http.NewServeMux()
+	if config.ParentField != "" {
+		if !isQueryableField(config.ParentField) || metadataFields[config.ParentField] {
+			log.Fatalf("PARENT_FIELD must be a data path such as data.parent_id, got %q", config.ParentField)
+		}
+		docCollection.Indexes().CreateOne(c, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: config.ParentField, Value: 1}},
+		})
+	}
 
-	// Health check
-	mux.HandleFunc("/health", healthHandler)
+	// Trashed documents are swept after TRASH_RETENTION; 0 keeps them
+	docCollection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys:    bson.D{{Key: "deleted_at", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	})
+	if config.TrashRetention > 0 {
+		go sweepTrash()
+	}
 
-	// Auth routes
-	mux.HandleFunc("/auth/register", registerHandler)
-	mux.HandleFunc("/auth/login", loginHandler)
+	if config.TextSearch {
+		if _, err := docCollection.Indexes().CreateOne(c, textIndex); err != nil {
+			log.Printf("Warning: could not create text index: %v", err)
+		}
+	}
 
-	// API routes (protected)
-	mux.HandleFunc("/api/documents", authMiddleware(documentsHandler))
-	mux.HandleFunc("/api/documents/", authMiddleware(documentHandler))
-	mux.HandleFunc("/api/me", authMiddleware(meHandler))
+	if config.CompressionLevel < gzip.HuffmanOnly || config.CompressionLevel > gzip.BestCompression {
+		log.Fatalf("COMPRESSION_LEVEL must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression, config.CompressionLevel)
+	}
 
-	// Public read endpoint
-	mux.HandleFunc("/public/", publicHandler)
+	for _, field := range []string{config.DataCreatedField, config.DataModifiedField, config.DataIDField, config.DataNameField} {
+		if strings.Contains(field, ".") || strings.HasPrefix(field, "$") {
+			log.Fatalf("DATA_*_FIELD settings must be top-level data keys, got %q", field)
+		}
+	}
+
+	if config.HTTPSOnly != "off" && config.HTTPSOnly != "redirect" && config.HTTPSOnly != "reject" {
+		log.Fatalf("HTTPS_ONLY must be off, redirect or reject, got %q", config.HTTPSOnly)
+	}
+
+	if config.NumberFormat != "plain" && config.NumberFormat != "go" {
+		log.Fatalf("NUMBER_FORMAT must be plain or go, got %q", config.NumberFormat)
+	}
+
+	if config.UniqueContent != "off" && config.UniqueContent != "reject" && config.UniqueContent != "return" {
+		log.Fatalf("UNIQUE_CONTENT must be off, reject or return, got %q", config.UniqueContent)
+	}
+
+	if config.DecodeErrors != "skip" && config.DecodeErrors != "fail" {
+		log.Fatalf("LIST_DECODE_ERRORS must be skip or fail, got %q", config.DecodeErrors)
+	}
+
+	if config.PublicFields != "all" && config.PublicFields != "allowlist" {
+		log.Fatalf("PUBLIC_FIELDS_MODE must be all or allowlist, got %q", config.PublicFields)
+	}
+
+	if config.RequireVerification != "off" && config.RequireVerification != "login" && config.RequireVerification != "create" {
+		log.Fatalf("REQUIRE_VERIFICATION must be off, login or create, got %q", config.RequireVerification)
+	}
+
+	// Chunks are collected in one MongoDB document, which can't pass 16MB
+	if config.UploadMaxBytes <= 0 || config.UploadMaxBytes > maxUploadDataBytes {
+		log.Fatalf("UPLOAD_MAX_BYTES must be between 1 and %d, got %d", maxUploadDataBytes, config.UploadMaxBytes)
+	}
+
+	if config.JWTSecret != "" && len(config.JWTSecret) < 32 {
+		log.Fatalf("JWT_SECRET must be at least 32 bytes")
+	}
+
+	// Setup routes
+	routes = newRoutes()
 
-	handler := corsMiddleware(mux)
+	handler := requestIDMiddleware(loggingMiddleware(httpsOnlyMiddleware(hstsMiddleware(pathMiddleware(corsMiddleware(compressionMiddleware(bodyLogMiddleware(versionMiddleware(breakerMiddleware(freshnessMiddleware(fieldsMiddleware(routes))))))))))))
 
 	addr := fmt.Sprintf(":%s", config.Port)
 	log.Printf("JSON API Server starting on port %s", config.Port)
 
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	server := &http.Server{Addr: addr, Handler: handler}
+	server.RegisterOnShutdown(closeStreams)
+	listen := server.ListenAndServe
+
+	// Serve HTTPS directly when a certificate is configured
+	if config.TLSCertFile != "" {
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			log.Fatalf("Invalid TLS configuration: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		listen = func() error { return server.ListenAndServeTLS(config.TLSCertFile, config.TLSKeyFile) }
+	}
+
+	// SIGTERM, e.g. from a rolling deploy, drains requests before exiting
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := serveUntil(stop, server, listen, config.ShutdownTimeout); err != nil {
+		if stop.Err() == nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		log.Printf("Shutdown did not finish within %s: %v", config.ShutdownTimeout, err)
+	}
+
+	disconnect, cancelDisconnect := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelDisconnect()
+	if err := client.Disconnect(disconnect); err != nil {
+		log.Printf("Failed to disconnect from MongoDB: %v", err)
 	}
+	log.Println("Server stopped")
+}
+
+// newRoutes builds the route table. main serves it, and /api/batch
+// dispatches operations through it.
+func newRoutes() *router {
+	rt := newRouter()
+
+	// Health check
+	rt.handle("/health", accessPublic, methods{http.MethodGet: readinessHandler})
+	rt.handle("/health/live", accessPublic, methods{http.MethodGet: livenessHandler})
+	rt.handle("/health/ready", accessPublic, methods{http.MethodGet: readinessHandler})
+
+	// API description
+	rt.handle("/openapi.json", accessPublic, methods{http.MethodGet: openAPIHandler})
+	rt.handle("/docs", accessPublic, methods{http.MethodGet: docsHandler})
+
+	// Auth routes
+	rt.handle("/auth/register", accessPublic, methods{http.MethodPost: registerHandler})
+	rt.handle("/auth/login", accessPublic, methods{http.MethodPost: loginHandler})
+	rt.handle("/auth/refresh", accessPublic, methods{http.MethodPost: refreshHandler})
+	rt.handle("/auth/verify", accessPublic, methods{http.MethodGet: verifyHandler})
+	rt.handle("/auth/verify/resend", accessPublic, methods{http.MethodPost: resendVerificationHandler})
+	rt.handle("/auth/forgot-password", accessPublic, methods{http.MethodPost: forgotPasswordHandler})
+	rt.handle("/auth/reset-password", accessPublic, methods{http.MethodPost: resetPasswordHandler})
+
+	// API routes (protected)
+	rt.handle("/api/documents", accessUser, methods{
+		http.MethodGet:  listDocuments,
+		http.MethodPost: verifiedOnly(idempotent(createDocument)),
+	})
+	rt.handle("/api/documents/group-by", accessUser, methods{http.MethodGet: groupDocuments})
+	rt.handle("/api/documents/near", accessUser, methods{http.MethodGet: nearDocuments})
+	rt.handle("/api/documents/trash", accessUser, methods{http.MethodGet: listTrash})
+	rt.handle("/api/documents/search", accessUser, methods{http.MethodGet: searchDocuments})
+	rt.handle("/api/documents/autocomplete", accessUser, methods{http.MethodGet: autocompleteDocuments})
+	rt.handle("/api/documents/by-name/{name}", accessUser, methods{http.MethodGet: getDocumentByName})
+	rt.handle("/api/documents/bulk", accessUser, methods{http.MethodPost: verifiedOnly(bulkCreateDocuments)})
+	rt.handle("/api/documents/{id}", accessUser, methods{
+		http.MethodGet:    withID(getDocument),
+		http.MethodPut:    withID(updateDocument),
+		http.MethodPatch:  withID(patchDocument),
+		http.MethodDelete: withID(deleteDocument),
+	})
+	rt.handle("/api/documents/{id}/clear", accessUser, methods{http.MethodPost: withID(clearDocument)})
+	rt.handle("/api/documents/{id}/compact", accessUser, methods{http.MethodPost: withID(compactDocument)})
+	rt.handle("/api/documents/{id}/template", accessUser, methods{http.MethodPut: withID(setDocumentTemplate)})
+	rt.handle("/api/documents/{id}/items", accessUser, methods{http.MethodGet: withID(getDocumentItems)})
+	rt.handle("/api/documents/{id}/freeze", accessUser, methods{http.MethodPost: withID(freezeDocument)})
+	rt.handle("/api/documents/{id}/chunks/begin", accessUser, methods{http.MethodPost: withID(beginUpload)})
+	rt.handle("/api/documents/{id}/chunks/commit", accessUser, methods{http.MethodPost: withID(commitUpload)})
+	rt.handle("/api/documents/{id}/chunks/{n}", accessUser, methods{http.MethodPut: withID(appendChunk)})
+	rt.handle("/api/documents/{id}/send", accessUser, methods{http.MethodPost: verifiedOnly(withID(sendDocument))})
+	rt.handle("/api/documents/{id}/publish", accessUser, methods{http.MethodPost: withID(publishDocument)})
+	rt.handle("/api/documents/{id}/unpublish", accessUser, methods{http.MethodPost: withID(unpublishDocument)})
+	rt.handle("/api/documents/{id}/validate", accessUser, methods{http.MethodPost: withID(validateDocument)})
+	rt.handle("/api/documents/{id}/latest", accessUser, methods{http.MethodPost: withID(markLatest)})
+	rt.handle("/api/documents/{id}/versions", accessUser, methods{http.MethodGet: withID(listVersions)})
+	rt.handle("/api/documents/{id}/versions/{n}", accessUser, methods{http.MethodGet: withID(getVersion)})
+	rt.handle("/api/documents/{id}/versions/{n}/restore", accessUser, methods{http.MethodPost: withID(restoreVersion)})
+	rt.handle("/api/documents/{id}/restore", accessUser, methods{http.MethodPost: withID(restoreDocument)})
+	rt.handle("/api/documents/{id}/unfreeze", accessAdmin, methods{http.MethodPost: withID(unfreezeDocument)})
+	rt.handle("/api/schemas", accessUser, methods{
+		http.MethodGet:  listSchemas,
+		http.MethodPost: createSchema,
+	})
+	rt.handle("/api/schemas/{name}/{version}", accessUser, methods{
+		http.MethodGet:    getSchema,
+		http.MethodDelete: deleteSchema,
+	})
+	rt.handle("/api/keys", accessUser, methods{
+		http.MethodGet:  listScopedKeys,
+		http.MethodPost: createScopedKey,
+	})
+	rt.handle("/api/keys/rotate", accessUser, methods{http.MethodPost: rotateAPIKey})
+	rt.handle("/api/keys/{id}", accessUser, methods{http.MethodDelete: withID(deleteScopedKey)})
+	rt.handle("/api/me", accessUser, methods{http.MethodGet: meHandler})
+	rt.handle("/api/me/usage", accessUser, methods{http.MethodGet: usageHandler})
+	rt.handle("/api/ws", accessUser, methods{http.MethodGet: wsHandler})
+	rt.handle("/api/me/purge-documents", accessUser, methods{http.MethodPost: purgeDocuments})
+	rt.handle("/api/transactions", accessUser, methods{http.MethodPost: verifiedOnly(transactionHandler)})
+	rt.handle("/api/batch", accessUser, methods{http.MethodPost: batchHandler})
+	rt.handle("/api/export/push", accessUser, methods{http.MethodPost: exportPush})
+	rt.handle("/api/import/csv", accessUser, methods{http.MethodPost: verifiedOnly(importCSV)})
+
+	// Admin routes (global API key only)
+	rt.handle("/admin/orphans", accessAdmin, methods{
+		http.MethodGet:    reportOrphans,
+		http.MethodDelete: purgeOrphans,
+	})
+	rt.handle("/admin/stats", accessAdmin, methods{http.MethodGet: statsHandler})
+	rt.handle("/admin/indexes", accessAdmin, methods{
+		http.MethodGet:  listIndexes,
+		http.MethodPost: createIndex,
+	})
+	rt.handle("/admin/indexes/{name}", accessAdmin, methods{http.MethodDelete: dropIndex})
+	rt.handle("/admin/users/import", accessAdmin, methods{http.MethodPost: importUser})
+	rt.handle("/admin/users/{id}/export", accessAdmin, methods{http.MethodGet: exportUser})
+	rt.handle("/admin/backfill", accessAdmin, methods{http.MethodPost: backfillHandler})
+
+	// Public read endpoint
+	rt.handle("/public/series/{name}", accessPublic, methods{http.MethodGet: publicSeriesHandler})
+	rt.handle("/public/{id}", accessPublic, methods{http.MethodGet: withID(publicHandler)})
+	rt.handle("/public/{id}/feed.xml", accessPublic, methods{http.MethodGet: withID(feedHandler)})
+	rt.handle("/public/{id}/render", accessPublic, methods{http.MethodGet: withID(renderHandler)})
+	return rt
 }
 
 // CORS middleware
@@ -160,8 +744,12 @@ func corsMiddleware(next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
 		}
 
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization")
+		_, path := splitVersion(r.URL.Path)
+		if allow := routes.allowedMethods(path); allow != "" {
+			w.Header().Set("Access-Control-Allow-Methods", allow)
+		}
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-API-Key, Authorization, X-Feature, X-Request-ID, If-None-Match, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, X-Total-Count, X-Data-Freshness, X-Request-ID, ETag, Creation-Token, Idempotent-Replayed")
 		w.Header().Set("Access-Control-Max-Age", "86400")
 
 		if r.Method == "OPTIONS" {
@@ -173,9 +761,26 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Auth middleware - supports both API key and legacy global API key
+// Auth middleware - supports session tokens, API keys and the legacy global
+// API key. Tokens are verified without a database lookup.
 func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	next = logUser(next)
 	return func(w http.ResponseWriter, r *http.Request) {
+		if token := bearerToken(r); token != "" {
+			claims, err := parseToken(token)
+			if err != nil {
+				sendJSON(w, http.StatusUnauthorized, APIResponse{
+					Success: false,
+					Error:   "Invalid or expired token",
+				})
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), "user_id", claims.Subject))
+			r = withFeatures(r)
+			next(w, r)
+			return
+		}
+
 		apiKey := r.Header.Get("X-API-Key")
 		if apiKey == "" {
 			apiKey = r.URL.Query().Get("api_key")
@@ -193,23 +798,51 @@ func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		if config.APIKey != "" && apiKey == config.APIKey {
 			// Use global context
 			r = r.WithContext(context.WithValue(r.Context(), "user_id", "global"))
+			r = withFeatures(r)
 			next(w, r)
 			return
 		}
 
-		// Check user API key
+		// Check user API key, then scoped keys
 		var user User
-		err := usersCollection.FindOne(ctx, bson.M{"api_key": apiKey}).Decode(&user)
+		c, cancel := dbContext(r.Context())
+		err := usersCollection.FindOne(c, liveAPIKey(apiKey)).Decode(&user)
+		cancel()
+		dbBreaker.Record(err)
 		if err != nil {
+			if scoped, ok := withScopedKey(r, apiKey); ok {
+				next(w, withFeatures(scoped))
+				return
+			}
 			sendJSON(w, http.StatusUnauthorized, APIResponse{
 				Success: false,
 				Error:   "Invalid API key",
 			})
 			return
 		}
+		// The key is returned at registration, so it must not bypass login
+		if config.RequireVerification == "login" && !user.isVerified() {
+			sendUnverified(w)
+			return
+		}
 
 		r = r.WithContext(context.WithValue(r.Context(), "user_id", user.ID))
 		r = r.WithContext(context.WithValue(r.Context(), "user", user))
+		if user.APIKey != apiKey {
+			r = r.WithContext(context.WithValue(r.Context(), "previous_key", true))
+		}
+		r = withFeatures(r)
+		next(w, r)
+	}
+}
+
+// Admin middleware - only the global API key may use admin routes
+func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if getUserID(r) != "global" {
+			sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Admin access required"})
+			return
+		}
 		next(w, r)
 	}
 }
@@ -221,51 +854,106 @@ func getUserID(r *http.Request) string {
 	return ""
 }
 
-// Health handler
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	sendJSON(w, http.StatusOK, APIResponse{
-		Success: true,
-		Message: "JSON API Server is running",
-		Data: map[string]interface{}{
-			"version":   "1.1.0",
-			"storage":   "mongodb",
-			"auth":      "email",
-			"timestamp": time.Now().UTC(),
-		},
-	})
+// parseFeatureFlags parses "name=true,other=false" into feature defaults.
+// A bare name enables the feature by default.
+func parseFeatureFlags(value string) map[string]bool {
+	features := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		name, setting, hasSetting := strings.Cut(strings.TrimSpace(item), "=")
+		if name == "" {
+			continue
+		}
+		enabled := true
+		if hasSetting {
+			enabled, _ = strconv.ParseBool(setting)
+		}
+		features[name] = enabled
+	}
+	return features
 }
 
-// Register handler
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Method not allowed"})
-		return
+// withFeatures resolves X-Feature overrides (e.g. "strict_json" or
+// "strict_json=false") for configured features into the request context.
+// Only called for authenticated requests.
+func withFeatures(r *http.Request) *http.Request {
+	header := r.Header.Values("X-Feature")
+	if len(header) == 0 {
+		return r
+	}
+
+	overrides := make(map[string]bool)
+	for name, enabled := range parseFeatureFlags(strings.Join(header, ",")) {
+		if _, known := config.Features[name]; known {
+			overrides[name] = enabled
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), "features", overrides))
+}
+
+// featureEnabled reports whether a feature is on for this request
+func featureEnabled(r *http.Request, name string) bool {
+	if overrides, ok := r.Context().Value("features").(map[string]bool); ok {
+		if enabled, ok := overrides[name]; ok {
+			return enabled
+		}
+	}
+	return config.Features[name]
+}
+
+// decodeJSON decodes a request body, rejecting unknown fields when the
+// strict_json feature is enabled
+func decodeJSON(r *http.Request, body []byte, v interface{}) error {
+	if !featureEnabled(r, "strict_json") {
+		return json.Unmarshal(body, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return json.Unmarshal(body, v)
+		}
+		return err
 	}
+	return nil
+}
 
+// Register handler
+func registerHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
-	body, _ := io.ReadAll(r.Body)
-	if err := json.Unmarshal(body, &input); err != nil {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid JSON"})
+	body, ok := readBody(w, r, config.MaxAuthBytes)
+	if !ok {
 		return
 	}
-
-	if input.Email == "" || input.Password == "" {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Email and password are required"})
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
 		return
 	}
 
-	if len(input.Password) < 6 {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Password must be at least 6 characters"})
+	var fieldErrors []FieldError
+	if input.Email == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "email", Message: "is required"})
+	}
+	if input.Password == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "password", Message: "is required"})
+	} else if len(input.Password) < 6 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "password", Message: "must be at least 6 characters"})
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
 		return
 	}
 
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
 	// Check if email exists
 	var existing User
-	err := usersCollection.FindOne(ctx, bson.M{"email": strings.ToLower(input.Email)}).Decode(&existing)
+	err := usersCollection.FindOne(c, bson.M{"email": strings.ToLower(input.Email)}).Decode(&existing)
+	dbBreaker.Record(err)
 	if err == nil {
 		sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "Email already registered"})
 		return
@@ -278,58 +966,94 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, tokenHash, err := newEmailToken()
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create account"})
+		return
+	}
+
 	// Create user
 	user := User{
 		ID:        uuid.New().String(),
 		Email:     strings.ToLower(input.Email),
 		Password:  string(hashedPassword),
-		APIKey:    uuid.New().String(),
+		APIKey:    generateAPIKey(),
 		CreatedAt: time.Now().UTC(),
+		// A new account has nothing stored yet
+		StorageBytes: new(int64),
+		Verified:     new(bool),
+		VerifyToken:  tokenHash,
 	}
 
-	_, err = usersCollection.InsertOne(ctx, user)
+	_, err = usersCollection.InsertOne(c, user)
+	dbBreaker.Record(err)
 	if err != nil {
 		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create account"})
 		return
 	}
+	sendVerification(user.Email, token)
 
 	sendJSON(w, http.StatusCreated, APIResponse{
 		Success: true,
-		Message: "Account created successfully",
+		Message: "Account created successfully; check your email to verify it",
 		Data: map[string]interface{}{
-			"id":      user.ID,
-			"email":   user.Email,
-			"api_key": user.APIKey,
+			"id":       user.ID,
+			"email":    user.Email,
+			"api_key":  user.APIKey,
+			"verified": false,
 		},
 	})
 }
 
-// Login handler
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Method not allowed"})
-		return
+// generateAPIKey creates a new user API key. With API_KEY_PREFIX set, keys
+// look like "<prefix><48 hex chars>" so leaked keys are easy to identify and
+// scan for; otherwise a plain UUID is used as before.
+func generateAPIKey() string {
+	if config.APIKeyPrefix == "" {
+		return uuid.New().String()
 	}
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return config.APIKeyPrefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	return config.APIKeyPrefix + hex.EncodeToString(buf)
+}
 
+// Login handler
+func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 
-	body, _ := io.ReadAll(r.Body)
+	body, ok := readBody(w, r, config.MaxAuthBytes)
+	if !ok {
+		return
+	}
 	if err := json.Unmarshal(body, &input); err != nil {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid JSON"})
+		sendParseError(w, err)
 		return
 	}
 
-	if input.Email == "" || input.Password == "" {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Email and password are required"})
+	var fieldErrors []FieldError
+	if input.Email == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "email", Message: "is required"})
+	}
+	if input.Password == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "password", Message: "is required"})
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
 		return
 	}
 
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
 	// Find user
 	var user User
-	err := usersCollection.FindOne(ctx, bson.M{"email": strings.ToLower(input.Email)}).Decode(&user)
+	err := usersCollection.FindOne(c, bson.M{"email": strings.ToLower(input.Email)}).Decode(&user)
+	dbBreaker.Record(err)
 	if err != nil {
 		sendJSON(w, http.StatusUnauthorized, APIResponse{Success: false, Error: "Invalid email or password"})
 		return
@@ -340,26 +1064,49 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		sendJSON(w, http.StatusUnauthorized, APIResponse{Success: false, Error: "Invalid email or password"})
 		return
 	}
+	if config.RequireVerification == "login" && !user.isVerified() {
+		sendUnverified(w)
+		return
+	}
+
+	data := map[string]interface{}{
+		"id":      user.ID,
+		"email":   user.Email,
+		"api_key": user.APIKey,
+	}
+	if config.JWTSecret != "" {
+		token, expires, err := issueToken(user.ID)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to issue token"})
+			return
+		}
+		data["token"] = token
+		data["token_expires_at"] = expires
+	}
 
 	sendJSON(w, http.StatusOK, APIResponse{
 		Success: true,
 		Message: "Login successful",
-		Data: map[string]interface{}{
-			"id":      user.ID,
-			"email":   user.Email,
-			"api_key": user.APIKey,
-		},
+		Data:    data,
 	})
 }
 
 // Me handler - get current user info
 func meHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Method not allowed"})
-		return
-	}
+	c, cancel := dbContext(r.Context())
+	defer cancel()
 
 	user, ok := r.Context().Value("user").(User)
+	if !ok && getUserID(r) != "global" {
+		// Token-authenticated requests carry only the user ID
+		err := usersCollection.FindOne(c, bson.M{"_id": getUserID(r)}).Decode(&user)
+		dbBreaker.Record(err)
+		if err != nil {
+			sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "User not found"})
+			return
+		}
+		ok = true
+	}
 	if !ok {
 		sendJSON(w, http.StatusOK, APIResponse{
 			Success: true,
@@ -381,135 +1128,567 @@ func meHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// Documents handler
-func documentsHandler(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		listDocuments(w, r)
-	case http.MethodPost:
-		createDocument(w, r)
-	default:
-		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Method not allowed"})
-	}
+// publicFilter matches a live document its owner has published. Documents
+// stored before visibility existed have no is_public and stay private.
+func publicFilter(id string) bson.M {
+	return live(bson.M{"_id": id, "is_public": true})
 }
 
-// Document handler
-func documentHandler(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/api/documents/")
-	id := strings.TrimSuffix(path, "/")
+// Public handler
+func publicHandler(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
 
-	if id == "" {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Document ID is required"})
+	var doc JSONDocument
+	err := docCollection.FindOne(c, publicFilter(id)).Decode(&doc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if !allowPublicRead(w, doc) {
 		return
 	}
 
-	switch r.Method {
-	case http.MethodGet:
-		getDocument(w, r, id)
-	case http.MethodPut:
-		updateDocument(w, r, id)
-	case http.MethodDelete:
-		deleteDocument(w, r, id)
-	default:
-		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Method not allowed"})
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	if prettyPublic(r) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(formatNumbers(publicData(doc))); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to serialize document"})
+		return
 	}
-}
 
-// Public handler
-func publicHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Only GET allowed"})
+	w.Header().Set("Cache-Control", publicCacheControl())
+	w.Header().Add("Vary", "Accept")
+	if notModified(w, r, weakETag(body.Bytes())) {
 		return
 	}
 
-	path := strings.TrimPrefix(r.URL.Path, "/public/")
-	id := strings.TrimSuffix(path, "/")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body.Bytes())
+}
 
-	if id == "" {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Document ID is required"})
-		return
+// prettyPublic decides whether a public response is indented. When enabled,
+// browsers (Accept preferring text/html) get readable output and API clients
+// stay compact; ?pretty=true|false overrides the header.
+func prettyPublic(r *http.Request) bool {
+	if !config.PublicPretty {
+		return false
+	}
+	if pretty, err := strconv.ParseBool(r.URL.Query().Get("pretty")); err == nil {
+		return pretty
 	}
+	accept := r.Header.Get("Accept")
+	return acceptQuality(accept, "text/html") > acceptQuality(accept, "application/json")
+}
 
-	var doc JSONDocument
-	err := docCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc)
-	if err != nil {
-		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
-		return
+// acceptQuality returns the q-value an Accept header gives a media type,
+// honoring type/* and */* wildcards with the most specific match winning
+func acceptQuality(accept, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	best, specificity := 0.0, -1
+
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		media := strings.ToLower(strings.TrimSpace(params[0]))
+
+		level := -1
+		switch media {
+		case mediaType:
+			level = 2
+		case mainType + "/*":
+			level = 1
+		case "*/*":
+			level = 0
+		}
+		if level < specificity || level < 0 {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && key == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		best, specificity = q, level
 	}
+	return best
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=60")
-	json.NewEncoder(w).Encode(doc.Data)
+// publicCacheControl builds the Cache-Control header for public reads.
+// The stale-* directives are only emitted when configured.
+func publicCacheControl() string {
+	directives := []string{"public", fmt.Sprintf("max-age=%d", config.PublicMaxAge)}
+	if config.PublicStaleWhileRevalidate > 0 {
+		directives = append(directives, fmt.Sprintf("stale-while-revalidate=%d", config.PublicStaleWhileRevalidate))
+	}
+	if config.PublicStaleIfError > 0 {
+		directives = append(directives, fmt.Sprintf("stale-if-error=%d", config.PublicStaleIfError))
+	}
+	return strings.Join(directives, ", ")
+}
+
+// resolveOwner turns an ?owner= value, either a user ID or an email, into
+// a user ID
+func resolveOwner(c context.Context, owner string) (string, error) {
+	if !strings.Contains(owner, "@") {
+		return owner, nil
+	}
+
+	var user User
+	err := usersCollection.FindOne(c, bson.M{"email": strings.ToLower(owner)}).Decode(&user)
+	dbBreaker.Record(err)
+	return user.ID, err
 }
 
 // List documents for current user
 func listDocuments(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r)
 
-	filter := bson.M{}
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	filter := live(bson.M{})
 	if userID != "global" {
 		filter["user_id"] = userID
+	} else if owner := r.URL.Query().Get("owner"); owner != "" {
+		// Only the global key can narrow the listing to another user
+		ownerID, err := resolveOwner(c, owner)
+		if err == mongo.ErrNoDocuments {
+			sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "User not found"})
+			return
+		}
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up owner"})
+			return
+		}
+		filter["user_id"] = ownerID
 	}
 
-	cursor, err := docCollection.Find(ctx, filter)
-	if err != nil {
-		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list documents"})
-		return
+	if r.URL.Query().Has("folder") {
+		if folder := r.URL.Query().Get("folder"); folder != "" {
+			filter["folder"] = folder
+		} else {
+			filter["folder"] = bson.M{"$exists": false}
+		}
 	}
-	defer cursor.Close(ctx)
 
-	var docs []JSONDocument
-	if err := cursor.All(ctx, &docs); err != nil {
-		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+	// Field presence filters: ?exists=data.foo&missing=data.bar
+	for param, present := range map[string]bool{"exists": true, "missing": false} {
+		for _, value := range r.URL.Query()[param] {
+			for _, field := range strings.Split(value, ",") {
+				field = strings.TrimSpace(field)
+				if field == "" {
+					continue
+				}
+				if !isQueryableField(field) {
+					sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid field path: " + field})
+					return
+				}
+				filter[field] = bson.M{"$exists": present}
+			}
+		}
+	}
+
+	if r.URL.Query().Get("tree") == "true" {
+		// A tree of a folder takes in its subfolders
+		if folder, ok := filter["folder"].(string); ok {
+			filter["folder"] = folderSubtree(folder)
+		}
+		listTree(c, w, filter)
 		return
 	}
 
-	if docs == nil {
-		docs = []JSONDocument{}
+	// Cursor pagination: ?limit= (default 50, max 200) and ?cursor=<last id>
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 200 {
+		limit = 200
 	}
 
-	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: docs})
-}
+	total, ok, err := listTotal(c, r, filter)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to count documents"})
+		return
+	}
+	if ok {
+		w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	}
+
+	if after := r.URL.Query().Get("cursor"); after != "" {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+
+	opts := options.Find().SetLimit(int64(limit + 1)).SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := docCollection.Find(c, filter, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	docs, skipped, lastID, err := decodeListed(c, r, cursor, limit)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+
+	// The extra document fetched beyond the limit only signals another page
+	page := APIResponse{Success: true, Skipped: skipped}
+	if cursor.Next(c) {
+		page.HasMore = true
+		page.NextCursor = lastID
+	}
+	for i := range docs {
+		docs[i].Data = formatNumbers(docs[i].Data)
+	}
+
+	var documents interface{} = docs
+	if r.URL.Query().Get("with_child_counts") == "true" {
+		if config.ParentField == "" {
+			sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Child counts are not enabled"})
+			return
+		}
+		documents, err = withChildCounts(c, userID, docs)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to count child documents"})
+			return
+		}
+	}
+
+	page.Data = documents
+	sendJSON(w, http.StatusOK, page)
+}
+
+// listTotal computes the X-Total-Count for a list according to ?count= (or
+// LIST_COUNT_MODE): "exact" counts matching documents across all pages,
+// "estimated" uses the collection metadata count when the listing is
+// unfiltered and falls back to exact otherwise, and "none" skips counting.
+// The estimate includes trashed documents.
+func listTotal(c context.Context, r *http.Request, filter bson.M) (int64, bool, error) {
+	mode := r.URL.Query().Get("count")
+	if mode == "" {
+		mode = config.CountMode
+	}
+
+	switch mode {
+	case "none", "false":
+		return 0, false, nil
+	case "estimated":
+		if isUnfiltered(filter) {
+			total, err := docCollection.EstimatedDocumentCount(c)
+			dbBreaker.Record(err)
+			return total, err == nil, err
+		}
+	}
+	total, err := docCollection.CountDocuments(c, filter)
+	dbBreaker.Record(err)
+	return total, err == nil, err
+}
+
+// isUnfiltered reports whether a list filter selects every live document
+func isUnfiltered(filter bson.M) bool {
+	_, trash := filter["deleted_at"]
+	return len(filter) == 0 || len(filter) == 1 && trash
+}
+
+var dataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Group documents by a top-level data field and count each value.
+// Array values (e.g. tags) are unwound so every element is counted.
+func groupDocuments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	field := strings.TrimPrefix(r.URL.Query().Get("field"), "data.")
+	if !dataKeyPattern.MatchString(field) {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "field must be a top-level data key"})
+		return
+	}
+
+	limit := config.MaxGroups
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l < limit {
+		limit = l
+	}
+
+	match := live(bson.M{})
+	if userID != "global" {
+		match["user_id"] = userID
+	}
+
+	path := "$data." + field
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$unwind", Value: bson.M{"path": path, "preserveNullAndEmptyArrays": true}}},
+		{{Key: "$group", Value: bson.M{"_id": path, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	cursor, err := docCollection.Aggregate(c, pipeline)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to group documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	var rows []struct {
+		Value interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	}
+	if err := cursor.All(c, &rows); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode groups"})
+		return
+	}
+
+	groups := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		groups = append(groups, map[string]interface{}{"value": row.Value, "count": row.Count})
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"field":  "data." + field,
+			"groups": groups,
+		},
+	})
+}
+
+var metadataFields = map[string]bool{"name": true, "created_at": true, "updated_at": true}
+
+// isQueryableField reports whether a field path may be used in a query.
+// Only metadata fields and dotted paths below data are accepted.
+func isQueryableField(field string) bool {
+	if metadataFields[field] {
+		return true
+	}
+	parts := strings.Split(field, ".")
+	if len(parts) < 2 || parts[0] != "data" {
+		return false
+	}
+	for _, part := range parts[1:] {
+		if !dataKeyPattern.MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateData runs the configured checks on document data before a write.
+// uniqueArrays lists the data paths whose arrays must not contain duplicates.
+func validateData(data map[string]interface{}, uniqueArrays []string) []FieldError {
+	fieldErrors := validateGeoPoint(data)
+	return append(fieldErrors, validateUniqueArrays(data, uniqueArrays)...)
+}
+
+// validateUniqueArrays reports constrained array paths containing duplicate
+// items. Items are compared by their canonical JSON encoding.
+func validateUniqueArrays(data map[string]interface{}, paths []string) []FieldError {
+	var fieldErrors []FieldError
+	for _, path := range paths {
+		if !isQueryableField(path) || metadataFields[path] {
+			fieldErrors = append(fieldErrors, FieldError{Field: "unique_arrays", Message: path + " is not a data path"})
+			continue
+		}
+
+		value, _ := lookupPath(data, strings.TrimPrefix(path, "data."))
+		items, ok := asArray(value)
+		if !ok {
+			continue
+		}
+
+		seen := make(map[string]bool, len(items))
+		for _, item := range items {
+			key, _ := json.Marshal(item)
+			if seen[string(key)] {
+				fieldErrors = append(fieldErrors, FieldError{Field: path, Message: "contains duplicate item " + string(key)})
+				break
+			}
+			seen[string(key)] = true
+		}
+	}
+	return fieldErrors
+}
 
 // Create document
 func createDocument(w http.ResponseWriter, r *http.Request) {
 	userID := getUserID(r)
 
 	var input struct {
-		Name string                 `json:"name"`
-		Data map[string]interface{} `json:"data"`
+		Name         string                 `json:"name"`
+		Folder       string                 `json:"folder"`
+		Data         map[string]interface{} `json:"data"`
+		UniqueArrays []string               `json:"unique_arrays"`
+		Derived      []Derivation           `json:"derived"`
+		SchemaRef    *SchemaRef             `json:"schema_ref"`
+		RateLimit    int                    `json:"public_rate_limit"`
+		IsPublic     bool                   `json:"is_public"`
+		ClientKey    string                 `json:"client_key"`
+		PublicFields []string               `json:"public_fields"`
 	}
 
-	body, _ := io.ReadAll(r.Body)
-	if err := json.Unmarshal(body, &input); err != nil {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid JSON"})
+	body, ok := readDocumentBody(w, r)
+	if !ok {
 		return
 	}
 
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	if input.RateLimit < 0 {
+		sendValidationError(w, []FieldError{{Field: "public_rate_limit", Message: "must not be negative"}})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	// A create for a client key that already has a document is a no-op. The
+	// Idempotency-Key stands in for a missing client key so the document
+	// stays protected after the stored response expires.
+	if input.ClientKey == "" {
+		input.ClientKey = r.Header.Get("Idempotency-Key")
+	}
+	if input.ClientKey != "" {
+		if !syncKeyPattern.MatchString(input.ClientKey) {
+			sendValidationError(w, []FieldError{{Field: "client_key", Message: "must be 1 to 255 printable ASCII characters"}})
+			return
+		}
+		existing, err := findByClientKey(c, userID, input.ClientKey)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up client_key"})
+			return
+		}
+		if existing != nil {
+			sendSynced(w, existing)
+			return
+		}
+	}
+
 	if input.Name == "" {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Document name is required"})
+		sendValidationError(w, []FieldError{{Field: "name", Message: "is required"}})
 		return
 	}
 
 	if input.Data == nil {
 		input.Data = make(map[string]interface{})
 	}
+	now := time.Now().UTC()
+	stampData(input.Data, nil, now, now)
+
+	if fieldErrors := validateData(input.Data, input.UniqueArrays); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	if fieldErrors := validateDerivations(input.Derived); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	if fieldErrors := validatePublicFields(input.PublicFields); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	if input.SchemaRef == nil {
+		ref, err := schemaForName(c, userID, input.Name)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+			return
+		}
+		input.SchemaRef = ref
+	}
+	if input.SchemaRef != nil {
+		fieldErrors, err := validateSchemaRef(c, userID, input.SchemaRef, input.Data)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+			return
+		}
+		if len(fieldErrors) > 0 {
+			sendValidationError(w, fieldErrors)
+			return
+		}
+	}
 
 	doc := JSONDocument{
 		ID:        uuid.New().String(),
 		UserID:    userID,
 		Name:      input.Name,
+		Folder:    input.Folder,
 		Data:      input.Data,
-		CreatedAt: time.Now().UTC(),
-		UpdatedAt: time.Now().UTC(),
+		Unique:    input.UniqueArrays,
+		Derived:   input.Derived,
+		SchemaRef: input.SchemaRef,
+		RateLimit: input.RateLimit,
+		IsPublic:  input.IsPublic,
+		ClientKey: input.ClientKey,
+		CreatedAt: now,
+		UpdatedAt: now,
+
+		PublicFields: input.PublicFields,
+		ContentHash:  contentHash(input.Data),
+	}
+	if config.InferSchema {
+		doc.Schema = inferSchema(doc.Data)
+	}
+
+	if config.UniqueContent != "off" && doc.ContentHash != "" {
+		existing, err := findByContentHash(c, userID, doc.ContentHash)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to check for duplicate data"})
+			return
+		}
+		if existing != nil {
+			sendDuplicateContent(w, existing)
+			return
+		}
 	}
 
-	_, err := docCollection.InsertOne(ctx, doc)
+	size := dataSize(doc.Data)
+	if !chargeStorage(c, w, userID, size) {
+		return
+	}
+	_, err := docCollection.InsertOne(c, doc)
+	dbBreaker.Record(err)
+	settleStorage(userID, size, err == nil)
+	if isContentConflict(err) {
+		// A concurrent create stored the same data first
+		existing, _ := findByContentHash(c, userID, doc.ContentHash)
+		sendDuplicateContent(w, existing)
+		return
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		// A concurrent create may have claimed the client key first
+		if doc.ClientKey != "" {
+			if existing, findErr := findByClientKey(c, userID, doc.ClientKey); findErr == nil && existing != nil {
+				sendSynced(w, existing)
+				return
+			}
+		}
+		sendNameConflict(w)
+		return
+	}
 	if err != nil {
 		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to save document"})
 		return
 	}
 
+	w.Header().Set("Location", documentLocation(w, doc.ID))
+	if doc.ClientKey != "" {
+		w.Header().Set("Creation-Token", doc.ClientKey)
+	}
 	sendJSON(w, http.StatusCreated, APIResponse{
 		Success: true,
 		Message: "Document created successfully",
@@ -526,18 +1705,217 @@ func getDocument(w http.ResponseWriter, r *http.Request, id string) {
 		filter["user_id"] = userID
 	}
 
+	// ?fields= only loads the selected paths, unless the whole document is
+	// being downloaded
+	opts := options.FindOne()
+	projection := documentProjection(r.URL.Query().Get("fields"))
+	if projection != nil && r.URL.Query().Get("download") != "true" {
+		opts.SetProjection(projection)
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
 	var doc JSONDocument
-	err := docCollection.FindOne(ctx, filter).Decode(&doc)
+	err := docCollection.FindOne(c, live(filter), opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == nil && projection != nil && len(doc.Derived) > 0 && r.URL.Query().Get("derive") != "false" {
+		// Derivations may read paths outside the projection
+		err = docCollection.FindOne(c, live(filter)).Decode(&doc)
+		dbBreaker.Record(err)
+	}
 	if err != nil {
 		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
 		return
 	}
 
+	// Computed fields are added on read only; ?derive=false returns stored data
+	if r.URL.Query().Get("derive") != "false" {
+		doc.Data = applyDerivations(doc.Data, doc.Derived)
+	}
+	doc.Data = formatNumbers(doc.Data)
+
+	if r.URL.Query().Get("download") == "true" {
+		downloadDocument(w, r, doc)
+		return
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to serialize document"})
+		return
+	}
+	if notModified(w, r, weakETag(body)) {
+		return
+	}
+
 	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: doc})
 }
 
+// Get document by name - serves the caller's document with that name like a
+// read by ID. Without UNIQUE_DOC_NAMES two documents may share the name,
+// which returns 409 with code name_ambiguous.
+func getDocumentByName(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{"name": pathParam(r, "name")}
+	if userID := getUserID(r); userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(2)
+	cursor, err := docCollection.Find(c, live(filter), opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up document"})
+		return
+	}
+	var matches []JSONDocument
+	if err := cursor.All(c, &matches); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up document"})
+		return
+	}
+
+	switch len(matches) {
+	case 0:
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+	case 1:
+		getDocument(w, r, matches[0].ID)
+	default:
+		sendJSON(w, http.StatusConflict, APIResponse{
+			Success: false,
+			Error:   "Several documents have this name; read them by ID",
+			Code:    "name_ambiguous",
+		})
+	}
+}
+
+// downloadDocument serves a document as a JSON file attachment. The body is
+// serialized up front so Range requests can resume an interrupted download;
+// its strong ETag lets If-Range check the document hasn't changed since.
+// Like public reads, the file's data carries DATA_ID_FIELD and
+// DATA_NAME_FIELD for consumers that keep only the data.
+func downloadDocument(w http.ResponseWriter, r *http.Request, doc JSONDocument) {
+	doc.Data = identifyData(doc, doc.Data)
+	body, err := json.Marshal(doc)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to serialize document"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": doc.Name + ".json"}))
+	w.Header().Set("ETag", strongETag(body))
+	http.ServeContent(w, r, "", doc.UpdatedAt, bytes.NewReader(body))
+}
+
+// Get a window of an array stored inside a document's data
+func getDocumentItems(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+	query := r.URL.Query()
+
+	path := query.Get("path")
+	if !isQueryableField(path) || metadataFields[path] {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "path must be a data path such as data.items"})
+		return
+	}
+
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	if limit > 1000 {
+		limit = 1000
+	}
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	opts := options.FindOne().SetProjection(bson.M{path: 1})
+	err = docCollection.FindOne(c, live(filter), opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+
+	value, _ := lookupPath(doc.Data, strings.TrimPrefix(path, "data."))
+	items, ok := asArray(value)
+	if !ok {
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{Success: false, Error: path + " is not an array"})
+		return
+	}
+
+	total := len(items)
+	start := offset
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"path":   path,
+			"items":  items[start:end],
+			"offset": offset,
+			"limit":  limit,
+			"total":  total,
+		},
+	})
+}
+
+// lookupPath resolves a dotted path such as "profile.name" within data
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// asArray normalizes arrays decoded from JSON or BSON
+func asArray(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case bson.A:
+		return v, true
+	}
+	return nil, false
+}
+
 // Update document
 func updateDocument(w http.ResponseWriter, r *http.Request, id string) {
+	// A merge patch updates data like PATCH instead of replacing it
+	if isMergePatch(r) {
+		patchDocument(w, r, id)
+		return
+	}
+	if dataKeys, scoped := scopedDataKeys(r); scoped {
+		updateScopedData(w, r, id, dataKeys)
+		return
+	}
 	userID := getUserID(r)
 
 	filter := bson.M{"_id": id}
@@ -545,42 +1923,394 @@ func updateDocument(w http.ResponseWriter, r *http.Request, id string) {
 		filter["user_id"] = userID
 	}
 
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
 	var existingDoc JSONDocument
-	err := docCollection.FindOne(ctx, filter).Decode(&existingDoc)
+	err := docCollection.FindOne(c, live(filter)).Decode(&existingDoc)
+	dbBreaker.Record(err)
 	if err != nil {
 		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
 		return
 	}
+	if existingDoc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	filter["frozen"] = bson.M{"$ne": true}
+	prior := existingDoc
 
 	var input struct {
-		Name string                 `json:"name"`
-		Data map[string]interface{} `json:"data"`
+		Name         string                 `json:"name"`
+		Folder       *string                `json:"folder"`
+		Data         map[string]interface{} `json:"data"`
+		UniqueArrays []string               `json:"unique_arrays"`
+		Derived      []Derivation           `json:"derived"`
+		SchemaRef    *SchemaRef             `json:"schema_ref"`
+		RateLimit    *int                   `json:"public_rate_limit"`
+		IsPublic     *bool                  `json:"is_public"`
+		PublicFields *[]string              `json:"public_fields"`
 	}
 
-	body, _ := io.ReadAll(r.Body)
-	if err := json.Unmarshal(body, &input); err != nil {
-		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Invalid JSON"})
+	body, ok := readDocumentBody(w, r)
+	if !ok {
+		return
+	}
+
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	if input.RateLimit != nil && *input.RateLimit < 0 {
+		sendValidationError(w, []FieldError{{Field: "public_rate_limit", Message: "must not be negative"}})
+		return
+	}
+
+	now := time.Now().UTC()
+	if input.Data != nil {
+		stampData(input.Data, existingDoc.Data, existingDoc.CreatedAt, now)
+	}
+
+	if input.Data != nil || input.UniqueArrays != nil {
+		data, uniqueArrays := existingDoc.Data, existingDoc.Unique
+		if input.Data != nil {
+			data = input.Data
+		}
+		if input.UniqueArrays != nil {
+			uniqueArrays = input.UniqueArrays
+		}
+		if fieldErrors := validateData(data, uniqueArrays); len(fieldErrors) > 0 {
+			sendValidationError(w, fieldErrors)
+			return
+		}
+	}
+
+	if fieldErrors := validateDerivations(input.Derived); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
 		return
 	}
+	if input.PublicFields != nil {
+		if fieldErrors := validatePublicFields(*input.PublicFields); len(fieldErrors) > 0 {
+			sendValidationError(w, fieldErrors)
+			return
+		}
+	}
 
-	update := bson.M{"$set": bson.M{"updated_at": time.Now().UTC()}}
+	// Validate against the referenced shared schema. The stored version is
+	// kept unless the request names a schema; {"name": ""} detaches it.
+	// Documents without one pick up a schema registered under their name.
+	schemaRef := existingDoc.SchemaRef
+	schemaChanged := input.SchemaRef != nil
+	if input.SchemaRef != nil {
+		schemaRef = input.SchemaRef
+		if schemaRef.Name == "" {
+			schemaRef = nil
+		}
+	} else if schemaRef == nil {
+		name := existingDoc.Name
+		if input.Name != "" {
+			name = input.Name
+		}
+		ref, err := schemaForName(c, existingDoc.UserID, name)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+			return
+		}
+		schemaRef, schemaChanged = ref, ref != nil
+	}
+	if schemaRef != nil && (input.Data != nil || schemaChanged) {
+		data := existingDoc.Data
+		if input.Data != nil {
+			data = input.Data
+		}
+		fieldErrors, err := validateSchemaRef(c, existingDoc.UserID, schemaRef, data)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+			return
+		}
+		if len(fieldErrors) > 0 {
+			sendValidationError(w, fieldErrors)
+			return
+		}
+	}
+
+	// Compare against the schema inferred on create: ?enforce_schema=warn
+	// reports drift in the response, strict rejects it
+	var drift []FieldError
+	enforce := r.URL.Query().Get("enforce_schema")
+	if input.Data != nil && existingDoc.Schema != nil && (enforce == "warn" || enforce == "strict") {
+		drift = schemaDrift(existingDoc.Schema, input.Data)
+		if enforce == "strict" && len(drift) > 0 {
+			sendValidationError(w, drift)
+			return
+		}
+	}
+
+	update := bson.M{"$set": bson.M{"updated_at": now}}
 	if input.Name != "" {
 		update["$set"].(bson.M)["name"] = input.Name
 		existingDoc.Name = input.Name
 	}
+	if input.Folder != nil {
+		// An empty folder moves the document back to the top level
+		if *input.Folder == "" {
+			update["$unset"] = bson.M{"folder": ""}
+		} else {
+			update["$set"].(bson.M)["folder"] = *input.Folder
+		}
+		existingDoc.Folder = *input.Folder
+	}
 	if input.Data != nil {
 		update["$set"].(bson.M)["data"] = input.Data
+		update["$set"].(bson.M)["content_hash"] = contentHashValue(input.Data)
 		existingDoc.Data = input.Data
 	}
+	if input.UniqueArrays != nil {
+		update["$set"].(bson.M)["unique_arrays"] = input.UniqueArrays
+		existingDoc.Unique = input.UniqueArrays
+	}
+	if input.Derived != nil {
+		update["$set"].(bson.M)["derived"] = input.Derived
+		existingDoc.Derived = input.Derived
+	}
+	if schemaChanged {
+		if schemaRef == nil {
+			unset, _ := update["$unset"].(bson.M)
+			if unset == nil {
+				unset = bson.M{}
+				update["$unset"] = unset
+			}
+			unset["schema_ref"] = ""
+		} else {
+			update["$set"].(bson.M)["schema_ref"] = schemaRef
+		}
+		existingDoc.SchemaRef = schemaRef
+	}
+	if input.RateLimit != nil {
+		update["$set"].(bson.M)["public_rate_limit"] = *input.RateLimit
+		existingDoc.RateLimit = *input.RateLimit
+	}
+	if input.IsPublic != nil {
+		update["$set"].(bson.M)["is_public"] = *input.IsPublic
+		existingDoc.IsPublic = *input.IsPublic
+	}
+	if input.PublicFields != nil {
+		update["$set"].(bson.M)["public_fields"] = *input.PublicFields
+		existingDoc.PublicFields = *input.PublicFields
+	}
 
-	_, err = docCollection.UpdateOne(ctx, filter, update)
+	var growth int64
+	if input.Data != nil {
+		growth = dataSize(input.Data) - dataSize(prior.Data)
+	}
+	if !chargeStorage(c, w, prior.UserID, growth) {
+		return
+	}
+	result, err := docCollection.UpdateOne(c, live(filter), update)
+	dbBreaker.Record(err)
+	settleStorage(prior.UserID, growth, err == nil && result.MatchedCount > 0)
+	if mongo.IsDuplicateKeyError(err) {
+		sendWriteConflict(w, err)
+		return
+	}
 	if err != nil {
 		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update"})
 		return
 	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	recordVersion(c, r, prior)
+
+	existingDoc.UpdatedAt = now
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document updated", Data: existingDoc, Warnings: drift})
+}
+
+// folderNameIndex backs UNIQUE_NAMES_PER_FOLDER
+var folderNameIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "folder", Value: 1}, {Key: "name", Value: 1}},
+	Options: options.Index().SetName("user_folder_name").SetUnique(true),
+}
+
+// userNameIndex backs UNIQUE_DOC_NAMES
+var userNameIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "name", Value: 1}},
+	Options: options.Index().SetName("user_name").SetUnique(true),
+}
+
+// sendNameConflict reports a name already used when UNIQUE_DOC_NAMES or
+// UNIQUE_NAMES_PER_FOLDER is enabled
+func sendNameConflict(w http.ResponseWriter) {
+	message := "A document with this name already exists in the folder"
+	if config.UniqueDocNames {
+		message = "A document with this name already exists"
+	}
+	sendJSON(w, http.StatusConflict, APIResponse{
+		Success: false,
+		Error:   message,
+		Code:    "name_conflict",
+	})
+}
+
+// Clear document - empties data but keeps id, name and other settings
+func clearDocument(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	filter["frozen"] = bson.M{"$ne": true}
+
+	update := bson.M{"$set": bson.M{
+		"data":         bson.M{},
+		"content_hash": nil,
+		"updated_at":   time.Now().UTC(),
+	}}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	// The prior state is returned so it can be kept as a version
+	var doc JSONDocument
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := docCollection.FindOneAndUpdate(c, live(filter), update, opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendWriteMiss(w, r, id)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to clear document"})
+		return
+	}
+	recordVersion(c, r, doc)
+
+	cleared := map[string]interface{}{}
+	settleStorage(doc.UserID, dataSize(cleared)-dataSize(doc.Data), true)
+
+	doc.Data = cleared
+	doc.UpdatedAt = update["$set"].(bson.M)["updated_at"].(time.Time)
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document cleared", Data: doc})
+}
+
+// Freeze document - makes it permanently read-only
+func freezeDocument(w http.ResponseWriter, r *http.Request, id string) {
+	setFrozen(w, r, id, true)
+}
+
+// Publish document - makes it readable through /public/{id}
+func publishDocument(w http.ResponseWriter, r *http.Request, id string) {
+	setPublic(w, r, id, true)
+}
+
+// Unpublish document - makes it private again
+func unpublishDocument(w http.ResponseWriter, r *http.Request, id string) {
+	setPublic(w, r, id, false)
+}
+
+// setPublic changes a document's visibility. Frozen documents can still be
+// unpublished since visibility isn't part of their content.
+func setPublic(w http.ResponseWriter, r *http.Request, id string, public bool) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := docCollection.FindOneAndUpdate(c, live(filter), bson.M{"$set": bson.M{"is_public": public}}, opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update document"})
+		return
+	}
+
+	message := "Document published"
+	if !public {
+		message = "Document unpublished"
+	}
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: message, Data: doc})
+}
+
+// Unfreeze document - admin only, and only when FREEZE_REVERSIBLE is set
+func unfreezeDocument(w http.ResponseWriter, r *http.Request, id string) {
+	if !config.FreezeUndo {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Freezing is irreversible on this server"})
+		return
+	}
+	setFrozen(w, r, id, false)
+}
+
+func setFrozen(w http.ResponseWriter, r *http.Request, id string, frozen bool) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	update := bson.M{"$set": bson.M{"frozen": frozen}}
+	if !frozen {
+		update = bson.M{"$unset": bson.M{"frozen": ""}}
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := docCollection.FindOneAndUpdate(c, live(filter), update, opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update document"})
+		return
+	}
+
+	message := "Document frozen"
+	if !frozen {
+		message = "Document unfrozen"
+	}
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: message, Data: doc})
+}
+
+// sendWriteMiss explains why a write matched no document: 403 if the
+// document exists but is frozen, 404 otherwise
+func sendWriteMiss(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id, "frozen": true}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
 
-	existingDoc.UpdatedAt = time.Now().UTC()
-	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document updated", Data: existingDoc})
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	count, err := docCollection.CountDocuments(c, filter)
+	dbBreaker.Record(err)
+	if err == nil && count > 0 {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
 }
 
 // Delete document
@@ -592,17 +2322,372 @@ func deleteDocument(w http.ResponseWriter, r *http.Request, id string) {
 		filter["user_id"] = userID
 	}
 
-	result, err := docCollection.DeleteOne(ctx, filter)
-	if err != nil || result.DeletedCount == 0 {
-		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+	filter["frozen"] = bson.M{"$ne": true}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	// ?permanent=true removes the document outright, including from the trash
+	if r.URL.Query().Get("permanent") == "true" {
+		// The deleted document is returned so its storage can be released
+		var doc JSONDocument
+		err := docCollection.FindOneAndDelete(c, filter).Decode(&doc)
+		dbBreaker.Record(err)
+		if err == mongo.ErrNoDocuments {
+			sendWriteMiss(w, r, id)
+			return
+		}
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to delete document"})
+			return
+		}
+		releaseStorage(doc.UserID, dataSize(doc.Data))
+		deleteVersions(bson.M{"document_id": id})
+		sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document permanently deleted"})
+		return
+	}
+
+	result, err := docCollection.UpdateOne(c, live(filter), bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to delete document"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document moved to trash"})
+}
+
+// Report orphaned documents - documents whose owner no longer exists
+func reportOrphans(w http.ResponseWriter, r *http.Request) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	ids, err := findOrphanedDocumentIDs(c)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to find orphaned documents"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"count": len(ids),
+			"ids":   ids,
+		},
+	})
+}
+
+// Purge orphaned documents - requires ?confirm=true
+func purgeOrphans(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("confirm") != "true" {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Purging requires ?confirm=true"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	ids, err := findOrphanedDocumentIDs(c)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to find orphaned documents"})
+		return
+	}
+
+	result, err := docCollection.DeleteMany(c, bson.M{"_id": bson.M{"$in": ids}})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to purge orphaned documents"})
 		return
 	}
+	deleteVersions(bson.M{"document_id": bson.M{"$in": ids}})
+
+	log.Printf("request_id=%s purged %d orphaned documents", requestID(r), result.DeletedCount)
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Orphaned documents purged",
+		Data:    map[string]interface{}{"deleted": result.DeletedCount},
+	})
+}
+
+// findOrphanedDocumentIDs returns documents whose user_id matches no user.
+// Documents owned by the global key are only included when configured.
+func findOrphanedDocumentIDs(c context.Context) ([]string, error) {
+	match := bson.M{"owner": bson.M{"$size": 0}}
+	if !config.OrphanGlobal {
+		match["user_id"] = bson.M{"$ne": "global"}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         usersCollection.Name(),
+			"localField":   "user_id",
+			"foreignField": "_id",
+			"as":           "owner",
+		}}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	}
+
+	cursor, err := docCollection.Aggregate(c, pipeline)
+	dbBreaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	var rows []struct {
+		ID string `bson:"_id"`
+	}
+	if err := cursor.All(c, &rows); err != nil {
+		return nil, err
+	}
 
-	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document deleted"})
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// Backfill handler - populates missing derived fields on existing documents.
+// Processes one batch in _id order per call; pass the returned cursor as
+// ?after= to resume. Safe to run repeatedly since only missing fields are set.
+func backfillHandler(w http.ResponseWriter, r *http.Request) {
+	after := r.URL.Query().Get("after")
+	batch, err := strconv.Atoi(r.URL.Query().Get("batch"))
+	if err != nil || batch <= 0 || batch > 1000 {
+		batch = 500
+	}
+
+	filter := bson.M{}
+	if after != "" {
+		filter["_id"] = bson.M{"$gt": after}
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(batch))
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	cursor, err := docCollection.Find(c, filter, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to read documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	var docs []bson.M
+	if err := cursor.All(c, &docs); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+
+	var ids []interface{}
+	var sets []bson.M
+	var models []mongo.WriteModel
+	for _, doc := range docs {
+		if set := backfillFields(doc); len(set) > 0 {
+			ids, sets = append(ids, doc["_id"]), append(sets, set)
+			models = append(models, backfillModel(doc["_id"], set))
+		}
+	}
+
+	if len(models) > 0 {
+		_, err := docCollection.BulkWrite(c, models, options.BulkWrite().SetOrdered(false))
+		dbBreaker.Record(err)
+		// Under UNIQUE_CONTENT the index refuses the whole update of a
+		// document whose hash duplicates another's. Those older duplicates
+		// keep no hash but are written again for their other fields.
+		retries, ok := contentConflictRetries(err, ids, sets)
+		if ok && len(retries) > 0 {
+			_, err = docCollection.BulkWrite(c, retries, options.BulkWrite().SetOrdered(false))
+			dbBreaker.Record(err)
+			ok = err == nil
+		}
+		if !ok {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to backfill documents"})
+			return
+		}
+	}
+
+	next := after
+	if len(docs) > 0 {
+		next, _ = docs[len(docs)-1]["_id"].(string)
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data: map[string]interface{}{
+			"processed":   len(docs),
+			"updated":     len(models),
+			"next_cursor": next,
+			"done":        len(docs) < batch,
+		},
+	})
+}
+
+// backfillModel sets the backfilled fields on document id
+func backfillModel(id interface{}, set bson.M) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": id}).SetUpdate(bson.M{"$set": set})
+}
+
+// contentConflictRetries returns the backfill updates to write again
+// without content_hash, one for each that err says the content-hash index
+// refused. It reports false when any write failed for another reason.
+func contentConflictRetries(err error, ids []interface{}, sets []bson.M) ([]mongo.WriteModel, bool) {
+	if err == nil {
+		return nil, true
+	}
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+		return nil, false
+	}
+	var retries []mongo.WriteModel
+	for _, we := range bwe.WriteErrors {
+		if !isContentConflict(we.WriteError) || we.Index >= len(sets) {
+			return nil, false
+		}
+		set := bson.M{}
+		for key, value := range sets[we.Index] {
+			if key != "content_hash" {
+				set[key] = value
+			}
+		}
+		if len(set) > 0 {
+			retries = append(retries, backfillModel(ids[we.Index], set))
+		}
+	}
+	return retries, true
+}
+
+// backfillFields returns the $set needed to give a stored document every
+// field the current code expects
+func backfillFields(doc bson.M) bson.M {
+	set := bson.M{}
+	if _, ok := doc["data"]; !ok {
+		set["data"] = bson.M{}
+	}
+	if _, ok := doc["content_hash"]; !ok && config.UniqueContent != "off" {
+		data, _ := doc["data"].(bson.M)
+		set["content_hash"] = contentHashValue(data)
+	}
+	if _, ok := doc["name"]; !ok {
+		set["name"] = ""
+	}
+	if _, ok := doc["is_public"]; !ok {
+		set["is_public"] = false
+	}
+	if _, ok := doc["created_at"]; !ok {
+		set["created_at"] = time.Now().UTC()
+	}
+	if _, ok := doc["updated_at"]; !ok {
+		if createdAt, ok := doc["created_at"]; ok {
+			set["updated_at"] = createdAt
+		} else {
+			set["updated_at"] = set["created_at"]
+		}
+	}
+	return set
 }
 
 func sendJSON(w http.ResponseWriter, status int, data interface{}) {
+	if resp, ok := data.(APIResponse); ok {
+		// Error messages can quote input, e.g. a driver's duplicate key
+		resp.Error = redactText(resp.Error)
+		if !resp.Success && resp.RequestID == "" {
+			resp.RequestID = w.Header().Get("X-Request-ID")
+		}
+		data = resp
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(data)
 }
+
+// readBody reads the request body up to limit bytes, replying 413 when exceeded
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+				Success: false,
+				Error:   fmt.Sprintf("Request body exceeds %d bytes", limit),
+				Code:    "body_too_large",
+			})
+			return nil, false
+		}
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "Failed to read request body"})
+		return nil, false
+	}
+	return body, true
+}
+
+// readDocumentBody reads a create/update body, enforcing the total body limit
+// and, separately, the size of the raw "data" member
+func readDocumentBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return nil, false
+	}
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err == nil && len(envelope.Data) > config.MaxDataBytes {
+		sendJSON(w, http.StatusRequestEntityTooLarge, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Document data exceeds %d bytes", config.MaxDataBytes),
+			Code:    "data_too_large",
+		})
+		return nil, false
+	}
+	return body, true
+}
+
+// sendParseError reports a request body that could not be decoded as JSON.
+// The byte offset of the failure is included when the decoder provides one.
+func sendParseError(w http.ResponseWriter, err error) {
+	resp := APIResponse{Success: false, Error: "Invalid JSON", Code: "invalid_json"}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		resp.Error = "Invalid JSON: " + syntaxErr.Error()
+		resp.Offset = &syntaxErr.Offset
+	case errors.As(err, &typeErr) && typeErr.Field == "data" && config.StrictData:
+		// Document data is always stored as an object, so arrays and scalars
+		// are rejected either way; STRICT_OBJECT_DATA names the invariant
+		// for clients instead of leaving them a generic type error
+		resp.Error = "data must be a JSON object"
+		resp.Code = "invalid_data"
+		resp.Offset = &typeErr.Offset
+	case errors.As(err, &typeErr):
+		resp.Error = fmt.Sprintf("Invalid JSON: %s must be %s", typeErr.Field, typeErr.Type)
+		resp.Offset = &typeErr.Offset
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		resp.Error = "Invalid JSON: " + strings.TrimPrefix(err.Error(), "json: ")
+	}
+
+	sendJSON(w, http.StatusBadRequest, resp)
+}
+
+// sendValidationError reports well-formed input that failed field validation
+func sendValidationError(w http.ResponseWriter, fieldErrors []FieldError) {
+	messages := make([]string, len(fieldErrors))
+	for i, fe := range fieldErrors {
+		messages[i] = fe.Field + " " + fe.Message
+	}
+
+	sendJSON(w, http.StatusUnprocessableEntity, APIResponse{
+		Success: false,
+		Error:   "Validation failed: " + strings.Join(messages, "; "),
+		Code:    "validation_failed",
+		Errors:  fieldErrors,
+	})
+}
@@ -0,0 +1,1402 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setupTestDB points the collections at a fresh database on the server in
+// TEST_MONGODB_URI, dropped when the test ends. Tests that need MongoDB
+// are skipped without it.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI is not set")
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(c, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := client.Ping(c, nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+
+	db := client.Database("jsonapi_test_" + strings.ReplaceAll(uuid.New().String(), "-", "")[:12])
+	docCollection = db.Collection("documents")
+	usersCollection = db.Collection("users")
+	seriesCollection = db.Collection("series")
+	schemasCollection = db.Collection("schemas")
+	keysCollection = db.Collection("scoped_keys")
+	versionsCollection = db.Collection("document_versions")
+	idempotencyCollection = db.Collection("idempotency_keys")
+	uploadsCollection = db.Collection("uploads")
+
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+}
+
+// asUser returns r as authenticated by userID, as authMiddleware leaves it
+func asUser(r *http.Request, userID string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "user_id", userID))
+}
+
+// withPathParams returns r carrying route parameters, as the router leaves it
+func withPathParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), "path_params", params))
+}
+
+// serve runs a handler and returns its recorded response
+func serve(h http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// storedData returns data as it reads back from MongoDB, where arrays
+// decode as bson.A
+func storedData(t *testing.T, data map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	raw, err := bson.Marshal(JSONDocument{ID: "doc", Data: data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc JSONDocument
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Data
+}
+
+// insertVisibilityDocs stores doc three times: published, unpublished, and
+// as stored before visibility existed, with no is_public at all. It
+// returns the status a public route should give each ID.
+func insertVisibilityDocs(t *testing.T, doc JSONDocument) map[string]int {
+	t.Helper()
+	c := context.Background()
+	doc.ID, doc.IsPublic = "published", true
+	docCollection.InsertOne(c, doc)
+	doc.ID, doc.IsPublic = "private", false
+	docCollection.InsertOne(c, doc)
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var legacy bson.M
+	bson.Unmarshal(raw, &legacy)
+	legacy["_id"] = "legacy"
+	delete(legacy, "is_public")
+	docCollection.InsertOne(c, legacy)
+
+	return map[string]int{"published": http.StatusOK, "private": http.StatusNotFound, "legacy": http.StatusNotFound}
+}
+
+func TestListDocumentsPages(t *testing.T) {
+	setupTestDB(t)
+
+	for _, id := range []string{"a", "b", "c"} {
+		doc := JSONDocument{ID: id, UserID: "u1", Name: id, Data: map[string]interface{}{}}
+		if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query   string
+		ids     []string
+		cursor  string
+		hasMore bool
+	}{
+		{"?limit=2", []string{"a", "b"}, "b", true},
+		{"?limit=2&cursor=b", []string{"c"}, "", false},
+		{"", []string{"a", "b", "c"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := serve(listDocuments, asUser(httptest.NewRequest(http.MethodGet, "/api/documents"+tt.query, nil), "u1"))
+			var resp struct {
+				Data       []JSONDocument `json:"data"`
+				NextCursor string         `json:"next_cursor"`
+				HasMore    bool           `json:"has_more"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("data is not a list of documents: %v", err)
+			}
+			var ids []string
+			for _, doc := range resp.Data {
+				ids = append(ids, doc.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("ids = %v, want %v", ids, tt.ids)
+			}
+			if resp.NextCursor != tt.cursor || resp.HasMore != tt.hasMore {
+				t.Errorf("next_cursor, has_more = %q, %v, want %q, %v", resp.NextCursor, resp.HasMore, tt.cursor, tt.hasMore)
+			}
+		})
+	}
+}
+
+func TestPurgeOrphansRemovesVersions(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", APIKey: "key"})
+	for _, doc := range []JSONDocument{{ID: "kept", UserID: "u1"}, {ID: "orphan", UserID: "gone"}} {
+		if _, err := docCollection.InsertOne(c, doc); err != nil {
+			t.Fatal(err)
+		}
+		versionsCollection.InsertOne(c, bson.M{"document_id": doc.ID, "version": 1})
+	}
+
+	w := serve(purgeOrphans, httptest.NewRequest(http.MethodDelete, "/admin/orphans?confirm=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	for id, want := range map[string]int64{"kept": 1, "orphan": 0} {
+		docs, _ := docCollection.CountDocuments(c, bson.M{"_id": id})
+		versions, _ := versionsCollection.CountDocuments(c, bson.M{"document_id": id})
+		if docs != want || versions != want {
+			t.Errorf("%s: %d documents and %d versions left, want %d of each", id, docs, versions, want)
+		}
+	}
+}
+
+func TestNonObjectData(t *testing.T) {
+	strict := config.StrictData
+	t.Cleanup(func() { config.StrictData = strict })
+
+	tests := []struct {
+		name   string
+		body   string
+		strict bool
+		code   string
+	}{
+		{"array", `{"name":"n","data":[1,2]}`, true, "invalid_data"},
+		{"number", `{"name":"n","data":1}`, true, "invalid_data"},
+		{"string", `{"name":"n","data":"x"}`, true, "invalid_data"},
+		{"boolean", `{"name":"n","data":true}`, true, "invalid_data"},
+		{"array, not strict", `{"name":"n","data":[1,2]}`, false, "invalid_json"},
+		{"scalar, not strict", `{"name":"n","data":true}`, false, "invalid_json"},
+		{"other field", `{"name":1}`, true, "invalid_json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.StrictData = tt.strict
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(tt.body)), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			w := serve(createDocument, r)
+
+			var resp APIResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != http.StatusBadRequest || resp.Code != tt.code {
+				t.Errorf("status, code = %d, %q, want 400, %q", w.Code, resp.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestUpdateNonObjectData(t *testing.T) {
+	setupTestDB(t)
+	strict := config.StrictData
+	t.Cleanup(func() { config.StrictData = strict })
+	config.StrictData = true
+
+	c := context.Background()
+	docCollection.InsertOne(c, JSONDocument{ID: "d", UserID: "u1", Name: "n", Data: map[string]interface{}{"a": 1.0}})
+	for _, data := range []string{`[1,2]`, `"x"`, `3`} {
+		r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/d", strings.NewReader(`{"name":"n","data":`+data+`}`)), "u1")
+		r.Header.Set("Content-Type", "application/json")
+		w := serve(func(w http.ResponseWriter, r *http.Request) { updateDocument(w, r, "d") }, r)
+
+		var resp APIResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusBadRequest || resp.Code != "invalid_data" {
+			t.Errorf("data %s: status, code = %d, %q, want 400, invalid_data", data, w.Code, resp.Code)
+		}
+	}
+
+	var stored JSONDocument
+	docCollection.FindOne(c, bson.M{"_id": "d"}).Decode(&stored)
+	if stored.Data["a"] != 1.0 {
+		t.Errorf("rejected updates changed data to %v", stored.Data)
+	}
+}
+
+func TestUpdateDocumentSkipsTrash(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+
+	deleted := time.Now().UTC()
+	docCollection.InsertOne(c, JSONDocument{ID: "trashed", UserID: "u1", Name: "old", Data: map[string]interface{}{"a": 1.0}, DeletedAt: &deleted})
+
+	r := httptest.NewRequest(http.MethodPut, "/api/documents/trashed", strings.NewReader(`{"name":"new","data":{"a":2}}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(func(w http.ResponseWriter, r *http.Request) { updateDocument(w, r, "trashed") }, asUser(r, "u1"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+
+	var stored JSONDocument
+	docCollection.FindOne(c, bson.M{"_id": "trashed"}).Decode(&stored)
+	if stored.Name != "old" || stored.Data["a"] != 1.0 {
+		t.Errorf("trashed document changed to %q %v", stored.Name, stored.Data)
+	}
+}
+
+func TestIsQueryableField(t *testing.T) {
+	tests := []struct {
+		field string
+		want  bool
+	}{
+		{"name", true},
+		{"updated_at", true},
+		{"data.title", true},
+		{"data.a.b_c-1", true},
+		{"data", false},
+		{"user_id", false},
+		{"data.$where", false},
+		{"data.a..b", false},
+		{"data.a b", false},
+		{"meta.title", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			if got := isQueryableField(tt.field); got != tt.want {
+				t.Errorf("isQueryableField(%q) = %v, want %v", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListDocumentsPresenceFilters(t *testing.T) {
+	setupTestDB(t)
+	for id, data := range map[string]map[string]interface{}{
+		"both":    {"foo": 1, "bar": 1},
+		"foo":     {"foo": 1},
+		"neither": {},
+	} {
+		docCollection.InsertOne(context.Background(), JSONDocument{ID: id, UserID: "u1", Data: data})
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		ids    string
+	}{
+		{"?exists=data.foo", http.StatusOK, "both,foo"},
+		{"?missing=data.bar", http.StatusOK, "foo,neither"},
+		{"?exists=data.foo&missing=data.bar", http.StatusOK, "foo"},
+		{"?exists=data.foo,data.bar", http.StatusOK, "both"},
+		{"?exists=user_id", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := serve(listDocuments, asUser(httptest.NewRequest(http.MethodGet, "/api/documents"+tt.query, nil), "u1"))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			var resp struct {
+				Data []JSONDocument `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			var ids []string
+			for _, doc := range resp.Data {
+				ids = append(ids, doc.ID)
+			}
+			if got := strings.Join(ids, ","); got != tt.ids {
+				t.Errorf("ids = %s, want %s", got, tt.ids)
+			}
+		})
+	}
+}
+
+func TestClearDocument(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	deleted := time.Now().UTC()
+	tests := []struct {
+		name   string
+		doc    JSONDocument
+		status int
+	}{
+		{"clears data", JSONDocument{ID: "d", UserID: "u1", Name: "keep"}, http.StatusOK},
+		{"stays public", JSONDocument{ID: "d", UserID: "u1", Name: "keep", IsPublic: true}, http.StatusOK},
+		{"another user's document", JSONDocument{ID: "d", UserID: "u2", Name: "keep"}, http.StatusNotFound},
+		{"frozen", JSONDocument{ID: "d", UserID: "u1", Name: "keep", Frozen: true}, http.StatusForbidden},
+		{"trashed", JSONDocument{ID: "d", UserID: "u1", Name: "keep", DeletedAt: &deleted}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			c := context.Background()
+			tt.doc.Data = map[string]interface{}{"a": 1.0}
+			tt.doc.CreatedAt = created
+			docCollection.InsertOne(c, tt.doc)
+
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/d/clear", nil), "u1")
+			w := serve(func(w http.ResponseWriter, r *http.Request) { clearDocument(w, r, "d") }, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+
+			var stored JSONDocument
+			docCollection.FindOne(c, bson.M{"_id": "d"}).Decode(&stored)
+			wantLen := 1
+			if tt.status == http.StatusOK {
+				wantLen = 0
+			}
+			if len(stored.Data) != wantLen || stored.Name != "keep" {
+				t.Errorf("stored %q with data %v, want %d keys", stored.Name, stored.Data, wantLen)
+			}
+			if !stored.CreatedAt.Equal(created) {
+				t.Errorf("created_at = %s, want it kept as %s", stored.CreatedAt, created)
+			}
+			if stored.IsPublic != tt.doc.IsPublic {
+				t.Errorf("is_public = %v, want it kept as %v", stored.IsPublic, tt.doc.IsPublic)
+			}
+		})
+	}
+}
+
+func TestOrphanedDocuments(t *testing.T) {
+	setupTestDB(t)
+	saved := config.OrphanGlobal
+	t.Cleanup(func() { config.OrphanGlobal = saved })
+	config.OrphanGlobal = false
+
+	c := context.Background()
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "one@example.com"})
+	for _, doc := range []JSONDocument{{ID: "owned", UserID: "u1"}, {ID: "orphan", UserID: "deleted"}, {ID: "global-doc", UserID: "global"}} {
+		docCollection.InsertOne(c, doc)
+	}
+
+	w := serve(reportOrphans, httptest.NewRequest(http.MethodGet, "/admin/orphans", nil))
+	var report struct {
+		Data struct {
+			IDs []string `json:"ids"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &report)
+	if w.Code != http.StatusOK || strings.Join(report.Data.IDs, ",") != "orphan" {
+		t.Errorf("report: status %d, ids %v, want 200 and only the orphan", w.Code, report.Data.IDs)
+	}
+
+	if w := serve(purgeOrphans, httptest.NewRequest(http.MethodDelete, "/admin/orphans", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("purge without confirm: status %d, want 400", w.Code)
+	}
+	if n, _ := docCollection.CountDocuments(c, bson.M{}); n != 3 {
+		t.Errorf("%d documents left after an unconfirmed purge, want 3", n)
+	}
+
+	if w := serve(purgeOrphans, httptest.NewRequest(http.MethodDelete, "/admin/orphans?confirm=true", nil)); w.Code != http.StatusOK {
+		t.Fatalf("purge: status %d", w.Code)
+	}
+	for id, want := range map[string]int64{"owned": 1, "orphan": 0, "global-doc": 1} {
+		if n, _ := docCollection.CountDocuments(c, bson.M{"_id": id}); n != want {
+			t.Errorf("%s: %d left after purge, want %d", id, n, want)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	data := map[string]interface{}{
+		"title":   "x",
+		"profile": map[string]interface{}{"name": "Ada", "tags": []interface{}{"a"}},
+		"items":   []interface{}{1.0, 2.0},
+	}
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"title", `"x"`, true},
+		{"profile.name", `"Ada"`, true},
+		{"profile.tags", `["a"]`, true},
+		{"items", `[1,2]`, true},
+		{"missing", "", false},
+		{"profile.missing", "", false},
+		{"title.deeper", "", false},
+		{"items.0", "", false},
+	}
+	for _, stored := range []bool{false, true} {
+		source := data
+		if stored {
+			source = storedData(t, data)
+		}
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s stored=%v", tt.path, stored), func(t *testing.T) {
+				got, ok := lookupPath(source, tt.path)
+				if ok != tt.ok {
+					t.Fatalf("ok = %v, want %v", ok, tt.ok)
+				}
+				if raw, _ := json.Marshal(got); ok && string(raw) != tt.want {
+					t.Errorf("lookupPath = %s, want %s", raw, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestAsArray(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  int
+		ok    bool
+	}{
+		{"decoded JSON", []interface{}{1, 2}, 2, true},
+		{"decoded BSON", bson.A{1, 2, 3}, 3, true},
+		{"empty", []interface{}{}, 0, true},
+		{"object", map[string]interface{}{}, 0, false},
+		{"string", "abc", 0, false},
+		{"nil", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := asArray(tt.value)
+			if ok != tt.ok || len(got) != tt.want {
+				t.Errorf("asArray = %v, %v, want %d items, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGetDocumentItems(t *testing.T) {
+	setupTestDB(t)
+	items := []interface{}{}
+	for i := 0; i < 5; i++ {
+		items = append(items, float64(i))
+	}
+	docCollection.InsertOne(context.Background(), JSONDocument{ID: "d", UserID: "u1", Data: map[string]interface{}{
+		"list": items, "nested": map[string]interface{}{"list": items}, "title": "x",
+	}})
+
+	tests := []struct {
+		query  string
+		status int
+		items  string
+	}{
+		{"?path=data.list&limit=2", http.StatusOK, "[0,1]"},
+		{"?path=data.list&offset=3&limit=10", http.StatusOK, "[3,4]"},
+		{"?path=data.list&offset=9", http.StatusOK, "[]"},
+		{"?path=data.nested.list&offset=4", http.StatusOK, "[4]"},
+		{"?path=data.title", http.StatusUnprocessableEntity, ""},
+		{"?path=name", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents/d/items"+tt.query, nil), "u1")
+			w := serve(func(w http.ResponseWriter, r *http.Request) { getDocumentItems(w, r, "d") }, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Data struct {
+					Items json.RawMessage `json:"items"`
+					Total int             `json:"total"`
+				} `json:"data"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if string(resp.Data.Items) != tt.items || resp.Data.Total != 5 {
+				t.Errorf("items = %s of %d, want %s of 5", resp.Data.Items, resp.Data.Total, tt.items)
+			}
+		})
+	}
+}
+
+func TestParseFeatureFlags(t *testing.T) {
+	tests := []struct {
+		value string
+		want  map[string]bool
+	}{
+		{"", map[string]bool{}},
+		{"strict_json", map[string]bool{"strict_json": true}},
+		{"strict_json=false", map[string]bool{"strict_json": false}},
+		{" a=true , b=0,c ", map[string]bool{"a": true, "b": false, "c": true}},
+		{"a=maybe", map[string]bool{"a": false}},
+		{",,=true", map[string]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got := parseFeatureFlags(tt.value)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("parseFeatureFlags(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFeatureEnabled(t *testing.T) {
+	saved := config.Features
+	t.Cleanup(func() { config.Features = saved })
+	config.Features = map[string]bool{"strict_json": false, "beta": true}
+
+	tests := []struct {
+		name    string
+		headers []string
+		feature string
+		want    bool
+	}{
+		{"default off", nil, "strict_json", false},
+		{"default on", nil, "beta", true},
+		{"enabled by header", []string{"strict_json"}, "strict_json", true},
+		{"disabled by header", []string{"beta=false"}, "beta", false},
+		{"several headers", []string{"beta=false", "strict_json=true"}, "strict_json", true},
+		{"unknown features are ignored", []string{"made_up"}, "made_up", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, h := range tt.headers {
+				r.Header.Add("X-Feature", h)
+			}
+			if got := featureEnabled(withFeatures(r), tt.feature); got != tt.want {
+				t.Errorf("featureEnabled(%q) = %v, want %v", tt.feature, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONStrict(t *testing.T) {
+	saved := config.Features
+	t.Cleanup(func() { config.Features = saved })
+	config.Features = map[string]bool{"strict_json": false}
+
+	tests := []struct {
+		name    string
+		feature string
+		body    string
+		wantErr bool
+	}{
+		{"lenient ignores unknown fields", "", `{"name":"a","extra":1}`, false},
+		{"strict rejects unknown fields", "strict_json", `{"name":"a","extra":1}`, true},
+		{"strict accepts known fields", "strict_json", `{"name":"a"}`, false},
+		{"strict reports syntax errors", "strict_json", `{"name":`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tt.feature != "" {
+				r.Header.Set("X-Feature", tt.feature)
+			}
+			var input struct {
+				Name string `json:"name"`
+			}
+			err := decodeJSON(withFeatures(r), []byte(tt.body), &input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublicCacheControl(t *testing.T) {
+	maxAge, swr, sie := config.PublicMaxAge, config.PublicStaleWhileRevalidate, config.PublicStaleIfError
+	t.Cleanup(func() {
+		config.PublicMaxAge, config.PublicStaleWhileRevalidate, config.PublicStaleIfError = maxAge, swr, sie
+	})
+
+	tests := []struct {
+		maxAge, swr, sie int
+		want             string
+	}{
+		{60, 0, 0, "public, max-age=60"},
+		{0, 0, 0, "public, max-age=0"},
+		{60, 30, 0, "public, max-age=60, stale-while-revalidate=30"},
+		{60, 0, 600, "public, max-age=60, stale-if-error=600"},
+		{10, 30, 600, "public, max-age=10, stale-while-revalidate=30, stale-if-error=600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			config.PublicMaxAge, config.PublicStaleWhileRevalidate, config.PublicStaleIfError = tt.maxAge, tt.swr, tt.sie
+			if got := publicCacheControl(); got != tt.want {
+				t.Errorf("publicCacheControl() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadDocumentBody(t *testing.T) {
+	maxBody, maxData := config.MaxBodyBytes, config.MaxDataBytes
+	t.Cleanup(func() { config.MaxBodyBytes, config.MaxDataBytes = maxBody, maxData })
+	config.MaxBodyBytes, config.MaxDataBytes = 100, 20
+
+	tests := []struct {
+		name   string
+		body   string
+		ok     bool
+		status int
+		code   string
+	}{
+		{"within both limits", `{"name":"a","data":{"x":1}}`, true, 0, ""},
+		{"long envelope, small data", `{"name":"` + strings.Repeat("n", 60) + `","data":{}}`, true, 0, ""},
+		{"data over its limit", `{"data":{"x":"` + strings.Repeat("d", 20) + `"}}`, false, http.StatusRequestEntityTooLarge, "data_too_large"},
+		{"body over its limit", `{"name":"` + strings.Repeat("n", 120) + `"}`, false, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{"invalid JSON is left to the decoder", `{"data":`, true, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			body, ok := readDocumentBody(w, httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(tt.body)))
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok {
+				if string(body) != tt.body {
+					t.Errorf("body = %s, want it unchanged", body)
+				}
+				return
+			}
+			var resp APIResponse
+			json.Unmarshal(w.Body.Bytes(), &resp)
+			if w.Code != tt.status || resp.Code != tt.code {
+				t.Errorf("status, code = %d, %q, want %d, %q", w.Code, resp.Code, tt.status, tt.code)
+			}
+		})
+	}
+}
+
+func TestAuthBodyLimit(t *testing.T) {
+	saved := config.MaxAuthBytes
+	t.Cleanup(func() { config.MaxAuthBytes = saved })
+	config.MaxAuthBytes = 64
+
+	body := `{"email":"a@example.com","password":"` + strings.Repeat("p", 100) + `"}`
+	for path, handler := range map[string]http.HandlerFunc{
+		"/auth/register": registerHandler,
+		"/auth/login":    loginHandler,
+	} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var resp APIResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusRequestEntityTooLarge || resp.Code != "body_too_large" {
+			t.Errorf("%s: status, code = %d, %q, want 413, body_too_large", path, w.Code, resp.Code)
+		}
+	}
+}
+
+func TestBackfillFields(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	complete := bson.M{"_id": "a", "data": bson.M{}, "name": "n", "is_public": true, "created_at": created, "updated_at": created}
+
+	tests := []struct {
+		name string
+		doc  bson.M
+		want []string
+	}{
+		{"complete", complete, nil},
+		{"missing data and name", bson.M{"_id": "a", "is_public": false, "created_at": created, "updated_at": created}, []string{"data", "name"}},
+		{"missing visibility", bson.M{"_id": "a", "data": bson.M{}, "name": "n", "created_at": created, "updated_at": created}, []string{"is_public"}},
+		{"missing timestamps", bson.M{"_id": "a", "data": bson.M{}, "name": "n", "is_public": false}, []string{"created_at", "updated_at"}},
+		{"missing updated_at", bson.M{"_id": "a", "data": bson.M{}, "name": "n", "is_public": false, "created_at": created}, []string{"updated_at"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := backfillFields(tt.doc)
+			var got []string
+			for key := range set {
+				got = append(got, key)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("backfillFields sets %v, want %v", got, tt.want)
+			}
+			if set["is_public"] != nil && set["is_public"] != false {
+				t.Errorf("is_public backfilled as %v, want false", set["is_public"])
+			}
+			if _, ok := tt.doc["created_at"]; ok && set["updated_at"] != nil && set["updated_at"] != created {
+				t.Errorf("updated_at backfilled as %v, want created_at", set["updated_at"])
+			}
+		})
+	}
+}
+
+func TestBackfillHandlerBatches(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		docCollection.InsertOne(c, bson.M{"_id": id, "user_id": "u1"})
+	}
+
+	var next string
+	for i, want := range []struct {
+		processed int
+		done      bool
+	}{{2, false}, {1, true}} {
+		w := serve(backfillHandler, httptest.NewRequest(http.MethodPost, "/admin/backfill?batch=2&after="+next, nil))
+		var resp struct {
+			Data struct {
+				Processed  int    `json:"processed"`
+				Updated    int    `json:"updated"`
+				NextCursor string `json:"next_cursor"`
+				Done       bool   `json:"done"`
+			} `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Data.Processed != want.processed || resp.Data.Updated != want.processed || resp.Data.Done != want.done {
+			t.Errorf("batch %d = %+v, want %d processed and updated, done %v", i, resp.Data, want.processed, want.done)
+		}
+		next = resp.Data.NextCursor
+	}
+
+	n, _ := docCollection.CountDocuments(c, bson.M{"is_public": false, "data": bson.M{"$exists": true}, "created_at": bson.M{"$exists": true}})
+	if n != 3 {
+		t.Errorf("%d documents backfilled, want 3", n)
+	}
+}
+
+func TestContentConflictRetries(t *testing.T) {
+	ids := []interface{}{"a", "b", "c"}
+	sets := []bson.M{
+		{"content_hash": "h", "is_public": false},
+		{"content_hash": "h", "name": ""},
+		{"content_hash": "h"},
+	}
+	conflict := func(index int) mongo.BulkWriteError {
+		return mongo.BulkWriteError{WriteError: mongo.WriteError{Index: index, Code: 11000,
+			Message: "E11000 duplicate key error collection: db.documents index: user_content_hash dup key"}}
+	}
+
+	if retries, ok := contentConflictRetries(nil, ids, sets); !ok || len(retries) != 0 {
+		t.Errorf("no error: %d retries, ok %v; want none", len(retries), ok)
+	}
+
+	err := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{conflict(1), conflict(2)}}
+	retries, ok := contentConflictRetries(err, ids, sets)
+	if !ok || len(retries) != 1 {
+		t.Fatalf("conflicts: %d retries, ok %v; want 1", len(retries), ok)
+	}
+	update := retries[0].(*mongo.UpdateOneModel)
+	if update.Filter.(bson.M)["_id"] != "b" {
+		t.Errorf("retried %v, want b", update.Filter)
+	}
+	if set := update.Update.(bson.M)["$set"].(bson.M); len(set) != 1 || set["name"] != "" {
+		t.Errorf("retried $set %v, want only name", set)
+	}
+
+	other := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{conflict(0),
+		{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error index: user_client_key"}}}}
+	if _, ok := contentConflictRetries(other, ids, sets); ok {
+		t.Error("another duplicate key was retried")
+	}
+	if _, ok := contentConflictRetries(errors.New("network"), ids, sets); ok {
+		t.Error("a non-write error was retried")
+	}
+}
+
+func TestBackfillContentConflict(t *testing.T) {
+	setupTestDB(t)
+	saved := config.UniqueContent
+	t.Cleanup(func() { config.UniqueContent = saved })
+	config.UniqueContent = "reject"
+	c := context.Background()
+	if _, err := docCollection.Indexes().CreateOne(c, contentHashIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two documents stored before content hashes, with the same data
+	for _, id := range []string{"a", "b"} {
+		docCollection.InsertOne(c, bson.M{"_id": id, "user_id": "u1", "data": bson.M{"n": 1.0}})
+	}
+	w := serve(backfillHandler, httptest.NewRequest(http.MethodPost, "/admin/backfill", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	// One gets the hash; the other is refused it but backfilled otherwise
+	hashed, _ := docCollection.CountDocuments(c, bson.M{"content_hash": bson.M{"$type": "string"}})
+	if hashed != 1 {
+		t.Errorf("%d documents hashed, want 1", hashed)
+	}
+	n, _ := docCollection.CountDocuments(c, bson.M{"is_public": false, "name": "", "created_at": bson.M{"$exists": true}})
+	if n != 2 {
+		t.Errorf("%d documents backfilled, want 2", n)
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept string
+		media  string
+		want   float64
+	}{
+		{"", "application/json", 0},
+		{"application/json", "application/json", 1},
+		{"text/html", "application/json", 0},
+		{"*/*", "application/json", 1},
+		{"*/*;q=0.8", "application/json", 0.8},
+		{"application/*;q=0.5", "application/json", 0.5},
+		{"*/*;q=0.1, application/json;q=0.9", "application/json", 0.9},
+		{"application/json;q=0.2, */*", "application/json", 0.2},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html", 1},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "application/json", 0.8},
+		{"TEXT/HTML; q=0.7", "text/html", 0.7},
+		{"text/html;q=bad", "text/html", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept+" "+tt.media, func(t *testing.T) {
+			if got := acceptQuality(tt.accept, tt.media); got != tt.want {
+				t.Errorf("acceptQuality = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrettyPublic(t *testing.T) {
+	saved := config.PublicPretty
+	t.Cleanup(func() { config.PublicPretty = saved })
+
+	browser := "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	tests := []struct {
+		name    string
+		enabled bool
+		accept  string
+		query   string
+		want    bool
+	}{
+		{"disabled", false, browser, "?pretty=true", false},
+		{"browser", true, browser, "", true},
+		{"API client", true, "application/json", "", false},
+		{"no Accept", true, "", "", false},
+		{"forced on", true, "application/json", "?pretty=true", true},
+		{"forced off", true, browser, "?pretty=0", false},
+		{"invalid override", true, browser, "?pretty=maybe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.PublicPretty = tt.enabled
+			r := httptest.NewRequest(http.MethodGet, "/public/doc"+tt.query, nil)
+			r.Header.Set("Accept", tt.accept)
+			if got := prettyPublic(r); got != tt.want {
+				t.Errorf("prettyPublic = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublicHandlerVisibility(t *testing.T) {
+	setupTestDB(t)
+	ids := insertVisibilityDocs(t, JSONDocument{UserID: "u1", Name: "doc", Data: map[string]interface{}{"n": 1.0}})
+	for id, want := range ids {
+		r := httptest.NewRequest(http.MethodGet, "/public/"+id, nil)
+		w := serve(withID(publicHandler), withPathParams(r, map[string]string{"id": id}))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", id, w.Code, want)
+		}
+	}
+}
+
+func TestGenerateAPIKey(t *testing.T) {
+	saved := config.APIKeyPrefix
+	t.Cleanup(func() { config.APIKeyPrefix = saved })
+
+	tests := []struct {
+		prefix  string
+		pattern string
+	}{
+		{"", `^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`},
+		{"jsk_", `^jsk_[0-9a-f]{48}$`},
+		{"live-", `^live-[0-9a-f]{48}$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			config.APIKeyPrefix = tt.prefix
+			a, b := generateAPIKey(), generateAPIKey()
+			if !regexp.MustCompile(tt.pattern).MatchString(a) {
+				t.Errorf("generateAPIKey() = %q, want it to match %s", a, tt.pattern)
+			}
+			if a == b {
+				t.Errorf("two keys were both %q", a)
+			}
+		})
+	}
+}
+
+func TestPrefixedKeyAuthenticates(t *testing.T) {
+	setupTestDB(t)
+	saved := config.APIKeyPrefix
+	t.Cleanup(func() { config.APIKeyPrefix = saved })
+	config.APIKeyPrefix = "jsk_live_"
+
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(`{"email":"p@example.com","password":"secret1"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := serve(registerHandler, r)
+	var resp struct {
+		Data struct {
+			ID     string `json:"id"`
+			APIKey string `json:"api_key"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusCreated || !strings.HasPrefix(resp.Data.APIKey, "jsk_live_") {
+		t.Fatalf("register: status %d, key %q", w.Code, resp.Data.APIKey)
+	}
+
+	var seen string
+	h := authMiddleware(func(w http.ResponseWriter, r *http.Request) { seen = getUserID(r) })
+	for name, r := range map[string]*http.Request{
+		"header": httptest.NewRequest(http.MethodGet, "/api/documents", nil),
+		"query":  httptest.NewRequest(http.MethodGet, "/api/documents?api_key="+resp.Data.APIKey, nil),
+	} {
+		if name == "header" {
+			r.Header.Set("X-API-Key", resp.Data.APIKey)
+		}
+		seen = ""
+		if w := serve(h, r); w.Code != http.StatusOK || seen != resp.Data.ID {
+			t.Errorf("%s: status %d, user %q; want 200 as %q", name, w.Code, seen, resp.Data.ID)
+		}
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/documents", nil)
+	r.Header.Set("X-API-Key", "jsk_live_"+strings.Repeat("0", 48))
+	if w := serve(h, r); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown prefixed key: status %d, want 401", w.Code)
+	}
+}
+
+func TestUnfreezeRequiresReversibleFreezing(t *testing.T) {
+	saved := config.FreezeUndo
+	t.Cleanup(func() { config.FreezeUndo = saved })
+	config.FreezeUndo = false
+
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/d/unfreeze", nil), "global")
+	w := serve(withID(unfreezeDocument), withPathParams(r, map[string]string{"id": "d"}))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+}
+
+func TestFrozenDocumentRejectsWrites(t *testing.T) {
+	saved := config.FreezeUndo
+	t.Cleanup(func() { config.FreezeUndo = saved })
+	config.FreezeUndo = true
+
+	type write struct {
+		name    string
+		method  string
+		body    string
+		handler func(http.ResponseWriter, *http.Request, string)
+	}
+	writes := []write{
+		{"update", http.MethodPut, `{"name":"x","data":{"a":2}}`, updateDocument},
+		{"clear", http.MethodPost, "", clearDocument},
+		{"delete", http.MethodDelete, "", deleteDocument},
+	}
+	tests := []struct {
+		name   string
+		frozen bool
+		want   int
+	}{
+		{"frozen", true, http.StatusForbidden},
+		{"unfrozen", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, op := range writes {
+			t.Run(tt.name+" "+op.name, func(t *testing.T) {
+				setupTestDB(t)
+				docCollection.InsertOne(context.Background(), JSONDocument{ID: "d", UserID: "u1", Name: "n", Data: map[string]interface{}{"a": 1.0}})
+
+				call := func(h func(http.ResponseWriter, *http.Request, string), user, method, body string) int {
+					r := asUser(httptest.NewRequest(method, "/api/documents/d", strings.NewReader(body)), user)
+					r.Header.Set("Content-Type", "application/json")
+					return serve(withID(h), withPathParams(r, map[string]string{"id": "d"})).Code
+				}
+				if got := call(freezeDocument, "u1", http.MethodPost, ""); got != http.StatusOK {
+					t.Fatalf("freeze: status = %d", got)
+				}
+				if !tt.frozen {
+					if got := call(unfreezeDocument, "global", http.MethodPost, ""); got != http.StatusOK {
+						t.Fatalf("unfreeze: status = %d", got)
+					}
+				}
+				if got := call(op.handler, "u1", op.method, op.body); got != tt.want {
+					t.Errorf("%s: status = %d, want %d", op.name, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateUniqueArrays(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		paths []string
+		want  []string
+	}{
+		{"no constraints", `{"tags":["a","a"]}`, nil, nil},
+		{"unique items", `{"tags":["a","b"]}`, []string{"data.tags"}, nil},
+		{"duplicate strings", `{"tags":["a","b","a"]}`, []string{"data.tags"}, []string{`data.tags contains duplicate item "a"`}},
+		{"duplicate objects with different key order", `{"items":[{"a":1,"b":2},{"b":2,"a":1}]}`, []string{"data.items"}, []string{`data.items contains duplicate item {"a":1,"b":2}`}},
+		{"numbers and strings differ", `{"tags":[1,"1"]}`, []string{"data.tags"}, nil},
+		{"nested path", `{"a":{"tags":[true,true]}}`, []string{"data.a.tags"}, []string{"data.a.tags contains duplicate item true"}},
+		{"missing path", `{}`, []string{"data.tags"}, nil},
+		{"not an array", `{"tags":"a"}`, []string{"data.tags"}, nil},
+		{"metadata path", `{}`, []string{"name"}, []string{"unique_arrays name is not a data path"}},
+		{"outside data", `{}`, []string{"tags"}, []string{"unique_arrays tags is not a data path"}},
+	}
+	for _, tt := range tests {
+		for _, stored := range []bool{false, true} {
+			name := tt.name
+			if stored {
+				name += " (stored)"
+			}
+			t.Run(name, func(t *testing.T) {
+				var data map[string]interface{}
+				if err := json.Unmarshal([]byte(tt.data), &data); err != nil {
+					t.Fatal(err)
+				}
+				if stored {
+					data = storedData(t, data)
+				}
+				var got []string
+				for _, fe := range validateUniqueArrays(data, tt.paths) {
+					got = append(got, fe.Field+" "+fe.Message)
+				}
+				if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+					t.Errorf("validateUniqueArrays = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestIsUnfiltered(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   bool
+	}{
+		{"empty", bson.M{}, true},
+		{"live documents", live(bson.M{}), true},
+		{"one user", live(bson.M{"user_id": "u1"}), false},
+		{"folder", bson.M{"folder": "a"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUnfiltered(tt.filter); got != tt.want {
+				t.Errorf("isUnfiltered(%v) = %v, want %v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListTotal(t *testing.T) {
+	saved := config.CountMode
+	t.Cleanup(func() { config.CountMode = saved })
+
+	tests := []struct {
+		name   string
+		config string
+		query  string
+		user   string
+		want   string
+	}{
+		{"exact by default", "exact", "", "u1", "2"},
+		{"exact for the global key", "exact", "", "global", "3"},
+		{"none from config", "none", "", "u1", ""},
+		{"none from query", "exact", "?count=none", "u1", ""},
+		{"false from query", "exact", "?count=false", "u1", ""},
+		{"estimated falls back to exact when filtered", "exact", "?count=estimated", "u1", "2"},
+		{"estimated when unfiltered", "exact", "?count=estimated", "global", "3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.CountMode = tt.config
+			if tt.want != "" {
+				setupTestDB(t)
+				for i, owner := range []string{"u1", "u1", "u2"} {
+					docCollection.InsertOne(context.Background(), JSONDocument{ID: fmt.Sprint(i), UserID: owner, Name: "n"})
+				}
+			}
+
+			r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents"+tt.query, nil), tt.user)
+			filter := live(bson.M{})
+			if tt.user != "global" {
+				filter["user_id"] = tt.user
+			}
+			total, ok, err := listTotal(context.Background(), r, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if ok {
+				got = fmt.Sprint(total)
+			}
+			if got != tt.want {
+				t.Errorf("total = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFolders(t *testing.T) {
+	setupTestDB(t)
+	if _, err := docCollection.Indexes().CreateOne(context.Background(), folderNameIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(body string) int {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(body)), "u1")
+		r.Header.Set("Content-Type", "application/json")
+		return serve(createDocument, r).Code
+	}
+	writes := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"top level", `{"name":"a","data":{"n":1}}`, http.StatusCreated},
+		{"in a folder", `{"name":"a","folder":"f","data":{"n":2}}`, http.StatusCreated},
+		{"other name in the folder", `{"name":"b","folder":"f","data":{"n":3}}`, http.StatusCreated},
+		{"same name in another folder", `{"name":"a","folder":"g","data":{"n":4}}`, http.StatusCreated},
+		{"same name in the same folder", `{"name":"a","folder":"f","data":{"n":5}}`, http.StatusConflict},
+		{"same name at the top level", `{"name":"a","data":{"n":6}}`, http.StatusConflict},
+	}
+	for _, tt := range writes {
+		if got := create(tt.body); got != tt.want {
+			t.Errorf("create %s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	lists := []struct {
+		query string
+		want  int
+	}{
+		{"", 4},
+		{"?folder=f", 2},
+		{"?folder=g", 1},
+		{"?folder=", 1},
+		{"?folder=missing", 0},
+	}
+	for _, tt := range lists {
+		w := serve(listDocuments, asUser(httptest.NewRequest(http.MethodGet, "/api/documents"+tt.query, nil), "u1"))
+		var resp struct {
+			Data []JSONDocument `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		if len(resp.Data) != tt.want {
+			t.Errorf("list %q: %d documents, want %d", tt.query, len(resp.Data), tt.want)
+		}
+	}
+
+	var doc JSONDocument
+	docCollection.FindOne(context.Background(), bson.M{"name": "b"}).Decode(&doc)
+	r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/"+doc.ID, strings.NewReader(`{"name":"a"}`)), "u1")
+	r.Header.Set("Content-Type", "application/json")
+	if w := serve(withID(updateDocument), withPathParams(r, map[string]string{"id": doc.ID})); w.Code != http.StatusConflict {
+		t.Errorf("rename onto an existing name: status = %d, want 409", w.Code)
+	}
+}
+
+func TestUniqueDocNames(t *testing.T) {
+	setupTestDB(t)
+	saved := config.UniqueDocNames
+	t.Cleanup(func() { config.UniqueDocNames = saved })
+	config.UniqueDocNames = true
+	if _, err := docCollection.Indexes().CreateOne(context.Background(), userNameIndex); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(userID, body string) int {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", strings.NewReader(body)), userID)
+		r.Header.Set("Content-Type", "application/json")
+		return serve(createDocument, r).Code
+	}
+	writes := []struct {
+		name   string
+		userID string
+		body   string
+		want   int
+	}{
+		{"first", "u1", `{"name":"a","data":{"n":1}}`, http.StatusCreated},
+		{"same name in a folder", "u1", `{"name":"a","folder":"f","data":{"n":2}}`, http.StatusConflict},
+		{"same name for another user", "u2", `{"name":"a","data":{"n":3}}`, http.StatusCreated},
+	}
+	for _, tt := range writes {
+		if got := create(tt.userID, tt.body); got != tt.want {
+			t.Errorf("create %s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	byName := func(userID, name string) *httptest.ResponseRecorder {
+		r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents/by-name/"+name, nil), userID)
+		return serve(getDocumentByName, withPathParams(r, map[string]string{"name": name}))
+	}
+	w := byName("u1", "a")
+	var resp struct {
+		Data JSONDocument `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || resp.Data.Data["n"] != float64(1) {
+		t.Errorf("by name: status %d, data %v; want 200 and the first document", w.Code, resp.Data.Data)
+	}
+	if w := byName("u1", "missing"); w.Code != http.StatusNotFound {
+		t.Errorf("missing name: status = %d, want 404", w.Code)
+	}
+}
+
+func TestDownloadDocument(t *testing.T) {
+	updated := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	doc := JSONDocument{ID: "d", UserID: "u1", Name: "report", Data: map[string]interface{}{"a": 1.0}, UpdatedAt: updated}
+	full, _ := json.Marshal(doc)
+
+	tests := []struct {
+		name    string
+		rangeH  string
+		ifRange string
+		status  int
+		body    string
+		content string
+	}{
+		{"whole document", "", "", http.StatusOK, string(full), ""},
+		{"first bytes", "bytes=0-9", "", http.StatusPartialContent, string(full[:10]), fmt.Sprintf("bytes 0-9/%d", len(full))},
+		{"resume", "bytes=10-", "", http.StatusPartialContent, string(full[10:]), fmt.Sprintf("bytes 10-%d/%d", len(full)-1, len(full))},
+		{"unsatisfiable", fmt.Sprintf("bytes=%d-", len(full)+10), "", http.StatusRequestedRangeNotSatisfiable, "", fmt.Sprintf("bytes */%d", len(full))},
+		{"if-range matches", "bytes=0-9", updated.Format(http.TimeFormat), http.StatusPartialContent, string(full[:10]), fmt.Sprintf("bytes 0-9/%d", len(full))},
+		{"if-range stale", "bytes=0-9", updated.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK, string(full), ""},
+		{"if-range etag matches", "bytes=0-9", strongETag(full), http.StatusPartialContent, string(full[:10]), fmt.Sprintf("bytes 0-9/%d", len(full))},
+		{"if-range etag stale", "bytes=0-9", `"stale"`, http.StatusOK, string(full), ""},
+		{"if-range weak etag", "bytes=0-9", weakETag(full), http.StatusOK, string(full), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/documents/d?download=true", nil)
+			if tt.rangeH != "" {
+				r.Header.Set("Range", tt.rangeH)
+			}
+			if tt.ifRange != "" {
+				r.Header.Set("If-Range", tt.ifRange)
+			}
+			w := httptest.NewRecorder()
+			downloadDocument(w, r, doc)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+			if got := w.Header().Get("Content-Range"); got != tt.content {
+				t.Errorf("Content-Range = %q, want %q", got, tt.content)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" && w.Code != http.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename=report.json` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			if got := w.Header().Get("ETag"); got != strongETag(full) {
+				t.Errorf("ETag = %q, want the strong %q", got, strongETag(full))
+			}
+		})
+	}
+
+	// A client holding the download gets 304
+	r := httptest.NewRequest(http.MethodGet, "/api/documents/d?download=true", nil)
+	r.Header.Set("If-None-Match", strongETag(full))
+	w := httptest.NewRecorder()
+	downloadDocument(w, r, doc)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d, want 304", w.Code)
+	}
+}
+
+func TestResolveOwnerID(t *testing.T) {
+	// IDs are used as given, without a lookup
+	if got, err := resolveOwner(context.Background(), "u1"); got != "u1" || err != nil {
+		t.Errorf("resolveOwner(u1) = %q, %v", got, err)
+	}
+}
+
+func TestListByOwner(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "owner@example.com"})
+	for i, owner := range []string{"u1", "u1", "u2"} {
+		docCollection.InsertOne(c, JSONDocument{ID: fmt.Sprint(i), UserID: owner, Name: "n"})
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		query  string
+		status int
+		count  int
+	}{
+		{"global key, everyone", "global", "", http.StatusOK, 3},
+		{"global key, by ID", "global", "?owner=u2", http.StatusOK, 1},
+		{"global key, by email", "global", "?owner=Owner@example.com", http.StatusOK, 2},
+		{"global key, unknown email", "global", "?owner=nobody@example.com", http.StatusNotFound, 0},
+		{"user key ignores owner", "u2", "?owner=u1", http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(listDocuments, asUser(httptest.NewRequest(http.MethodGet, "/api/documents"+tt.query, nil), tt.user))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			var resp struct {
+				Data []JSONDocument `json:"data"`
+			}
+			json.NewDecoder(w.Body).Decode(&resp)
+			if len(resp.Data) != tt.count {
+				t.Errorf("%d documents, want %d", len(resp.Data), tt.count)
+			}
+		})
+	}
+}
+
+func TestDBContext(t *testing.T) {
+	saved := config.DBTimeout
+	t.Cleanup(func() { config.DBTimeout = saved })
+
+	config.DBTimeout = time.Minute
+	c, cancel := dbContext(context.Background())
+	deadline, ok := c.Deadline()
+	cancel()
+	if !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("deadline = %v, %v; want one within DB_TIMEOUT", deadline, ok)
+	}
+
+	config.DBTimeout = 0
+	c, cancel = dbContext(context.Background())
+	defer cancel()
+	if _, ok := c.Deadline(); ok {
+		t.Error("DB_TIMEOUT=0 still set a deadline")
+	}
+
+	// A client that goes away cancels its database work
+	parent, disconnect := context.WithCancel(context.Background())
+	c, cancel = dbContext(parent)
+	defer cancel()
+	disconnect()
+	if c.Err() != context.Canceled {
+		t.Errorf("after the request was cancelled: err = %v, want %v", c.Err(), context.Canceled)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	bundleFormat  = "json-api/user-bundle"
+	bundleVersion = 1
+)
+
+// BundleUser is the account part of a migration bundle. Unlike User it
+// carries the password hash so the account keeps working after import.
+type BundleUser struct {
+	ID           string    `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash"`
+	APIKey       string    `json:"api_key"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserBundle is a portable copy of one user and everything they own, used to
+// move accounts between instances
+type UserBundle struct {
+	Format     string          `json:"format"`
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	User       BundleUser      `json:"user"`
+	Documents  []JSONDocument  `json:"documents"`
+	Schemas    []SharedSchema  `json:"schemas"`
+	Series     []SeriesPointer `json:"series"`
+}
+
+// ImportConflict describes something in a bundle that could not be kept as is
+type ImportConflict struct {
+	Kind       string `json:"kind"`
+	ID         string `json:"id"`
+	Resolution string `json:"resolution"`
+}
+
+// Export a user with their password hash, API key, documents, schemas and
+// series pointers as a bundle for POST /admin/users/import
+func exportUser(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var user User
+	err := usersCollection.FindOne(c, bson.M{"_id": id}).Decode(&user)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "User not found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to export user"})
+		return
+	}
+
+	bundle := UserBundle{
+		Format:     bundleFormat,
+		Version:    bundleVersion,
+		ExportedAt: time.Now().UTC(),
+		User: BundleUser{
+			ID:           user.ID,
+			Email:        user.Email,
+			PasswordHash: user.Password,
+			APIKey:       user.APIKey,
+			CreatedAt:    user.CreatedAt,
+		},
+		Documents: []JSONDocument{},
+		Schemas:   []SharedSchema{},
+		Series:    []SeriesPointer{},
+	}
+
+	owned := bson.M{"user_id": user.ID}
+	if err := findAll(c, docCollection, owned, &bundle.Documents); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to export documents"})
+		return
+	}
+	if err := findAll(c, schemasCollection, owned, &bundle.Schemas); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to export schemas"})
+		return
+	}
+	if err := findAll(c, seriesCollection, owned, &bundle.Series); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to export series"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: bundle})
+}
+
+func findAll(c context.Context, collection *mongo.Collection, filter bson.M, results interface{}) error {
+	cursor, err := collection.Find(c, filter)
+	dbBreaker.Record(err)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(c)
+	return cursor.All(c, results)
+}
+
+// validateBundle checks the bundle's format and that every record has the
+// fields an import needs
+func validateBundle(bundle *UserBundle) []FieldError {
+	var fieldErrors []FieldError
+	if bundle.Format != bundleFormat || bundle.Version != bundleVersion {
+		fieldErrors = append(fieldErrors, FieldError{Field: "format", Message: fmt.Sprintf("must be %q version %d", bundleFormat, bundleVersion)})
+		return fieldErrors
+	}
+
+	// "global" identifies the admin key, so it can't be a user ID
+	if bundle.User.ID == "" || bundle.User.ID == "global" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "user.id", Message: "must be a non-empty ID other than \"global\""})
+	}
+	if bundle.User.Email == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "user.email", Message: "is required"})
+	}
+	if _, err := bcrypt.Cost([]byte(bundle.User.PasswordHash)); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "user.password_hash", Message: "must be a bcrypt hash"})
+	}
+
+	seen := map[string]bool{}
+	for i, doc := range bundle.Documents {
+		field := fmt.Sprintf("documents[%d]", i)
+		if doc.ID == "" || seen[doc.ID] {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".id", Message: "must be present and unique"})
+		}
+		seen[doc.ID] = true
+		if doc.Name == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".name", Message: "is required"})
+		}
+		if doc.Data == nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".data", Message: "is required"})
+		}
+	}
+
+	seen = map[string]bool{}
+	for i, schema := range bundle.Schemas {
+		field := fmt.Sprintf("schemas[%d]", i)
+		if schema.ID == "" || seen[schema.ID] {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".id", Message: "must be present and unique"})
+		}
+		seen[schema.ID] = true
+		if schema.Name == "" || schema.Version <= 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: "must have a name and a positive version"})
+		}
+		if _, err := compileSchema(schema.Schema); err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: field + ".schema", Message: "is not a valid JSON Schema"})
+		}
+	}
+
+	for i, pointer := range bundle.Series {
+		if pointer.Name == "" || pointer.DocumentID == "" {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("series[%d]", i), Message: "must have a name and a document_id"})
+		}
+	}
+	return fieldErrors
+}
+
+// takenIDs returns which of ids already exist in the collection
+func takenIDs(c context.Context, collection *mongo.Collection, ids []string) (map[string]bool, error) {
+	taken := map[string]bool{}
+	if len(ids) == 0 {
+		return taken, nil
+	}
+
+	cursor, err := collection.Find(c, bson.M{"_id": bson.M{"$in": ids}})
+	dbBreaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	for cursor.Next(c) {
+		var record struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&record); err == nil {
+			taken[record.ID] = true
+		}
+	}
+	return taken, cursor.Err()
+}
+
+// Import a bundle produced by exportUser. IDs are kept unless they are
+// already taken here, in which case new ones are assigned and references are
+// rewritten. An existing account with the same email fails the import.
+func importUser(w http.ResponseWriter, r *http.Request) {
+	body, ok := readBody(w, r, config.MigrationMaxBytes)
+	if !ok {
+		return
+	}
+
+	var bundle UserBundle
+	if err := decodeJSON(r, body, &bundle); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if fieldErrors := validateBundle(&bundle); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	email := strings.ToLower(bundle.User.Email)
+	count, err := usersCollection.CountDocuments(c, bson.M{"email": email})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to import user"})
+		return
+	}
+	if count > 0 {
+		sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "Email already registered", Code: "email_conflict"})
+		return
+	}
+
+	conflicts := []ImportConflict{}
+	remapped := map[string]string{}
+	failed := func() {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to import user"})
+	}
+
+	user := User{
+		ID:        bundle.User.ID,
+		Email:     email,
+		Password:  bundle.User.PasswordHash,
+		APIKey:    bundle.User.APIKey,
+		CreatedAt: bundle.User.CreatedAt,
+	}
+	taken, err := takenIDs(c, usersCollection, []string{user.ID})
+	if err != nil {
+		failed()
+		return
+	}
+	// Orphaned documents left under the ID would otherwise be adopted
+	orphans, err := docCollection.CountDocuments(c, bson.M{"user_id": user.ID})
+	dbBreaker.Record(err)
+	if err != nil {
+		failed()
+		return
+	}
+	if taken[user.ID] || orphans > 0 {
+		user.ID = uuid.New().String()
+		remapped[bundle.User.ID] = user.ID
+		conflicts = append(conflicts, ImportConflict{Kind: "user", ID: bundle.User.ID, Resolution: "assigned id " + user.ID})
+	}
+	keyCount, err := usersCollection.CountDocuments(c, bson.M{"api_key": user.APIKey})
+	dbBreaker.Record(err)
+	if err != nil {
+		failed()
+		return
+	}
+	if user.APIKey == "" || keyCount > 0 {
+		user.APIKey = generateAPIKey()
+		conflicts = append(conflicts, ImportConflict{Kind: "api_key", ID: user.ID, Resolution: "generated a new API key"})
+	}
+
+	docIDs := make([]string, len(bundle.Documents))
+	for i, doc := range bundle.Documents {
+		docIDs[i] = doc.ID
+	}
+	if taken, err = takenIDs(c, docCollection, docIDs); err != nil {
+		failed()
+		return
+	}
+	docs := make([]interface{}, len(bundle.Documents))
+	for i, doc := range bundle.Documents {
+		if taken[doc.ID] {
+			newID := uuid.New().String()
+			remapped[doc.ID] = newID
+			conflicts = append(conflicts, ImportConflict{Kind: "document", ID: doc.ID, Resolution: "assigned id " + newID})
+			doc.ID = newID
+		}
+		doc.UserID = user.ID
+		bundle.Documents[i] = doc
+	}
+
+	// Series names are global; a name already held here is dropped along
+	// with the document's membership in it
+	series := []interface{}{}
+	names := make([]string, len(bundle.Series))
+	for i, pointer := range bundle.Series {
+		names[i] = pointer.Name
+	}
+	if taken, err = takenIDs(c, seriesCollection, names); err != nil {
+		failed()
+		return
+	}
+	dropped := map[string]bool{}
+	for _, pointer := range bundle.Series {
+		if taken[pointer.Name] {
+			dropped[pointer.Name] = true
+			conflicts = append(conflicts, ImportConflict{Kind: "series", ID: pointer.Name, Resolution: "skipped, the name is in use"})
+			continue
+		}
+		pointer.UserID = user.ID
+		if newID, ok := remapped[pointer.DocumentID]; ok {
+			pointer.DocumentID = newID
+		}
+		series = append(series, pointer)
+	}
+	for i, doc := range bundle.Documents {
+		if dropped[doc.Series] {
+			doc.Series = ""
+		}
+		docs[i] = doc
+	}
+
+	schemaIDs := make([]string, len(bundle.Schemas))
+	for i, schema := range bundle.Schemas {
+		schemaIDs[i] = schema.ID
+	}
+	if taken, err = takenIDs(c, schemasCollection, schemaIDs); err != nil {
+		failed()
+		return
+	}
+	schemas := make([]interface{}, len(bundle.Schemas))
+	for i, schema := range bundle.Schemas {
+		if taken[schema.ID] {
+			newID := uuid.New().String()
+			remapped[schema.ID] = newID
+			conflicts = append(conflicts, ImportConflict{Kind: "schema", ID: schema.ID, Resolution: "assigned id " + newID})
+			schema.ID = newID
+		}
+		schema.UserID = user.ID
+		schemas[i] = schema
+	}
+
+	// The account is created last so a failed import never leaves a user
+	// who can log in to a partial copy; anything written is rolled back
+	batches := []struct {
+		collection *mongo.Collection
+		records    []interface{}
+		inserted   []interface{}
+	}{{collection: docCollection, records: docs}, {collection: schemasCollection, records: schemas}, {collection: seriesCollection, records: series}}
+	rollback := func() {
+		// Runs even if the request was cancelled part way
+		undo, cancel := dbContext(context.WithoutCancel(c))
+		defer cancel()
+		for _, batch := range batches {
+			if len(batch.inserted) > 0 {
+				_, err := batch.collection.DeleteMany(undo, bson.M{"_id": bson.M{"$in": batch.inserted}, "user_id": user.ID})
+				dbBreaker.Record(err)
+			}
+		}
+	}
+	for i := range batches {
+		if len(batches[i].records) == 0 {
+			continue
+		}
+		result, err := batches[i].collection.InsertMany(c, batches[i].records)
+		dbBreaker.Record(err)
+		if result != nil {
+			batches[i].inserted = result.InsertedIDs
+		}
+		if err != nil {
+			rollback()
+			if mongo.IsDuplicateKeyError(err) {
+				sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "Bundle conflicts with data written concurrently; retry"})
+				return
+			}
+			failed()
+			return
+		}
+	}
+	_, err = usersCollection.InsertOne(c, user)
+	dbBreaker.Record(err)
+	if err != nil {
+		rollback()
+		failed()
+		return
+	}
+
+	log.Printf("request_id=%s imported user %s with %d documents", requestID(r), user.ID, len(docs))
+	sendJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "User imported",
+		Data: map[string]interface{}{
+			"user_id":   user.ID,
+			"api_key":   user.APIKey,
+			"documents": len(docs),
+			"schemas":   len(schemas),
+			"series":    len(series),
+			"remapped":  remapped,
+			"conflicts": conflicts,
+		},
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestValidateBundle(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	valid := func() UserBundle {
+		return UserBundle{
+			Format:    bundleFormat,
+			Version:   bundleVersion,
+			User:      BundleUser{ID: "u1", Email: "u1@example.com", PasswordHash: string(hash)},
+			Documents: []JSONDocument{{ID: "d1", Name: "a", Data: map[string]interface{}{}}},
+			Schemas:   []SharedSchema{{ID: "s1", Name: "a", Version: 1, Schema: json.RawMessage(`{}`)}},
+			Series:    []SeriesPointer{{Name: "s", DocumentID: "d1"}},
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*UserBundle)
+		want   []string
+	}{
+		{"valid", func(b *UserBundle) {}, nil},
+		{"wrong format", func(b *UserBundle) { b.Format = "other" }, []string{"format"}},
+		{"wrong version", func(b *UserBundle) { b.Version = 2 }, []string{"format"}},
+		{"global user", func(b *UserBundle) { b.User.ID = "global" }, []string{"user.id"}},
+		{"no email", func(b *UserBundle) { b.User.Email = "" }, []string{"user.email"}},
+		{"plain password", func(b *UserBundle) { b.User.PasswordHash = "password" }, []string{"user.password_hash"}},
+		{"duplicate document", func(b *UserBundle) { b.Documents = append(b.Documents, b.Documents[0]) }, []string{"documents[1].id"}},
+		{"document without name or data", func(b *UserBundle) { b.Documents[0].Name, b.Documents[0].Data = "", nil }, []string{"documents[0].data", "documents[0].name"}},
+		{"schema without version", func(b *UserBundle) { b.Schemas[0].Version = 0 }, []string{"schemas[0]"}},
+		{"invalid schema", func(b *UserBundle) { b.Schemas[0].Schema = json.RawMessage(`{"type":"text"}`) }, []string{"schemas[0].schema"}},
+		{"series without document", func(b *UserBundle) { b.Series[0].DocumentID = "" }, []string{"series[0]"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundle := valid()
+			tt.modify(&bundle)
+			var got []string
+			for _, fe := range validateBundle(&bundle) {
+				got = append(got, fe.Field)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("validateBundle fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportImportUser(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", Password: string(hash), APIKey: "key"})
+	docCollection.InsertOne(c, JSONDocument{ID: "d1", UserID: "u1", Name: "a", Data: map[string]interface{}{"x": 1.0}})
+
+	w := serve(exportUser, withPathParams(httptest.NewRequest(http.MethodGet, "/admin/users/u1/export", nil), map[string]string{"id": "u1"}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status = %d: %s", w.Code, w.Body)
+	}
+	var exported struct {
+		Data json.RawMessage `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&exported)
+
+	importBundle := func() (int, []ImportConflict) {
+		r := httptest.NewRequest(http.MethodPost, "/admin/users/import", bytes.NewReader(exported.Data))
+		r.Header.Set("Content-Type", "application/json")
+		w := serve(importUser, r)
+		var resp struct {
+			Data struct {
+				Conflicts []ImportConflict `json:"conflicts"`
+			} `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Data.Conflicts
+	}
+
+	if code, _ := importBundle(); code != http.StatusConflict {
+		t.Errorf("import while the email is registered: status = %d, want 409", code)
+	}
+
+	// With the account gone but its document left behind, the document gets a new ID
+	usersCollection.DeleteOne(c, bson.M{"_id": "u1"})
+	code, conflicts := importBundle()
+	if code != http.StatusCreated {
+		t.Fatalf("import: status = %d, want 201", code)
+	}
+	var kinds []string
+	for _, conflict := range conflicts {
+		kinds = append(kinds, conflict.Kind)
+	}
+	if strings.Join(kinds, ",") != "document" {
+		t.Errorf("conflicts = %+v, want one document conflict", conflicts)
+	}
+	if n, _ := docCollection.CountDocuments(c, bson.M{"user_id": "u1"}); n != 2 {
+		t.Errorf("documents owned after import = %d, want 2", n)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// encoding/json switches to exponent notation for floats from 1e21 up
+const exponentThreshold = 1e21
+
+// formatNumbers prepares document data for output. With NUMBER_FORMAT=plain
+// whole-valued floats, which is what JSON integers decode to, are written
+// as plain integers (1e+21 becomes 1000000000000000000000). Integers
+// decoded as json.Number or stored as BSON int32/int64 are already written
+// as they are.
+func formatNumbers(data map[string]interface{}) map[string]interface{} {
+	if config.NumberFormat != "plain" || data == nil {
+		return data
+	}
+	return plainNumbers(data).(map[string]interface{})
+}
+
+// plainNumbers returns a copy of a decoded value with large whole-valued
+// floats replaced by their plain integer form
+func plainNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if math.Abs(v) >= exponentThreshold && !math.IsInf(v, 0) && v == math.Trunc(v) {
+			return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		return v
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, member := range v {
+			out[key] = plainNumbers(member)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = plainNumbers(item)
+		}
+		return out
+	case bson.A:
+		out := make(bson.A, len(v))
+		for i, item := range v {
+			out[i] = plainNumbers(item)
+		}
+		return out
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFormatNumbersPlain(t *testing.T) {
+	saved := config.NumberFormat
+	t.Cleanup(func() { config.NumberFormat = saved })
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(`{"big":1000000000000000000000000,"small":1000000,"ratio":0.5,"tiny":1e-7,"list":[5e22],"nested":{"n":-3e21}}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	data = storedData(t, data)
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"plain", `{"big":1000000000000000000000000,"list":[50000000000000000000000],"nested":{"n":-3000000000000000000000},"ratio":0.5,"small":1000000,"tiny":1e-7}`},
+		{"go", `{"big":1e+24,"list":[5e+22],"nested":{"n":-3e+21},"ratio":0.5,"small":1000000,"tiny":1e-7}`},
+	}
+	for _, tt := range tests {
+		config.NumberFormat = tt.format
+		out, err := json.Marshal(formatNumbers(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.format, out, tt.want)
+		}
+	}
+}
+
+func TestFormatNumbersKeepsJSONNumbers(t *testing.T) {
+	saved := config.NumberFormat
+	t.Cleanup(func() { config.NumberFormat = saved })
+	config.NumberFormat = "plain"
+
+	data := map[string]interface{}{"id": json.Number("12345678901234567890"), "count": int64(42)}
+	out, err := json.Marshal(formatNumbers(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"count":42,"id":12345678901234567890}`; string(out) != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
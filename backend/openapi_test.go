@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpecCoversRoutes(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range newRoutes().routes {
+		operations, ok := spec.Paths[rt.pattern]
+		if !ok {
+			t.Errorf("%s is not in openapi.json", rt.pattern)
+			continue
+		}
+		for method := range rt.handlers {
+			if _, ok := operations[strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is not in openapi.json", method, rt.pattern)
+			}
+		}
+	}
+}
+
+func TestDocsPagePinsSwaggerUI(t *testing.T) {
+	if strings.Count(docsPage, swaggerUIBase) != 2 {
+		t.Errorf("docs page loads Swagger UI from elsewhere than %s", swaggerUIBase)
+	}
+	if strings.Contains(docsPage, "swagger-ui-dist@5/") {
+		t.Error("docs page loads a floating Swagger UI version")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// mergePatch deep-merges patch into current. Objects merge key by key,
+// arrays and scalars replace the stored value and null deletes the key.
+// It returns the merged data plus the dot-notation $set and $unset
+// operations that apply the same change in MongoDB.
+func mergePatch(current, patch map[string]interface{}, prefix string, set, unset bson.M) map[string]interface{} {
+	merged := make(map[string]interface{}, len(current)+len(patch))
+	for k, v := range current {
+		merged[k] = v
+	}
+
+	for key, value := range patch {
+		path := prefix + "." + key
+		switch v := value.(type) {
+		case nil:
+			if _, ok := merged[key]; ok {
+				delete(merged, key)
+				unset[path] = ""
+			}
+		case map[string]interface{}:
+			if existing, ok := merged[key].(map[string]interface{}); ok {
+				merged[key] = mergePatch(existing, v, path, set, unset)
+				continue
+			}
+			// Nothing to merge into: store the object, minus null members
+			cleaned := mergePatch(nil, v, path, bson.M{}, bson.M{})
+			merged[key] = cleaned
+			set[path] = cleaned
+		default:
+			merged[key] = value
+			set[path] = value
+		}
+	}
+	return merged
+}
+
+// isMergePatch reports whether the request body is an RFC 7386 merge patch
+// (Content-Type: application/merge-patch+json)
+func isMergePatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/merge-patch+json"
+}
+
+// patchKeyErrors rejects keys that can't be addressed with dot notation
+func patchKeyErrors(patch map[string]interface{}, prefix string) []FieldError {
+	var fieldErrors []FieldError
+	for key, value := range patch {
+		path := prefix + "." + key
+		if key == "" || strings.Contains(key, ".") || strings.HasPrefix(key, "$") {
+			fieldErrors = append(fieldErrors, FieldError{Field: path, Message: "key must be non-empty and must not contain '.' or start with '$'"})
+			continue
+		}
+		if child, ok := value.(map[string]interface{}); ok {
+			fieldErrors = append(fieldErrors, patchKeyErrors(child, path)...)
+		}
+	}
+	return fieldErrors
+}
+
+// Patch document - deep-merges data into the stored document. PUT with an
+// application/merge-patch+json body is handled here too; an
+// application/json-patch+json body is passed to jsonPatchDocument.
+func patchDocument(w http.ResponseWriter, r *http.Request, id string) {
+	if isJSONPatch(r) {
+		jsonPatchDocument(w, r, id)
+		return
+	}
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var existingDoc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&existingDoc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if existingDoc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	filter["frozen"] = bson.M{"$ne": true}
+	prior := existingDoc
+
+	var input struct {
+		Name string                 `json:"name"`
+		Data map[string]interface{} `json:"data"`
+	}
+
+	body, ok := readDocumentBody(w, r)
+	if !ok {
+		return
+	}
+
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	if input.Data == nil && input.Name == "" {
+		sendValidationError(w, []FieldError{{Field: "data", Message: "is required"}})
+		return
+	}
+	if fieldErrors := patchKeyErrors(input.Data, "data"); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	if dataKeys, scoped := scopedDataKeys(r); scoped {
+		if input.Name != "" {
+			sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Scoped keys may only update data", Code: "scope_denied"})
+			return
+		}
+		if outside := disallowedKeys(input.Data, dataKeys); len(outside) > 0 {
+			sendScopeDenied(w, outside)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	if input.Data == nil {
+		input.Data = make(map[string]interface{})
+	}
+	stampData(input.Data, existingDoc.Data, existingDoc.CreatedAt, now)
+
+	set := bson.M{"updated_at": now}
+	unset := bson.M{}
+	merged := mergePatch(existingDoc.Data, input.Data, "data", set, unset)
+	set["content_hash"] = contentHashValue(merged)
+
+	if fieldErrors := validateData(merged, existingDoc.Unique); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	name := existingDoc.Name
+	if input.Name != "" {
+		name = input.Name
+	}
+	fieldErrors, err := documentSchemaErrors(c, &existingDoc, name, merged, set)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	if input.Name != "" {
+		set["name"] = input.Name
+		existingDoc.Name = input.Name
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	growth := dataSize(merged) - dataSize(prior.Data)
+	if !chargeStorage(c, w, prior.UserID, growth) {
+		return
+	}
+	result, err := docCollection.UpdateOne(c, filter, update)
+	dbBreaker.Record(err)
+	settleStorage(prior.UserID, growth, err == nil && result.MatchedCount > 0)
+	if mongo.IsDuplicateKeyError(err) {
+		sendWriteConflict(w, err)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	recordVersion(c, r, prior)
+
+	existingDoc.Data = merged
+	existingDoc.UpdatedAt = set["updated_at"].(time.Time)
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Message: "Document patched: objects were merged, arrays replaced and null values removed",
+		Data:    existingDoc,
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMergePatch(t *testing.T) {
+	const current = `{"a":1,"b":{"c":2,"d":3},"e":[1,2],"f":"x"}`
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+		set   string
+		unset string
+	}{
+		{"empty patch", `{}`, current, `{}`, `{}`},
+		{"replace scalar", `{"a":5}`, `{"a":5,"b":{"c":2,"d":3},"e":[1,2],"f":"x"}`, `{"data.a":5}`, `{}`},
+		{"add key", `{"g":true}`, `{"a":1,"b":{"c":2,"d":3},"e":[1,2],"f":"x","g":true}`, `{"data.g":true}`, `{}`},
+		{"merge nested object", `{"b":{"d":4,"z":0}}`, `{"a":1,"b":{"c":2,"d":4,"z":0},"e":[1,2],"f":"x"}`, `{"data.b.d":4,"data.b.z":0}`, `{}`},
+		{"replace array", `{"e":[3]}`, `{"a":1,"b":{"c":2,"d":3},"e":[3],"f":"x"}`, `{"data.e":[3]}`, `{}`},
+		{"null deletes", `{"f":null,"b":{"c":null}}`, `{"a":1,"b":{"d":3},"e":[1,2]}`, `{}`, `{"data.b.c":"","data.f":""}`},
+		{"null for a missing key", `{"missing":null}`, current, `{}`, `{}`},
+		{"object over scalar", `{"a":{"x":1,"y":null}}`, `{"a":{"x":1},"b":{"c":2,"d":3},"e":[1,2],"f":"x"}`, `{"data.a":{"x":1}}`, `{}`},
+		{"scalar over object", `{"b":"flat"}`, `{"a":1,"b":"flat","e":[1,2],"f":"x"}`, `{"data.b":"flat"}`, `{}`},
+	}
+	for _, tt := range tests {
+		for _, stored := range []bool{false, true} {
+			name := tt.name
+			if stored {
+				name += " (stored)"
+			}
+			t.Run(name, func(t *testing.T) {
+				var data, patch map[string]interface{}
+				json.Unmarshal([]byte(current), &data)
+				if err := json.Unmarshal([]byte(tt.patch), &patch); err != nil {
+					t.Fatal(err)
+				}
+				if stored {
+					data = storedData(t, data)
+				}
+				before, _ := json.Marshal(data)
+
+				set, unset := bson.M{}, bson.M{}
+				merged := mergePatch(data, patch, "data", set, unset)
+				for _, check := range []struct {
+					what string
+					got  interface{}
+					want string
+				}{{"merged", merged, tt.want}, {"$set", set, tt.set}, {"$unset", unset, tt.unset}} {
+					if raw, _ := json.Marshal(check.got); string(raw) != check.want {
+						t.Errorf("%s = %s, want %s", check.what, raw, check.want)
+					}
+				}
+				if after, _ := json.Marshal(data); string(after) != string(before) {
+					t.Errorf("mergePatch modified the stored data: %s", after)
+				}
+			})
+		}
+	}
+}
+
+func TestPatchKeyErrors(t *testing.T) {
+	tests := []struct {
+		patch string
+		want  []string
+	}{
+		{`{"a":1,"b":{"c":2}}`, nil},
+		{`{"a.b":1}`, []string{"data.a.b"}},
+		{`{"$set":1}`, []string{"data.$set"}},
+		{`{"":1}`, []string{"data."}},
+		{`{"a":{"b.c":1,"$d":2}}`, []string{"data.a.$d", "data.a.b.c"}},
+		{`{"a":[{"b.c":1}]}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.patch, func(t *testing.T) {
+			var patch map[string]interface{}
+			json.Unmarshal([]byte(tt.patch), &patch)
+			var got []string
+			for _, fe := range patchKeyErrors(patch, "data") {
+				got = append(got, fe.Field)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("patchKeyErrors fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsMergePatch(t *testing.T) {
+	for contentType, want := range map[string]bool{
+		"application/merge-patch+json":                true,
+		"Application/Merge-Patch+JSON; charset=utf-8": true,
+		"application/json":                            false,
+		"application/json-patch+json":                 false,
+		"":                                            false,
+	} {
+		r := httptest.NewRequest(http.MethodPut, "/api/documents/1", nil)
+		r.Header.Set("Content-Type", contentType)
+		if got := isMergePatch(r); got != want {
+			t.Errorf("isMergePatch(%q) = %v, want %v", contentType, got, want)
+		}
+	}
+}
+
+func TestUpdateDocumentMergePatch(t *testing.T) {
+	setupTestDB(t)
+	doc := JSONDocument{
+		ID:     "doc-1",
+		UserID: "u1",
+		Name:   "settings",
+		Data: map[string]interface{}{
+			"theme": map[string]interface{}{"color": "blue", "font": map[string]interface{}{"size": 12, "family": "serif"}},
+			"tags":  []interface{}{"a", "b"},
+			"keep":  true,
+		},
+	}
+	if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(contentType, body string) {
+		r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/doc-1", strings.NewReader(body)), "u1")
+		r.Header.Set("Content-Type", contentType)
+		if w := serve(withID(updateDocument), withPathParams(r, map[string]string{"id": "doc-1"})); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", contentType, w.Code, w.Body)
+		}
+	}
+	stored := func() string {
+		var got JSONDocument
+		if err := docCollection.FindOne(context.Background(), bson.M{"_id": "doc-1"}).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := json.Marshal(got.Data)
+		return string(raw)
+	}
+
+	put("application/merge-patch+json", `{"data":{"theme":{"font":{"family":null}},"tags":["c"]}}`)
+	if got, want := stored(), `{"keep":true,"tags":["c"],"theme":{"color":"blue","font":{"size":12}}}`; got != want {
+		t.Errorf("after merge patch data = %s, want %s", got, want)
+	}
+
+	put("application/json", `{"data":{"tags":[]}}`)
+	if got, want := stored(), `{"tags":[]}`; got != want {
+		t.Errorf("after plain PUT data = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxPublicFields caps the allowlist of a document
+const maxPublicFields = 100
+
+// validatePublicFields checks an allowlist of data paths such as "title"
+// or "author.name"
+func validatePublicFields(paths []string) []FieldError {
+	if len(paths) > maxPublicFields {
+		return []FieldError{{Field: "public_fields", Message: fmt.Sprintf("must have at most %d entries", maxPublicFields)}}
+	}
+	for _, path := range paths {
+		for _, part := range strings.Split(path, ".") {
+			if part == "" || strings.HasPrefix(part, "$") {
+				return []FieldError{{Field: "public_fields", Message: "must be data paths such as title or author.name"}}
+			}
+		}
+	}
+	return nil
+}
+
+// publicData returns the data a public read may expose, identified by
+// DATA_ID_FIELD and DATA_NAME_FIELD. With PUBLIC_FIELDS_MODE=allowlist only
+// the document's public_fields are kept, so a field added later stays
+// private until it is listed.
+func publicData(doc JSONDocument) map[string]interface{} {
+	if config.PublicFields != "allowlist" {
+		return identifyData(doc, doc.Data)
+	}
+
+	sel := fieldSelector{}
+	for _, path := range doc.PublicFields {
+		sel.add(strings.Split(path, "."), nil)
+	}
+	allowed, _ := allowFields(sel, doc.Data)
+	data, _ := allowed.(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	return identifyData(doc, data)
+}
+
+// allowFields keeps only the selected paths of value. Unlike ?fields=, a
+// path that runs into a scalar exposes nothing rather than the scalar.
+func allowFields(sel fieldSelector, value interface{}) (interface{}, bool) {
+	if sel == nil {
+		return value, true
+	}
+	if items, ok := asArray(value); ok {
+		kept := make([]interface{}, 0, len(items))
+		for _, item := range items {
+			if v, ok := allowFields(sel, item); ok {
+				kept = append(kept, v)
+			}
+		}
+		return kept, true
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	kept := make(map[string]interface{}, len(sel))
+	for key, child := range sel {
+		if member, ok := m[key]; ok {
+			if v, ok := allowFields(child, member); ok {
+				kept[key] = v
+			}
+		}
+	}
+	return kept, true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidatePublicFields(t *testing.T) {
+	tests := []struct {
+		paths []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"title", "author.name"}, true},
+		{[]string{""}, false},
+		{[]string{"author..name"}, false},
+		{[]string{"$where"}, false},
+		{[]string{"author.$name"}, false},
+	}
+	for _, tt := range tests {
+		if got := validatePublicFields(tt.paths) == nil; got != tt.valid {
+			t.Errorf("validatePublicFields(%q) valid = %v, want %v", tt.paths, got, tt.valid)
+		}
+	}
+}
+
+func TestPublicDataAllowlist(t *testing.T) {
+	saved := config.PublicFields
+	t.Cleanup(func() { config.PublicFields = saved })
+	config.PublicFields = "allowlist"
+
+	doc := JSONDocument{
+		Data: storedData(t, map[string]interface{}{
+			"title":  "Post",
+			"author": map[string]interface{}{"name": "Ann", "email": "ann@example.com"},
+			"tags":   []interface{}{map[string]interface{}{"label": "go", "internal": true}},
+		}),
+		PublicFields: []string{"title", "author.name", "tags.label"},
+	}
+	want := map[string]interface{}{
+		"title":  "Post",
+		"author": map[string]interface{}{"name": "Ann"},
+		"tags":   []interface{}{map[string]interface{}{"label": "go"}},
+	}
+	if got := publicData(doc); !reflect.DeepEqual(got, want) {
+		t.Errorf("publicData = %v, want %v", got, want)
+	}
+
+	// A field added later stays private until it is allowlisted
+	doc.Data["secret"] = "s3cr3t"
+	if _, ok := publicData(doc)["secret"]; ok {
+		t.Error("newly added field is public before being allowlisted")
+	}
+	doc.PublicFields = append(doc.PublicFields, "secret")
+	if got := publicData(doc)["secret"]; got != "s3cr3t" {
+		t.Errorf("allowlisted field = %v, want s3cr3t", got)
+	}
+
+	// A path through a scalar exposes nothing
+	doc.PublicFields = []string{"title.length"}
+	if got := publicData(doc); len(got) != 0 {
+		t.Errorf("publicData = %v, want no fields", got)
+	}
+
+	config.PublicFields = "all"
+	if got := publicData(doc); !reflect.DeepEqual(got, doc.Data) {
+		t.Errorf("publicData in all mode = %v, want the full data", got)
+	}
+}
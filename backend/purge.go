@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Purge the caller's documents - deletes every document the user owns,
+// frozen ones included, while keeping the account and its keys. Requires
+// the account password. Series pointers always go with the documents;
+// their version history is kept unless {"versions": true} asks for it too.
+func purgeDocuments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	if userID == "global" {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Purging is only available to user accounts"})
+		return
+	}
+
+	var input struct {
+		Password string `json:"password"`
+		Versions bool   `json:"versions"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if input.Password == "" {
+		sendValidationError(w, []FieldError{{Field: "password", Message: "is required"}})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var user User
+	err := usersCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "User not found"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		sendJSON(w, http.StatusUnauthorized, APIResponse{Success: false, Error: "Invalid password"})
+		return
+	}
+
+	owned := bson.M{"user_id": userID}
+	removed := map[string]int64{}
+
+	result, err := docCollection.DeleteMany(c, owned)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to purge documents"})
+		return
+	}
+	removed["documents"] = result.DeletedCount
+
+	_, err = usersCollection.UpdateOne(c, bson.M{"_id": userID}, bson.M{"$set": bson.M{"storage_bytes": int64(0)}})
+	dbBreaker.Record(err)
+	if err != nil {
+		log.Printf("Failed to reset storage total for %s: %v", userID, err)
+	}
+
+	result, err = seriesCollection.DeleteMany(c, owned)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to purge series"})
+		return
+	}
+	removed["series"] = result.DeletedCount
+
+	if input.Versions {
+		result, err = versionsCollection.DeleteMany(c, owned)
+		dbBreaker.Record(err)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to purge versions"})
+			return
+		}
+		removed["versions"] = result.DeletedCount
+	}
+
+	log.Printf("request_id=%s purged %d documents for user %s", requestID(r), removed["documents"], userID)
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Documents purged; the account and its keys are unchanged", Data: removed})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPurgeDocumentsKeepsAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		docs     int64
+		versions int64
+	}{
+		{"keeps versions by default", `{"password":"secret"}`, http.StatusOK, 0, 1},
+		{"versions on request", `{"password":"secret","versions":true}`, http.StatusOK, 0, 0},
+		{"wrong password", `{"password":"wrong","versions":true}`, http.StatusUnauthorized, 2, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			c := context.Background()
+
+			hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+			usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", Password: string(hash), APIKey: "account-key"})
+			keysCollection.InsertOne(c, ScopedKey{ID: "k1", UserID: "u1", Key: "scoped-key"})
+			for _, doc := range []JSONDocument{{ID: "a", UserID: "u1"}, {ID: "b", UserID: "u1"}, {ID: "other", UserID: "u2"}} {
+				docCollection.InsertOne(c, doc)
+			}
+			versionsCollection.InsertOne(c, bson.M{"document_id": "a", "user_id": "u1", "version": 1})
+
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/me/purge-documents", strings.NewReader(tt.body)), "u1")
+			if w := serve(purgeDocuments, r); w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			if n, _ := docCollection.CountDocuments(c, bson.M{"user_id": "u1"}); n != tt.docs {
+				t.Errorf("%d documents left, want %d", n, tt.docs)
+			}
+			if n, _ := docCollection.CountDocuments(c, bson.M{"user_id": "u2"}); n != 1 {
+				t.Error("another user's document was purged")
+			}
+			if n, _ := versionsCollection.CountDocuments(c, bson.M{"user_id": "u1"}); n != tt.versions {
+				t.Errorf("%d versions left, want %d", n, tt.versions)
+			}
+
+			for _, key := range []string{"account-key", "scoped-key"} {
+				r := httptest.NewRequest(http.MethodGet, "/api/documents", nil)
+				r.Header.Set("X-API-Key", key)
+				if w := serve(authMiddleware(listDocuments), r); w.Code != http.StatusOK {
+					t.Errorf("%s after the purge: status = %d, want 200", key, w.Code)
+				}
+			}
+		})
+	}
+}
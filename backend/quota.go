@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// dataSize is what a document's data counts against its owner's storage,
+// its BSON size as stored
+func dataSize(data map[string]interface{}) int64 {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return 0
+	}
+	return int64(len(raw))
+}
+
+// adjustStorage adds delta bytes to a user's running storage total. Growth
+// that would pass MAX_STORAGE_BYTES_PER_USER fails with errQuotaExceeded
+// and leaves the total unchanged. Owners without an account, such as the
+// global key or deleted users, aren't tracked. A total missing since before
+// quotas were tracked is counted from the documents on the first growth,
+// which is charged before its write; shrinking leaves it missing.
+func adjustStorage(c context.Context, userID string, delta int64) error {
+	if userID == "global" || delta == 0 {
+		return nil
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		// The total must already exist, so one missing since before quotas
+		// were tracked is never incremented from zero
+		filter := bson.M{"_id": userID, "storage_bytes": bson.M{"$exists": true}}
+		if delta > 0 && config.MaxStorageBytes > 0 {
+			filter["storage_bytes"] = bson.M{"$lte": config.MaxStorageBytes - delta}
+		}
+		result, err := usersCollection.UpdateOne(c, filter, bson.M{"$inc": bson.M{"storage_bytes": delta}})
+		dbBreaker.Record(err)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount > 0 {
+			return nil
+		}
+
+		var user User
+		err = usersCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+		dbBreaker.Record(err)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if user.StorageBytes != nil {
+			return errQuotaExceeded
+		}
+		// Shrinking is released once its write is made, so a recount now
+		// would already see it and it would come off twice. The next
+		// growth's recount picks it up instead.
+		if delta < 0 {
+			return nil
+		}
+		if err := recountStorage(c, userID); err != nil {
+			return err
+		}
+	}
+	return errQuotaExceeded
+}
+
+// recountStorage sets a user's missing storage total from their documents
+func recountStorage(c context.Context, userID string) error {
+	totals, err := storageByOwner(c, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = usersCollection.UpdateOne(c,
+		bson.M{"_id": userID, "storage_bytes": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"storage_bytes": totals[userID]}})
+	dbBreaker.Record(err)
+	return err
+}
+
+// storageByOwner sums the data size of the documents matching filter per
+// owner
+func storageByOwner(c context.Context, filter bson.M) (map[string]int64, error) {
+	cursor, err := docCollection.Aggregate(c, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$user_id", "bytes": bson.M{"$sum": bson.M{"$bsonSize": "$data"}}}}},
+	})
+	dbBreaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	var rows []struct {
+		UserID string `bson:"_id"`
+		Bytes  int64  `bson:"bytes"`
+	}
+	if err := cursor.All(c, &rows); err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		totals[row.UserID] = row.Bytes
+	}
+	return totals, nil
+}
+
+// chargeStorage takes a write's growth from the owner's quota before it is
+// made, replying with the error itself when it can't. Shrinking is only
+// credited by settleStorage once the write has succeeded.
+func chargeStorage(c context.Context, w http.ResponseWriter, userID string, delta int64) bool {
+	if delta <= 0 {
+		return true
+	}
+
+	err := adjustStorage(c, userID, delta)
+	if err == errQuotaExceeded {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Storage quota exceeded", Code: "quota_exceeded"})
+		return false
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to check storage quota"})
+		return false
+	}
+	return true
+}
+
+// settleStorage finishes the accounting of a write charged with
+// chargeStorage: growth is refunded if the write failed and shrinking is
+// credited if it succeeded
+func settleStorage(userID string, delta int64, written bool) {
+	switch {
+	case written && delta < 0:
+		releaseStorage(userID, -delta)
+	case !written && delta > 0:
+		releaseStorage(userID, delta)
+	}
+}
+
+// releaseStorage takes bytes off a user's total. Failures are logged; the
+// write has already been decided.
+func releaseStorage(userID string, bytes int64) {
+	// Not tied to the request: the client leaving must not skew the total
+	c, cancel := dbContext(context.Background())
+	defer cancel()
+
+	if err := adjustStorage(c, userID, -bytes); err != nil {
+		log.Printf("Failed to release %d storage bytes for %s: %v", bytes, userID, err)
+	}
+}
+
+// releaseStorageOf releases the storage of documents about to be deleted
+// by filter
+func releaseStorageOf(filter bson.M) {
+	c, cancel := dbContext(context.Background())
+	defer cancel()
+
+	totals, err := storageByOwner(c, filter)
+	if err != nil {
+		log.Printf("Failed to total storage being released: %v", err)
+		return
+	}
+	for userID, bytes := range totals {
+		releaseStorage(userID, bytes)
+	}
+}
+
+// Report the caller's stored bytes and what remains of their quota
+func usageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	if userID == "global" {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Usage is tracked for user accounts"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var user User
+	err := usersCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+	dbBreaker.Record(err)
+	if err == nil && user.StorageBytes == nil {
+		if err = recountStorage(c, userID); err == nil {
+			err = usersCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+			dbBreaker.Record(err)
+		}
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to load usage"})
+		return
+	}
+
+	usage := map[string]interface{}{
+		"storage_bytes":     *user.StorageBytes,
+		"max_storage_bytes": nil,
+		"remaining_bytes":   nil,
+	}
+	if config.MaxStorageBytes > 0 {
+		remaining := config.MaxStorageBytes - *user.StorageBytes
+		if remaining < 0 {
+			remaining = 0
+		}
+		usage["max_storage_bytes"] = config.MaxStorageBytes
+		usage["remaining_bytes"] = remaining
+	}
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: usage})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDataSize(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want int64
+	}{
+		// An empty BSON document is a 4-byte length and a terminating zero
+		{"empty", map[string]interface{}{}, 5},
+		{"nil", nil, 5},
+		// 5 + type byte + "a\x00" + int32
+		{"one int32", map[string]interface{}{"a": int32(1)}, 12},
+		// 5 + type byte + "s\x00" + length + "hi\x00"
+		{"one string", map[string]interface{}{"s": "hi"}, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dataSize(tt.data); got != tt.want {
+				t.Errorf("dataSize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUntrackedStorage(t *testing.T) {
+	// Neither reaches the database
+	if err := adjustStorage(context.Background(), "global", 100); err != nil {
+		t.Errorf("global key: %v", err)
+	}
+	if err := adjustStorage(context.Background(), "u1", 0); err != nil {
+		t.Errorf("zero delta: %v", err)
+	}
+	if !chargeStorage(context.Background(), httptest.NewRecorder(), "u1", -10) {
+		t.Error("shrinking write was refused")
+	}
+
+	r := asUser(httptest.NewRequest(http.MethodGet, "/api/usage", nil), "global")
+	if w := serve(usageHandler, r); w.Code != http.StatusForbidden {
+		t.Errorf("global usage: status = %d, want 403", w.Code)
+	}
+}
+
+func TestAdjustStorage(t *testing.T) {
+	setupTestDB(t)
+	saved := config.MaxStorageBytes
+	t.Cleanup(func() { config.MaxStorageBytes = saved })
+	config.MaxStorageBytes = 100
+
+	c := context.Background()
+	// u1 predates quota tracking: its total is counted from its documents
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com"})
+	docCollection.InsertOne(c, JSONDocument{ID: "d", UserID: "u1", Name: "n", Data: map[string]interface{}{"s": "hi"}})
+
+	tests := []struct {
+		name  string
+		user  string
+		delta int64
+		err   error
+		total int64
+	}{
+		{"first write recounts", "u1", 10, nil, 25},
+		{"grow within the quota", "u1", 75, nil, 100},
+		{"grow past the quota", "u1", 1, errQuotaExceeded, 100},
+		{"shrink", "u1", -50, nil, 50},
+		{"shrink always allowed", "u1", -50, nil, 0},
+		{"no account", "gone", 10, nil, -1},
+	}
+	for _, tt := range tests {
+		if err := adjustStorage(c, tt.user, tt.delta); err != tt.err {
+			t.Fatalf("%s: error = %v, want %v", tt.name, err, tt.err)
+		}
+		if tt.total < 0 {
+			continue
+		}
+		var user User
+		usersCollection.FindOne(c, bson.M{"_id": tt.user}).Decode(&user)
+		if user.StorageBytes == nil || *user.StorageBytes != tt.total {
+			t.Errorf("%s: storage_bytes = %v, want %d", tt.name, user.StorageBytes, tt.total)
+		}
+	}
+}
+
+func TestChargeStorage(t *testing.T) {
+	setupTestDB(t)
+	saved := config.MaxStorageBytes
+	t.Cleanup(func() { config.MaxStorageBytes = saved })
+	config.MaxStorageBytes = 100
+
+	total := int64(90)
+	usersCollection.InsertOne(context.Background(), User{ID: "u1", Email: "u1@example.com", StorageBytes: &total})
+
+	w := httptest.NewRecorder()
+	if chargeStorage(context.Background(), w, "u1", 20) {
+		t.Fatal("write past the quota was charged")
+	}
+	var resp APIResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusForbidden || resp.Code != "quota_exceeded" {
+		t.Errorf("over quota: status = %d, code = %q; want 403 quota_exceeded", w.Code, resp.Code)
+	}
+	if !chargeStorage(context.Background(), httptest.NewRecorder(), "u1", 10) {
+		t.Error("write within the quota was refused")
+	}
+
+	w = serve(usageHandler, asUser(httptest.NewRequest(http.MethodGet, "/api/usage", nil), "u1"))
+	var usage struct {
+		Data map[string]int64 `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&usage)
+	want := map[string]int64{"storage_bytes": 100, "max_storage_bytes": 100, "remaining_bytes": 0}
+	for key, value := range want {
+		if usage.Data[key] != value {
+			t.Errorf("usage %s = %d, want %d", key, usage.Data[key], value)
+		}
+	}
+
+	settleStorage("u1", 10, false)
+	var user User
+	usersCollection.FindOne(context.Background(), bson.M{"_id": "u1"}).Decode(&user)
+	if *user.StorageBytes != 90 {
+		t.Errorf("after refund storage_bytes = %d, want 90", *user.StorageBytes)
+	}
+}
+
+func TestUntrackedStorageWrites(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	big := `{"s":"` + strings.Repeat("x", 200) + `"}`
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		handler func(http.ResponseWriter, *http.Request, string)
+	}{
+		{"update grows", http.MethodPut, `{"data":` + big + `}`, updateDocument},
+		{"update shrinks", http.MethodPut, `{"data":{"s":"x"}}`, updateDocument},
+		{"patch grows", http.MethodPatch, `{"data":` + big + `}`, patchDocument},
+		{"patch shrinks", http.MethodPatch, `{"data":{"s":"x"}}`, patchDocument},
+		{"permanent delete", http.MethodDelete, ``, deleteDocument},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Each owner predates quota tracking, so the write is the first
+			// to touch its total
+			userID := fmt.Sprintf("legacy%d", i)
+			usersCollection.InsertOne(c, User{ID: userID, Email: userID + "@example.com"})
+			docCollection.InsertOne(c, JSONDocument{ID: userID + "-other", UserID: userID, Name: "other", Data: map[string]interface{}{"n": 1.0}})
+			docCollection.InsertOne(c, JSONDocument{ID: userID + "-doc", UserID: userID, Name: "doc", Data: map[string]interface{}{"s": strings.Repeat("y", 100)}})
+
+			r := httptest.NewRequest(tt.method, "/api/documents/"+userID+"-doc?permanent=true", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := serve(func(w http.ResponseWriter, r *http.Request) { tt.handler(w, r, userID+"-doc") }, asUser(r, userID))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+
+			totals, err := storageByOwner(c, bson.M{"user_id": userID})
+			if err != nil {
+				t.Fatal(err)
+			}
+			w = serve(usageHandler, asUser(httptest.NewRequest(http.MethodGet, "/api/me/usage", nil), userID))
+			var usage struct {
+				Data map[string]int64 `json:"data"`
+			}
+			json.NewDecoder(w.Body).Decode(&usage)
+			if usage.Data["storage_bytes"] != totals[userID] {
+				t.Errorf("storage_bytes = %d, want %d", usage.Data["storage_bytes"], totals[userID])
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows perMinute requests per minute with bursts up to the
+// same amount
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per key, in memory
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// Allow takes a token for key and otherwise reports how long until one is
+// available
+func (l *rateLimiter) Allow(key string, perMinute int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(perMinute) / 60 // tokens per second
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(perMinute), last: now}
+		l.buckets[key] = b
+		l.prune(now)
+	}
+	b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// prune drops buckets idle for more than a minute, which are full again
+// and so equivalent to new ones. It runs as new keys arrive.
+func (l *rateLimiter) prune(now time.Time) {
+	if len(l.buckets) < 10000 {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+var (
+	publicDocLimiter = newRateLimiter()
+	userLimiter      = newRateLimiter()
+)
+
+// sendRateLimited replies 429 with Retry-After rounded up to whole seconds
+func sendRateLimited(w http.ResponseWriter, wait time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	sendJSON(w, http.StatusTooManyRequests, APIResponse{Success: false, Error: message, Code: "rate_limited"})
+}
+
+// Rate limit middleware - limits each authenticated caller to RATE_LIMIT_RPM
+// requests per minute, or RATE_LIMIT_GLOBAL_RPM for the global API key
+func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := getUserID(r)
+		limit := config.RateLimitRPM
+		if userID == "global" {
+			limit = config.RateLimitGlobalRPM
+		}
+		if limit <= 0 {
+			next(w, r)
+			return
+		}
+
+		if allowed, wait := userLimiter.Allow(userID, limit); !allowed {
+			sendRateLimited(w, wait, "Rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// allowPublicRead applies the document's public read limit, falling back to
+// PUBLIC_DOC_RATE_LIMIT. It sends the 429 itself when the limit is exceeded.
+func allowPublicRead(w http.ResponseWriter, doc JSONDocument) bool {
+	limit := config.PublicDocRateLimit
+	if doc.RateLimit > 0 {
+		limit = doc.RateLimit
+	}
+	if limit <= 0 {
+		return true
+	}
+
+	allowed, wait := publicDocLimiter.Allow(doc.ID, limit)
+	if allowed {
+		return true
+	}
+
+	sendRateLimited(w, wait, "Document read rate exceeded")
+	return false
+}
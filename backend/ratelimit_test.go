@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name      string
+		perMinute int
+		requests  int
+		idle      time.Duration
+		allowed   int
+	}{
+		{"burst up to the limit", 3, 5, 0, 3},
+		{"one token per interval", 3, 5, 20 * time.Second, 4},
+		{"partial interval", 3, 5, 10 * time.Second, 3},
+		{"refill capped at the limit", 3, 10, time.Hour, 6},
+		{"high limit", 600, 700, 0, 600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter()
+			allowed := 0
+			// Drain the bucket, go idle, then count what is allowed in total
+			for i := 0; i < tt.perMinute; i++ {
+				if ok, _ := l.Allow("k", tt.perMinute); ok {
+					allowed++
+				}
+			}
+			l.buckets["k"].last = l.buckets["k"].last.Add(-tt.idle)
+			for i := tt.perMinute; i < tt.requests; i++ {
+				if ok, _ := l.Allow("k", tt.perMinute); ok {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d requests, want %d", allowed, tt.requests, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	l := newRateLimiter()
+	l.Allow("a", 2)
+	l.Allow("a", 2)
+	ok, wait := l.Allow("a", 2)
+	if ok {
+		t.Fatal("third request allowed with a limit of 2")
+	}
+	if wait <= 29*time.Second || wait > 30*time.Second {
+		t.Errorf("wait = %v, want about 30s", wait)
+	}
+	if ok, _ := l.Allow("b", 2); !ok {
+		t.Error("a different key shares the exhausted bucket")
+	}
+}
+
+func TestAllowPublicRead(t *testing.T) {
+	saved, savedLimiter := config.PublicDocRateLimit, publicDocLimiter
+	t.Cleanup(func() { config.PublicDocRateLimit, publicDocLimiter = saved, savedLimiter })
+
+	tests := []struct {
+		name     string
+		config   int
+		doc      int
+		requests int
+		allowed  int
+	}{
+		{"unlimited", 0, 0, 5, 5},
+		{"server default", 2, 0, 5, 2},
+		{"document limit overrides", 2, 4, 5, 4},
+		{"document limit without a default", 0, 1, 5, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.PublicDocRateLimit = tt.config
+			publicDocLimiter = newRateLimiter()
+			doc := JSONDocument{ID: "d", RateLimit: tt.doc}
+
+			allowed := 0
+			var last *httptest.ResponseRecorder
+			for i := 0; i < tt.requests; i++ {
+				last = httptest.NewRecorder()
+				if allowPublicRead(last, doc) {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d reads, want %d", allowed, tt.requests, tt.allowed)
+			}
+			if allowed < tt.requests {
+				if last.Code != http.StatusTooManyRequests || last.Header().Get("Retry-After") == "" {
+					t.Errorf("limited read: status = %d, Retry-After = %q", last.Code, last.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	savedRPM, savedGlobal, savedLimiter := config.RateLimitRPM, config.RateLimitGlobalRPM, userLimiter
+	t.Cleanup(func() {
+		config.RateLimitRPM, config.RateLimitGlobalRPM, userLimiter = savedRPM, savedGlobal, savedLimiter
+	})
+
+	tests := []struct {
+		name    string
+		rpm     int
+		global  int
+		user    string
+		allowed int
+	}{
+		{"user limit", 3, 0, "u1", 3},
+		{"global key limit", 3, 5, "global", 5},
+		{"global key unlimited", 3, 0, "global", 10},
+		{"disabled", 0, 5, "u1", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.RateLimitRPM, config.RateLimitGlobalRPM = tt.rpm, tt.global
+			userLimiter = newRateLimiter()
+			h := rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				w := serve(h, asUser(httptest.NewRequest(http.MethodGet, "/api/documents", nil), tt.user))
+				switch w.Code {
+				case http.StatusOK:
+					allowed++
+				case http.StatusTooManyRequests:
+					if w.Header().Get("Retry-After") == "" {
+						t.Error("429 without Retry-After")
+					}
+				default:
+					t.Fatalf("status = %d", w.Code)
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of 10 requests, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+
+	// Each user has their own bucket
+	config.RateLimitRPM = 1
+	userLimiter = newRateLimiter()
+	h := rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	for _, user := range []string{"u1", "u2"} {
+		if w := serve(h, asUser(httptest.NewRequest(http.MethodGet, "/api/documents", nil), user)); w.Code != http.StatusOK {
+			t.Errorf("first request for %s: status = %d, want 200", user, w.Code)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// documentReadPreference builds the read preference used for document
+// reads from READ_PREFERENCE and READ_MAX_STALENESS
+func documentReadPreference() (*readpref.ReadPref, error) {
+	mode, err := readpref.ModeFromString(config.ReadPreference)
+	if err != nil {
+		return nil, err
+	}
+	if config.ReadMaxStaleness > 0 {
+		return readpref.New(mode, readpref.WithMaxStaleness(config.ReadMaxStaleness))
+	}
+	return readpref.New(mode)
+}
+
+// dataFreshness describes where reads may be served from and how stale
+// they may be, e.g. "primary" or "secondaryPreferred; max-staleness=90"
+func dataFreshness() string {
+	mode, err := readpref.ModeFromString(config.ReadPreference)
+	if err != nil || mode == readpref.PrimaryMode {
+		return "primary"
+	}
+	if config.ReadMaxStaleness > 0 {
+		return fmt.Sprintf("%s; max-staleness=%d", mode, int(config.ReadMaxStaleness.Seconds()))
+	}
+	return mode.String() + "; max-staleness=unbounded"
+}
+
+// Freshness middleware - labels reads with X-Data-Freshness so clients can
+// re-read from the primary when they need strong consistency
+func freshnessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.FreshnessHeader && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			w.Header().Set("X-Data-Freshness", dataFreshness())
+		}
+		next.ServeHTTP(w, r)
+	})
+}
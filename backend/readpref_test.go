@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestDocumentReadPreference(t *testing.T) {
+	savedMode, savedStaleness := config.ReadPreference, config.ReadMaxStaleness
+	t.Cleanup(func() { config.ReadPreference, config.ReadMaxStaleness = savedMode, savedStaleness })
+
+	tests := []struct {
+		mode      string
+		staleness time.Duration
+		want      readpref.Mode
+		freshness string
+		wantErr   bool
+	}{
+		{"primary", 0, readpref.PrimaryMode, "primary", false},
+		{"secondaryPreferred", 0, readpref.SecondaryPreferredMode, "secondaryPreferred; max-staleness=unbounded", false},
+		{"nearest", 90 * time.Second, readpref.NearestMode, "nearest; max-staleness=90", false},
+		{"primary", 90 * time.Second, 0, "primary", true},
+		{"fastest", 0, 0, "primary", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.staleness.String(), func(t *testing.T) {
+			config.ReadPreference, config.ReadMaxStaleness = tt.mode, tt.staleness
+			rp, err := documentReadPreference()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("documentReadPreference error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && rp.Mode() != tt.want {
+				t.Errorf("mode = %v, want %v", rp.Mode(), tt.want)
+			}
+			if got := dataFreshness(); got != tt.freshness {
+				t.Errorf("dataFreshness = %q, want %q", got, tt.freshness)
+			}
+		})
+	}
+}
+
+func TestFreshnessMiddleware(t *testing.T) {
+	savedHeader, savedMode := config.FreshnessHeader, config.ReadPreference
+	t.Cleanup(func() { config.FreshnessHeader, config.ReadPreference = savedHeader, savedMode })
+	config.ReadPreference = "primary"
+
+	tests := []struct {
+		enabled bool
+		method  string
+		want    string
+	}{
+		{true, http.MethodGet, "primary"},
+		{true, http.MethodHead, "primary"},
+		{true, http.MethodPost, ""},
+		{false, http.MethodGet, ""},
+	}
+	for _, tt := range tests {
+		config.FreshnessHeader = tt.enabled
+		w := httptest.NewRecorder()
+		freshnessMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+			ServeHTTP(w, httptest.NewRequest(tt.method, "/api/documents", nil))
+		if got := w.Header().Get("X-Data-Freshness"); got != tt.want {
+			t.Errorf("enabled=%v %s: X-Data-Freshness = %q, want %q", tt.enabled, tt.method, got, tt.want)
+		}
+	}
+}
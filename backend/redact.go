@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// redactedValue replaces the value of every sensitive field
+const redactedValue = "[REDACTED]"
+
+// logBodyMax caps how much of each body is kept for the log
+const logBodyMax = 4096
+
+// redactPatterns turns LOG_REDACT_FIELDS into the expression matching a
+// sensitive field name. Names match case-insensitively and in full, with *
+// standing for any run of name characters, e.g. *token*.
+func redactPatterns(names []string) string {
+	var alternatives []string
+	for _, name := range names {
+		parts := strings.Split(strings.TrimSpace(name), "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		if joined := strings.Join(parts, `[\w.-]*`); joined != "" {
+			alternatives = append(alternatives, joined)
+		}
+	}
+	if len(alternatives) == 0 {
+		return ""
+	}
+	return "(?i)(?:" + strings.Join(alternatives, "|") + ")"
+}
+
+var (
+	// sensitiveName matches a whole field name; nil redacts nothing
+	sensitiveName *regexp.Regexp
+	// sensitiveText finds "name": value and name=value pairs in free text,
+	// such as driver errors quoting a duplicate key
+	sensitiveText *regexp.Regexp
+)
+
+// compileRedaction builds the matchers from config.LogRedactFields
+func compileRedaction() {
+	sensitiveName, sensitiveText = nil, nil
+	pattern := redactPatterns(config.LogRedactFields)
+	if pattern == "" {
+		return
+	}
+	sensitiveName = regexp.MustCompile("^" + pattern + "$")
+	sensitiveText = regexp.MustCompile(`(["']?\b` + pattern + `["']?\s*[:=]\s*)("(?:[^"\\]|\\.)*"|'[^']*'|[^\s,;}\]]+)`)
+}
+
+// isSensitive reports whether a field's value must not be logged
+func isSensitive(name string) bool {
+	return sensitiveName != nil && sensitiveName.MatchString(name)
+}
+
+// redactValue returns a copy of a decoded JSON value with sensitive fields
+// masked at any depth
+func redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		masked := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if isSensitive(key) {
+				masked[key] = redactedValue
+			} else {
+				masked[key] = redactValue(value)
+			}
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, value := range v {
+			masked[i] = redactValue(value)
+		}
+		return masked
+	}
+	return v
+}
+
+// redactText masks sensitive name/value pairs in a message
+func redactText(s string) string {
+	if sensitiveText == nil {
+		return s
+	}
+	return sensitiveText.ReplaceAllString(s, "${1}"+redactedValue)
+}
+
+// redactBody renders a body for the log. JSON is masked field by field;
+// anything else, truncated JSON included, is logged by size only since its
+// fields cannot be told apart.
+func redactBody(body []byte, truncated bool) string {
+	if len(body) == 0 {
+		return "-"
+	}
+	var value interface{}
+	if truncated || json.Unmarshal(body, &value) != nil {
+		if truncated {
+			return fmt.Sprintf("[%d+ bytes]", len(body))
+		}
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	masked, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	return string(masked)
+}
+
+// redactingWriter masks sensitive pairs in everything written to the log
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, redactText(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// teeResponse passes a response through while keeping its status and the
+// first logBodyMax bytes
+type teeResponse struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (t *teeResponse) WriteHeader(status int) {
+	t.status = status
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeResponse) Write(p []byte) (int, error) {
+	if room := logBodyMax - t.body.Len(); room < len(p) {
+		t.body.Write(p[:room])
+		t.truncated = true
+	} else {
+		t.body.Write(p)
+	}
+	return t.ResponseWriter.Write(p)
+}
+
+// Body logging middleware - with LOG_BODIES on, logs each request and
+// response body with sensitive fields masked. WebSocket upgrades are not
+// logged.
+func bodyLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !config.LogBodies || websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Keep a prefix for the log and hand the handler the whole body,
+		// so its own size limits still apply
+		var request []byte
+		requestTruncated := false
+		if r.Body != nil {
+			read, _ := io.ReadAll(io.LimitReader(r.Body, logBodyMax+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(read), r.Body), r.Body}
+			request, requestTruncated = read, len(read) > logBodyMax
+			if requestTruncated {
+				request = read[:logBodyMax]
+			}
+		}
+
+		tee := &teeResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(tee, r)
+
+		log.Printf("request_id=%s %s %s status=%d request=%s response=%s",
+			requestID(r), r.Method, r.URL.Path, tee.status,
+			redactBody(request, requestTruncated), redactBody(tee.body.Bytes(), tee.truncated))
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withRedaction sets LOG_REDACT_FIELDS for the rest of the test
+func withRedaction(t *testing.T, names ...string) {
+	saved := config.LogRedactFields
+	t.Cleanup(func() {
+		config.LogRedactFields = saved
+		compileRedaction()
+	})
+	config.LogRedactFields = names
+	compileRedaction()
+}
+
+func TestIsSensitive(t *testing.T) {
+	withRedaction(t, "password", "api_key", "*token*")
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"password", true},
+		{"Password", true},
+		{"api_key", true},
+		{"token", true},
+		{"access_token", true},
+		{"TokenExpiry", true},
+		{"password_hint", false},
+		{"email", false},
+	}
+	for _, tt := range tests {
+		if got := isSensitive(tt.name); got != tt.want {
+			t.Errorf("isSensitive(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactText(t *testing.T) {
+	withRedaction(t, "password", "ssn")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`dup key: { ssn: "123-45-6789" }`, `dup key: { ssn: ` + redactedValue + ` }`},
+		{`{"password":"hunter2","name":"a"}`, `{"password":` + redactedValue + `,"name":"a"}`},
+		{`password=hunter2 user=a`, `password=` + redactedValue + ` user=a`},
+		{`no secrets here`, `no secrets here`},
+	}
+	for _, tt := range tests {
+		if got := redactText(tt.in); got != tt.want {
+			t.Errorf("redactText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBodyLogMiddlewareRedacts(t *testing.T) {
+	withRedaction(t, "password", "api_key", "ssn")
+	saved := config.LogBodies
+	t.Cleanup(func() { config.LogBodies = saved })
+	config.LogBodies = true
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var received string
+	handler := bodyLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		sendJSON(w, http.StatusCreated, APIResponse{Success: true, Data: map[string]interface{}{"api_key": "key-123", "email": "a@b.c"}})
+	}))
+
+	body := `{"email":"a@b.c","password":"hunter2","data":{"people":[{"ssn":"123-45-6789","name":"Ann"}]}}`
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(body)))
+
+	if received != body {
+		t.Errorf("handler read %q, want the whole body", received)
+	}
+	if !strings.Contains(w.Body.String(), "key-123") {
+		t.Errorf("response to the client was masked: %s", w.Body)
+	}
+	out := logged.String()
+	for _, secret := range []string{"hunter2", "123-45-6789", "key-123"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log contains %q: %s", secret, out)
+		}
+	}
+	for _, kept := range []string{`"password":"[REDACTED]"`, `"ssn":"[REDACTED]"`, `"api_key":"[REDACTED]"`, `"name":"Ann"`, "status=201"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("log is missing %s: %s", kept, out)
+		}
+	}
+}
+
+func TestSendJSONRedactsErrors(t *testing.T) {
+	withRedaction(t, "email")
+	w := httptest.NewRecorder()
+	sendJSON(w, http.StatusConflict, APIResponse{Error: `E11000 duplicate key error dup key: { email: "a@b.c" }`})
+	if strings.Contains(w.Body.String(), "a@b.c") {
+		t.Errorf("error message was not masked: %s", w.Body)
+	}
+}
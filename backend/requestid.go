@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Incoming IDs are echoed in headers, so only plain tokens are accepted
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// traceparent is "version-traceid-parentid-flags"; the trace ID is reused
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// remoteAddrIn reports whether the client address is covered by a list of
+// IPs or CIDR ranges
+func remoteAddrIn(remoteAddr string, sources []string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, source := range sources {
+		if _, network, err := net.ParseCIDR(source); err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(source); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// incomingRequestID returns the caller's X-Request-ID, or the trace ID of a
+// W3C traceparent header, if either is well formed
+func incomingRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); requestIDPattern.MatchString(id) {
+		return id
+	}
+	if match := traceparentPattern.FindStringSubmatch(strings.TrimSpace(r.Header.Get("traceparent"))); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// Request ID middleware - reuses the incoming X-Request-ID or traceparent
+// trace ID from trusted sources and mints a new one otherwise. The effective
+// ID is echoed in X-Request-ID and stored in the context.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := ""
+		if remoteAddrIn(r.RemoteAddr, config.RequestIDTrusted) {
+			id = incomingRequestID(r)
+		}
+		if id == "" {
+			id = uuid.New().String()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), "request_id", id))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestID returns the ID assigned to the request
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value("request_id").(string)
+	return id
+}
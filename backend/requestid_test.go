@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestRemoteAddrIn(t *testing.T) {
+	sources := []string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3:5000", true},
+		{"192.0.2.7:443", true},
+		{"192.0.2.8:443", false},
+		{"[2001:db8::1]:80", true},
+		{"203.0.113.1", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if got := remoteAddrIn(tt.addr, sources); got != tt.want {
+			t.Errorf("remoteAddrIn(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	saved := config.RequestIDTrusted
+	t.Cleanup(func() { config.RequestIDTrusted = saved })
+	config.RequestIDTrusted = []string{"10.0.0.0/8"}
+
+	const trace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		requestID   string
+		traceparent string
+		want        string // "" expects a newly minted ID
+	}{
+		{"trusted X-Request-ID kept", "10.0.0.1:1234", "req-123", "", "req-123"},
+		{"untrusted X-Request-ID replaced", "203.0.113.1:1234", "req-123", "", ""},
+		{"malformed X-Request-ID replaced", "10.0.0.1:1234", "bad id\r\n", "", ""},
+		{"trusted traceparent", "10.0.0.1:1234", "", "00-" + trace + "-00f067aa0ba902b7-01", trace},
+		{"X-Request-ID preferred to traceparent", "10.0.0.1:1234", "req-123", "00-" + trace + "-00f067aa0ba902b7-01", "req-123"},
+		{"malformed traceparent", "10.0.0.1:1234", "", "00-" + trace + "-short-01", ""},
+		{"untrusted traceparent", "203.0.113.1:1234", "", "00-" + trace + "-00f067aa0ba902b7-01", ""},
+		{"none sent", "10.0.0.1:1234", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.requestID != "" {
+				r.Header.Set("X-Request-ID", tt.requestID)
+			}
+			if tt.traceparent != "" {
+				r.Header.Set("traceparent", tt.traceparent)
+			}
+
+			var seen string
+			w := httptest.NewRecorder()
+			requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r)
+			})).ServeHTTP(w, r)
+
+			header := w.Header().Get("X-Request-ID")
+			if header != seen {
+				t.Errorf("response header %q, context %q; want the same ID", header, seen)
+			}
+			if tt.want != "" {
+				if seen != tt.want {
+					t.Errorf("request ID = %q, want %q", seen, tt.want)
+				}
+				return
+			}
+			if _, err := uuid.Parse(seen); err != nil {
+				t.Errorf("request ID = %q, want a minted UUID", seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sendPasswordReset emails token, as a link to the RESET_URL page when one
+// is set
+func sendPasswordReset(email, token string) {
+	body := "Use this token within " + config.ResetTTL.String() + " to choose a new password:\n\n" + token + "\n"
+	if config.ResetURL != "" {
+		body = "Reset your password by opening this link within " + config.ResetTTL.String() + ":\n\n" +
+			config.ResetURL + "?token=" + url.QueryEscape(token) + "\n"
+	}
+	body += "\nIf you did not ask for a password reset, ignore this message; your password is unchanged.\n"
+	if err := mailer.Send(email, "Reset your password", body); err != nil {
+		log.Printf("failed to send password reset email to %s: %v", email, err)
+	}
+}
+
+// Forgot password handler - stores a reset token valid for PASSWORD_RESET_TTL
+// and emails it. The reply is the same whether or not the email is
+// registered, and the email is sent in the background so the response time
+// doesn't tell either.
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	body, ok := readBody(w, r, config.MaxAuthBytes)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if input.Email == "" {
+		sendValidationError(w, []FieldError{{Field: "email", Message: "is required"}})
+		return
+	}
+
+	token, hash, err := newEmailToken()
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create reset token"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	// A new request replaces any earlier token
+	email := strings.ToLower(input.Email)
+	result, err := usersCollection.UpdateOne(c,
+		bson.M{"email": email},
+		bson.M{"$set": bson.M{"reset_token": hash, "reset_expires": time.Now().UTC().Add(config.ResetTTL)}},
+	)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create reset token"})
+		return
+	}
+	if result.MatchedCount > 0 {
+		go sendPasswordReset(email, token)
+	}
+
+	sendJSON(w, http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "If the account exists, password reset instructions were sent",
+	})
+}
+
+// Reset password handler - sets a new password for the account a valid,
+// unexpired reset token was sent for. The token can only be used once.
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	body, ok := readBody(w, r, config.MaxAuthBytes)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	var fieldErrors []FieldError
+	if input.Token == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "token", Message: "is required"})
+	}
+	if input.NewPassword == "" {
+		fieldErrors = append(fieldErrors, FieldError{Field: "new_password", Message: "is required"})
+	} else if len(input.NewPassword) < 6 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "new_password", Message: "must be at least 6 characters"})
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to reset password"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	ok, err = resetPassword(c, input.Token, string(hashedPassword))
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to reset password"})
+		return
+	}
+	if !ok {
+		sendJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid or expired reset token",
+			Code:    "invalid_reset_token",
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Password reset"})
+}
+
+// resetPassword stores a new password hash if token is a live reset token,
+// clearing the token, and reports whether it was
+func resetPassword(c context.Context, token, passwordHash string) (bool, error) {
+	result, err := usersCollection.UpdateOne(c,
+		bson.M{"reset_token": hashEmailToken(token), "reset_expires": bson.M{"$gt": time.Now().UTC()}},
+		bson.M{
+			"$set":   bson.M{"password": passwordHash},
+			"$unset": bson.M{"reset_token": "", "reset_expires": ""},
+		},
+	)
+	dbBreaker.Record(err)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// channelMailer hands sent messages to the test, since reset emails are
+// sent in the background
+type channelMailer chan string
+
+func (m channelMailer) Send(to, subject, body string) error {
+	m <- body
+	return nil
+}
+
+func TestResetPasswordValidation(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/auth/reset-password", strings.NewReader(`{"token":"t","new_password":"short"}`))
+	if w := serve(resetPasswordHandler, r); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	setupTestDB(t)
+	saved := mailer
+	t.Cleanup(func() { mailer = saved })
+	sent := make(channelMailer, 1)
+	mailer = sent
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old-secret"), bcrypt.MinCost)
+	usersCollection.InsertOne(context.Background(), User{ID: "u1", Email: "user@example.com", Password: string(hash), APIKey: "k1"})
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		return serve(forgotPasswordHandler, httptest.NewRequest(http.MethodPost, "/auth/forgot-password", strings.NewReader(`{"email":"`+email+`"}`)))
+	}
+	known, unknown := forgot("User@example.com"), forgot("nobody@example.com")
+	if known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Errorf("responses differ: %d %s vs %d %s", known.Code, known.Body, unknown.Code, unknown.Body)
+	}
+
+	var body string
+	select {
+	case body = <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email sent")
+	}
+	token := regexp.MustCompile(`[0-9a-f]{64}`).FindString(body)
+	if token == "" {
+		t.Fatalf("no token in %q", body)
+	}
+
+	reset := func(token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/auth/reset-password", strings.NewReader(`{"token":"`+token+`","new_password":"new-secret"}`))
+		return serve(resetPasswordHandler, r).Code
+	}
+	if code := reset(token); code != http.StatusOK {
+		t.Fatalf("reset: status %d, want %d", code, http.StatusOK)
+	}
+	login := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(`{"email":"user@example.com","password":"new-secret"}`))
+	if w := serve(loginHandler, login); w.Code != http.StatusOK {
+		t.Errorf("login with new password: status %d: %s", w.Code, w.Body)
+	}
+	if code := reset(token); code != http.StatusBadRequest {
+		t.Errorf("reused token: status %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// An expired token is refused
+	forgot("user@example.com")
+	token = regexp.MustCompile(`[0-9a-f]{64}`).FindString(<-sent)
+	usersCollection.UpdateOne(context.Background(), bson.M{"_id": "u1"}, bson.M{"$set": bson.M{"reset_expires": time.Now().Add(-time.Minute)}})
+	if code := reset(token); code != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
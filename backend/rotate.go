@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// liveAPIKey matches the account whose current key is apiKey, or whose
+// previous key is and is still within its grace period
+func liveAPIKey(apiKey string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"api_key": apiKey},
+		bson.M{"previous_api_key": apiKey, "previous_api_key_expires": bson.M{"$gt": time.Now().UTC()}},
+	}}
+}
+
+// usedPreviousKey reports whether the request authenticated with an account's
+// previous key during its grace period
+func usedPreviousKey(r *http.Request) bool {
+	previous, _ := r.Context().Value("previous_key").(bool)
+	return previous
+}
+
+// isAccountRoute reports whether pattern manages the account itself rather
+// than its documents: the profile, which shows the current key, the keys,
+// and the bulk purge
+func isAccountRoute(pattern string) bool {
+	return pattern == "/api/me" || strings.HasPrefix(pattern, "/api/me/") ||
+		pattern == "/api/keys" || strings.HasPrefix(pattern, "/api/keys/")
+}
+
+// Previous key middleware - a rotated-out key keeps working for documents
+// during the grace period, but can't read the new key or rotate again, so a
+// leaked key can't take the account over
+func previousKeyMiddleware(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if usedPreviousKey(r) && isAccountRoute(pattern) {
+			sendJSON(w, http.StatusForbidden, APIResponse{
+				Success: false,
+				Error:   "A rotated-out key can't manage the account; use the current key",
+				Code:    "previous_key",
+			})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Rotate the caller's account key - the new key is returned and the old one
+// keeps working for API_KEY_GRACE so clients can switch over. Rotating again
+// within the grace period ends it for the key before.
+func rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	if userID == "global" {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "The global key is set by API_KEY"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var user User
+	err := usersCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "User not found"})
+		return
+	}
+
+	newKey := generateAPIKey()
+	expires := time.Now().UTC().Add(config.APIKeyGrace)
+	set := bson.M{"api_key": newKey}
+	update := bson.M{"$set": set}
+	if config.APIKeyGrace > 0 {
+		set["previous_api_key"] = user.APIKey
+		set["previous_api_key_expires"] = expires
+	} else {
+		update["$unset"] = bson.M{"previous_api_key": "", "previous_api_key_expires": ""}
+	}
+
+	// Matching the old key keeps two concurrent rotations from both winning
+	result, err := usersCollection.UpdateOne(c, bson.M{"_id": userID, "api_key": user.APIKey}, update)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to rotate key"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "The key was rotated concurrently; try again"})
+		return
+	}
+
+	data := map[string]interface{}{"api_key": newKey}
+	if config.APIKeyGrace > 0 {
+		data["previous_api_key_expires_at"] = expires
+	}
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "API key rotated", Data: data})
+}
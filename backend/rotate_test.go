@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// authStatus runs a request with apiKey through authMiddleware
+func authStatus(apiKey string) int {
+	r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+	r.Header.Set("X-API-Key", apiKey)
+	return serve(authMiddleware(func(w http.ResponseWriter, r *http.Request) {}), r).Code
+}
+
+func TestRotateAPIKeyGlobal(t *testing.T) {
+	r := asUser(httptest.NewRequest(http.MethodPost, "/api/keys/rotate", nil), "global")
+	if w := serve(rotateAPIKey, r); w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestRotateAPIKeyOverlap(t *testing.T) {
+	setupTestDB(t)
+	saved := config.APIKeyGrace
+	t.Cleanup(func() { config.APIKeyGrace = saved })
+	config.APIKeyGrace = time.Hour
+	c := context.Background()
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", APIKey: "old-key"})
+
+	w := serve(rotateAPIKey, asUser(httptest.NewRequest(http.MethodPost, "/api/keys/rotate", nil), "u1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("rotate: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Data struct {
+			APIKey string `json:"api_key"`
+		} `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	newKey := resp.Data.APIKey
+	if newKey == "" || newKey == "old-key" {
+		t.Fatalf("new key = %q", newKey)
+	}
+
+	// Both keys work during the grace period
+	if code := authStatus(newKey); code != http.StatusOK {
+		t.Errorf("new key: status %d, want %d", code, http.StatusOK)
+	}
+	if code := authStatus("old-key"); code != http.StatusOK {
+		t.Errorf("old key within grace: status %d, want %d", code, http.StatusOK)
+	}
+
+	// After it the old key is rejected
+	usersCollection.UpdateOne(c, bson.M{"_id": "u1"}, bson.M{"$set": bson.M{"previous_api_key_expires": time.Now().Add(-time.Second)}})
+	if code := authStatus("old-key"); code != http.StatusUnauthorized {
+		t.Errorf("old key after grace: status %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := authStatus(newKey); code != http.StatusOK {
+		t.Errorf("new key after grace: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestPreviousKeyMiddleware(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	tests := []struct {
+		pattern  string
+		previous bool
+		want     int
+	}{
+		{"/api/documents", true, http.StatusOK},
+		{"/api/documents/{id}", true, http.StatusOK},
+		{"/api/me", true, http.StatusForbidden},
+		{"/api/me/usage", true, http.StatusForbidden},
+		{"/api/me/purge-documents", true, http.StatusForbidden},
+		{"/api/keys", true, http.StatusForbidden},
+		{"/api/keys/rotate", true, http.StatusForbidden},
+		{"/api/keys/{id}", true, http.StatusForbidden},
+		{"/api/me", false, http.StatusOK},
+		{"/api/keys/rotate", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.previous {
+			r = r.WithContext(context.WithValue(r.Context(), "previous_key", true))
+		}
+		if w := serve(previousKeyMiddleware(tt.pattern, ok), r); w.Code != tt.want {
+			t.Errorf("%s previous=%v: status %d, want %d", tt.pattern, tt.previous, w.Code, tt.want)
+		}
+	}
+}
+
+func TestPreviousKeyCannotManageAccount(t *testing.T) {
+	setupTestDB(t)
+	saved, savedRoutes := config.APIKeyGrace, routes
+	t.Cleanup(func() { config.APIKeyGrace, routes = saved, savedRoutes })
+	config.APIKeyGrace = time.Hour
+	c := context.Background()
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", APIKey: "old-key"})
+
+	routes = newRouter()
+	routes.handle("/api/documents", accessUser, methods{http.MethodGet: listDocuments})
+	routes.handle("/api/keys/rotate", accessUser, methods{http.MethodPost: rotateAPIKey})
+	routes.handle("/api/me", accessUser, methods{http.MethodGet: meHandler})
+	call := func(method, path, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := call(http.MethodPost, "/api/keys/rotate", "old-key"); w.Code != http.StatusOK {
+		t.Fatalf("rotate: status %d: %s", w.Code, w.Body)
+	}
+
+	// The rotated-out key still reads documents but can't see the new key
+	// or rotate the owner out
+	if w := call(http.MethodGet, "/api/documents", "old-key"); w.Code != http.StatusOK {
+		t.Errorf("old key listing documents: status %d, want 200", w.Code)
+	}
+	if w := call(http.MethodGet, "/api/me", "old-key"); w.Code != http.StatusForbidden {
+		t.Errorf("old key reading /api/me: status %d, want 403", w.Code)
+	}
+	if w := call(http.MethodPost, "/api/keys/rotate", "old-key"); w.Code != http.StatusForbidden {
+		t.Errorf("old key rotating: status %d, want 403", w.Code)
+	}
+
+	var user User
+	usersCollection.FindOne(c, bson.M{"_id": "u1"}).Decode(&user)
+	if w := call(http.MethodGet, "/api/me", user.APIKey); w.Code != http.StatusOK {
+		t.Errorf("current key reading /api/me: status %d, want 200", w.Code)
+	}
+}
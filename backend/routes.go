@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Route access levels
+const (
+	accessPublic = iota
+	accessUser
+	accessAdmin
+)
+
+// methods maps HTTP methods to the handler serving them
+type methods map[string]http.HandlerFunc
+
+// route maps a path pattern to its allowed methods. Patterns are matched
+// segment by segment and {name} segments capture path parameters.
+type route struct {
+	pattern  string
+	segments []string
+	access   int
+	handlers methods
+}
+
+// router dispatches requests using a table of routes. The same table is
+// used to answer 405s with an Allow header and to build CORS preflights,
+// so the method lists cannot drift apart.
+type router struct {
+	routes []*route
+}
+
+func newRouter() *router {
+	return &router{}
+}
+
+// handle registers a route. Routes are matched in registration order, so
+// static paths must be registered before parameterized ones they overlap.
+func (rt *router) handle(pattern string, access int, handlers methods) {
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		access:   access,
+		handlers: handlers,
+	})
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// match finds the route for a path and returns its captured parameters
+func (rt *router) match(path string) (*route, map[string]string) {
+	segments := splitPath(path)
+
+	for _, rte := range rt.routes {
+		if len(rte.segments) != len(segments) {
+			continue
+		}
+
+		params := map[string]string{}
+		matched := true
+		for i, seg := range rte.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				params[seg[1:len(seg)-1]] = segments[i]
+				continue
+			}
+			if seg != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rte, params
+		}
+	}
+	return nil, nil
+}
+
+// allowedMethods lists the methods a route accepts, including OPTIONS
+func (rte *route) allowedMethods() string {
+	list := make([]string, 0, len(rte.handlers)+1)
+	for method := range rte.handlers {
+		list = append(list, method)
+	}
+	sort.Strings(list)
+	return strings.Join(append(list, http.MethodOptions), ", ")
+}
+
+// allowedMethods returns the Allow value for a path, or "" if no route matches
+func (rt *router) allowedMethods(path string) string {
+	if rte, _ := rt.match(path); rte != nil {
+		return rte.allowedMethods()
+	}
+	return ""
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rte, params := rt.match(r.URL.Path)
+	if rte == nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Not found"})
+		return
+	}
+
+	handler, ok := rte.handlers[r.Method]
+	if !ok {
+		w.Header().Set("Allow", rte.allowedMethods())
+		sendJSON(w, http.StatusMethodNotAllowed, APIResponse{Success: false, Error: "Method not allowed"})
+		return
+	}
+
+	switch rte.access {
+	case accessUser:
+		handler = authMiddleware(rateLimitMiddleware(previousKeyMiddleware(rte.pattern, scopedKeyMiddleware(rte.pattern, handler))))
+	case accessAdmin:
+		handler = authMiddleware(rateLimitMiddleware(scopedKeyMiddleware(rte.pattern, adminMiddleware(handler))))
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), "path_params", params))
+	handler(w, r)
+}
+
+// pathParam returns a parameter captured from the route pattern
+func pathParam(r *http.Request, name string) string {
+	if params, ok := r.Context().Value("path_params").(map[string]string); ok {
+		return params[name]
+	}
+	return ""
+}
+
+// withID adapts handlers that take the {id} path parameter as an argument
+func withID(h func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, pathParam(r, "id"))
+	}
+}
+
+// Supported API versions. Unversioned paths are aliases for the latest.
+var (
+	apiVersions   = []string{"v1"}
+	latestVersion = "v1"
+)
+
+func supportedVersion(version string) bool {
+	for _, v := range apiVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// splitVersion separates a /vN prefix from a request path
+func splitVersion(path string) (version, rest string) {
+	segment, remainder, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if len(segment) < 2 || segment[0] != 'v' || strings.Trim(segment[1:], "0123456789") != "" {
+		return "", path
+	}
+	return segment, "/" + remainder
+}
+
+// acceptVersion reads a version from Accept: application/vnd.jsonapi.vN+json
+func acceptVersion(accept string) string {
+	for _, part := range strings.Split(accept, ",") {
+		media, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.HasPrefix(media, "application/vnd.jsonapi.") && strings.HasSuffix(media, "+json") {
+			return strings.TrimSuffix(strings.TrimPrefix(media, "application/vnd.jsonapi."), "+json")
+		}
+	}
+	return ""
+}
+
+// Version middleware - resolves the API version from a /vN path prefix or
+// the Accept header, strips the prefix and records the version in the context
+func versionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, rest := splitVersion(r.URL.Path)
+		if version != "" {
+			if !supportedVersion(version) {
+				sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Unsupported API version " + version})
+				return
+			}
+			u := *r.URL
+			u.Path = rest
+			r = r.WithContext(r.Context())
+			r.URL = &u
+		} else if version = acceptVersion(r.Header.Get("Accept")); version != "" {
+			if !supportedVersion(version) {
+				sendJSON(w, http.StatusNotAcceptable, APIResponse{Success: false, Error: "Unsupported API version " + version})
+				return
+			}
+		} else {
+			version = latestVersion
+		}
+
+		w.Header().Set("API-Version", version)
+		r = r.WithContext(context.WithValue(r.Context(), "api_version", version))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiVersion returns the API version selected for the request
+func apiVersion(r *http.Request) string {
+	if version, ok := r.Context().Value("api_version").(string); ok {
+		return version
+	}
+	return latestVersion
+}
+
+// documentLocation is the URL of document id under the API version of the
+// response, which versionMiddleware records in its API-Version header, so
+// the Location of a created document keeps working once a later version
+// becomes the default
+func documentLocation(w http.ResponseWriter, id string) string {
+	version := w.Header().Get("API-Version")
+	if version == "" {
+		version = latestVersion
+	}
+	return "/" + version + "/api/documents/" + id
+}
+
+// canonicalPath cleans a request path: duplicate slashes and dot segments
+// are collapsed and trailing slashes dropped
+func canonicalPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+// Path middleware - normalizes request paths according to PATH_MODE:
+// "clean" serves the canonical path, "redirect" sends a 308 to it and
+// "strict" answers non-canonical paths with 404
+func pathMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonical := canonicalPath(r.URL.Path)
+		if canonical == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch config.PathMode {
+		case "strict":
+			sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Not found"})
+			return
+		case "redirect":
+			// Preflights can't follow redirects, so they are cleaned instead
+			if r.Method != http.MethodOptions {
+				u := *r.URL
+				u.Path = canonical
+				u.RawPath = ""
+				http.Redirect(w, r, u.RequestURI(), http.StatusPermanentRedirect)
+				return
+			}
+		}
+
+		u := *r.URL
+		u.Path = canonical
+		u.RawPath = ""
+		r = r.WithContext(r.Context())
+		r.URL = &u
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	handler := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + pathParam(r, "id")))
+		}
+	}
+	rt := newRouter()
+	rt.handle("/items/search", accessPublic, methods{http.MethodGet: handler("search")})
+	rt.handle("/items/{id}", accessPublic, methods{http.MethodGet: handler("get"), http.MethodDelete: handler("delete")})
+	rt.handle("/items", accessPublic, methods{http.MethodGet: handler("list"), http.MethodPost: handler("create")})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+		allow  string
+	}{
+		{http.MethodGet, "/items", http.StatusOK, "list:", ""},
+		{http.MethodPost, "/items/", http.StatusOK, "create:", ""},
+		{http.MethodGet, "/items/abc", http.StatusOK, "get:abc", ""},
+		{http.MethodDelete, "/items/abc", http.StatusOK, "delete:abc", ""},
+		{http.MethodGet, "/items/search", http.StatusOK, "search:", ""},
+		{http.MethodPut, "/items/abc", http.StatusMethodNotAllowed, "", "DELETE, GET, OPTIONS"},
+		{http.MethodDelete, "/items", http.StatusMethodNotAllowed, "", "GET, POST, OPTIONS"},
+		{http.MethodGet, "/items/abc/more", http.StatusNotFound, "", ""},
+		{http.MethodGet, "/other", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body, tt.body)
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRouterAccess(t *testing.T) {
+	rt := newRouter()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	rt.handle("/open", accessPublic, methods{http.MethodGet: ok})
+	rt.handle("/private", accessUser, methods{http.MethodGet: ok})
+	rt.handle("/admin", accessAdmin, methods{http.MethodGet: ok})
+
+	for path, want := range map[string]int{"/open": http.StatusOK, "/private": http.StatusUnauthorized, "/admin": http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s without a key: status = %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestSplitVersion(t *testing.T) {
+	tests := []struct {
+		path    string
+		version string
+		rest    string
+	}{
+		{"/v1/api/documents", "v1", "/api/documents"},
+		{"/v12/api", "v12", "/api"},
+		{"/v1", "v1", "/"},
+		{"/api/documents", "", "/api/documents"},
+		{"/v/api", "", "/v/api"},
+		{"/version/api", "", "/version/api"},
+		{"/v1a/api", "", "/v1a/api"},
+		{"/", "", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			version, rest := splitVersion(tt.path)
+			if version != tt.version || rest != tt.rest {
+				t.Errorf("splitVersion(%q) = %q, %q, want %q, %q", tt.path, version, rest, tt.version, tt.rest)
+			}
+		})
+	}
+}
+
+func TestAcceptVersion(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"application/json", ""},
+		{"application/vnd.jsonapi.v1+json", "v1"},
+		{"application/vnd.jsonapi.v2+json; q=0.9", "v2"},
+		{"text/html, application/vnd.jsonapi.v1+json", "v1"},
+		{"application/vnd.jsonapi.v1", ""},
+		{"application/vnd.other.v1+json", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			if got := acceptVersion(tt.accept); got != tt.want {
+				t.Errorf("acceptVersion(%q) = %q, want %q", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		accept  string
+		status  int
+		version string
+		seen    string
+	}{
+		{"unversioned", "/api/documents", "", http.StatusOK, "v1", "/api/documents"},
+		{"path prefix", "/v1/api/documents", "", http.StatusOK, "v1", "/api/documents"},
+		{"unsupported path prefix", "/v9/api/documents", "", http.StatusNotFound, "", ""},
+		{"accept header", "/api/documents", "application/vnd.jsonapi.v1+json", http.StatusOK, "v1", "/api/documents"},
+		{"unsupported accept header", "/api/documents", "application/vnd.jsonapi.v9+json", http.StatusNotAcceptable, "", ""},
+		{"path prefix wins", "/v1/api", "application/vnd.jsonapi.v9+json", http.StatusOK, "v1", "/api"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen, version string
+			h := versionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen, version = r.URL.Path, apiVersion(r)
+			}))
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if seen != tt.seen || version != tt.version {
+				t.Errorf("handler saw %q at %q, want %q at %q", seen, version, tt.seen, tt.version)
+			}
+			if tt.version != "" && w.Header().Get("API-Version") != tt.version {
+				t.Errorf("API-Version = %q, want %q", w.Header().Get("API-Version"), tt.version)
+			}
+		})
+	}
+}
+
+func TestDocumentLocation(t *testing.T) {
+	for _, path := range []string{"/api/documents", "/v1/api/documents"} {
+		var location string
+		h := versionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			location = documentLocation(w, "doc-1")
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+		if location != "/v1/api/documents/doc-1" {
+			t.Errorf("%s: Location = %q, want /v1/api/documents/doc-1", path, location)
+		}
+	}
+
+	// Handlers called outside the middleware, as by tests, get the latest
+	if got := documentLocation(httptest.NewRecorder(), "doc-1"); got != "/"+latestVersion+"/api/documents/doc-1" {
+		t.Errorf("without a version: Location = %q", got)
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("API-Version", "v2")
+	if got := documentLocation(w, "doc-1"); got != "/v2/api/documents/doc-1" {
+		t.Errorf("v2: Location = %q, want /v2/api/documents/doc-1", got)
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/documents", "/api/documents"},
+		{"/api//documents", "/api/documents"},
+		{"/api/documents/", "/api/documents"},
+		{"/api/./documents", "/api/documents"},
+		{"/api/x/../documents", "/api/documents"},
+		{"/../api", "/api"},
+		{"api", "/api"},
+		{"", "/"},
+		{"/", "/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := canonicalPath(tt.path); got != tt.want {
+				t.Errorf("canonicalPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathMiddleware(t *testing.T) {
+	saved := config.PathMode
+	t.Cleanup(func() { config.PathMode = saved })
+
+	tests := []struct {
+		mode     string
+		method   string
+		target   string
+		status   int
+		seen     string
+		location string
+	}{
+		{"clean", http.MethodGet, "/api//documents?limit=1", http.StatusOK, "/api/documents", ""},
+		{"clean", http.MethodGet, "/api/documents", http.StatusOK, "/api/documents", ""},
+		{"redirect", http.MethodGet, "/api//documents?limit=1", http.StatusPermanentRedirect, "", "/api/documents?limit=1"},
+		{"redirect", http.MethodOptions, "/api//documents", http.StatusOK, "/api/documents", ""},
+		{"redirect", http.MethodGet, "/api/documents", http.StatusOK, "/api/documents", ""},
+		{"strict", http.MethodGet, "/api/documents/", http.StatusNotFound, "", ""},
+		{"strict", http.MethodGet, "/api/documents", http.StatusOK, "/api/documents", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.method+" "+tt.target, func(t *testing.T) {
+			config.PathMode = tt.mode
+			seen := ""
+			h := pathMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = r.URL.Path
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if seen != tt.seen {
+				t.Errorf("handler saw %q, want %q", seen, tt.seen)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SharedSchema is one immutable version of a named JSON Schema. Publishing
+// under an existing name creates the next version, so documents pinned to
+// an older version keep validating against the schema they were written with.
+type SharedSchema struct {
+	ID        string          `json:"id" bson:"_id"`
+	UserID    string          `json:"user_id" bson:"user_id"`
+	Name      string          `json:"name" bson:"name"`
+	Version   int             `json:"version" bson:"version"`
+	Schema    json.RawMessage `json:"schema" bson:"schema"`
+	CreatedAt time.Time       `json:"created_at" bson:"created_at"`
+}
+
+// SchemaRef points a document at a shared schema. A zero Version on write
+// resolves to the latest version, which is then stored on the document.
+type SchemaRef struct {
+	Name    string `json:"name" bson:"name"`
+	Version int    `json:"version" bson:"version"`
+}
+
+var errSchemaNotFound = errors.New("schema not found")
+
+// compileSchema compiles a JSON Schema. External $refs are not resolved so
+// schemas can't make the server read files or fetch URLs.
+func compileSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external reference %s is not allowed", s)
+	}
+	if err := compiler.AddResource("mem://schema.json", bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("mem://schema.json")
+}
+
+// findSchema loads a schema version owned by the user; version 0 means latest
+func findSchema(c context.Context, userID, name string, version int) (*SharedSchema, error) {
+	filter := bson.M{"user_id": userID, "name": name}
+	opts := options.FindOne().SetSort(bson.M{"version": -1})
+	if version > 0 {
+		filter["version"] = version
+	}
+
+	var schema SharedSchema
+	err := schemasCollection.FindOne(c, filter, opts).Decode(&schema)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		return nil, errSchemaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+// schemaForName returns a reference to the latest schema registered under a
+// document's name, or nil when there is none. Documents without an explicit
+// schema_ref are validated against it and pinned to that version.
+func schemaForName(c context.Context, userID, name string) (*SchemaRef, error) {
+	schema, err := findSchema(c, userID, name, 0)
+	if err == errSchemaNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaRef{Name: schema.Name, Version: schema.Version}, nil
+}
+
+// validateSchemaRef validates data against the referenced schema and pins
+// the reference to the version used. Field errors describe invalid data or
+// an unknown schema; err is only set for database failures.
+func validateSchemaRef(c context.Context, userID string, ref *SchemaRef, data map[string]interface{}) ([]FieldError, error) {
+	schema, err := findSchema(c, userID, ref.Name, ref.Version)
+	if err == errSchemaNotFound {
+		return []FieldError{{Field: "schema_ref", Message: "refers to an unknown schema or version"}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ref.Version = schema.Version
+
+	compiled, err := compileSchema(schema.Schema)
+	if err != nil {
+		return []FieldError{{Field: "schema_ref", Message: "refers to a schema that does not compile"}}, nil
+	}
+
+	// Round-trip through JSON so BSON-decoded values validate like request bodies
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var instance interface{}
+	if err := json.Unmarshal(encoded, &instance); err != nil {
+		return nil, err
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if err := compiled.Validate(instance); errors.As(err, &validationErr) {
+		return schemaFieldErrors(validationErr), nil
+	} else if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// documentSchemaErrors validates data against the document's pinned schema,
+// or the latest one registered under name when none is pinned. A newly
+// pinned reference is stored on doc and added to the $set in set.
+func documentSchemaErrors(c context.Context, doc *JSONDocument, name string, data map[string]interface{}, set bson.M) ([]FieldError, error) {
+	if doc.SchemaRef == nil {
+		ref, err := schemaForName(c, doc.UserID, name)
+		if err != nil || ref == nil {
+			return nil, err
+		}
+		doc.SchemaRef = ref
+		set["schema_ref"] = ref
+	}
+	return validateSchemaRef(c, doc.UserID, doc.SchemaRef, data)
+}
+
+// schemaFieldErrors flattens a schema validation error into field errors on
+// data paths, keeping only the leaf causes
+func schemaFieldErrors(ve *jsonschema.ValidationError) []FieldError {
+	if len(ve.Causes) > 0 {
+		var fieldErrors []FieldError
+		for _, cause := range ve.Causes {
+			fieldErrors = append(fieldErrors, schemaFieldErrors(cause)...)
+		}
+		return fieldErrors
+	}
+	field := "data" + strings.ReplaceAll(ve.InstanceLocation, "/", ".")
+	return []FieldError{{Field: field, Message: ve.Message}}
+}
+
+// List the caller's schemas, every version, ordered by name and version
+func listSchemas(w http.ResponseWriter, r *http.Request) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "version", Value: 1}})
+	cursor, err := schemasCollection.Find(c, bson.M{"user_id": getUserID(r)}, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list schemas"})
+		return
+	}
+	defer cursor.Close(c)
+
+	schemas := []SharedSchema{}
+	if err := cursor.All(c, &schemas); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode schemas"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: schemas})
+}
+
+// Publish a schema under a name, creating its next version
+func createSchema(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	var input struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	// Names double as document names, so anything but '/' is allowed
+	var fieldErrors []FieldError
+	if input.Name == "" || len(input.Name) > 200 || strings.Contains(input.Name, "/") {
+		fieldErrors = append(fieldErrors, FieldError{Field: "name", Message: "must be 1-200 characters without '/'"})
+	}
+	if len(input.Schema) == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "schema", Message: "is required"})
+	} else if _, err := compileSchema(input.Schema); err != nil {
+		fieldErrors = append(fieldErrors, FieldError{Field: "schema", Message: "is not a valid JSON Schema: " + err.Error()})
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	version := 1
+	latest, err := findSchema(c, userID, input.Name, 0)
+	if err != nil && err != errSchemaNotFound {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to save schema"})
+		return
+	}
+	if latest != nil {
+		version = latest.Version + 1
+	}
+
+	schema := SharedSchema{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      input.Name,
+		Version:   version,
+		Schema:    input.Schema,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err = schemasCollection.InsertOne(c, schema)
+	dbBreaker.Record(err)
+	if mongo.IsDuplicateKeyError(err) {
+		sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "A new version was published concurrently; retry"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to save schema"})
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, APIResponse{Success: true, Message: fmt.Sprintf("Schema %s version %d created", schema.Name, schema.Version), Data: schema})
+}
+
+// schemaVersionParam parses the {version} path parameter; "latest" is 0
+func schemaVersionParam(r *http.Request) (int, bool) {
+	value := pathParam(r, "version")
+	if value == "latest" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(value)
+	return version, err == nil && version > 0
+}
+
+// Get one version of a schema
+func getSchema(w http.ResponseWriter, r *http.Request) {
+	version, ok := schemaVersionParam(r)
+	if !ok {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "version must be a positive integer or \"latest\""})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	schema, err := findSchema(c, getUserID(r), pathParam(r, "name"), version)
+	if err == errSchemaNotFound {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Schema not found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to load schema"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: schema})
+}
+
+// Delete a schema version that no document references
+func deleteSchema(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	name := pathParam(r, "name")
+	version, ok := schemaVersionParam(r)
+	if !ok || version == 0 {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "version must be a positive integer"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	inUse, err := docCollection.CountDocuments(c, bson.M{"user_id": userID, "schema_ref.name": name, "schema_ref.version": version}, options.Count().SetLimit(1))
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to delete schema"})
+		return
+	}
+	if inUse > 0 {
+		sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "Schema version is referenced by documents"})
+		return
+	}
+
+	result, err := schemasCollection.DeleteOne(c, bson.M{"user_id": userID, "name": name, "version": version})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to delete schema"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Schema not found"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Schema deleted"})
+}
+
+// Re-validate a stored document against its pinned schema version, or the
+// latest version with ?version=latest. Nothing is modified.
+func validateDocument(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&doc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if doc.SchemaRef == nil {
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{Success: false, Error: "Document does not reference a schema"})
+		return
+	}
+
+	ref := *doc.SchemaRef
+	if r.URL.Query().Get("version") == "latest" {
+		ref.Version = 0
+	}
+	fieldErrors, err := validateSchemaRef(c, doc.UserID, &ref, doc.Data)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate document"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{
+		Success: true,
+		Data:    map[string]interface{}{"valid": len(fieldErrors) == 0, "schema_ref": ref},
+		Errors:  fieldErrors,
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+const testSchema = `{"type":"object","required":["title"],"properties":{"title":{"type":"string"},"meta":{"type":"object","properties":{"n":{"type":"integer"}}}}}`
+
+func TestCompileSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr bool
+	}{
+		{"valid", testSchema, false},
+		{"empty schema", `{}`, false},
+		{"malformed JSON", `{"type":`, true},
+		{"invalid keyword value", `{"type":"text"}`, true},
+		{"external reference", `{"$ref":"https://example.com/schema.json"}`, true},
+		{"file reference", `{"$ref":"file:///etc/passwd"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := compileSchema(json.RawMessage(tt.schema)); (err != nil) != tt.wantErr {
+				t.Errorf("compileSchema error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchemaFieldErrors(t *testing.T) {
+	compiled, err := compileSchema(json.RawMessage(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		data   string
+		fields []string
+	}{
+		{"valid", `{"title":"a","meta":{"n":1}}`, nil},
+		{"missing required", `{}`, []string{"data"}},
+		{"wrong type", `{"title":1}`, []string{"data.title"}},
+		{"nested wrong type", `{"title":"a","meta":{"n":1.5}}`, []string{"data.meta.n"}},
+		{"several errors", `{"title":1,"meta":{"n":"x"}}`, []string{"data.meta.n", "data.title"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instance interface{}
+			json.Unmarshal([]byte(tt.data), &instance)
+			var fields []string
+			var ve *jsonschema.ValidationError
+			if err := compiled.Validate(instance); errors.As(err, &ve) {
+				for _, fe := range schemaFieldErrors(ve) {
+					fields = append(fields, fe.Field)
+				}
+			}
+			sort.Strings(fields)
+			if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestSchemaVersionParam(t *testing.T) {
+	tests := []struct {
+		value   string
+		version int
+		ok      bool
+	}{
+		{"latest", 0, true},
+		{"1", 1, true},
+		{"12", 12, true},
+		{"0", 0, false},
+		{"-1", -1, false},
+		{"v1", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			r := withPathParams(httptest.NewRequest(http.MethodGet, "/api/schemas/s/x", nil), map[string]string{"version": tt.value})
+			version, ok := schemaVersionParam(r)
+			if ok != tt.ok || ok && version != tt.version {
+				t.Errorf("schemaVersionParam(%q) = %d, %v, want %d, %v", tt.value, version, ok, tt.version, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCreateSchemaValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing name", `{"schema":{}}`},
+		{"slash in name", `{"name":"a/b","schema":{}}`},
+		{"long name", `{"name":"` + strings.Repeat("a", 201) + `","schema":{}}`},
+		{"missing schema", `{"name":"a"}`},
+		{"invalid schema", `{"name":"a","schema":{"type":"text"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/schemas", strings.NewReader(tt.body)), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			if w := serve(createSchema, r); w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("status = %d, want 422: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestSchemaVersions(t *testing.T) {
+	setupTestDB(t)
+	publish := func(schema string) int {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/schemas", strings.NewReader(`{"name":"post","schema":`+schema+`}`)), "u1")
+		r.Header.Set("Content-Type", "application/json")
+		return serve(createSchema, r).Code
+	}
+	if got := publish(testSchema); got != http.StatusCreated {
+		t.Fatalf("publish v1: status = %d", got)
+	}
+	if got := publish(`{"type":"object","required":["title","body"]}`); got != http.StatusCreated {
+		t.Fatalf("publish v2: status = %d", got)
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		data    map[string]interface{}
+		pinned  int
+		errors  int
+	}{
+		{"latest pins v2", 0, map[string]interface{}{"title": "a"}, 2, 1},
+		{"v1 stays valid", 1, map[string]interface{}{"title": "a"}, 1, 0},
+		{"v1 errors", 1, map[string]interface{}{"title": 1.0}, 1, 1},
+		{"unknown version", 9, map[string]interface{}{}, 9, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := &SchemaRef{Name: "post", Version: tt.version}
+			fieldErrors, err := validateSchemaRef(context.Background(), "u1", ref, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ref.Version != tt.pinned {
+				t.Errorf("pinned version = %d, want %d", ref.Version, tt.pinned)
+			}
+			if len(fieldErrors) != tt.errors {
+				t.Errorf("field errors = %v, want %d", fieldErrors, tt.errors)
+			}
+		})
+	}
+
+	ref, err := schemaForName(context.Background(), "u2", "post")
+	if err != nil || ref != nil {
+		t.Errorf("another user's schema: ref = %v, err = %v, want none", ref, err)
+	}
+}
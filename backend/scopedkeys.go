@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Scopes a key can be given
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// ScopedKey is an extra, named API key for a user, limited to documents and
+// schemas. Scopes say whether it may read, write or both. A key with data
+// keys may only write those top-level data keys, so services sharing a
+// document can't clobber keys another owns.
+type ScopedKey struct {
+	ID        string    `json:"id" bson:"_id"`
+	UserID    string    `json:"user_id" bson:"user_id"`
+	Key       string    `json:"key" bson:"key"`
+	Label     string    `json:"label,omitempty" bson:"label,omitempty"`
+	Scopes    []string  `json:"scopes" bson:"scopes,omitempty"`
+	DataKeys  []string  `json:"data_keys,omitempty" bson:"data_keys,omitempty"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// scopes returns the key's scopes. Keys created before scopes existed
+// could read and write their data keys.
+func (k ScopedKey) scopes() []string {
+	if k.Scopes == nil {
+		return []string{scopeRead, scopeWrite}
+	}
+	return k.Scopes
+}
+
+// maskKey hides all but the last four characters of a key, keeping any
+// API_KEY_PREFIX so keys can still be told apart
+func maskKey(key string) string {
+	rest := strings.TrimPrefix(key, config.APIKeyPrefix)
+	if len(rest) <= 4 {
+		return key[:len(key)-len(rest)] + "****"
+	}
+	return key[:len(key)-len(rest)] + "****" + rest[len(rest)-4:]
+}
+
+// withScopedKey authenticates a request made with a scoped key, or returns
+// false if apiKey isn't one
+func withScopedKey(r *http.Request, apiKey string) (*http.Request, bool) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var key ScopedKey
+	err := keysCollection.FindOne(c, bson.M{"key": apiKey}).Decode(&key)
+	dbBreaker.Record(err)
+	if err != nil {
+		return r, false
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), "user_id", key.UserID))
+	r = r.WithContext(context.WithValue(r.Context(), "key_scopes", key.scopes()))
+	if len(key.DataKeys) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), "data_keys", key.DataKeys))
+	}
+	return r, true
+}
+
+// keyScopes returns the scopes of the key a request was made with, and
+// whether it was made with a scoped key at all
+func keyScopes(r *http.Request) ([]string, bool) {
+	scopes, ok := r.Context().Value("key_scopes").([]string)
+	return scopes, ok
+}
+
+// scopedDataKeys returns the data keys a scoped key may write, and whether
+// the request was made with one
+func scopedDataKeys(r *http.Request) ([]string, bool) {
+	keys, ok := r.Context().Value("data_keys").([]string)
+	return keys, ok
+}
+
+// disallowedKeys lists the top-level keys of data outside the allowed set
+func disallowedKeys(data map[string]interface{}, allowed []string) []string {
+	var outside []string
+	for key := range data {
+		if !containsString(allowed, key) {
+			outside = append(outside, key)
+		}
+	}
+	sort.Strings(outside)
+	return outside
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Scoped key middleware - scoped keys may only use document and schema
+// routes: reading takes the read scope and anything else the write scope.
+// Keys with data keys write only through PUT and PATCH on a single
+// document. Everything else, including /api/me which would reveal the
+// account key, is refused.
+func scopedKeyMiddleware(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if scopes, scoped := keyScopes(r); scoped {
+			_, limited := scopedDataKeys(r)
+			if !scopedKeyAllows(pattern, r.Method, scopes, limited) {
+				sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Not permitted for a scoped key", Code: "scope_denied"})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func scopedKeyAllows(pattern, method string, scopes []string, limited bool) bool {
+	if !strings.HasPrefix(pattern, "/api/documents") && !strings.HasPrefix(pattern, "/api/schemas") {
+		return false
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return containsString(scopes, scopeRead)
+	case http.MethodPut, http.MethodPatch:
+		return containsString(scopes, scopeWrite) && (!limited || pattern == "/api/documents/{id}")
+	}
+	return containsString(scopes, scopeWrite) && !limited
+}
+
+// sendScopeDenied reports data keys a scoped key tried to write
+func sendScopeDenied(w http.ResponseWriter, keys []string) {
+	sendJSON(w, http.StatusForbidden, APIResponse{
+		Success: false,
+		Error:   "Key may not modify data keys: " + strings.Join(keys, ", "),
+		Code:    "scope_denied",
+	})
+}
+
+// Update with a scoped key - the top-level data keys sent replace the
+// stored ones and all other keys are left untouched
+func updateScopedData(w http.ResponseWriter, r *http.Request, id string, allowed []string) {
+	body, ok := readDocumentBody(w, r)
+	if !ok {
+		return
+	}
+
+	var input map[string]json.RawMessage
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	var data map[string]interface{}
+	if raw, ok := input["data"]; !ok || json.Unmarshal(raw, &data) != nil || data == nil {
+		sendValidationError(w, []FieldError{{Field: "data", Message: "must be an object"}})
+		return
+	}
+	for member := range input {
+		if member != "data" {
+			sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Scoped keys may only update data", Code: "scope_denied"})
+			return
+		}
+	}
+	if outside := disallowedKeys(data, allowed); len(outside) > 0 {
+		sendScopeDenied(w, outside)
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	filter := bson.M{"_id": id, "user_id": getUserID(r)}
+	var existingDoc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&existingDoc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if existingDoc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+	filter["frozen"] = bson.M{"$ne": true}
+	prior := existingDoc
+
+	// Timestamps are added after the scope check; they aren't the key's to write
+	now := time.Now().UTC()
+	stampData(data, existingDoc.Data, existingDoc.CreatedAt, now)
+
+	set := bson.M{"updated_at": now}
+	merged := make(map[string]interface{}, len(existingDoc.Data)+len(data))
+	for k, v := range existingDoc.Data {
+		merged[k] = v
+	}
+	for k, v := range data {
+		merged[k] = v
+		set["data."+k] = v
+	}
+	set["content_hash"] = contentHashValue(merged)
+
+	if fieldErrors := validateData(merged, existingDoc.Unique); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	fieldErrors, err := documentSchemaErrors(c, &existingDoc, existingDoc.Name, merged, set)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	growth := dataSize(merged) - dataSize(prior.Data)
+	if !chargeStorage(c, w, prior.UserID, growth) {
+		return
+	}
+	result, err := docCollection.UpdateOne(c, filter, bson.M{"$set": set})
+	dbBreaker.Record(err)
+	settleStorage(prior.UserID, growth, err == nil && result.MatchedCount > 0)
+	if isContentConflict(err) {
+		sendDuplicateContent(w, nil)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+
+	recordVersion(c, r, prior)
+
+	existingDoc.Data = merged
+	existingDoc.UpdatedAt = set["updated_at"].(time.Time)
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document updated", Data: existingDoc})
+}
+
+// List the caller's scoped keys
+func listScopedKeys(w http.ResponseWriter, r *http.Request) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"created_at": 1})
+	cursor, err := keysCollection.Find(c, bson.M{"user_id": getUserID(r)}, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list keys"})
+		return
+	}
+	defer cursor.Close(c)
+
+	keys := []ScopedKey{}
+	if err := cursor.All(c, &keys); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode keys"})
+		return
+	}
+	// Keys are only shown in full when created
+	for i := range keys {
+		keys[i].Key = maskKey(keys[i].Key)
+		keys[i].Scopes = keys[i].scopes()
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: keys})
+}
+
+// Create a key named {label} with {scopes}, optionally limited to writing
+// {data_keys}
+func createScopedKey(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	if userID == "global" {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "Scoped keys belong to user accounts"})
+		return
+	}
+
+	var input struct {
+		Label    string   `json:"label"`
+		Scopes   []string `json:"scopes"`
+		DataKeys []string `json:"data_keys"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	// Keys limited to data keys could always read and write them
+	if input.Scopes == nil && len(input.DataKeys) > 0 {
+		input.Scopes = []string{scopeRead, scopeWrite}
+	}
+	if fieldErrors := scopeErrors(input.Scopes, input.DataKeys); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	if fieldErrors := patchKeyErrors(stringSet(input.DataKeys), "data_keys"); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	key := ScopedKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Key:       generateAPIKey(),
+		Label:     input.Label,
+		Scopes:    input.Scopes,
+		DataKeys:  input.DataKeys,
+		CreatedAt: time.Now().UTC(),
+	}
+	_, err := keysCollection.InsertOne(c, key)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create key"})
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, APIResponse{Success: true, Message: "Scoped key created", Data: key})
+}
+
+// scopeErrors checks the scopes of a new key
+func scopeErrors(scopes, dataKeys []string) []FieldError {
+	if len(scopes) == 0 {
+		return []FieldError{{Field: "scopes", Message: "must list read, write or both"}}
+	}
+	for _, scope := range scopes {
+		if scope != scopeRead && scope != scopeWrite {
+			return []FieldError{{Field: "scopes", Message: "may only contain read and write, got " + scope}}
+		}
+	}
+	if len(dataKeys) > 0 && !containsString(scopes, scopeWrite) {
+		return []FieldError{{Field: "data_keys", Message: "need the write scope"}}
+	}
+	return nil
+}
+
+// stringSet turns values into map keys so they can be checked like data keys
+func stringSet(values []string) map[string]interface{} {
+	set := make(map[string]interface{}, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// Revoke one of the caller's scoped keys
+func deleteScopedKey(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	result, err := keysCollection.DeleteOne(c, bson.M{"_id": id, "user_id": getUserID(r)})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to delete key"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Key not found"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Scoped key revoked"})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDisallowedKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		allowed []string
+		want    []string
+	}{
+		{"all allowed", map[string]interface{}{"a": 1, "b": 2}, []string{"a", "b", "c"}, nil},
+		{"some outside", map[string]interface{}{"a": 1, "z": 2, "y": 3}, []string{"a"}, []string{"y", "z"}},
+		{"nothing allowed", map[string]interface{}{"a": 1}, nil, []string{"a"}},
+		{"empty data", map[string]interface{}{}, []string{"a"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := disallowedKeys(tt.data, tt.allowed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("disallowedKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateScopedDataValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed JSON", `{"data":`, http.StatusBadRequest},
+		{"no data", `{}`, http.StatusUnprocessableEntity},
+		{"data not an object", `{"data":[1]}`, http.StatusUnprocessableEntity},
+		{"other members", `{"name":"x","data":{"a":1}}`, http.StatusForbidden},
+		{"key outside the scope", `{"data":{"a":1,"b":2}}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/d", strings.NewReader(tt.body)), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			updateScopedData(w, r, "d", []string{"a"})
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateScopedData(t *testing.T) {
+	setupTestDB(t)
+	docCollection.InsertOne(context.Background(), JSONDocument{ID: "d", UserID: "u1", Name: "shared",
+		Data: map[string]interface{}{"a": 1.0, "b": map[string]interface{}{"owned": "elsewhere"}}})
+
+	tests := []struct {
+		name string
+		user string
+		body string
+		want int
+		data string
+	}{
+		{"writes its own keys", "u1", `{"data":{"a":{"x":2}}}`, http.StatusOK, `{"a":{"x":2},"b":{"owned":"elsewhere"}}`},
+		{"another user's document", "u2", `{"data":{"a":3}}`, http.StatusNotFound, `{"a":{"x":2},"b":{"owned":"elsewhere"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/d", strings.NewReader(tt.body)), tt.user)
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			updateScopedData(w, r, "d", []string{"a"})
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			var stored JSONDocument
+			docCollection.FindOne(context.Background(), bson.M{"_id": "d"}).Decode(&stored)
+			if got, _ := json.Marshal(stored.Data); string(got) != tt.data {
+				t.Errorf("stored data = %s, want %s", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestScopedKeyMiddleware(t *testing.T) {
+	readOnly := []string{scopeRead}
+	readWrite := []string{scopeRead, scopeWrite}
+	tests := []struct {
+		name    string
+		pattern string
+		method  string
+		scopes  []string
+		limited bool
+		want    int
+	}{
+		{"read-only key reads", "/api/documents/{id}", http.MethodGet, readOnly, false, http.StatusOK},
+		{"read-only key creates", "/api/documents", http.MethodPost, readOnly, false, http.StatusForbidden},
+		{"read-only key updates", "/api/documents/{id}", http.MethodPut, readOnly, false, http.StatusForbidden},
+		{"read-only key deletes", "/api/documents/{id}", http.MethodDelete, readOnly, false, http.StatusForbidden},
+		{"write-only key reads", "/api/schemas", http.MethodGet, []string{scopeWrite}, false, http.StatusForbidden},
+		{"write key creates", "/api/documents", http.MethodPost, readWrite, false, http.StatusOK},
+		{"write key deletes", "/api/documents/{id}", http.MethodDelete, readWrite, false, http.StatusOK},
+		{"data key limited update", "/api/documents/{id}", http.MethodPatch, readWrite, true, http.StatusOK},
+		{"data key limited create", "/api/documents", http.MethodPost, readWrite, true, http.StatusForbidden},
+		{"account routes", "/api/me", http.MethodGet, readWrite, false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(tt.method, "/", nil), "u1")
+			r = r.WithContext(context.WithValue(r.Context(), "key_scopes", tt.scopes))
+			if tt.limited {
+				r = r.WithContext(context.WithValue(r.Context(), "data_keys", []string{"a"}))
+			}
+			handler := scopedKeyMiddleware(tt.pattern, func(w http.ResponseWriter, r *http.Request) {})
+			if w := serve(handler, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestScopeErrors(t *testing.T) {
+	tests := []struct {
+		scopes   []string
+		dataKeys []string
+		valid    bool
+	}{
+		{[]string{"read"}, nil, true},
+		{[]string{"read", "write"}, []string{"a"}, true},
+		{nil, nil, false},
+		{[]string{"admin"}, nil, false},
+		{[]string{"read"}, []string{"a"}, false},
+	}
+	for _, tt := range tests {
+		if got := scopeErrors(tt.scopes, tt.dataKeys) == nil; got != tt.valid {
+			t.Errorf("scopeErrors(%v, %v) valid = %v, want %v", tt.scopes, tt.dataKeys, got, tt.valid)
+		}
+	}
+}
+
+func TestMaskKey(t *testing.T) {
+	saved := config.APIKeyPrefix
+	t.Cleanup(func() { config.APIKeyPrefix = saved })
+
+	config.APIKeyPrefix = ""
+	if got := maskKey("0f8fad5b-d9cb-469f-a165-70867728950e"); got != "****950e" {
+		t.Errorf("maskKey = %q, want ****950e", got)
+	}
+	config.APIKeyPrefix = "jsonapi_live_"
+	if got := maskKey("jsonapi_live_abcdef123456"); got != "jsonapi_live_****3456" {
+		t.Errorf("maskKey = %q, want jsonapi_live_****3456", got)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// textIndex covers the name and every string value in data. MongoDB allows
+// one text index per collection, so a wildcard index is used rather than
+// picking fields.
+var textIndex = mongo.IndexModel{
+	Keys:    bson.D{{Key: "$**", Value: "text"}},
+	Options: options.Index().SetName("document_text").SetWeights(bson.M{"name": 5}),
+}
+
+// Search documents - full-text search over names and data string values,
+// best matches first
+func searchDocuments(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	query := r.URL.Query()
+
+	if !config.TextSearch {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Text search is not enabled"})
+		return
+	}
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		sendValidationError(w, []FieldError{{Field: "q", Message: "is required"}})
+		return
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	filter := live(bson.M{"$text": bson.M{"$search": q}})
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+	score := bson.M{"score": bson.M{"$meta": "textScore"}}
+	opts := options.Find().SetProjection(score).SetSort(score).SetLimit(int64(limit))
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	cursor, err := docCollection.Find(c, filter, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to search documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	results := []struct {
+		JSONDocument `bson:",inline"`
+		Score        float64 `json:"score" bson:"score"`
+	}{}
+	if err := cursor.All(c, &results); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: results})
+}
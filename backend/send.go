@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// copyData deep-copies data, so a copy shares no maps or arrays with the
+// document it was made from
+func copyData(data map[string]interface{}) (map[string]interface{}, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var copied map[string]interface{}
+	if err := bson.Unmarshal(raw, &copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+// sentCopy is the document a recipient receives for doc. Only the content
+// travels: the folder, visibility, client key and schema reference belong
+// to the sender's account.
+func sentCopy(doc JSONDocument, recipientID string, data map[string]interface{}, now time.Time) JSONDocument {
+	sent := JSONDocument{
+		ID:        uuid.New().String(),
+		UserID:    recipientID,
+		Name:      "Shared: " + doc.Name,
+		Data:      data,
+		Unique:    doc.Unique,
+		Derived:   doc.Derived,
+		Template:  doc.Template,
+		CreatedAt: now,
+		UpdatedAt: now,
+
+		ContentHash: contentHash(data),
+	}
+	if config.InferSchema {
+		sent.Schema = inferSchema(sent.Data)
+	}
+	return sent
+}
+
+// Send document - stores a copy of the document in the account registered
+// under email. The recipient owns the copy outright and the original is
+// unaffected by later changes to it.
+func sendDocument(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	var input struct {
+		Email string `json:"email"`
+	}
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if input.Email == "" {
+		sendValidationError(w, []FieldError{{Field: "email", Message: "is required"}})
+		return
+	}
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&doc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+
+	var recipient User
+	err = usersCollection.FindOne(c, bson.M{"email": strings.ToLower(input.Email)}).Decode(&recipient)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Recipient not found", Code: "recipient_not_found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up recipient"})
+		return
+	}
+
+	data, err := copyData(doc.Data)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to copy document"})
+		return
+	}
+	now := time.Now().UTC()
+	stampData(data, nil, now, now)
+	sent := sentCopy(doc, recipient.ID, data, now)
+
+	// The copy counts against the recipient's quota, not the sender's
+	size := dataSize(sent.Data)
+	if !chargeStorage(c, w, recipient.ID, size) {
+		return
+	}
+	_, err = docCollection.InsertOne(c, sent)
+	dbBreaker.Record(err)
+	settleStorage(recipient.ID, size, err == nil)
+	if mongo.IsDuplicateKeyError(err) {
+		sendWriteConflict(w, err)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to save document"})
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, APIResponse{
+		Success: true,
+		Message: "Document sent to " + recipient.Email,
+		Data:    map[string]interface{}{"id": sent.ID, "name": sent.Name, "user_id": sent.UserID},
+	})
+}
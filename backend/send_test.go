@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSentCopy(t *testing.T) {
+	var data map[string]interface{}
+	json.Unmarshal([]byte(`{"list":[1,{"x":2}],"nested":{"y":3}}`), &data)
+	doc := JSONDocument{
+		ID:        "doc-1",
+		UserID:    "sender",
+		Name:      "Plan",
+		Folder:    "work",
+		Data:      storedData(t, data),
+		IsPublic:  true,
+		ClientKey: "k1",
+		SchemaRef: &SchemaRef{Name: "plan", Version: 1},
+	}
+
+	copied, err := copyData(doc.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := sentCopy(doc, "recipient", copied, time.Now())
+	if sent.ID == doc.ID || sent.UserID != "recipient" || sent.Name != "Shared: Plan" {
+		t.Errorf("copy is %s owned by %s named %q; want a new ID owned by recipient named \"Shared: Plan\"", sent.ID, sent.UserID, sent.Name)
+	}
+	if sent.Folder != "" || sent.IsPublic || sent.ClientKey != "" || sent.SchemaRef != nil {
+		t.Errorf("copy kept the sender's settings: %+v", sent)
+	}
+
+	// Changing the copy leaves the original alone
+	sent.Data["nested"].(map[string]interface{})["y"] = 99
+	sent.Data["list"].(bson.A)[0] = 99
+	if raw, _ := json.Marshal(doc.Data); string(raw) != `{"list":[1,{"x":2}],"nested":{"y":3}}` {
+		t.Errorf("original changed with the copy: %s", raw)
+	}
+}
+
+func TestSendDocument(t *testing.T) {
+	setupTestDB(t)
+	for _, user := range []User{{ID: "u1", Email: "one@example.com"}, {ID: "u2", Email: "two@example.com"}} {
+		user.APIKey = user.ID + "-key"
+		if _, err := usersCollection.InsertOne(context.Background(), user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := JSONDocument{ID: "doc-1", UserID: "u1", Name: "Plan", Data: map[string]interface{}{"steps": []interface{}{"a"}}}
+	if _, err := docCollection.InsertOne(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(email string) *httptest.ResponseRecorder {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/doc-1/send", strings.NewReader(`{"email":"`+email+`"}`)), "u1")
+		return serve(withID(sendDocument), withPathParams(r, map[string]string{"id": "doc-1"}))
+	}
+	if w := send("nobody@example.com"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown recipient: status %d, want 404", w.Code)
+	}
+
+	w := send("Two@example.com")
+	var resp struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("send: status %d: %s", w.Code, w.Body)
+	}
+
+	// The recipient edits their copy; the original keeps its data
+	r := asUser(httptest.NewRequest(http.MethodPut, "/api/documents/"+resp.Data.ID, strings.NewReader(`{"data":{"steps":["b"]}}`)), "u2")
+	r.Header.Set("Content-Type", "application/json")
+	if w := serve(withID(updateDocument), withPathParams(r, map[string]string{"id": resp.Data.ID})); w.Code != http.StatusOK {
+		t.Fatalf("recipient update: status %d: %s", w.Code, w.Body)
+	}
+	var original, copied JSONDocument
+	docCollection.FindOne(context.Background(), bson.M{"_id": "doc-1"}).Decode(&original)
+	docCollection.FindOne(context.Background(), bson.M{"_id": resp.Data.ID}).Decode(&copied)
+	if copied.UserID != "u2" || copied.Name != "Shared: Plan" {
+		t.Errorf("copy owned by %s named %q, want u2 and \"Shared: Plan\"", copied.UserID, copied.Name)
+	}
+	if raw, _ := json.Marshal(original.Data); string(raw) != `{"steps":["a"]}` {
+		t.Errorf("original data = %s after the copy changed", raw)
+	}
+}
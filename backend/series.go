@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SeriesPointer names the document /public/series/{name} currently serves.
+// Series names are global so public URLs are unambiguous; the first owner
+// to publish under a name keeps it.
+type SeriesPointer struct {
+	Name       string    `json:"name" bson:"_id"`
+	UserID     string    `json:"user_id" bson:"user_id"`
+	DocumentID string    `json:"document_id" bson:"document_id"`
+	UpdatedAt  time.Time `json:"updated_at" bson:"updated_at"`
+}
+
+// Mark a document as the latest in a series, moving the series pointer
+func markLatest(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	var input struct {
+		Series string `json:"series"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if !dataKeyPattern.MatchString(input.Series) {
+		sendValidationError(w, []FieldError{{Field: "series", Message: "must contain only letters, digits, '_' or '-'"}})
+		return
+	}
+
+	filter := bson.M{"_id": id}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to load document"})
+		return
+	}
+
+	// The pointer goes first so a refused name leaves the document
+	// untagged. Upserting on (name, owner) fails with a duplicate key when
+	// another user already owns the name.
+	pointer := SeriesPointer{Name: input.Series, UserID: doc.UserID, DocumentID: doc.ID, UpdatedAt: time.Now().UTC()}
+	_, err = seriesCollection.UpdateOne(c,
+		bson.M{"_id": pointer.Name, "user_id": pointer.UserID},
+		bson.M{"$set": bson.M{"document_id": pointer.DocumentID, "updated_at": pointer.UpdatedAt}},
+		options.Update().SetUpsert(true),
+	)
+	dbBreaker.Record(err)
+	if mongo.IsDuplicateKeyError(err) {
+		sendJSON(w, http.StatusConflict, APIResponse{Success: false, Error: "Series name is owned by another user"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update series"})
+		return
+	}
+
+	_, err = docCollection.UpdateOne(c, bson.M{"_id": doc.ID}, bson.M{"$set": bson.M{"series": input.Series}})
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update document"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document is now the latest in " + pointer.Name, Data: pointer})
+}
+
+// Serve the latest document of a series
+func publicSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var pointer SeriesPointer
+	err := seriesCollection.FindOne(c, bson.M{"_id": pathParam(r, "name")}).Decode(&pointer)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Series not found"})
+		return
+	}
+
+	publicHandler(w, r, pointer.DocumentID)
+}
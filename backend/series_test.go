@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMarkLatestValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed JSON", `{"series":`, http.StatusBadRequest},
+		{"missing series", `{}`, http.StatusUnprocessableEntity},
+		{"slash in series", `{"series":"a/b"}`, http.StatusUnprocessableEntity},
+		{"dot in series", `{"series":"a.b"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/d/latest", strings.NewReader(tt.body)), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			if w := serve(withID(markLatest), withPathParams(r, map[string]string{"id": "d"})); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestSeries(t *testing.T) {
+	setupTestDB(t)
+	for _, doc := range []JSONDocument{
+		{ID: "a", UserID: "u1", Name: "a", Data: map[string]interface{}{"v": 1.0}, IsPublic: true},
+		{ID: "b", UserID: "u1", Name: "b", Data: map[string]interface{}{"v": 2.0}, IsPublic: true},
+		{ID: "c", UserID: "u2", Name: "c", Data: map[string]interface{}{"v": 3.0}, IsPublic: true},
+	} {
+		docCollection.InsertOne(context.Background(), doc)
+	}
+
+	mark := func(user, id string) int {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/api/documents/"+id+"/latest", strings.NewReader(`{"series":"releases"}`)), user)
+		r.Header.Set("Content-Type", "application/json")
+		return serve(withID(markLatest), withPathParams(r, map[string]string{"id": id})).Code
+	}
+	latest := func() float64 {
+		r := withPathParams(httptest.NewRequest(http.MethodGet, "/public/series/releases", nil), map[string]string{"name": "releases"})
+		w := serve(publicSeriesHandler, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("public series: status = %d", w.Code)
+		}
+		var data map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&data)
+		v, _ := data["v"].(float64)
+		return v
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		id     string
+		want   int
+		latest float64
+		series string
+	}{
+		{"first mark", "u1", "a", http.StatusOK, 1, "releases"},
+		{"pointer moves", "u1", "b", http.StatusOK, 2, "releases"},
+		{"name owned by another user", "u2", "c", http.StatusConflict, 2, ""},
+		{"another user's document", "u2", "a", http.StatusNotFound, 2, "releases"},
+		{"pointer moves back", "u1", "a", http.StatusOK, 1, "releases"},
+	}
+	for _, tt := range tests {
+		if got := mark(tt.user, tt.id); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+		if got := latest(); got != tt.latest {
+			t.Errorf("%s: latest v = %v, want %v", tt.name, got, tt.latest)
+		}
+		var stored JSONDocument
+		docCollection.FindOne(context.Background(), bson.M{"_id": tt.id}).Decode(&stored)
+		if stored.Series != tt.series {
+			t.Errorf("%s: stored series = %q, want %q", tt.name, stored.Series, tt.series)
+		}
+	}
+
+	r := withPathParams(httptest.NewRequest(http.MethodGet, "/public/series/none", nil), map[string]string{"name": "none"})
+	if w := serve(publicSeriesHandler, r); w.Code != http.StatusNotFound {
+		t.Errorf("unknown series: status = %d, want 404", w.Code)
+	}
+}
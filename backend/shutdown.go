@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// serveUntil runs listen until it fails or c is done, then stops accepting
+// connections and waits up to timeout for in-flight requests to finish.
+// Hijacked connections such as WebSockets are left to the server's
+// RegisterOnShutdown hooks.
+func serveUntil(c context.Context, server *http.Server, listen func() error, timeout time.Duration) error {
+	failed := make(chan error, 1)
+	go func() {
+		if err := listen(); !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		return err
+	case <-c.Done():
+	}
+
+	log.Printf("Shutting down, draining requests for up to %s", timeout)
+	drain, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(drain); err != nil {
+		// Whatever is still running is cut off
+		server.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// slowServer serves one handler that blocks until release is closed,
+// returning the server, its listen function and its URL
+func slowServer(t *testing.T, started, release chan struct{}) (*http.Server, func() error, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})}
+	return server, func() error { return server.Serve(listener) }, "http://" + listener.Addr().String()
+}
+
+func TestServeUntilDrainsRequests(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server, listen, url := slowServer(t, started, release)
+	stop, cancel := context.WithCancel(context.Background())
+
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(stop, server, listen, 5*time.Second) }()
+
+	got := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		got <- string(body)
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if body := <-got; body != "done" {
+		t.Errorf("in-flight request got %q, want it to finish", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serveUntil = %v, want nil", err)
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("server still accepts connections after shutdown")
+	}
+}
+
+func TestServeUntilTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	server, listen, url := slowServer(t, started, release)
+	stop, cancel := context.WithCancel(context.Background())
+
+	served := make(chan error, 1)
+	go func() { served <- serveUntil(stop, server, listen, 50*time.Millisecond) }()
+	go http.Get(url)
+
+	<-started
+	cancel()
+	if err := <-served; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("serveUntil = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestServeUntilListenFailure(t *testing.T) {
+	failure := errors.New("address in use")
+	err := serveUntil(context.Background(), &http.Server{}, func() error { return failure }, time.Second)
+	if err != failure {
+		t.Errorf("serveUntil = %v, want %v", err, failure)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// usageStats is the operator view returned by /admin/stats
+type usageStats struct {
+	Users        int64       `json:"users"`
+	Documents    int64       `json:"documents"`
+	DataBytes    int64       `json:"data_bytes"`
+	StorageBytes int64       `json:"storage_bytes"`
+	TopUsers     []userCount `json:"top_users"`
+	ComputedAt   time.Time   `json:"computed_at"`
+}
+
+type userCount struct {
+	UserID    string `json:"user_id" bson:"_id"`
+	Documents int64  `json:"documents" bson:"documents"`
+}
+
+// Stats are aggregated at most once per STATS_CACHE_TTL
+var statsCache struct {
+	sync.Mutex
+	stats   *usageStats
+	expires time.Time
+}
+
+// computeStats aggregates user and document totals across all users
+func computeStats(c context.Context) (*usageStats, error) {
+	stats := &usageStats{ComputedAt: time.Now().UTC()}
+
+	var err error
+	if stats.Users, err = usersCollection.EstimatedDocumentCount(c); err != nil {
+		return nil, err
+	}
+	if stats.Documents, err = docCollection.EstimatedDocumentCount(c); err != nil {
+		return nil, err
+	}
+
+	var collStats struct {
+		Size        int64 `bson:"size"`
+		StorageSize int64 `bson:"storageSize"`
+	}
+	err = docCollection.Database().RunCommand(c, bson.D{{Key: "collStats", Value: docCollection.Name()}}).Decode(&collStats)
+	if err != nil {
+		return nil, err
+	}
+	stats.DataBytes, stats.StorageBytes = collStats.Size, collStats.StorageSize
+
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$user_id", "documents": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"documents": -1}},
+		{"$limit": config.StatsTopN},
+	}
+	cursor, err := docCollection.Aggregate(c, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	stats.TopUsers = []userCount{}
+	if err := cursor.All(c, &stats.TopUsers); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Report usage totals for billing. ?refresh=true bypasses the cache.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	statsCache.Lock()
+	defer statsCache.Unlock()
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	if statsCache.stats == nil || time.Now().After(statsCache.expires) || r.URL.Query().Get("refresh") == "true" {
+		stats, err := computeStats(c)
+		dbBreaker.Record(err)
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to compute stats"})
+			return
+		}
+		statsCache.stats = stats
+		statsCache.expires = time.Now().Add(config.StatsCacheTTL)
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: statsCache.stats})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// resetStatsCache clears the stats cache now and when the test ends
+func resetStatsCache(t *testing.T) {
+	statsCache.stats, statsCache.expires = nil, time.Time{}
+	t.Cleanup(func() { statsCache.stats, statsCache.expires = nil, time.Time{} })
+}
+
+func TestStatsServedFromCache(t *testing.T) {
+	resetStatsCache(t)
+	statsCache.stats = &usageStats{Users: 7, TopUsers: []userCount{}}
+	statsCache.expires = time.Now().Add(time.Minute)
+
+	w := serve(statsHandler, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	var resp struct {
+		Data usageStats `json:"data"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if resp.Data.Users != 7 {
+		t.Errorf("users = %d, want the cached 7", resp.Data.Users)
+	}
+}
+
+func TestStats(t *testing.T) {
+	setupTestDB(t)
+	resetStatsCache(t)
+	savedTopN, savedTTL := config.StatsTopN, config.StatsCacheTTL
+	t.Cleanup(func() { config.StatsTopN, config.StatsCacheTTL = savedTopN, savedTTL })
+	config.StatsTopN, config.StatsCacheTTL = 2, time.Hour
+
+	for i, owner := range []string{"u1", "u2", "u2", "u3", "u3", "u3"} {
+		docCollection.InsertOne(context.Background(), JSONDocument{ID: fmt.Sprint(i), UserID: owner, Name: "n"})
+	}
+	usersCollection.InsertOne(context.Background(), User{ID: "u1", Email: "u1@example.com"})
+
+	get := func(query string) usageStats {
+		w := serve(statsHandler, httptest.NewRequest(http.MethodGet, "/admin/stats"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var resp struct {
+			Data usageStats `json:"data"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp.Data
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		documents int64
+	}{
+		{"computed", "", 6},
+		{"cached", "", 6},
+		{"refreshed", "?refresh=true", 7},
+	}
+	for i, tt := range tests {
+		if i == 1 {
+			docCollection.InsertOne(context.Background(), JSONDocument{ID: "extra", UserID: "u1", Name: "n"})
+		}
+		stats := get(tt.query)
+		if stats.Documents != tt.documents {
+			t.Errorf("%s: documents = %d, want %d", tt.name, stats.Documents, tt.documents)
+		}
+		if stats.Users != 1 {
+			t.Errorf("%s: users = %d, want 1", tt.name, stats.Users)
+		}
+		if len(stats.TopUsers) != 2 || stats.TopUsers[0].UserID != "u3" || stats.TopUsers[0].Documents != 3 {
+			t.Errorf("%s: top users = %+v, want u3 first with 3 of 2 entries", tt.name, stats.TopUsers)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"text/template/parse"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxTemplateBytes caps the source of a document's template
+	maxTemplateBytes = 64 << 10
+	// maxRenderBytes caps the HTML a template may produce
+	maxRenderBytes = 1 << 20
+	// maxRangeDepth caps how deeply range actions may nest
+	maxRangeDepth = 2
+	// maxRenderIterations caps the range iterations one render may run,
+	// including those that write nothing
+	maxRenderIterations = 100000
+)
+
+var (
+	errRenderTooLarge = fmt.Errorf("rendered output exceeds %d bytes", maxRenderBytes)
+	errRenderTooLong  = fmt.Errorf("rendering exceeds %d loop iterations", maxRenderIterations)
+)
+
+// parseDocumentTemplate parses an HTML template and checks it stays inside
+// the sandbox: no defined or invoked sub-templates, so nothing can recurse,
+// and range only over values taken from the data and at most two deep, so
+// loops are bounded by the document itself. Each range body also counts
+// against maxRenderIterations, so a render of the returned template stops
+// even when the data is large and the loops write nothing.
+func parseDocumentTemplate(source string) (*template.Template, error) {
+	if len(source) > maxTemplateBytes {
+		return nil, fmt.Errorf("template exceeds %d bytes", maxTemplateBytes)
+	}
+
+	iterations := 0
+	funcs := template.FuncMap{"renderStep": func() (bool, error) {
+		iterations++
+		if iterations > maxRenderIterations {
+			return false, errRenderTooLong
+		}
+		return false, nil
+	}}
+	tmpl, err := template.New("document").Option("missingkey=zero").Funcs(funcs).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Templates()) > 1 {
+		return nil, errors.New("templates may not define sub-templates")
+	}
+	if tmpl.Tree == nil {
+		return tmpl, nil
+	}
+	if err := checkTemplateNode(tmpl.Tree.Root, 0, nonDataVariables(tmpl.Tree.Root)); err != nil {
+		return nil, err
+	}
+
+	// {{if renderStep}}{{end}} writes nothing, so it can open any range
+	// body without changing the output or its escaping
+	step, err := template.New("step").Funcs(funcs).Parse("{{if renderStep}}{{end}}")
+	if err != nil {
+		return nil, err
+	}
+	countRanges(tmpl.Tree.Root, step.Tree.Root.Nodes[0])
+	return tmpl, nil
+}
+
+func checkTemplateNode(node parse.Node, ranges int, nonData map[string]bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child, ranges, nonData); err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errors.New("templates may not invoke sub-templates")
+	case *parse.RangeNode:
+		if !isDataPipe(n.Pipe, nonData) {
+			return errors.New("range must iterate over a value from the data, such as {{range .items}}")
+		}
+		if ranges == maxRangeDepth {
+			return fmt.Errorf("range may be nested at most %d deep", maxRangeDepth)
+		}
+		return checkBranch(&n.BranchNode, ranges+1, nonData)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, ranges, nonData)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, ranges, nonData)
+	}
+	return nil
+}
+
+func checkBranch(branch *parse.BranchNode, ranges int, nonData map[string]bool) error {
+	if err := checkTemplateNode(branch.List, ranges, nonData); err != nil {
+		return err
+	}
+	return checkTemplateNode(branch.ElseList, ranges, nonData)
+}
+
+// isDataPipe reports whether a pipeline is a bare field or dot, or a
+// variable that only ever holds values from the data
+func isDataPipe(pipe *parse.PipeNode, nonData map[string]bool) bool {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode, *parse.DotNode:
+		return true
+	case *parse.VariableNode:
+		return !nonData[arg.Ident[0]]
+	}
+	return false
+}
+
+// nonDataVariables names the variables a template binds, anywhere, to
+// something other than a value from the data: a literal such as
+// {{$n := 1000000}}, a function result, or a range index. Scopes are
+// ignored, so reusing a name is judged by its worst binding.
+func nonDataVariables(root *parse.ListNode) map[string]bool {
+	nonData := map[string]bool{}
+	// A variable bound to another is only known once that one is, so
+	// repeat until nothing changes
+	for changed := true; changed; {
+		changed = false
+		mark := func(v *parse.VariableNode) {
+			if !nonData[v.Ident[0]] {
+				nonData[v.Ident[0]] = true
+				changed = true
+			}
+		}
+		walkPipes(root, func(pipe *parse.PipeNode, isRange bool) {
+			if len(pipe.Decl) == 0 {
+				return
+			}
+			if isRange && len(pipe.Decl) == 2 {
+				mark(pipe.Decl[0])
+			}
+			if !isDataPipe(pipe, nonData) {
+				for _, v := range pipe.Decl {
+					mark(v)
+				}
+			}
+		})
+	}
+	return nonData
+}
+
+// walkPipes calls fn for every action and control pipeline under node
+func walkPipes(node parse.Node, fn func(pipe *parse.PipeNode, isRange bool)) {
+	var branch *parse.BranchNode
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				walkPipes(child, fn)
+			}
+		}
+		return
+	case *parse.ActionNode:
+		fn(n.Pipe, false)
+		return
+	case *parse.RangeNode:
+		fn(n.Pipe, true)
+		branch = &n.BranchNode
+	case *parse.IfNode:
+		fn(n.Pipe, false)
+		branch = &n.BranchNode
+	case *parse.WithNode:
+		fn(n.Pipe, false)
+		branch = &n.BranchNode
+	default:
+		return
+	}
+	walkPipes(branch.List, fn)
+	walkPipes(branch.ElseList, fn)
+}
+
+// countRanges puts a copy of step at the start of every range body
+func countRanges(node parse.Node, step parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				countRanges(child, step)
+			}
+		}
+	case *parse.RangeNode:
+		countRanges(n.List, step)
+		countRanges(n.ElseList, step)
+		n.List.Nodes = append([]parse.Node{step.Copy()}, n.List.Nodes...)
+	case *parse.IfNode:
+		countRanges(n.List, step)
+		countRanges(n.ElseList, step)
+	case *parse.WithNode:
+		countRanges(n.List, step)
+		countRanges(n.ElseList, step)
+	}
+}
+
+// cappedBuffer fails writes once maxRenderBytes would be exceeded, which
+// stops template execution
+type cappedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > maxRenderBytes {
+		return 0, errRenderTooLarge
+	}
+	return b.Buffer.Write(p)
+}
+
+// Set or remove a document's HTML template. An empty template removes it.
+func setDocumentTemplate(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	var input struct {
+		Template string `json:"template"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(r, body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	update := bson.M{"$unset": bson.M{"template": ""}}
+	if input.Template != "" {
+		if _, err := parseDocumentTemplate(input.Template); err != nil {
+			sendValidationError(w, []FieldError{{Field: "template", Message: err.Error()}})
+			return
+		}
+		update = bson.M{"$set": bson.M{"template": input.Template}}
+	}
+
+	filter := bson.M{"_id": id, "frozen": bson.M{"$ne": true}}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := docCollection.FindOneAndUpdate(c, live(filter), update, opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendWriteMiss(w, r, id)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to update document"})
+		return
+	}
+
+	message := "Template set"
+	if input.Template == "" {
+		message = "Template removed"
+	}
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: message, Data: doc})
+}
+
+// Render a public document's data through its HTML template
+func renderHandler(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	err := docCollection.FindOne(c, publicFilter(id)).Decode(&doc)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+	if doc.Template == "" {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document has no template"})
+		return
+	}
+	if !allowPublicRead(w, doc) {
+		return
+	}
+
+	tmpl, err := parseDocumentTemplate(doc.Template)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Invalid template: " + err.Error()})
+		return
+	}
+	var body cappedBuffer
+	if err := tmpl.Execute(&body, publicData(doc)); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to render document: " + err.Error()})
+		return
+	}
+
+	w.Header().Set("Cache-Control", publicCacheControl())
+	if notModified(w, r, weakETag(body.Bytes())) {
+		return
+	}
+
+	// The page is the document owner's markup, so it runs sandboxed with
+	// no scripts and no access to this origin
+	w.Header().Set("Content-Security-Policy", "sandbox; default-src 'none'; style-src 'unsafe-inline'; img-src https: data:")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseDocumentTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{"plain HTML", `<h1>Hello</h1>`, ""},
+		{"empty", ``, ""},
+		{"fields", `<h1>{{.title}}</h1>{{if .draft}}draft{{else}}live{{end}}`, ""},
+		{"range over data", `{{range .items}}<li>{{.name}}</li>{{end}}`, ""},
+		{"nested range", `{{range .groups}}{{range .items}}{{.}}{{end}}{{end}}`, ""},
+		{"range over a variable", `{{with $x := .items}}{{range $x}}{{.}}{{end}}{{end}}`, ""},
+		{"range too deep", `{{range .a}}{{range .b}}{{range .c}}{{end}}{{end}}{{end}}`, "range may be nested at most 2 deep"},
+		{"range inside if", `{{if .a}}{{range .b}}{{range .c}}{{range .d}}{{end}}{{end}}{{end}}{{end}}`, "range may be nested at most 2 deep"},
+		{"range over a call", `{{range len .items}}{{end}}`, "range must iterate over a value from the data"},
+		{"range over a literal", `{{range 1000000}}{{end}}`, "range must iterate over a value from the data"},
+		{"range over a literal variable", `{{$n := 1000000000}}{{range $n}}{{range $n}}{{end}}{{end}}`, "range must iterate over a value from the data"},
+		{"range over a copied literal variable", `{{$n := 1000000000}}{{$m := $n}}{{range $m}}{{end}}`, "range must iterate over a value from the data"},
+		{"range over a reassigned variable", `{{$x := .items}}{{$x = 1000000000}}{{range $x}}{{end}}`, "range must iterate over a value from the data"},
+		{"range over a range index", `{{range $i, $v := .items}}{{range $i}}{{end}}{{end}}`, "range must iterate over a value from the data"},
+		{"range over a range element", `{{range $i, $v := .groups}}{{range $v}}{{.}}{{end}}{{end}}`, ""},
+		{"define", `{{define "x"}}x{{end}}`, "templates may not define sub-templates"},
+		{"block", `{{block "x" .}}x{{end}}`, "templates may not define sub-templates"},
+		{"invoke undefined template", `{{template "x"}}`, "templates may not invoke sub-templates"},
+		{"syntax error", `{{if}}`, "missing value for if"},
+		{"too large", strings.Repeat("a", maxTemplateBytes+1), "template exceeds"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDocumentTemplate(tt.source)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseDocumentTemplate error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseDocumentTemplate error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderDocumentTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		data    map[string]interface{}
+		want    string
+		wantErr error
+	}{
+		{"escapes data", `<p>{{.title}}</p>`, map[string]interface{}{"title": "<script>x</script>"}, `<p>&lt;script&gt;x&lt;/script&gt;</p>`, nil},
+		{"missing keys are empty", `<p>{{.missing}}</p>`, map[string]interface{}{}, `<p></p>`, nil},
+		{"ranges over arrays", `{{range .items}}[{{.}}]{{end}}`, map[string]interface{}{"items": []interface{}{"a", "b"}}, `[a][b]`, nil},
+		{"iteration budget", `{{range .items}}{{range $.items}}{{end}}{{end}}`, map[string]interface{}{"items": make([]interface{}, 400)}, "", errRenderTooLong},
+		{"output cap", `{{range .items}}{{.}}{{end}}`, map[string]interface{}{"items": []interface{}{strings.Repeat("x", maxRenderBytes/2), strings.Repeat("x", maxRenderBytes/2+1)}}, "", errRenderTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseDocumentTemplate(tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body cappedBuffer
+			err = tmpl.Execute(&body, tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Execute error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body.String() != tt.want {
+				t.Errorf("rendered %q, want %q", body.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderHandlerVisibility(t *testing.T) {
+	setupTestDB(t)
+	ids := insertVisibilityDocs(t, JSONDocument{UserID: "u1", Name: "page", Template: `<p>{{.title}}</p>`,
+		Data: map[string]interface{}{"title": "hello"}})
+	for id, want := range ids {
+		r := httptest.NewRequest(http.MethodGet, "/public/"+id+"/render", nil)
+		w := serve(withID(renderHandler), withPathParams(r, map[string]string{"id": id}))
+		if w.Code != want {
+			t.Errorf("%s: status = %d, want %d", id, w.Code, want)
+		}
+	}
+}
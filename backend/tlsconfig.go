@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// serverTLSConfig builds the TLS settings from TLS_MIN_VERSION and
+// TLS_CIPHER_SUITES. An empty suite list keeps Go's secure defaults; suites
+// only apply to TLS 1.2 since TLS 1.3 suites are not configurable.
+func serverTLSConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[config.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("TLS_MIN_VERSION must be 1.2 or 1.3, got %q", config.TLSMinVersion)
+	}
+
+	var suites []uint16
+	for _, name := range config.TLSCipherSuites {
+		id, ok := secureCipherSuite(name)
+		if !ok {
+			return nil, fmt.Errorf("TLS_CIPHER_SUITES: %q is not a supported secure cipher suite", name)
+		}
+		suites = append(suites, id)
+	}
+
+	return &tls.Config{MinVersion: minVersion, CipherSuites: suites}, nil
+}
+
+// secureCipherSuite looks up a suite by its standard name, excluding the
+// ones Go classifies as insecure
+func secureCipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// HSTS middleware - sends Strict-Transport-Security on responses served
+// over HTTPS, directly or via a trusted proxy, so browsers keep using it
+func hstsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestScheme(r) == "https" && config.HSTSMaxAge > 0 {
+			value := fmt.Sprintf("max-age=%d", config.HSTSMaxAge)
+			if config.HSTSSubdomains {
+				value += "; includeSubDomains"
+			}
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestScheme returns "https" for TLS connections and, for requests from
+// TRUSTED_PROXIES, the first X-Forwarded-Proto value; "http" otherwise
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if remoteAddrIn(r.RemoteAddr, config.TrustedProxies) {
+		proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+		if proto = strings.ToLower(strings.TrimSpace(proto)); proto != "" {
+			return proto
+		}
+	}
+	return "http"
+}
+
+// HTTPS-only middleware - applies HTTPS_ONLY to plain HTTP requests:
+// "redirect" sends them to the https URL (301 for GET and HEAD, 308 for
+// other methods so the body is resent) and "reject" answers 403. Health
+// checks are exempt so probes can use plain HTTP.
+func httpsOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.HTTPSOnly == "off" || requestScheme(r) == "https" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, rest := splitVersion(canonicalPath(r.URL.Path)); isHealthPath(rest) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if config.HTTPSOnly == "redirect" {
+			status := http.StatusMovedPermanently
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				status = http.StatusPermanentRedirect
+			}
+			http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), status)
+			return
+		}
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "HTTPS is required", Code: "https_required"})
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerTLSConfig(t *testing.T) {
+	savedVersion, savedSuites := config.TLSMinVersion, config.TLSCipherSuites
+	t.Cleanup(func() { config.TLSMinVersion, config.TLSCipherSuites = savedVersion, savedSuites })
+
+	tests := []struct {
+		name    string
+		version string
+		suites  []string
+		min     uint16
+		count   int
+		wantErr bool
+	}{
+		{"defaults", "1.2", nil, tls.VersionTLS12, 0, false},
+		{"TLS 1.3", "1.3", nil, tls.VersionTLS13, 0, false},
+		{"chosen suites", "1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, tls.VersionTLS12, 2, false},
+		{"old version", "1.0", nil, 0, 0, true},
+		{"insecure suite", "1.2", []string{"TLS_RSA_WITH_RC4_128_SHA"}, 0, 0, true},
+		{"unknown suite", "1.2", []string{"TLS_MADE_UP"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.TLSMinVersion, config.TLSCipherSuites = tt.version, tt.suites
+			cfg, err := serverTLSConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("serverTLSConfig error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if cfg.MinVersion != tt.min {
+				t.Errorf("MinVersion = %x, want %x", cfg.MinVersion, tt.min)
+			}
+			if len(cfg.CipherSuites) != tt.count {
+				t.Errorf("CipherSuites = %v, want %d entries", cfg.CipherSuites, tt.count)
+			}
+		})
+	}
+}
+
+func TestHSTSMiddleware(t *testing.T) {
+	savedAge, savedSub := config.HSTSMaxAge, config.HSTSSubdomains
+	t.Cleanup(func() { config.HSTSMaxAge, config.HSTSSubdomains = savedAge, savedSub })
+
+	tests := []struct {
+		name       string
+		https      bool
+		maxAge     int
+		subdomains bool
+		want       string
+	}{
+		{"plain HTTP", false, 3600, false, ""},
+		{"HTTPS", true, 3600, false, "max-age=3600"},
+		{"HTTPS with subdomains", true, 3600, true, "max-age=3600; includeSubDomains"},
+		{"disabled", true, 0, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.HSTSMaxAge, config.HSTSSubdomains = tt.maxAge, tt.subdomains
+			r := httptest.NewRequest(http.MethodGet, "/api/documents", nil)
+			if tt.https {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			hstsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if got := w.Header().Get("Strict-Transport-Security"); got != tt.want {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	saved := config.TrustedProxies
+	t.Cleanup(func() { config.TrustedProxies = saved })
+	config.TrustedProxies = []string{"10.0.0.0/8"}
+
+	tests := []struct {
+		name      string
+		tls       bool
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"plain", false, "203.0.113.1:1234", "", "http"},
+		{"TLS", true, "203.0.113.1:1234", "", "https"},
+		{"trusted proxy", false, "10.1.2.3:1234", "https", "https"},
+		{"trusted proxy, first hop wins", false, "10.1.2.3:1234", "HTTPS, http", "https"},
+		{"trusted proxy without header", false, "10.1.2.3:1234", "", "http"},
+		{"untrusted client", false, "203.0.113.1:1234", "https", "http"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			if got := requestScheme(r); got != tt.want {
+				t.Errorf("requestScheme = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSOnlyMiddleware(t *testing.T) {
+	saved := config.HTTPSOnly
+	t.Cleanup(func() { config.HTTPSOnly = saved })
+
+	tests := []struct {
+		mode     string
+		method   string
+		target   string
+		https    bool
+		status   int
+		location string
+	}{
+		{"off", http.MethodGet, "/api/documents", false, http.StatusOK, ""},
+		{"redirect", http.MethodGet, "/api/documents?a=1", false, http.StatusMovedPermanently, "https://example.com/api/documents?a=1"},
+		{"redirect", http.MethodPost, "/api/documents", false, http.StatusPermanentRedirect, "https://example.com/api/documents"},
+		{"redirect", http.MethodGet, "/api/documents", true, http.StatusOK, ""},
+		{"reject", http.MethodGet, "/api/documents", false, http.StatusForbidden, ""},
+		{"reject", http.MethodGet, "/health", false, http.StatusOK, ""},
+		{"reject", http.MethodGet, "/health/ready", false, http.StatusOK, ""},
+		{"reject", http.MethodGet, "/healthz", false, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+" "+tt.method+" "+tt.target, func(t *testing.T) {
+			config.HTTPSOnly = tt.mode
+			r := httptest.NewRequest(tt.method, "http://example.com"+tt.target, nil)
+			if tt.https {
+				r.TLS = &tls.ConnectionState{}
+			}
+			w := httptest.NewRecorder()
+			httpsOnlyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Location"); got != tt.location {
+				t.Errorf("Location = %q, want %q", got, tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxTransactionOps = 100
+
+// txOperation is one step of a transaction request
+type txOperation struct {
+	Op   string                 `json:"op"`
+	ID   string                 `json:"id"`
+	Name string                 `json:"name"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// txError aborts a transaction and identifies the operation that failed
+type txError struct {
+	index  int
+	status int
+	msg    string
+}
+
+func (e *txError) Error() string { return e.msg }
+
+// Run a list of create/update/delete operations atomically
+func transactionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	var input struct {
+		Operations []txOperation `json:"operations"`
+	}
+
+	body, ok := readBody(w, r, config.MaxBodyBytes)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+
+	if len(input.Operations) == 0 {
+		sendValidationError(w, []FieldError{{Field: "operations", Message: "must not be empty"}})
+		return
+	}
+	if len(input.Operations) > maxTransactionOps {
+		sendValidationError(w, []FieldError{{Field: "operations", Message: fmt.Sprintf("must have at most %d entries", maxTransactionOps)}})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	session, err := docCollection.Database().Client().StartSession()
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to start transaction"})
+		return
+	}
+	defer session.EndSession(c)
+
+	result, err := session.WithTransaction(c, func(sc mongo.SessionContext) (interface{}, error) {
+		results := make([]map[string]interface{}, 0, len(input.Operations))
+		for i, op := range input.Operations {
+			res, err := applyTxOperation(sc, userID, i, op)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, res)
+		}
+		return results, nil
+	})
+	dbBreaker.Record(err)
+
+	var opErr *txError
+	var serverErr mongo.ServerError
+	switch {
+	case errors.As(err, &opErr):
+		sendJSON(w, opErr.status, APIResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Operation %d failed: %s; transaction rolled back", opErr.index, opErr.msg),
+			Code:    "transaction_aborted",
+			Data:    map[string]interface{}{"failed_operation": opErr.index},
+		})
+		return
+	case errors.As(err, &serverErr) && (serverErr.HasErrorCode(20) || strings.Contains(err.Error(), "replica set")):
+		sendJSON(w, http.StatusNotImplemented, APIResponse{
+			Success: false,
+			Error:   "Transactions require MongoDB to run as a replica set or sharded cluster",
+			Code:    "transactions_unsupported",
+		})
+		return
+	case err != nil:
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Transaction failed and was rolled back"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Transaction committed", Data: result})
+}
+
+// applyTxOperation performs one operation inside the session transaction
+func applyTxOperation(sc mongo.SessionContext, userID string, index int, op txOperation) (map[string]interface{}, error) {
+	fail := func(status int, msg string) error {
+		return &txError{index: index, status: status, msg: msg}
+	}
+
+	filter := live(bson.M{"_id": op.ID, "frozen": bson.M{"$ne": true}})
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	switch op.Op {
+	case "create":
+		if op.Name == "" {
+			return nil, fail(http.StatusUnprocessableEntity, "name is required")
+		}
+		if op.Data == nil {
+			op.Data = make(map[string]interface{})
+		}
+		now := time.Now().UTC()
+		stampData(op.Data, nil, now, now)
+		if fieldErrors := validateData(op.Data, nil); len(fieldErrors) > 0 {
+			return nil, fail(http.StatusUnprocessableEntity, fieldErrors[0].Field+" "+fieldErrors[0].Message)
+		}
+		schemaRef, err := schemaForName(sc, userID, op.Name)
+		if err != nil {
+			return nil, err
+		}
+		if schemaRef != nil {
+			fieldErrors, err := validateSchemaRef(sc, userID, schemaRef, op.Data)
+			if err != nil {
+				return nil, err
+			}
+			if len(fieldErrors) > 0 {
+				return nil, fail(http.StatusUnprocessableEntity, fieldErrors[0].Field+" "+fieldErrors[0].Message)
+			}
+		}
+
+		doc := JSONDocument{
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Name:      op.Name,
+			Data:      op.Data,
+			SchemaRef: schemaRef,
+			CreatedAt: now,
+			UpdatedAt: now,
+
+			ContentHash: contentHash(op.Data),
+		}
+		if config.InferSchema {
+			doc.Schema = inferSchema(doc.Data)
+		}
+		if err := adjustStorage(sc, userID, dataSize(doc.Data)); err == errQuotaExceeded {
+			return nil, fail(http.StatusForbidden, err.Error())
+		} else if err != nil {
+			return nil, err
+		}
+		if _, err := docCollection.InsertOne(sc, doc); isContentConflict(err) {
+			return nil, fail(http.StatusConflict, "a document with the same data already exists")
+		} else if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"op": op.Op, "id": doc.ID}, nil
+
+	case "update":
+		set := bson.M{"updated_at": time.Now().UTC()}
+		if op.Name != "" {
+			set["name"] = op.Name
+		}
+		if op.Data != nil {
+			var existing JSONDocument
+			if err := docCollection.FindOne(sc, filter).Decode(&existing); err == mongo.ErrNoDocuments {
+				return nil, fail(http.StatusNotFound, "document not found or frozen")
+			} else if err != nil {
+				return nil, err
+			}
+			stampData(op.Data, existing.Data, existing.CreatedAt, set["updated_at"].(time.Time))
+			if fieldErrors := validateData(op.Data, existing.Unique); len(fieldErrors) > 0 {
+				return nil, fail(http.StatusUnprocessableEntity, fieldErrors[0].Field+" "+fieldErrors[0].Message)
+			}
+			schemaRef := existing.SchemaRef
+			if schemaRef == nil {
+				name := existing.Name
+				if op.Name != "" {
+					name = op.Name
+				}
+				ref, err := schemaForName(sc, existing.UserID, name)
+				if err != nil {
+					return nil, err
+				}
+				schemaRef = ref
+				if ref != nil {
+					set["schema_ref"] = ref
+				}
+			}
+			if schemaRef != nil {
+				fieldErrors, err := validateSchemaRef(sc, existing.UserID, schemaRef, op.Data)
+				if err != nil {
+					return nil, err
+				}
+				if len(fieldErrors) > 0 {
+					return nil, fail(http.StatusUnprocessableEntity, fieldErrors[0].Field+" "+fieldErrors[0].Message)
+				}
+			}
+			// The total is adjusted inside the transaction, so it rolls back too
+			if err := adjustStorage(sc, existing.UserID, dataSize(op.Data)-dataSize(existing.Data)); err == errQuotaExceeded {
+				return nil, fail(http.StatusForbidden, err.Error())
+			} else if err != nil {
+				return nil, err
+			}
+			set["data"] = op.Data
+			set["content_hash"] = contentHashValue(op.Data)
+		}
+		res, err := docCollection.UpdateOne(sc, filter, bson.M{"$set": set})
+		if isContentConflict(err) {
+			return nil, fail(http.StatusConflict, "a document with the same data already exists")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, fail(http.StatusNotFound, "document not found or frozen")
+		}
+		return map[string]interface{}{"op": op.Op, "id": op.ID}, nil
+
+	case "delete":
+		// Like DELETE /api/documents/{id}, this moves the document to the trash
+		res, err := docCollection.UpdateOne(sc, filter, bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, fail(http.StatusNotFound, "document not found or frozen")
+		}
+		return map[string]interface{}{"op": op.Op, "id": op.ID}, nil
+	}
+
+	return nil, fail(http.StatusBadRequest, `op must be "create", "update" or "delete"`)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTransactionValidation(t *testing.T) {
+	tooMany := `{"operations":[` + strings.Repeat(`{"op":"delete","id":"x"},`, maxTransactionOps) + `{"op":"delete","id":"x"}]}`
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"malformed JSON", `{"operations":`, http.StatusBadRequest},
+		{"no operations", `{"operations":[]}`, http.StatusUnprocessableEntity},
+		{"missing operations", `{}`, http.StatusUnprocessableEntity},
+		{"too many operations", tooMany, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/transactions", strings.NewReader(tt.body)), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			if w := serve(transactionHandler, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestTransactionRollsBack(t *testing.T) {
+	tests := []struct {
+		name   string
+		ops    string
+		want   int
+		failed int
+		count  int64
+	}{
+		{"all succeed", `[{"op":"create","name":"a"},{"op":"update","id":"d","data":{"x":2}}]`, http.StatusOK, -1, 2},
+		{"unknown op", `[{"op":"create","name":"a"},{"op":"upsert","id":"d"}]`, http.StatusBadRequest, 1, 1},
+		{"missing document", `[{"op":"create","name":"a"},{"op":"delete","id":"missing"}]`, http.StatusNotFound, 1, 1},
+		{"create without name", `[{"op":"delete","id":"d"},{"op":"create"}]`, http.StatusUnprocessableEntity, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupTestDB(t)
+			docCollection.InsertOne(context.Background(), JSONDocument{ID: "d", UserID: "u1", Name: "d", Data: map[string]interface{}{"x": 1.0}})
+
+			r := asUser(httptest.NewRequest(http.MethodPost, "/api/transactions", strings.NewReader(`{"operations":`+tt.ops+`}`)), "u1")
+			r.Header.Set("Content-Type", "application/json")
+			w := serve(transactionHandler, r)
+			if w.Code == http.StatusNotImplemented {
+				t.Skip("MongoDB is not running as a replica set")
+			}
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.failed >= 0 {
+				var resp struct {
+					Data struct {
+						FailedOperation int `json:"failed_operation"`
+					} `json:"data"`
+				}
+				json.NewDecoder(w.Body).Decode(&resp)
+				if resp.Data.FailedOperation != tt.failed {
+					t.Errorf("failed_operation = %d, want %d", resp.Data.FailedOperation, tt.failed)
+				}
+			}
+			count, _ := docCollection.CountDocuments(context.Background(), live(bson.M{"user_id": "u1"}))
+			if count != tt.count {
+				t.Errorf("live documents = %d, want %d", count, tt.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// live restricts a document filter to documents that are not in the trash
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// List trashed documents, most recently deleted first
+func listTrash(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+
+	filter := bson.M{"deleted_at": bson.M{"$exists": true}}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > 200 {
+		limit = 50
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := docCollection.Find(c, filter, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list trash"})
+		return
+	}
+	defer cursor.Close(c)
+
+	docs := []JSONDocument{}
+	if err := cursor.All(c, &docs); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: docs})
+}
+
+// Restore a trashed document
+func restoreDocument(w http.ResponseWriter, r *http.Request, id string) {
+	userID := getUserID(r)
+
+	filter := bson.M{"_id": id, "deleted_at": bson.M{"$exists": true}}
+	if userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	var doc JSONDocument
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := docCollection.FindOneAndUpdate(c, filter, bson.M{"$unset": bson.M{"deleted_at": ""}}, opts).Decode(&doc)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found in trash"})
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to restore document"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document restored", Data: doc})
+}
+
+// sweepTrash permanently deletes documents trashed longer than
+// TRASH_RETENTION, checking once an hour
+func sweepTrash() {
+	for range time.Tick(time.Hour) {
+		sweepExpiredTrash()
+	}
+}
+
+// sweepExpiredTrash runs one pass of the trash sweep
+func sweepExpiredTrash() {
+	c, cancel := dbContext(context.Background())
+	defer cancel()
+
+	cutoff := time.Now().UTC().Add(-config.TrashRetention)
+	expired := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+
+	// IDs are collected first so the documents' versions go with them
+	ids, err := docCollection.Distinct(c, "_id", expired)
+	dbBreaker.Record(err)
+	if err != nil {
+		log.Printf("Trash sweep failed: %v", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	swept := bson.M{"_id": bson.M{"$in": ids}, "deleted_at": bson.M{"$lt": cutoff}}
+	releaseStorageOf(swept)
+	result, err := docCollection.DeleteMany(c, swept)
+	dbBreaker.Record(err)
+	if err != nil {
+		log.Printf("Trash sweep failed: %v", err)
+		return
+	}
+	deleteVersions(bson.M{"document_id": bson.M{"$in": ids}})
+	log.Printf("Trash sweep removed %d documents", result.DeletedCount)
+}
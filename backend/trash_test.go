@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTrashRoundTrip(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	docCollection.InsertOne(c, JSONDocument{ID: "doc", UserID: "u1", Name: "n", Data: map[string]interface{}{"n": 1.0}})
+
+	call := func(h func(http.ResponseWriter, *http.Request, string), method, user string) int {
+		r := asUser(httptest.NewRequest(method, "/api/documents/doc", nil), user)
+		return serve(withID(h), withPathParams(r, map[string]string{"id": "doc"})).Code
+	}
+	trashed := func() []JSONDocument {
+		w := serve(listTrash, asUser(httptest.NewRequest(http.MethodGet, "/api/trash", nil), "u1"))
+		var resp struct {
+			Data []JSONDocument `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Data
+	}
+
+	if code := call(deleteDocument, http.MethodDelete, "u1"); code != http.StatusOK {
+		t.Fatalf("delete: status %d", code)
+	}
+	if code := call(getDocument, http.MethodGet, "u1"); code != http.StatusNotFound {
+		t.Errorf("get while trashed: status %d, want 404", code)
+	}
+	if docs := trashed(); len(docs) != 1 || docs[0].ID != "doc" || docs[0].DeletedAt == nil {
+		t.Errorf("trash = %+v, want doc with deleted_at", docs)
+	}
+
+	// Only the owner can restore, and only from the trash
+	if code := call(restoreDocument, http.MethodPost, "u2"); code != http.StatusNotFound {
+		t.Errorf("restore by another user: status %d, want 404", code)
+	}
+	if code := call(restoreDocument, http.MethodPost, "u1"); code != http.StatusOK {
+		t.Fatalf("restore: status %d", code)
+	}
+	if code := call(restoreDocument, http.MethodPost, "u1"); code != http.StatusNotFound {
+		t.Errorf("restore of a live document: status %d, want 404", code)
+	}
+
+	if docs := trashed(); len(docs) != 0 {
+		t.Errorf("trash after restore = %+v, want empty", docs)
+	}
+	var doc JSONDocument
+	docCollection.FindOne(c, bson.M{"_id": "doc"}).Decode(&doc)
+	if doc.DeletedAt != nil || doc.Data["n"] != 1.0 {
+		t.Errorf("restored document = %+v, want it live and unchanged", doc)
+	}
+	if code := call(getDocument, http.MethodGet, "u1"); code != http.StatusOK {
+		t.Errorf("get after restore: status %d, want 200", code)
+	}
+}
+
+func TestSweepExpiredTrash(t *testing.T) {
+	setupTestDB(t)
+	saved := config.TrashRetention
+	t.Cleanup(func() { config.TrashRetention = saved })
+	config.TrashRetention = 24 * time.Hour
+
+	c := context.Background()
+	now := time.Now().UTC()
+	expired, recent := now.Add(-48*time.Hour), now.Add(-time.Hour)
+	data := map[string]interface{}{"s": "hi"}
+	docCollection.InsertOne(c, JSONDocument{ID: "expired", UserID: "u1", Name: "a", Data: data, DeletedAt: &expired})
+	docCollection.InsertOne(c, JSONDocument{ID: "recent", UserID: "u1", Name: "b", Data: data, DeletedAt: &recent})
+	docCollection.InsertOne(c, JSONDocument{ID: "live", UserID: "u1", Name: "c", Data: data})
+	for _, id := range []string{"expired", "recent"} {
+		versionsCollection.InsertOne(c, DocumentVersion{ID: id + "-1", DocumentID: id, UserID: "u1", Version: 1, CreatedAt: now})
+	}
+	total := 3 * dataSize(data)
+	usersCollection.InsertOne(c, User{ID: "u1", Email: "u1@example.com", StorageBytes: &total})
+
+	sweepExpiredTrash()
+
+	for id, want := range map[string]int64{"expired": 0, "recent": 1, "live": 1} {
+		if n, _ := docCollection.CountDocuments(c, bson.M{"_id": id}); n != want {
+			t.Errorf("%s: %d documents left, want %d", id, n, want)
+		}
+	}
+	for id, want := range map[string]int64{"expired": 0, "recent": 1} {
+		if n, _ := versionsCollection.CountDocuments(c, bson.M{"document_id": id}); n != want {
+			t.Errorf("%s: %d versions left, want %d", id, n, want)
+		}
+	}
+	var user User
+	usersCollection.FindOne(c, bson.M{"_id": "u1"}).Decode(&user)
+	if user.StorageBytes == nil || *user.StorageBytes != 2*dataSize(data) {
+		t.Errorf("storage_bytes = %v, want %d after releasing the swept document", user.StorageBytes, 2*dataSize(data))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxTreeDocuments caps ?tree=true, which lists every matching document at
+// once
+const maxTreeDocuments = 10000
+
+// treeFolder is a folder in a ?tree=true listing. The root has an empty
+// name and path.
+type treeFolder struct {
+	Name      string         `json:"name"`
+	Path      string         `json:"path"`
+	Folders   []*treeFolder  `json:"folders"`
+	Documents []treeDocument `json:"documents"`
+}
+
+// treeDocument is the metadata of a document in a tree listing
+type treeDocument struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	IsPublic  bool      `json:"is_public"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// folderSegments splits a folder into its "/"-separated path, ignoring
+// empty segments
+func folderSegments(folder string) []string {
+	var segments []string
+	for _, segment := range strings.Split(folder, "/") {
+		if segment = strings.TrimSpace(segment); segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// folderSubtree matches folder and every folder beneath it, segment by
+// segment as buildTree reads them, so "a/b" takes in " a//b/c" but not
+// "a/bc". A folder with no segments is matched exactly.
+func folderSubtree(folder string) interface{} {
+	segments := folderSegments(folder)
+	if len(segments) == 0 {
+		return folder
+	}
+	for i, segment := range segments {
+		segments[i] = regexp.QuoteMeta(segment)
+	}
+	return bson.M{"$regex": `^[\s/]*` + strings.Join(segments, `\s*/[\s/]*`) + `\s*(/|$)`}
+}
+
+// buildTree nests documents under their folder paths. Documents without a
+// folder sit at the root; folders and documents are sorted by name.
+func buildTree(docs []JSONDocument) *treeFolder {
+	root := &treeFolder{Folders: []*treeFolder{}, Documents: []treeDocument{}}
+	index := map[string]*treeFolder{"": root}
+
+	for _, doc := range docs {
+		parent, path := root, ""
+		for _, segment := range folderSegments(doc.Folder) {
+			if path != "" {
+				path += "/"
+			}
+			path += segment
+			folder, ok := index[path]
+			if !ok {
+				folder = &treeFolder{Name: segment, Path: path, Folders: []*treeFolder{}, Documents: []treeDocument{}}
+				index[path] = folder
+				parent.Folders = append(parent.Folders, folder)
+			}
+			parent = folder
+		}
+		parent.Documents = append(parent.Documents, treeDocument{
+			ID:        doc.ID,
+			Name:      doc.Name,
+			IsPublic:  doc.IsPublic,
+			CreatedAt: doc.CreatedAt,
+			UpdatedAt: doc.UpdatedAt,
+		})
+	}
+
+	for _, folder := range index {
+		sort.Slice(folder.Folders, func(i, j int) bool { return folder.Folders[i].Name < folder.Folders[j].Name })
+		sort.SliceStable(folder.Documents, func(i, j int) bool { return folder.Documents[i].Name < folder.Documents[j].Name })
+	}
+	return root
+}
+
+// listTree answers ?tree=true with every document matching filter nested
+// by folder, without their data. Pagination does not apply.
+func listTree(c context.Context, w http.ResponseWriter, filter bson.M) {
+	opts := options.Find().
+		SetProjection(bson.M{"name": 1, "folder": 1, "is_public": 1, "created_at": 1, "updated_at": 1}).
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetLimit(maxTreeDocuments + 1)
+	cursor, err := docCollection.Find(c, filter, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list documents"})
+		return
+	}
+	defer cursor.Close(c)
+
+	var docs []JSONDocument
+	if err := cursor.All(c, &docs); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode documents"})
+		return
+	}
+	if len(docs) > maxTreeDocuments {
+		sendJSON(w, http.StatusUnprocessableEntity, APIResponse{
+			Success: false,
+			Error:   "Too many documents for a tree listing; narrow it with ?folder= or list pages instead",
+			Code:    "tree_too_large",
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: buildTree(docs)})
+}
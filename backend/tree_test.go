@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuildTree(t *testing.T) {
+	docs := []JSONDocument{
+		{ID: "1", Name: "readme"},
+		{ID: "2", Name: "q1", Folder: "reports/2024"},
+		{ID: "3", Name: "notes", Folder: "reports"},
+		{ID: "4", Name: "q2", Folder: "/reports//2024/"},
+		{ID: "5", Name: "logo", Folder: "assets"},
+	}
+
+	var got struct {
+		Documents []treeDocument
+		Folders   []struct {
+			Name      string
+			Path      string
+			Documents []treeDocument
+			Folders   []struct {
+				Path      string
+				Documents []treeDocument
+			}
+		}
+	}
+	raw, _ := json.Marshal(buildTree(docs))
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Documents) != 1 || got.Documents[0].ID != "1" {
+		t.Errorf("root documents = %v, want readme", got.Documents)
+	}
+	if len(got.Folders) != 2 || got.Folders[0].Name != "assets" || got.Folders[1].Name != "reports" {
+		t.Fatalf("root folders = %+v, want assets and reports", got.Folders)
+	}
+	reports := got.Folders[1]
+	if len(reports.Documents) != 1 || reports.Documents[0].ID != "3" {
+		t.Errorf("reports documents = %v, want notes", reports.Documents)
+	}
+	if len(reports.Folders) != 1 || reports.Folders[0].Path != "reports/2024" {
+		t.Fatalf("reports folders = %+v, want reports/2024", reports.Folders)
+	}
+	if year := reports.Folders[0].Documents; len(year) != 2 || year[0].ID != "2" || year[1].ID != "4" {
+		t.Errorf("reports/2024 documents = %v, want q1 and q2", year)
+	}
+}
+
+func TestBuildTreeEmpty(t *testing.T) {
+	raw, _ := json.Marshal(buildTree(nil))
+	if want := `{"name":"","path":"","folders":[],"documents":[]}`; string(raw) != want {
+		t.Errorf("empty tree = %s, want %s", raw, want)
+	}
+}
+
+func TestFolderSubtree(t *testing.T) {
+	if got := folderSubtree("//"); got != "//" {
+		t.Errorf("folderSubtree(//) = %v, want an exact match", got)
+	}
+	pattern := regexp.MustCompile(folderSubtree("reports/2024").(bson.M)["$regex"].(string))
+	for folder, want := range map[string]bool{
+		"reports/2024":         true,
+		"reports/2024/q1":      true,
+		"/reports//2024/":      true,
+		" reports / 2024 /q1":  true,
+		"reports/2024-old":     false,
+		"reports":              false,
+		"archive/reports/2024": false,
+	} {
+		if got := pattern.MatchString(folder); got != want {
+			t.Errorf("%q matched = %v, want %v", folder, got, want)
+		}
+	}
+	if pattern := regexp.MustCompile(folderSubtree("a.b").(bson.M)["$regex"].(string)); pattern.MatchString("axb") {
+		t.Error("folder taken as a regular expression")
+	}
+}
+
+func TestListTreeFolder(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	for id, folder := range map[string]string{"1": "reports", "2": "reports/2024", "3": "reports/2024/q1", "4": "reports-old", "5": ""} {
+		docCollection.InsertOne(c, JSONDocument{ID: id, UserID: "u1", Name: id, Folder: folder})
+	}
+
+	r := asUser(httptest.NewRequest(http.MethodGet, "/api/documents?tree=true&folder=reports/2024", nil), "u1")
+	w := serve(listDocuments, r)
+	var resp struct {
+		Data *treeFolder `json:"data"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Data == nil {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var ids []string
+	var walk func(folder *treeFolder)
+	walk = func(folder *treeFolder) {
+		for _, doc := range folder.Documents {
+			ids = append(ids, doc.ID)
+		}
+		for _, sub := range folder.Folders {
+			walk(sub)
+		}
+	}
+	walk(resp.Data)
+	if len(ids) != 2 || ids[0] != "2" || ids[1] != "3" {
+		t.Errorf("tree holds %v, want reports/2024 and its subfolder's 2 and 3", ids)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// isVerified reports whether the user confirmed their email. Accounts
+// created before verification existed have no flag and count as verified.
+func (u User) isVerified() bool {
+	return u.Verified == nil || *u.Verified
+}
+
+// newEmailToken returns a token to email, for verification or a password
+// reset, and the hash stored for it
+func newEmailToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(raw)
+	return token, hashEmailToken(token), nil
+}
+
+// hashEmailToken hashes a token for storage, so a leaked users collection
+// cannot be used to verify accounts or reset passwords
+func hashEmailToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendVerification emails the verification link for token. Failures are
+// logged; the user can ask for another link.
+func sendVerification(email, token string) {
+	link := config.VerifyURL + "?token=" + url.QueryEscape(token)
+	body := "Confirm your email address by opening this link:\n\n" + link + "\n\nIf you did not create an account, ignore this message.\n"
+	if err := mailer.Send(email, "Confirm your email address", body); err != nil {
+		log.Printf("failed to send verification email to %s: %v", email, err)
+	}
+}
+
+// Verify handler - confirms the email of the account a token was sent for
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		sendValidationError(w, []FieldError{{Field: "token", Message: "is required"}})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	result, err := usersCollection.UpdateOne(c,
+		bson.M{"verify_token": hashEmailToken(token)},
+		bson.M{"$set": bson.M{"verified": true}, "$unset": bson.M{"verify_token": ""}},
+	)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to verify email"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendJSON(w, http.StatusBadRequest, APIResponse{
+			Success: false,
+			Error:   "Invalid or already used verification token",
+			Code:    "invalid_verification_token",
+		})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Email verified"})
+}
+
+// Resend handler - sends a new verification link to an unverified account.
+// The reply is the same whether or not the email is registered.
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	body, ok := readBody(w, r, config.MaxAuthBytes)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		sendParseError(w, err)
+		return
+	}
+	if input.Email == "" {
+		sendValidationError(w, []FieldError{{Field: "email", Message: "is required"}})
+		return
+	}
+
+	token, hash, err := newEmailToken()
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create verification token"})
+		return
+	}
+
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	email := strings.ToLower(input.Email)
+	result, err := usersCollection.UpdateOne(c,
+		bson.M{"email": email, "verified": false},
+		bson.M{"$set": bson.M{"verify_token": hash}},
+	)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to create verification token"})
+		return
+	}
+	if result.MatchedCount > 0 {
+		sendVerification(email, token)
+	}
+
+	sendJSON(w, http.StatusAccepted, APIResponse{
+		Success: true,
+		Message: "If the account exists and is unverified, a new verification link was sent",
+	})
+}
+
+// sendUnverified refuses a request from an account whose email is not yet
+// verified
+func sendUnverified(w http.ResponseWriter) {
+	sendJSON(w, http.StatusForbidden, APIResponse{
+		Success: false,
+		Error:   "Email address is not verified",
+		Code:    "email_unverified",
+	})
+}
+
+// userVerified reports whether the caller's account is verified. The global
+// key always is.
+func userVerified(c context.Context, r *http.Request) (bool, error) {
+	userID := getUserID(r)
+	if userID == "global" {
+		return true, nil
+	}
+	user, ok := r.Context().Value("user").(User)
+	if !ok {
+		// Token and scoped key requests carry only the user ID
+		err := usersCollection.FindOne(c, bson.M{"_id": userID}).Decode(&user)
+		dbBreaker.Record(err)
+		if err != nil {
+			return false, err
+		}
+	}
+	return user.isVerified(), nil
+}
+
+// verifiedOnly wraps a handler that creates documents so that, with
+// REQUIRE_VERIFICATION=create, unverified accounts are refused
+func verifiedOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.RequireVerification != "create" {
+			next(w, r)
+			return
+		}
+
+		c, cancel := dbContext(r.Context())
+		verified, err := userVerified(c, r)
+		cancel()
+		if err != nil {
+			sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to look up account"})
+			return
+		}
+		if !verified {
+			sendUnverified(w)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// recordingMailer keeps sent messages instead of sending them
+type recordingMailer struct {
+	bodies []string
+}
+
+func (m *recordingMailer) Send(to, subject, body string) error {
+	m.bodies = append(m.bodies, body)
+	return nil
+}
+
+// withVerification sets REQUIRE_VERIFICATION and a recording mailer for the
+// rest of the test
+func withVerification(t *testing.T, mode string) *recordingMailer {
+	savedMode, savedMailer := config.RequireVerification, mailer
+	t.Cleanup(func() { config.RequireVerification, mailer = savedMode, savedMailer })
+	sent := &recordingMailer{}
+	config.RequireVerification, mailer = mode, sent
+	return sent
+}
+
+func TestMailMessageStripsHeaderBreaks(t *testing.T) {
+	msg := string(mailMessage("from@example.com", "a@example.com\r\nBcc: x@example.com", "Hi", "line one\nline two"))
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("recipient added a header: %q", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nline one\r\nline two") {
+		t.Errorf("body not CRLF-terminated after headers: %q", msg)
+	}
+}
+
+func TestVerifiedOnly(t *testing.T) {
+	withVerification(t, "create")
+	called := false
+	handler := verifiedOnly(func(w http.ResponseWriter, r *http.Request) { called = true })
+
+	unverified := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", nil), "u1")
+	unverified = unverified.WithContext(context.WithValue(unverified.Context(), "user", User{ID: "u1", Verified: new(bool)}))
+	if w := serve(handler, unverified); w.Code != http.StatusForbidden || called {
+		t.Errorf("unverified: status %d, called %v; want 403 and not called", w.Code, called)
+	}
+
+	legacy := asUser(httptest.NewRequest(http.MethodPost, "/api/documents", nil), "u1")
+	legacy = legacy.WithContext(context.WithValue(legacy.Context(), "user", User{ID: "u1"}))
+	if serve(handler, legacy); !called {
+		t.Error("account from before verification was refused")
+	}
+}
+
+func TestRegistrationVerification(t *testing.T) {
+	setupTestDB(t)
+	sent := withVerification(t, "login")
+	credentials := `{"email":"New@example.com","password":"secret1"}`
+
+	if w := serve(registerHandler, httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(credentials))); w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", w.Code, w.Body)
+	}
+	login := func() int {
+		return serve(loginHandler, httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(credentials))).Code
+	}
+	if code := login(); code != http.StatusForbidden {
+		t.Errorf("login before verifying: status %d, want %d", code, http.StatusForbidden)
+	}
+
+	if len(sent.bodies) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(sent.bodies))
+	}
+	match := regexp.MustCompile(`\?token=(\S+)`).FindStringSubmatch(sent.bodies[0])
+	if match == nil {
+		t.Fatalf("no verification link in %q", sent.bodies[0])
+	}
+	verify := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/auth/verify?token="+url.QueryEscape(match[1]), nil)
+		return serve(verifyHandler, r).Code
+	}
+	if code := verify(); code != http.StatusOK {
+		t.Fatalf("verify: status %d, want %d", code, http.StatusOK)
+	}
+	if code := login(); code != http.StatusOK {
+		t.Errorf("login after verifying: status %d, want %d", code, http.StatusOK)
+	}
+	if code := verify(); code != http.StatusBadRequest {
+		t.Errorf("reused token: status %d, want %d", code, http.StatusBadRequest)
+	}
+}
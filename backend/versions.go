@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DocumentVersion is a snapshot of a document's name and data taken just
+// before it was changed. Versions are numbered from 1 per document.
+type DocumentVersion struct {
+	ID         string                 `json:"id" bson:"_id"`
+	DocumentID string                 `json:"document_id" bson:"document_id"`
+	UserID     string                 `json:"user_id" bson:"user_id"`
+	Version    int                    `json:"version" bson:"version"`
+	Name       string                 `json:"name" bson:"name"`
+	Data       map[string]interface{} `json:"data" bson:"data"`
+	CreatedAt  time.Time              `json:"created_at" bson:"created_at"`
+}
+
+var errVersionContention = errors.New("could not allocate a version number")
+
+// recordVersion stores the prior state of a document that was just updated
+// and prunes versions beyond VERSION_LIMIT. Failures are logged rather than
+// failing the write, which has already been applied.
+func recordVersion(c context.Context, r *http.Request, prior JSONDocument) {
+	if config.VersionLimit <= 0 {
+		return
+	}
+
+	if err := saveVersion(c, prior); err != nil {
+		log.Printf("request_id=%s failed to record version of %s: %v", requestID(r), prior.ID, err)
+	}
+}
+
+func saveVersion(c context.Context, prior JSONDocument) error {
+	// Concurrent updates may pick the same number; the unique index rejects
+	// all but one and the others retry with the next number
+	for attempt := 0; attempt < 3; attempt++ {
+		number := 1
+		var latest DocumentVersion
+		err := versionsCollection.FindOne(c, bson.M{"document_id": prior.ID}, options.FindOne().SetSort(bson.M{"version": -1})).Decode(&latest)
+		dbBreaker.Record(err)
+		if err == nil {
+			number = latest.Version + 1
+		} else if err != mongo.ErrNoDocuments {
+			return err
+		}
+
+		version := DocumentVersion{
+			ID:         uuid.New().String(),
+			DocumentID: prior.ID,
+			UserID:     prior.UserID,
+			Version:    number,
+			Name:       prior.Name,
+			Data:       prior.Data,
+			CreatedAt:  time.Now().UTC(),
+		}
+		_, err = versionsCollection.InsertOne(c, version)
+		dbBreaker.Record(err)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = versionsCollection.DeleteMany(c, bson.M{"document_id": prior.ID, "version": bson.M{"$lte": number - config.VersionLimit}})
+		dbBreaker.Record(err)
+		return err
+	}
+	return errVersionContention
+}
+
+// deleteVersions removes the history of documents that no longer exist
+func deleteVersions(filter bson.M) {
+	c, cancel := dbContext(context.Background())
+	defer cancel()
+
+	_, err := versionsCollection.DeleteMany(c, filter)
+	dbBreaker.Record(err)
+	if err != nil {
+		log.Printf("Failed to delete document versions: %v", err)
+	}
+}
+
+// findOwnedDocument loads a live document the caller may access
+func findOwnedDocument(c context.Context, r *http.Request, id string) (JSONDocument, error) {
+	filter := bson.M{"_id": id}
+	if userID := getUserID(r); userID != "global" {
+		filter["user_id"] = userID
+	}
+
+	var doc JSONDocument
+	err := docCollection.FindOne(c, live(filter)).Decode(&doc)
+	dbBreaker.Record(err)
+	return doc, err
+}
+
+// versionParam parses the {n} path parameter
+func versionParam(r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(pathParam(r, "n"))
+	return n, err == nil && n > 0
+}
+
+// List a document's versions, newest first, without their data
+func listVersions(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	if _, err := findOwnedDocument(c, r, id); err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return
+	}
+
+	opts := options.Find().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"data": 0})
+	cursor, err := versionsCollection.Find(c, bson.M{"document_id": id}, opts)
+	dbBreaker.Record(err)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to list versions"})
+		return
+	}
+	defer cursor.Close(c)
+
+	versions := []DocumentVersion{}
+	if err := cursor.All(c, &versions); err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to decode versions"})
+		return
+	}
+
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: versions})
+}
+
+// findVersion loads version {n} of a document the caller owns, replying
+// with the error itself when it can't
+func findVersion(c context.Context, w http.ResponseWriter, r *http.Request, id string) (JSONDocument, DocumentVersion, bool) {
+	var version DocumentVersion
+	n, ok := versionParam(r)
+	if !ok {
+		sendJSON(w, http.StatusBadRequest, APIResponse{Success: false, Error: "version must be a positive integer"})
+		return JSONDocument{}, version, false
+	}
+
+	doc, err := findOwnedDocument(c, r, id)
+	if err != nil {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Document not found"})
+		return doc, version, false
+	}
+
+	err = versionsCollection.FindOne(c, bson.M{"document_id": id, "version": n}).Decode(&version)
+	dbBreaker.Record(err)
+	if err == mongo.ErrNoDocuments {
+		sendJSON(w, http.StatusNotFound, APIResponse{Success: false, Error: "Version not found"})
+		return doc, version, false
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to load version"})
+		return doc, version, false
+	}
+	return doc, version, true
+}
+
+// Get one version of a document
+func getVersion(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	if _, version, ok := findVersion(c, w, r, id); ok {
+		sendJSON(w, http.StatusOK, APIResponse{Success: true, Data: version})
+	}
+}
+
+// Restore a document to version {n}. The state being replaced is recorded
+// as a new version, so a restore can itself be undone.
+func restoreVersion(w http.ResponseWriter, r *http.Request, id string) {
+	c, cancel := dbContext(r.Context())
+	defer cancel()
+
+	doc, version, ok := findVersion(c, w, r, id)
+	if !ok {
+		return
+	}
+	if doc.Frozen {
+		sendJSON(w, http.StatusForbidden, APIResponse{Success: false, Error: "document is frozen"})
+		return
+	}
+
+	prior := doc
+
+	// The document's unique arrays or schema may have changed since the
+	// version was taken, so it's checked like any other write
+	if fieldErrors := validateData(version.Data, doc.Unique); len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+	now := time.Now().UTC()
+	set := bson.M{
+		"name":         version.Name,
+		"data":         version.Data,
+		"content_hash": contentHashValue(version.Data),
+		"updated_at":   now,
+	}
+	fieldErrors, err := documentSchemaErrors(c, &doc, version.Name, version.Data, set)
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to validate against schema"})
+		return
+	}
+	if len(fieldErrors) > 0 {
+		sendValidationError(w, fieldErrors)
+		return
+	}
+
+	growth := dataSize(version.Data) - dataSize(doc.Data)
+	if !chargeStorage(c, w, doc.UserID, growth) {
+		return
+	}
+
+	filter := live(bson.M{"_id": id, "user_id": doc.UserID, "frozen": bson.M{"$ne": true}})
+	result, err := docCollection.UpdateOne(c, filter, bson.M{"$set": set})
+	dbBreaker.Record(err)
+	settleStorage(doc.UserID, growth, err == nil && result.MatchedCount > 0)
+	if mongo.IsDuplicateKeyError(err) {
+		sendWriteConflict(w, err)
+		return
+	}
+	if err != nil {
+		sendJSON(w, http.StatusInternalServerError, APIResponse{Success: false, Error: "Failed to restore version"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		sendWriteMiss(w, r, id)
+		return
+	}
+	recordVersion(c, r, prior)
+
+	doc.Name, doc.Data, doc.UpdatedAt = version.Name, version.Data, now
+	sendJSON(w, http.StatusOK, APIResponse{Success: true, Message: "Document restored to version " + strconv.Itoa(version.Version), Data: doc})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestVersionParam(t *testing.T) {
+	for n, want := range map[string]bool{"1": true, "12": true, "0": false, "-1": false, "x": false, "": false} {
+		r := withPathParams(httptest.NewRequest(http.MethodGet, "/", nil), map[string]string{"n": n})
+		if _, ok := versionParam(r); ok != want {
+			t.Errorf("versionParam(%q) ok = %v, want %v", n, ok, want)
+		}
+	}
+}
+
+func TestDocumentVersions(t *testing.T) {
+	setupTestDB(t)
+	saved := config.VersionLimit
+	t.Cleanup(func() { config.VersionLimit = saved })
+	config.VersionLimit = 2
+
+	c := context.Background()
+	docCollection.InsertOne(c, JSONDocument{ID: "doc", UserID: "u1", Name: "v1", Data: map[string]interface{}{"n": 1.0}})
+
+	call := func(h func(http.ResponseWriter, *http.Request, string), method, body string, n int) (int, json.RawMessage) {
+		r := asUser(httptest.NewRequest(method, "/api/documents/doc/versions", strings.NewReader(body)), "u1")
+		r.Header.Set("Content-Type", "application/json")
+		w := serve(withID(h), withPathParams(r, map[string]string{"id": "doc", "n": strconv.Itoa(n)}))
+		var resp struct {
+			Data json.RawMessage `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp.Data
+	}
+	stored := func() JSONDocument {
+		var doc JSONDocument
+		docCollection.FindOne(c, bson.M{"_id": "doc"}).Decode(&doc)
+		return doc
+	}
+
+	// Each update records the state it replaced
+	for n := 2; n <= 4; n++ {
+		body := `{"name":"v` + strconv.Itoa(n) + `","data":{"n":` + strconv.Itoa(n) + `}}`
+		if code, _ := call(updateDocument, http.MethodPut, body, 0); code != http.StatusOK {
+			t.Fatalf("update %d: status %d", n, code)
+		}
+	}
+
+	// Three versions were recorded and the oldest pruned at VERSION_LIMIT
+	code, raw := call(listVersions, http.MethodGet, "", 0)
+	var listed []DocumentVersion
+	json.Unmarshal(raw, &listed)
+	if code != http.StatusOK || len(listed) != 2 || listed[0].Version != 3 || listed[1].Version != 2 {
+		t.Fatalf("list: status %d, versions %+v; want 3 and 2", code, listed)
+	}
+	if listed[0].Data != nil {
+		t.Errorf("list included data %v", listed[0].Data)
+	}
+
+	code, raw = call(getVersion, http.MethodGet, "", 3)
+	var version DocumentVersion
+	json.Unmarshal(raw, &version)
+	if code != http.StatusOK || version.Name != "v3" || version.Data["n"] != 3.0 {
+		t.Errorf("get 3: status %d, version %+v; want v3 with n 3", code, version)
+	}
+	if code, _ := call(getVersion, http.MethodGet, "", 1); code != http.StatusNotFound {
+		t.Errorf("get pruned version 1: status %d, want 404", code)
+	}
+
+	// Restoring records the state it replaces, so it can be undone
+	if code, _ := call(restoreVersion, http.MethodPost, "", 2); code != http.StatusOK {
+		t.Fatalf("restore 2: status %d", code)
+	}
+	if doc := stored(); doc.Name != "v2" || doc.Data["n"] != 2.0 {
+		t.Errorf("after restore: %q %v, want v2 with n 2", doc.Name, doc.Data)
+	}
+	code, raw = call(getVersion, http.MethodGet, "", 4)
+	json.Unmarshal(raw, &version)
+	if code != http.StatusOK || version.Name != "v4" || version.Data["n"] != 4.0 {
+		t.Errorf("version 4: status %d, version %+v; want the replaced v4", code, version)
+	}
+
+	// A version that breaks a constraint added since isn't restored
+	versionsCollection.InsertOne(c, DocumentVersion{ID: "dup", DocumentID: "doc", UserID: "u1", Version: 9, Name: "dup",
+		Data: map[string]interface{}{"tags": bson.A{"a", "a"}}, CreatedAt: time.Now().UTC()})
+	docCollection.UpdateOne(c, bson.M{"_id": "doc"}, bson.M{"$set": bson.M{"unique_arrays": bson.A{"data.tags"}}})
+	if code, _ := call(restoreVersion, http.MethodPost, "", 9); code != http.StatusUnprocessableEntity {
+		t.Errorf("restore of invalid version: status %d, want 422", code)
+	}
+	if doc := stored(); doc.Name != "v2" {
+		t.Errorf("invalid restore changed the document to %q", doc.Name)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// wsPongWait is how long a client may stay silent, pongs included
+	wsPongWait = 60 * time.Second
+	// wsPingInterval must be shorter than wsPongWait
+	wsPingInterval = 25 * time.Second
+	wsWriteWait    = 10 * time.Second
+	wsMaxMessage   = 64 << 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range config.AllowedOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// streamsCtx parents every change stream; closeStreams ends them all when
+// the server shuts down, since Shutdown does not wait on hijacked
+// connections
+var streamsCtx, closeStreams = context.WithCancel(context.Background())
+
+// streamCounts tracks open streaming connections per user so
+// MAX_STREAMS_PER_USER can be enforced
+var streamCounts = struct {
+	sync.Mutex
+	open map[string]int
+}{open: map[string]int{}}
+
+func acquireStream(userID string) bool {
+	streamCounts.Lock()
+	defer streamCounts.Unlock()
+	if config.MaxStreamsPerUser > 0 && streamCounts.open[userID] >= config.MaxStreamsPerUser {
+		return false
+	}
+	streamCounts.open[userID]++
+	return true
+}
+
+func releaseStream(userID string) {
+	streamCounts.Lock()
+	defer streamCounts.Unlock()
+	if streamCounts.open[userID]--; streamCounts.open[userID] <= 0 {
+		delete(streamCounts.open, userID)
+	}
+}
+
+// wsRequest is a message from the client. {"type": "subscribe", "ids": [...]}
+// follows those documents and {"type": "subscribe", "all": true} every
+// document the caller owns; "unsubscribe" takes the same fields.
+type wsRequest struct {
+	Type string   `json:"type"`
+	IDs  []string `json:"ids"`
+	All  bool     `json:"all"`
+}
+
+// wsMessage is a message to the client
+type wsMessage struct {
+	Type       string        `json:"type"`
+	Operation  string        `json:"operation,omitempty"`
+	DocumentID string        `json:"document_id,omitempty"`
+	Document   *JSONDocument `json:"document,omitempty"`
+	IDs        []string      `json:"ids,omitempty"`
+	NotFound   []string      `json:"not_found,omitempty"`
+	All        bool          `json:"all,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// changeEvent is the part of a change stream event that is forwarded
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID string `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *JSONDocument `bson:"fullDocument"`
+}
+
+// wsSubscriber is one WebSocket connection and what it is subscribed to
+type wsSubscriber struct {
+	conn    *websocket.Conn
+	userID  string
+	writeMu sync.Mutex
+
+	mu  sync.Mutex
+	all bool
+	ids map[string]bool
+	// owned holds the caller's document IDs while subscribed to all. Delete
+	// events carry only an ID, so this is how they are attributed.
+	owned map[string]bool
+}
+
+func (s *wsSubscriber) send(msg wsMessage) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return s.conn.WriteJSON(msg)
+}
+
+// Stream changes to the caller's documents over a WebSocket, backed by a
+// MongoDB change stream
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserID(r)
+	if !acquireStream(userID) {
+		sendJSON(w, http.StatusTooManyRequests, APIResponse{Success: false, Error: "Too many open streams", Code: "too_many_streams"})
+		return
+	}
+	defer releaseStream(userID)
+
+	// Upgrade replies with the error itself
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sub := &wsSubscriber{conn: conn, userID: userID, ids: map[string]bool{}}
+
+	// Deletes carry no document, so they pass the owner match and are
+	// attributed by ID afterwards
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{
+		"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}},
+	}}}}
+	if userID != "global" {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": []bson.M{
+			{"fullDocument.user_id": userID},
+			{"operationType": "delete"},
+		}}}})
+	}
+
+	streamCtx, cancel := context.WithCancel(streamsCtx)
+	defer cancel()
+	stream, err := docCollection.Watch(streamCtx, pipeline, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	dbBreaker.Record(err)
+	if err != nil {
+		sub.send(wsMessage{Type: "error", Error: "Change streams require MongoDB to run as a replica set or sharded cluster"})
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "change stream unavailable"), time.Now().Add(wsWriteWait))
+		return
+	}
+	defer stream.Close(context.Background())
+
+	go sub.forward(streamCtx, stream)
+	go sub.keepAlive(streamCtx)
+	sub.read(streamCtx)
+}
+
+// read handles client messages until the connection closes or goes quiet
+func (s *wsSubscriber) read(c context.Context) {
+	s.conn.SetReadLimit(wsMaxMessage)
+	s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, message, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+
+		var req wsRequest
+		if err := json.Unmarshal(message, &req); err != nil {
+			s.send(wsMessage{Type: "error", Error: "Invalid message: " + err.Error()})
+			continue
+		}
+
+		switch req.Type {
+		case "subscribe":
+			s.subscribe(c, req)
+		case "unsubscribe":
+			s.unsubscribe(req)
+		default:
+			s.send(wsMessage{Type: "error", Error: `type must be "subscribe" or "unsubscribe"`})
+		}
+	}
+}
+
+func (s *wsSubscriber) subscribe(streamCtx context.Context, req wsRequest) {
+	c, cancel := dbContext(streamCtx)
+	defer cancel()
+
+	if req.All {
+		var owned map[string]bool
+		if s.userID != "global" {
+			ids, err := docCollection.Distinct(c, "_id", bson.M{"user_id": s.userID})
+			dbBreaker.Record(err)
+			if err != nil {
+				s.send(wsMessage{Type: "error", Error: "Failed to subscribe"})
+				return
+			}
+			owned = make(map[string]bool, len(ids))
+			for _, id := range ids {
+				if id, ok := id.(string); ok {
+					owned[id] = true
+				}
+			}
+		}
+		s.mu.Lock()
+		s.all, s.owned = true, owned
+		s.mu.Unlock()
+		s.send(wsMessage{Type: "subscribed", All: true})
+		return
+	}
+
+	if len(req.IDs) == 0 {
+		s.send(wsMessage{Type: "error", Error: "subscribe needs ids or all"})
+		return
+	}
+
+	// Only documents the caller can read may be followed
+	filter := bson.M{"_id": bson.M{"$in": req.IDs}}
+	if s.userID != "global" {
+		filter["user_id"] = s.userID
+	}
+	found, err := docCollection.Distinct(c, "_id", filter)
+	dbBreaker.Record(err)
+	if err != nil {
+		s.send(wsMessage{Type: "error", Error: "Failed to subscribe"})
+		return
+	}
+
+	readable := make(map[string]bool, len(found))
+	for _, id := range found {
+		if id, ok := id.(string); ok {
+			readable[id] = true
+		}
+	}
+	var subscribed, notFound []string
+	s.mu.Lock()
+	for _, id := range req.IDs {
+		if readable[id] {
+			s.ids[id] = true
+			subscribed = append(subscribed, id)
+		} else {
+			notFound = append(notFound, id)
+		}
+	}
+	s.mu.Unlock()
+	s.send(wsMessage{Type: "subscribed", IDs: subscribed, NotFound: notFound})
+}
+
+func (s *wsSubscriber) unsubscribe(req wsRequest) {
+	s.mu.Lock()
+	if req.All {
+		s.all, s.owned = false, nil
+	}
+	for _, id := range req.IDs {
+		delete(s.ids, id)
+	}
+	s.mu.Unlock()
+	s.send(wsMessage{Type: "unsubscribed", IDs: req.IDs, All: req.All})
+}
+
+// wants reports whether an event is for a subscribed document, keeping the
+// set of owned IDs current
+func (s *wsSubscriber) wants(event changeEvent) bool {
+	id := event.DocumentKey.ID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids[id] {
+		if event.OperationType == "delete" {
+			delete(s.ids, id)
+		}
+		return true
+	}
+	if !s.all {
+		return false
+	}
+	if s.owned == nil {
+		return true // the global key sees every document
+	}
+	switch event.OperationType {
+	case "insert":
+		s.owned[id] = true
+	case "delete":
+		if !s.owned[id] {
+			return false
+		}
+		delete(s.owned, id)
+	}
+	return true
+}
+
+// forward relays change events until the stream fails or the connection
+// closes
+func (s *wsSubscriber) forward(c context.Context, stream *mongo.ChangeStream) {
+	// Closing the connection unblocks read so the handler returns
+	defer s.conn.Close()
+
+	for stream.Next(c) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			continue
+		}
+		if !s.wants(event) {
+			continue
+		}
+		msg := wsMessage{Type: "change", Operation: event.OperationType, DocumentID: event.DocumentKey.ID, Document: event.FullDocument}
+		if err := s.send(msg); err != nil {
+			return
+		}
+	}
+	if err := stream.Err(); err != nil && c.Err() == nil {
+		log.Printf("Change stream for %s ended: %v", s.userID, err)
+		s.send(wsMessage{Type: "error", Error: "Change stream ended"})
+	}
+}
+
+// keepAlive pings the client so dead connections are noticed
+func (s *wsSubscriber) keepAlive(c context.Context) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
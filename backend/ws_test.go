@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestAcquireStream(t *testing.T) {
+	saved := config.MaxStreamsPerUser
+	t.Cleanup(func() { config.MaxStreamsPerUser = saved })
+
+	tests := []struct {
+		name  string
+		limit int
+		open  []string
+		user  string
+		want  bool
+	}{
+		{"under the cap", 2, []string{"a"}, "a", true},
+		{"at the cap", 2, []string{"a", "a"}, "a", false},
+		{"another user", 2, []string{"a", "a"}, "b", true},
+		{"unlimited", 0, []string{"a", "a", "a"}, "a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.MaxStreamsPerUser = 0
+			for _, user := range tt.open {
+				acquireStream(user)
+			}
+			config.MaxStreamsPerUser = tt.limit
+
+			got := acquireStream(tt.user)
+			if got != tt.want {
+				t.Errorf("acquireStream = %v, want %v", got, tt.want)
+			}
+			if got {
+				releaseStream(tt.user)
+			}
+			for _, user := range tt.open {
+				releaseStream(user)
+			}
+			if len(streamCounts.open) != 0 {
+				t.Errorf("after releasing: open = %v", streamCounts.open)
+			}
+		})
+	}
+}
+
+// wsServer serves wsHandler as userID and returns its ws:// URL
+func wsServer(t *testing.T, userID string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wsHandler(w, asUser(r, userID))
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestWSHandlerStreamCap(t *testing.T) {
+	saved := config.MaxStreamsPerUser
+	t.Cleanup(func() { config.MaxStreamsPerUser = saved })
+	config.MaxStreamsPerUser = 1
+
+	acquireStream("u1")
+	defer releaseStream("u1")
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsServer(t, "u1"), nil)
+	if err == nil {
+		t.Fatal("dial succeeded past the stream cap")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("response = %v, want 429", resp)
+	}
+}
+
+func TestWSHandlerSubscribe(t *testing.T) {
+	setupTestDB(t)
+	c := context.Background()
+	docCollection.InsertOne(c, JSONDocument{ID: "mine", UserID: "u1", Data: map[string]interface{}{"n": 1.0}})
+	docCollection.InsertOne(c, JSONDocument{ID: "theirs", UserID: "u2"})
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsServer(t, "u1"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	if err := conn.WriteJSON(wsRequest{Type: "subscribe", IDs: []string{"mine", "theirs"}}); err != nil {
+		t.Fatal(err)
+	}
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type == "error" {
+		t.Skipf("change streams unavailable: %s", msg.Error)
+	}
+	if msg.Type != "subscribed" || len(msg.IDs) != 1 || msg.IDs[0] != "mine" || len(msg.NotFound) != 1 {
+		t.Fatalf("subscribe reply = %+v, want mine subscribed and theirs not found", msg)
+	}
+
+	docCollection.UpdateByID(c, "theirs", bson.M{"$set": bson.M{"name": "x"}})
+	docCollection.UpdateByID(c, "mine", bson.M{"$set": bson.M{"data.n": 2.0}})
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "change" || msg.Operation != "update" || msg.DocumentID != "mine" || msg.Document == nil {
+		t.Errorf("event = %+v, want an update to mine with the document", msg)
+	}
+
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		streamCounts.Lock()
+		open := streamCounts.open["u1"]
+		streamCounts.Unlock()
+		if open == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("stream slot was not released after the client disconnected")
+}
@@ -6,6 +6,7 @@ interface Document {
   id: string;
   name: string;
   data: Record<string, unknown>;
+  is_public: boolean;
   created_at: string;
   updated_at: string;
 }
@@ -21,6 +22,8 @@ export default function Home() {
   const [isConnected, setIsConnected] = useState(false);
   const [userEmail, setUserEmail] = useState("");
   const [documents, setDocuments] = useState<Document[]>([]);
+  const [nextCursor, setNextCursor] = useState<string | null>(null);
+  const [loadingMore, setLoadingMore] = useState(false);
   const [loading, setLoading] = useState(false);
   const [selectedDoc, setSelectedDoc] = useState<Document | null>(null);
   const [isModalOpen, setIsModalOpen] = useState(false);
@@ -63,6 +66,7 @@ export default function Home() {
       setIsConnected(true);
       const data = await res.json();
       setDocuments(data.data || []);
+      setNextCursor(data.has_more ? data.next_cursor : null);
       showNotification("Connected successfully", "success");
     } catch {
       showNotification("Invalid API key", "error");
@@ -121,6 +125,7 @@ export default function Home() {
       setUserEmail(data.data.email);
       setIsConnected(true);
       setDocuments([]);
+      setNextCursor(null);
       showNotification("Account created! Your API key: " + data.data.api_key, "success");
     } catch (err) {
       showNotification(err instanceof Error ? err.message : "Registration failed", "error");
@@ -144,6 +149,7 @@ export default function Home() {
       });
       const data = await res.json();
       setDocuments(data.data || []);
+      setNextCursor(data.has_more ? data.next_cursor : null);
     } catch {
       showNotification("Failed to load documents", "error");
     } finally {
@@ -151,12 +157,31 @@ export default function Home() {
     }
   };
 
+  const loadMoreDocuments = async () => {
+    if (!nextCursor) return;
+    setLoadingMore(true);
+    try {
+      const res = await fetch(`${apiUrl}/api/documents?cursor=${encodeURIComponent(nextCursor)}`, {
+        headers: { "X-API-Key": apiKey },
+      });
+      const data = await res.json();
+      if (!res.ok) throw new Error(data.error || "Failed to load documents");
+      setDocuments((prev) => [...prev, ...(data.data || [])]);
+      setNextCursor(data.has_more ? data.next_cursor : null);
+    } catch {
+      showNotification("Failed to load documents", "error");
+    } finally {
+      setLoadingMore(false);
+    }
+  };
+
   const disconnect = () => {
     localStorage.removeItem("apiUrl");
     localStorage.removeItem("apiKey");
     localStorage.removeItem("userEmail");
     setIsConnected(false);
     setDocuments([]);
+    setNextCursor(null);
     setApiUrl(DEFAULT_API_URL);
     setApiKey("");
     setUserEmail("");
@@ -227,6 +252,24 @@ export default function Home() {
     }
   };
 
+  const togglePublic = async () => {
+    if (!selectedDoc) return;
+    const action = selectedDoc.is_public ? "unpublish" : "publish";
+    try {
+      const res = await fetch(`${apiUrl}/api/documents/${selectedDoc.id}/${action}`, {
+        method: "POST",
+        headers: { "X-API-Key": apiKey },
+      });
+      const data = await res.json();
+      if (!res.ok) throw new Error(data.error || `Failed to ${action}`);
+      setSelectedDoc(data.data);
+      setDocuments((prev) => prev.map((doc) => (doc.id === data.data.id ? data.data : doc)));
+      showNotification(data.message, "success");
+    } catch (err) {
+      showNotification(err instanceof Error ? err.message : `Failed to ${action}`, "error");
+    }
+  };
+
   const copyToClipboard = (text: string, label: string) => {
     navigator.clipboard.writeText(text);
     showNotification(`${label} copied`, "success");
@@ -382,7 +425,9 @@ export default function Home() {
         <div className="flex items-center justify-between mb-6">
           <div>
             <h2 className="text-xl font-semibold text-gray-900">Documents</h2>
-            <p className="text-sm text-gray-500 mt-1">{documents.length} documents</p>
+            <p className="text-sm text-gray-500 mt-1">
+              {documents.length}{nextCursor ? "+" : ""} documents
+            </p>
           </div>
           <button
             onClick={openCreateModal}
@@ -430,6 +475,17 @@ export default function Home() {
                 ))}
               </tbody>
             </table>
+            {nextCursor && (
+              <div className="border-t border-gray-200 bg-gray-50 text-center py-3">
+                <button
+                  onClick={loadMoreDocuments}
+                  disabled={loadingMore}
+                  className="text-emerald-600 hover:text-emerald-700 font-medium text-sm disabled:opacity-50"
+                >
+                  {loadingMore ? "Loading..." : "Load more"}
+                </button>
+              </div>
+            )}
           </div>
         )}
 
@@ -443,6 +499,7 @@ export default function Home() {
               { method: "GET", path: "/api/documents/:id", desc: "Get document", auth: true },
               { method: "PUT", path: "/api/documents/:id", desc: "Update document", auth: true },
               { method: "DELETE", path: "/api/documents/:id", desc: "Delete document", auth: true },
+              { method: "POST", path: "/api/documents/:id/publish", desc: "Make document public", auth: true },
               { method: "GET", path: "/public/:id", desc: "Public read access", auth: false },
             ].map((ep, i) => (
               <div key={i} className="flex items-center gap-4 p-3 border border-gray-200 rounded-md">
@@ -662,11 +719,20 @@ curl "${apiUrl}/public/DOCUMENT_ID"`}
               </div>
 
               <div>
-                <label className="block text-xs text-gray-500 mb-1">Public URL</label>
-                <div className="flex gap-2">
-                  <code className="text-sm text-gray-700 bg-gray-50 px-2 py-1 rounded flex-1 truncate">{apiUrl}/public/{selectedDoc.id}</code>
-                  <button onClick={() => copyToClipboard(`${apiUrl}/public/${selectedDoc.id}`, "URL")} className="text-xs text-emerald-600 hover:text-emerald-700">Copy</button>
+                <div className="flex items-center justify-between mb-1">
+                  <label className="block text-xs text-gray-500">Public URL</label>
+                  <button onClick={togglePublic} className="text-xs text-emerald-600 hover:text-emerald-700">
+                    {selectedDoc.is_public ? "Make private" : "Publish"}
+                  </button>
                 </div>
+                {selectedDoc.is_public ? (
+                  <div className="flex gap-2">
+                    <code className="text-sm text-gray-700 bg-gray-50 px-2 py-1 rounded flex-1 truncate">{apiUrl}/public/{selectedDoc.id}</code>
+                    <button onClick={() => copyToClipboard(`${apiUrl}/public/${selectedDoc.id}`, "URL")} className="text-xs text-emerald-600 hover:text-emerald-700">Copy</button>
+                  </div>
+                ) : (
+                  <p className="text-sm text-gray-500">This document is private. Publish it to read it without an API key.</p>
+                )}
               </div>
 
               <div>
@@ -680,11 +746,12 @@ curl "${apiUrl}/public/DOCUMENT_ID"`}
               </div>
 
               {/* Integration Code */}
-              <div className="border border-emerald-200 rounded-lg bg-emerald-50">
-                <div className="flex items-center justify-between px-4 py-2 border-b border-emerald-200">
-                  <span className="text-sm font-medium text-emerald-800">Use in your website</span>
-                  <button
-                    onClick={() => copyToClipboard(`// Fetch ${selectedDoc.name} data
+              {selectedDoc.is_public && (
+                <div className="border border-emerald-200 rounded-lg bg-emerald-50">
+                  <div className="flex items-center justify-between px-4 py-2 border-b border-emerald-200">
+                    <span className="text-sm font-medium text-emerald-800">Use in your website</span>
+                    <button
+                      onClick={() => copyToClipboard(`// Fetch ${selectedDoc.name} data
 const response = await fetch('${apiUrl}/public/${selectedDoc.id}');
 const data = await response.json();
 console.log(data);
@@ -692,27 +759,28 @@ console.log(data);
 // Your API credentials (for authenticated requests)
 // API URL: ${apiUrl}
 // API Key: ${apiKey}`, "Integration code")}
-                    className="text-xs text-emerald-600 hover:text-emerald-700 font-medium"
-                  >
-                    Copy Code
-                  </button>
-                </div>
-                <pre className="p-4 text-sm overflow-x-auto text-gray-800">
-                  {`// Fetch ${selectedDoc.name} data
+                      className="text-xs text-emerald-600 hover:text-emerald-700 font-medium"
+                    >
+                      Copy Code
+                    </button>
+                  </div>
+                  <pre className="p-4 text-sm overflow-x-auto text-gray-800">
+                    {`// Fetch ${selectedDoc.name} data
 const response = await fetch('${apiUrl}/public/${selectedDoc.id}');
 const data = await response.json();
 console.log(data);`}
-                </pre>
-                <div className="px-4 pb-4 pt-2 border-t border-emerald-200 space-y-1">
-                  <div className="flex items-center justify-between text-sm">
-                    <span className="text-gray-600">API Key:</span>
-                    <div className="flex items-center gap-2">
-                      <code className="font-mono text-gray-800 text-xs">{apiKey}</code>
-                      <button onClick={() => copyToClipboard(apiKey, "API Key")} className="text-xs text-emerald-600">Copy</button>
+                  </pre>
+                  <div className="px-4 pb-4 pt-2 border-t border-emerald-200 space-y-1">
+                    <div className="flex items-center justify-between text-sm">
+                      <span className="text-gray-600">API Key:</span>
+                      <div className="flex items-center gap-2">
+                        <code className="font-mono text-gray-800 text-xs">{apiKey}</code>
+                        <button onClick={() => copyToClipboard(apiKey, "API Key")} className="text-xs text-emerald-600">Copy</button>
+                      </div>
                     </div>
                   </div>
                 </div>
-              </div>
+              )}
 
               <div className="flex justify-between pt-2">
                 <button onClick={deleteDocument} className="text-red-600 hover:text-red-700 text-sm">Delete</button>